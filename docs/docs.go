@@ -15,18 +15,18 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/deleteaccount": {
-            "delete": {
-                "description": "Delete a user from the database permanently along with all their sessions",
+        "/admin/serviceaccounts": {
+            "get": {
+                "description": "List every service account, newest first, with its client ID, authentication method, scopes and when it last got a token",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Delete a user",
+                "summary": "List service accounts",
                 "responses": {
                     "200": {
-                        "description": "response with message field",
+                        "description": "response with serviceAccounts field",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/models.ServiceAccountsResponse"
                         }
                     },
                     "401": {
@@ -35,6 +35,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "response with error field",
                         "schema": {
@@ -42,34 +48,32 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/login": {
+            },
             "post": {
-                "description": "Login an existing user with valid email and password",
+                "description": "Create a service account for a machine caller. Scopes are profile and admin. With a PEM encoded RSA (2048 bits or more), P-256 or Ed25519 ` + "`" + `publicKey` + "`" + `, the account authenticates with ` + "`" + `private_key_jwt` + "`" + `; otherwise it gets a client secret, which is only shown in this response.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "login a user",
+                "summary": "Create a service account",
                 "parameters": [
                     {
-                        "description": "User login credentials",
+                        "description": "name, scopes and optional public key",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserCredentialsRequest"
+                            "$ref": "#/definitions/models.CreateServiceAccountRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "response with message field",
+                    "201": {
+                        "description": "the client secret and the service account",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/models.ServiceAccountResponse"
                         }
                     },
                     "400": {
@@ -83,26 +87,59 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
+                    },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/logout": {
-            "post": {
-                "description": "Logs out a logged in user by deleting the associated session in the database",
+        "/admin/serviceaccounts/{id}": {
+            "delete": {
+                "description": "Delete a service account by its ID. Its tokens stop working right away.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "logout a user",
+                "summary": "Delete a service account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "service account ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "response with success message",
+                        "description": "response with message field",
                         "schema": {
                             "$ref": "#/definitions/models.MessageResponse"
                         }
                     },
                     "401": {
-                        "description": "unauthorized - cookie not found",
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -116,18 +153,33 @@ const docTemplate = `{
                 }
             }
         },
-        "/logouteverywhere": {
+        "/admin/serviceaccounts/{id}/secret": {
             "post": {
-                "description": "Logs out a logged in user on all devices by deleting all sessions associated with that user",
+                "description": "Give a service account a new client secret, which is only shown in this response. The old secret and the tokens issued with it stop working right away.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "End all user sessions",
+                "summary": "Rotate a service account's client secret",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "service account ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "response with message field",
+                        "description": "the new client secret and the service account",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/models.ServiceAccountResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "the service account authenticates with a public key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
                     "401": {
@@ -136,6 +188,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "response with error field",
                         "schema": {
@@ -145,52 +209,151 @@ const docTemplate = `{
                 }
             }
         },
-        "/ping": {
-            "get": {
-                "description": "do ping",
+        "/admin/users/import": {
+            "post": {
+                "description": "Import users with password hashes from another system. The body is JSONL or CSV\n(header ` + "`" + `email,password_hash` + "`" + `). Supported hashes: argon2id/argon2i, bcrypt ($2a$/$2b$/$2y$),\nDjango pbkdf2_sha256/pbkdf2_sha1/scrypt/argon2/bcrypt/bcrypt_sha256, and salted SHA.\nImported users are rehashed with the current algorithm on their first login.",
                 "consumes": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "ping the server",
+                "summary": "bulk import users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "jsonl or csv, defaults from Content-Type",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "validate rows without inserting them",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "response with message field",
+                        "description": "per-row import report",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/services.ImportReport"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/register": {
+        "/admin/users/{id}/export": {
+            "get": {
+                "description": "Download a copy of a user's personal data, e.g. to answer a data subject access request.",
+                "produces": [
+                    "application/json",
+                    "application/zip"
+                ],
+                "summary": "Export a user's data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or zip",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the export as an attachment",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
             "post": {
-                "description": "Add a new user to the database from a valid email and password",
+                "description": "Queue an export of a user's personal data. The download URL answers 202 until the export is ready, and expires after 24 hours.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "register a new user",
+                "summary": "Request a background export of a user's data",
                 "parameters": [
                     {
-                        "description": "User registration credentials",
+                        "type": "string",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "json (default) or zip",
                         "name": "request",
                         "in": "body",
-                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserCredentialsRequest"
+                            "$ref": "#/definitions/models.ExportRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "response with message field",
+                    "202": {
+                        "description": "the export ID, status and download URL",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/models.ExportRequestResponse"
                         }
                     },
                     "400": {
@@ -199,7 +362,19 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
-                    "500": {
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
                         "description": "response with error field",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
@@ -208,32 +383,65 @@ const docTemplate = `{
                 }
             }
         },
-        "/updateuser": {
+        "/admin/webhooks": {
+            "get": {
+                "description": "List every webhook subscription, newest first, without its secret",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List webhook subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "response with webhooks field",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhooksResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
             "post": {
-                "description": "Update a user's email or password in the database",
+                "description": "Subscribe an http(s) endpoint to account events: user.registered, user.email_changed, user.deletion_scheduled, user.restored and user.deleted. Payloads are signed with a secret, which is only shown in this response.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "update user credentials",
+                "summary": "Create a webhook subscription",
                 "parameters": [
                     {
-                        "description": "User registration credentials",
+                        "description": "endpoint URL, event types and optional description",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserCredentialsRequest"
+                            "$ref": "#/definitions/models.CreateWebhookRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "response with message field",
+                    "201": {
+                        "description": "the signing secret and the subscription",
                         "schema": {
-                            "$ref": "#/definitions/models.MessageResponse"
+                            "$ref": "#/definitions/models.WebhookResponse"
                         }
                     },
                     "400": {
@@ -248,6 +456,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "response with error field",
                         "schema": {
@@ -257,13 +471,22 @@ const docTemplate = `{
                 }
             }
         },
-        "/whoami": {
-            "get": {
-                "description": "Get a user's client IP, email, last login time, and user ID (can be extended)",
+        "/admin/webhooks/{id}": {
+            "delete": {
+                "description": "Delete a webhook subscription by its ID, along with its pending and past deliveries",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get information about the currently logged in user",
+                "summary": "Delete a webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "response with message field",
@@ -271,13 +494,25 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.MessageResponse"
                         }
                     },
-                    "400": {
+                    "401": {
                         "description": "response with error field",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
-                    "401": {
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
                         "description": "response with error field",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
@@ -285,39 +520,1762 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.ErrorResponse": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
-                }
-            }
-        },
-        "models.MessageResponse": {
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "string"
-                }
-            }
         },
-        "models.UserCredentialsRequest": {
-            "type": "object",
-            "required": [
-                "email",
-                "password"
-            ],
-            "properties": {
-                "email": {
-                    "type": "string"
-                },
+        "/admin/webhooks/{id}/deliveries": {
+            "get": {
+                "description": "List the latest 100 deliveries to a webhook subscription, newest first, with their attempts and last response. Dead deliveries failed every attempt and can be redelivered.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the deliveries of a webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "only deliveries with this status: pending, delivered or dead",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with deliveries field",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookDeliveriesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks/{id}/deliveries/{deliveryId}/redeliver": {
+            "post": {
+                "description": "Queue a dead or delivered delivery to be sent again right away, with a fresh set of attempts. The receiver gets the same delivery ID and payload.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Redeliver a webhook delivery",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "webhook subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "webhook delivery ID",
+                        "name": "deliveryId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with delivery field",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookDeliveryResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin session, or the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "the delivery is already pending",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/verify": {
+            "get": {
+                "description": "Forward auth endpoint for nginx auth_request, Traefik ForwardAuth and Caddy forward_auth. Validates the session cookie like every protected route and answers 200 with the X-Auth-User-Id, X-Auth-Email and X-Auth-Roles headers. A rotated or re-signed session cookie is set on the response for the proxy to pass back to the browser. Otherwise answers 401, with the login URL in X-Auth-Redirect if FORWARD_AUTH_LOGIN_URL is set. The login URL's rd parameter holds the original URL, rebuilt from X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Uri (or X-Original-Uri).",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Verify a proxied request",
+                "responses": {
+                    "200": {
+                        "description": "authenticated, see the X-Auth-* headers"
+                    },
+                    "401": {
+                        "description": "response with error and optional loginUrl fields",
+                        "schema": {
+                            "$ref": "#/definitions/models.ForwardAuthErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/csrf": {
+            "get": {
+                "description": "Sets the CSRF cookie and returns its token. Cookie-authenticated POST, PUT, PATCH and DELETE requests must send the token in the X-CSRF-Token header.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "get a CSRF token",
+                "responses": {
+                    "200": {
+                        "description": "response with csrfToken field",
+                        "schema": {
+                            "$ref": "#/definitions/models.CSRFTokenResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/deleteaccount": {
+            "delete": {
+                "description": "Schedule the user's account for permanent deletion at the end of the grace period (ACCOUNT_DELETION_GRACE_DAYS, 30 days by default). All sessions are revoked, login is refused, and an email with a restore token is sent. Requires a session that logged in or reauthenticated in the last 5 minutes.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a user",
+                "responses": {
+                    "200": {
+                        "description": "response with message field and the time the account will be deleted",
+                        "schema": {
+                            "$ref": "#/definitions/models.DeletionScheduledResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "the session must reauthenticate first, or a user hook refused",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "description": "Download a copy of the logged in user's personal data: profile (without the password hash), sessions, audit events and linked identities. For large accounts, request a background export with POST /export instead. Sessions must have logged in or reauthenticated in the last 5 minutes.",
+                "produces": [
+                    "application/json",
+                    "application/zip"
+                ],
+                "summary": "Export the user's data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "json (default) or zip",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the export as an attachment",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Queue an export of the logged in user's personal data. Poll the returned download URL: it answers 202 until the export is ready, and expires after 24 hours. Sessions must have logged in or reauthenticated in the last 5 minutes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Request a background export of the user's data",
+                "parameters": [
+                    {
+                        "description": "json (default) or zip",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.ExportRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "the export ID, status and download URL",
+                        "schema": {
+                            "$ref": "#/definitions/models.ExportRequestResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "the session must reauthenticate first",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/export/download": {
+            "get": {
+                "description": "Download an export requested with POST /export or POST /admin/users/{id}/export. The token in the link is the only credential needed.",
+                "produces": [
+                    "application/json",
+                    "application/zip"
+                ],
+                "summary": "Download a background export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "download token from the export's download URL",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the export as an attachment",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "202": {
+                        "description": "the export is still being generated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "unknown token or expired link",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "the export could not be generated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/introspect": {
+            "post": {
+                "description": "RFC 7662 token introspection for other backend services. The caller authenticates with HTTP Basic using an ` + "`" + `id:secret` + "`" + ` pair from INTROSPECTION_CLIENTS and posts the session token as the form field ` + "`" + `token` + "`" + `. Answers ` + "`" + `{\"active\": false}` + "`" + ` for malformed, expired, revoked or unknown tokens, and otherwise the user ID (` + "`" + `sub` + "`" + `), email, roles and expiry. The session is not rotated. With INTROSPECTION_CACHE_SECONDS set, results are reused per caller and token for that long.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Introspect a session token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "session token to introspect",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "whether the token is active and whose it is",
+                        "schema": {
+                            "$ref": "#/definitions/models.IntrospectionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "invalid client credentials",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login": {
+            "post": {
+                "description": "Login an existing user with valid email and password",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "login a user",
+                "parameters": [
+                    {
+                        "description": "User login credentials",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserCredentialsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "refused by a user hook, which may answer another 4xx status",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "password hashing queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/magic": {
+            "post": {
+                "description": "Email a single-use login link to an account, for passwordless login. The link expires after 15 minutes and only works in the browser that asked for it, which is bound to it with a cookie. Asking again from the same browser keeps the cookie, so earlier links still work. The response is the same whether or not the account exists; nothing is sent to locked accounts, accounts pending deletion, after 3 links to the account or after 10 requests from the client's IP address in 15 minutes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "email a login link",
+                "parameters": [
+                    {
+                        "description": "email of the account",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MagicLinkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/magic/verify": {
+            "post": {
+                "description": "Login with the token of an emailed magic link, from the browser that asked for it. The link can only be used once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "login with a magic link",
+                "parameters": [
+                    {
+                        "description": "magic link token",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MagicLinkLoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "the link is invalid, expired, used, or from another browser, or the account is locked",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "refused by a user hook, which may answer another 4xx status",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout": {
+            "post": {
+                "description": "Logs out a logged in user by deleting the associated session in the database",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "logout a user",
+                "responses": {
+                    "200": {
+                        "description": "response with success message",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized - cookie not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/logouteverywhere": {
+            "post": {
+                "description": "Logs out a logged in user on all devices by deleting all sessions associated with that user",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "End all user sessions",
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Password hashing queue wait time, utilization and rejections in the Prometheus text format",
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "server metrics",
+                "responses": {
+                    "200": {
+                        "description": "Prometheus text exposition format",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/token": {
+            "post": {
+                "description": "OAuth 2.0 client credentials grant (RFC 6749 section 4.4). Service accounts with a secret authenticate with HTTP Basic or the ` + "`" + `client_id` + "`" + ` and ` + "`" + `client_secret` + "`" + ` form fields; those with a public key send a ` + "`" + `private_key_jwt` + "`" + ` client assertion (RFC 7523) whose audience is TOKEN_ENDPOINT_URL. ` + "`" + `scope` + "`" + ` optionally narrows the token to some of the account's scopes. The token is sent as ` + "`" + `Authorization: Bearer \u003ctoken\u003e` + "`" + ` and expires after 15 minutes.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get an access token for a service account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "client_credentials",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "space separated scopes",
+                        "name": "scope",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client ID, unless sent with HTTP Basic",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client secret, unless sent with HTTP Basic",
+                        "name": "client_secret",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+                        "name": "client_assertion_type",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "signed JWT for private_key_jwt",
+                        "name": "client_assertion",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the access token and its lifetime in seconds",
+                        "schema": {
+                            "$ref": "#/definitions/models.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid_request, invalid_scope or unsupported_grant_type",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "invalid_client",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "server_error",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/ping": {
+            "get": {
+                "description": "do ping",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ping the server",
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reauthenticate": {
+            "post": {
+                "description": "Verify the logged in user's password again, allowing the session to change credentials or delete the account for the next few minutes. Wrong passwords count towards the account lockout.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "confirm the user's password",
+                "parameters": [
+                    {
+                        "description": "current password",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ReauthenticateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "password hashing queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/register": {
+            "post": {
+                "description": "Add a new user to the database from a valid email and password. With ENUMERATION_PROTECTION enabled the response is always \"Check your email to continue\" and the owner of an existing account is notified by email instead.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "register a new user",
+                "parameters": [
+                    {
+                        "description": "User registration credentials",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserCredentialsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field and, for password policy violations, a fields list",
+                        "schema": {
+                            "$ref": "#/definitions/models.PolicyErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "refused by a user hook, which may answer another 4xx status",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "password hashing queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/restoreaccount": {
+            "post": {
+                "description": "Cancel the pending deletion of an account with the restore token from the deletion email. The user can log in again afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Restore a user",
+                "parameters": [
+                    {
+                        "description": "restore token",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.RestoreAccountRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "the token is invalid or the grace period has ended",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions": {
+            "get": {
+                "description": "List the logged in user's unexpired sessions by public session ID, newest first, marking the session making the request as current",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the user's sessions",
+                "responses": {
+                    "200": {
+                        "description": "response with sessions field",
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionsResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/{id}": {
+            "delete": {
+                "description": "End one of the logged in user's sessions by its public session ID, e.g. to sign out a lost device",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke one of the user's sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "public session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tokens": {
+            "get": {
+                "description": "List the logged in user's personal access tokens, newest first, with their scopes, expiry, and when and from which IP they were last used. Expired tokens are listed until revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the user's personal access tokens",
+                "responses": {
+                    "200": {
+                        "description": "response with accessTokens field",
+                        "schema": {
+                            "$ref": "#/definitions/models.AccessTokensResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a token for scripts and CI jobs, sent as ` + "`" + `Authorization: Bearer \u003ctoken\u003e` + "`" + `. The token is only shown in this response. Scopes are profile, sessions, export and admin (admins only). Without expiresInDays (1 to 366) the token is valid until revoked. Needs a session with a recent login.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create a personal access token",
+                "parameters": [
+                    {
+                        "description": "token name, scopes and optional expiry in days",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreateAccessTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "the token and its details",
+                        "schema": {
+                            "$ref": "#/definitions/models.AccessTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tokens/{id}": {
+            "delete": {
+                "description": "Delete one of the logged in user's personal access tokens by its ID. Requests made with it are refused from then on.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke one of the user's personal access tokens",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "access token ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/updateuser": {
+            "post": {
+                "description": "Update a user's email or password in the database. Requires a session that logged in or reauthenticated in the last 5 minutes, and the current password to change the password.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "update user credentials",
+                "parameters": [
+                    {
+                        "description": "new email or password",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UpdateUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field and, for password policy violations, a fields list",
+                        "schema": {
+                            "$ref": "#/definitions/models.PolicyErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "the session must reauthenticate first, or a user hook refused",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "password hashing queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/whoami": {
+            "get": {
+                "description": "Get a user's client IP, email, last login time, and user ID (can be extended). ` + "`" + `principalType` + "`" + ` is ` + "`" + `user` + "`" + `, or ` + "`" + `service_account` + "`" + ` for service accounts, which get their service account ID, client ID and scopes instead.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get information about the currently logged in user",
+                "responses": {
+                    "200": {
+                        "description": "response with message field",
+                        "schema": {
+                            "$ref": "#/definitions/models.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "response with error field",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.AccessTokenInfo": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "lastUsedIp": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.AccessTokenResponse": {
+            "type": "object",
+            "properties": {
+                "accessToken": {
+                    "$ref": "#/definitions/models.AccessTokenInfo"
+                },
+                "token": {
+                    "type": "string",
+                    "example": "goauth_pat_..."
+                }
+            }
+        },
+        "models.AccessTokensResponse": {
+            "type": "object",
+            "properties": {
+                "accessTokens": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AccessTokenInfo"
+                    }
+                }
+            }
+        },
+        "models.CSRFTokenResponse": {
+            "type": "object",
+            "properties": {
+                "csrfToken": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CreateAccessTokenRequest": {
+            "type": "object",
+            "properties": {
+                "expiresInDays": {
+                    "type": "integer",
+                    "example": 90
+                },
+                "name": {
+                    "type": "string",
+                    "example": "ci"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "profile",
+                        "export"
+                    ]
+                }
+            }
+        },
+        "models.CreateServiceAccountRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "billing"
+                },
+                "publicKey": {
+                    "type": "string",
+                    "example": "-----BEGIN PUBLIC KEY-----\n..."
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "profile"
+                    ]
+                }
+            }
+        },
+        "models.CreateWebhookRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "billing"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user.registered"
+                    ]
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://billing.example.com/hooks/goauth"
+                }
+            }
+        },
+        "models.DeletionScheduledResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "purgeAfter": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ExportRequest": {
+            "type": "object",
+            "properties": {
+                "format": {
+                    "type": "string",
+                    "example": "json"
+                }
+            }
+        },
+        "models.ExportRequestResponse": {
+            "type": "object",
+            "properties": {
+                "downloadUrl": {
+                    "type": "string",
+                    "example": "/export/download?token=..."
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "pending"
+                }
+            }
+        },
+        "models.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string",
+                    "example": "password"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Password must be at least 8 characters"
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "min_length"
+                }
+            }
+        },
+        "models.ForwardAuthErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "loginUrl": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.IntrospectionResponse": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "claims": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "email": {
+                    "type": "string"
+                },
+                "exp": {
+                    "type": "integer",
+                    "example": 1767225600
+                },
+                "iat": {
+                    "type": "integer",
+                    "example": 1766620800
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user"
+                    ]
+                },
+                "sub": {
+                    "type": "string",
+                    "example": "5f0c0a3e-8d2b-4c1e-9a57-2b1f6f0e4c2d"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "session"
+                }
+            }
+        },
+        "models.MagicLinkLoginRequest": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MagicLinkRequest": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MessageResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "invalid_client"
+                },
+                "error_description": {
+                    "type": "string",
+                    "example": "Invalid client credentials"
+                }
+            }
+        },
+        "models.PolicyErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "fields": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.FieldError"
+                    }
+                }
+            }
+        },
+        "models.ReauthenticateRequest": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.RestoreAccountRequest": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ServiceAccountInfo": {
+            "type": "object",
+            "properties": {
+                "authMethod": {
+                    "type": "string"
+                },
+                "clientId": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "createdBy": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "publicKey": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.ServiceAccountResponse": {
+            "type": "object",
+            "properties": {
+                "clientSecret": {
+                    "type": "string",
+                    "example": "goauth_cs_..."
+                },
+                "serviceAccount": {
+                    "$ref": "#/definitions/models.ServiceAccountInfo"
+                }
+            }
+        },
+        "models.ServiceAccountsResponse": {
+            "type": "object",
+            "properties": {
+                "serviceAccounts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ServiceAccountInfo"
+                    }
+                }
+            }
+        },
+        "models.SessionInfo": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "current": {
+                    "type": "boolean"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.SessionsResponse": {
+            "type": "object",
+            "properties": {
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.SessionInfo"
+                    }
+                }
+            }
+        },
+        "models.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string",
+                    "example": "goauth_sat_..."
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 900
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "profile"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "Bearer"
+                }
+            }
+        },
+        "models.UpdateUserRequest": {
+            "type": "object",
+            "properties": {
+                "currentPassword": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserCredentialsRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
                 "password": {
                     "type": "string"
                 }
             }
+        },
+        "models.WebhookDeliveriesResponse": {
+            "type": "object",
+            "properties": {
+                "deliveries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.WebhookDeliveryInfo"
+                    }
+                }
+            }
+        },
+        "models.WebhookDeliveryInfo": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "deliveredAt": {
+                    "type": "string"
+                },
+                "eventId": {
+                    "type": "string"
+                },
+                "eventType": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "lastAttemptAt": {
+                    "type": "string"
+                },
+                "lastError": {
+                    "type": "string"
+                },
+                "lastStatusCode": {
+                    "type": "integer"
+                },
+                "nextAttemptAt": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "subscriptionId": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.WebhookDeliveryResponse": {
+            "type": "object",
+            "properties": {
+                "delivery": {
+                    "$ref": "#/definitions/models.WebhookDeliveryInfo"
+                }
+            }
+        },
+        "models.WebhookResponse": {
+            "type": "object",
+            "properties": {
+                "secret": {
+                    "type": "string",
+                    "example": "goauth_whsec_..."
+                },
+                "webhook": {
+                    "$ref": "#/definitions/models.WebhookSubscriptionInfo"
+                }
+            }
+        },
+        "models.WebhookSubscriptionInfo": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "createdBy": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.WebhooksResponse": {
+            "type": "object",
+            "properties": {
+                "webhooks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.WebhookSubscriptionInfo"
+                    }
+                }
+            }
+        },
+        "services.ImportReport": {
+            "type": "object",
+            "properties": {
+                "dryRun": {
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "imported": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ImportRowResult"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ImportRowResult": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "row": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/al-ce/goauth/internal/passwords"
+)
+
+// breachIndex implements `goauth breach-index [-min-count n] <input> <output>`
+func breachIndex(args []string) error {
+	flags := flag.NewFlagSet("breach-index", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	minCount := flags.Int("min-count", 1, "skip hashes seen fewer than this many times")
+	flags.Usage = func() {
+		fmt.Fprintln(Stderr, "usage: goauth breach-index [-min-count n] <pwned-passwords-ordered-by-hash.txt> <output>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected an input and an output file")
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	written, err := passwords.BuildBreachIndex(in, out, *minCount)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Do not leave a truncated index behind
+		os.Remove(flags.Arg(1))
+		return err
+	}
+
+	fmt.Fprintf(Stdout, "wrote %d hashes to %s\n", written, flags.Arg(1))
+	return nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/database"
+)
+
+// Stdin, Stdout and Stderr are where commands read input and write their
+// output, swappable for tests
+var (
+	Stdin  io.Reader = os.Stdin
+	Stdout io.Writer = os.Stdout
+	Stderr io.Writer = os.Stderr
+)
+
+// usage lists the available subcommands
+const usage = `usage: goauth [command]
+
+Without a command, or with serve, goauth starts the API server.
+
+commands:
+  serve           start the API server and background jobs
+  migrate         create or update the database tables
+  user            create, list, show, lock, unlock and delete users, or set a password
+  sessions        revoke a user's sessions
+  import          bulk import users with password hashes from another system
+  breach-index    build a compact index of a Have I Been Pwned password dataset
+  keys            generate and promote session signing keys
+  config          check the configuration from the environment
+
+Most commands accept -json to print machine readable output.
+`
+
+// Run dispatches `args` (without the program name) to a subcommand
+func Run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given\n%s", usage)
+	}
+	switch args[0] {
+	case "migrate":
+		return migrate(args[1:])
+	case "user":
+		return user(args[1:])
+	case "sessions":
+		return sessions(args[1:])
+	case "config":
+		return configCommand(args[1:])
+	case "import":
+		return importUsers(args[1:])
+	case "breach-index":
+		return breachIndex(args[1:])
+	case "keys":
+		return keys(args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(Stdout, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// connectDB connects to and migrates the database from `DATABASE_URL`
+func connectDB() (*gorm.DB, error) {
+	db, err := database.NewDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Migrate(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// printJSON writes `v` to Stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
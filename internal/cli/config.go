@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/al-ce/goauth/internal/cookies"
+	"github.com/al-ce/goauth/internal/keyring"
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/server"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// configUsage describes `goauth config`
+const configUsage = `usage: goauth config check [-json]
+
+commands:
+  check    validate the settings read from the environment, the same way the
+           server does on startup, without connecting to the database
+`
+
+// configCheck is the result of validating one group of settings
+type configCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// configCommand implements `goauth config check`
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprint(Stderr, configUsage)
+		if len(args) == 0 {
+			return fmt.Errorf("no config command given")
+		}
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(Stderr, configUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	checks := checkConfig()
+	failed := 0
+	for _, check := range checks {
+		if !check.OK {
+			failed++
+		}
+	}
+
+	if *asJSON {
+		if err := printJSON(map[string]any{"ok": failed == 0, "checks": checks}); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(Stdout, 0, 4, 2, ' ', 0)
+		for _, check := range checks {
+			if check.OK {
+				fmt.Fprintf(w, "ok\t%s\n", check.Name)
+			} else {
+				fmt.Fprintf(w, "FAIL\t%s\t%s\n", check.Name, check.Error)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d configuration checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkConfig validates each group of settings the server reads on startup
+func checkConfig() []configCheck {
+	run := func(name string, check func() error) configCheck {
+		if err := check(); err != nil {
+			return configCheck{Name: name, Error: err.Error()}
+		}
+		return configCheck{Name: name, OK: true}
+	}
+	required := func(env string) func() error {
+		return func() error {
+			if os.Getenv(env) == "" {
+				return fmt.Errorf("%s is not set", env)
+			}
+			return nil
+		}
+	}
+
+	return []configCheck{
+		run("database url", required(config.DatabaseURL)),
+		run("server port", required(config.AuthServerPort)),
+		run("grpc port", func() error {
+			port := os.Getenv(config.GRPCServerPort)
+			if port != "" && port == os.Getenv(config.AuthServerPort) {
+				return fmt.Errorf("%s must differ from %s", config.GRPCServerPort, config.AuthServerPort)
+			}
+			return nil
+		}),
+		run("session keys", func() error {
+			ring, err := keyring.NewFromEnv()
+			if err != nil {
+				return err
+			}
+			return ring.Validate()
+		}),
+		run("password hashing", func() error {
+			_, err := passwords.NewManagerFromEnv()
+			return err
+		}),
+		run("password policy", func() error {
+			_, err := passwords.NewPolicyFromEnv()
+			return err
+		}),
+		run("cookies", func() error {
+			_, err := cookies.NewManagerFromEnv()
+			return err
+		}),
+		run("csrf origins", func() error {
+			_, err := middleware.NewCSRFMiddleware(server.AllowedOrigins())
+			return err
+		}),
+		run("forward auth", func() error {
+			_, err := middleware.ForwardAuthLoginURLFromEnv()
+			return err
+		}),
+		run("account deletion", func() error {
+			_, err := services.DeletionGracePeriodFromEnv()
+			return err
+		}),
+		run("introspection", func() error {
+			if _, err := services.IntrospectionClientsFromEnv(); err != nil {
+				return err
+			}
+			_, err := services.IntrospectionCacheTTLFromEnv()
+			return err
+		}),
+		run("token endpoint", func() error {
+			_, err := services.TokenEndpointURLFromEnv()
+			return err
+		}),
+	}
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+)
+
+// importUsers implements `goauth import [-dry-run] [-format jsonl|csv] [-json] <file>`
+func importUsers(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	dryRun := flags.Bool("dry-run", false, "validate rows without inserting them")
+	format := flags.String("format", "", "input format, jsonl or csv (default: from file extension)")
+	asJSON := flags.Bool("json", false, "print the full report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(Stderr, "usage: goauth import [-dry-run] [-format jsonl|csv] [-json] <file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected exactly one input file")
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = services.ImportFormatJSONL
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = services.ImportFormatCSV
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	ur, err := repository.NewUserRepository(db)
+	if err != nil {
+		return err
+	}
+	importService, err := services.NewImportService(ur)
+	if err != nil {
+		return err
+	}
+
+	report, err := importService.ImportUsers(file, *format, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		printImportReport(report)
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, report.Total)
+	}
+	return nil
+}
+
+// printImportReport writes failed rows and a summary line
+func printImportReport(report *services.ImportReport) {
+	for _, row := range report.Rows {
+		if row.Status == services.ImportStatusFailed {
+			fmt.Fprintf(Stdout, "row %d\t%s\t%s\n", row.Row, row.Email, row.Error)
+		}
+	}
+	if report.DryRun {
+		fmt.Fprintf(Stdout, "dry run: %d rows, %d valid, %d failed\n",
+			report.Total, report.Total-report.Failed, report.Failed)
+		return
+	}
+	fmt.Fprintf(Stdout, "%d rows, %d imported, %d failed\n", report.Total, report.Imported, report.Failed)
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/al-ce/goauth/internal/keyring"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// keysUsage describes `goauth keys` and the key rotation steps
+const keysUsage = `usage: goauth keys <command> [-file path] [-json]
+
+commands:
+  generate [-id id]    add a new random key, not yet used for signing unless
+                       it is the first key
+  promote <id>         sign new session tokens with key <id>
+  list                 list key IDs, marking the active key
+
+The keyring file defaults to SESSION_KEYRING_FILE. To rotate without logging
+anyone out: generate a key and deploy the keyring to every instance, then
+promote it and deploy again. Sessions signed with the old key are re-signed on
+their next request; remove the old key once the longest session (7 days) has
+passed.
+`
+
+// keys implements `goauth keys generate|promote|list`
+func keys(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(Stderr, keysUsage)
+		return fmt.Errorf("no keys command given")
+	}
+
+	flags := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	path := flags.String("file", os.Getenv(config.SessionKeyringFile), "keyring file")
+	id := flags.String("id", "", "ID of the generated key (default: the current UTC time)")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(Stderr, keysUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *path == "" {
+		flags.Usage()
+		return fmt.Errorf("no keyring file, set -file or %s", config.SessionKeyringFile)
+	}
+
+	switch args[0] {
+	case "generate":
+		ring, err := loadOrCreateKeyring(*path)
+		if err != nil {
+			return err
+		}
+		key, err := ring.Generate(*id)
+		if err != nil {
+			return err
+		}
+		if ring.Active == "" {
+			// Nothing is signed yet, so the first key can be used right away
+			ring.Active = key.ID
+		}
+		if err := ring.Validate(); err != nil {
+			return err
+		}
+		if err := ring.SaveFile(*path); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(map[string]any{"id": key.ID, "active": ring.Active == key.ID})
+		}
+		fmt.Fprintf(Stdout, "generated key %s, promote it with `goauth keys promote %s` once every instance has it\n", key.ID, key.ID)
+		return nil
+	case "promote":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return fmt.Errorf("expected a key ID")
+		}
+		ring, err := keyring.LoadFile(*path)
+		if err != nil {
+			return err
+		}
+		if err := ring.Promote(flags.Arg(0)); err != nil {
+			return err
+		}
+		if err := ring.Validate(); err != nil {
+			return err
+		}
+		if err := ring.SaveFile(*path); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(map[string]any{"id": flags.Arg(0), "active": true})
+		}
+		fmt.Fprintf(Stdout, "promoted key %s\n", flags.Arg(0))
+		return nil
+	case "list":
+		ring, err := keyring.LoadFile(*path)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			list := make([]map[string]any, 0, len(ring.Keys))
+			for _, key := range ring.Keys {
+				list = append(list, map[string]any{"id": key.ID, "active": key.ID == ring.Active})
+			}
+			return printJSON(list)
+		}
+		for _, key := range ring.Keys {
+			marker := ""
+			if key.ID == ring.Active {
+				marker = " (active)"
+			}
+			fmt.Fprintf(Stdout, "%s%s\n", key.ID, marker)
+		}
+		return nil
+	default:
+		fmt.Fprint(Stderr, keysUsage)
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+}
+
+// loadOrCreateKeyring reads the keyring at `path`. If there is none yet, a new
+// keyring starts from `SESSION_KEY` as the active key, so sessions signed with
+// it stay valid after switching to the keyring.
+func loadOrCreateKeyring(path string) (*keyring.Keyring, error) {
+	ring, err := keyring.LoadFile(path)
+	if err == nil {
+		return ring, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ring = &keyring.Keyring{}
+	if secret := os.Getenv(config.SessionKey); secret != "" {
+		ring.Keys = append(ring.Keys, keyring.Key{ID: config.DefaultSessionKeyID, Secret: secret})
+		ring.Active = config.DefaultSessionKeyID
+	}
+	return ring, nil
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+)
+
+// migrate implements `goauth migrate [-json]`
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(Stderr, "usage: goauth migrate [-json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := connectDB(); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]bool{"migrated": true})
+	}
+	fmt.Fprintln(Stdout, "database migrated")
+	return nil
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+)
+
+// sessionsUsage describes `goauth sessions`
+const sessionsUsage = `usage: goauth sessions revoke -user <id|email> [-json]
+
+commands:
+  revoke    end every session of a user, logging them out on all devices
+`
+
+// sessions implements `goauth sessions revoke`
+func sessions(args []string) error {
+	if len(args) == 0 || args[0] != "revoke" {
+		fmt.Fprint(Stderr, sessionsUsage)
+		if len(args) == 0 {
+			return fmt.Errorf("no sessions command given")
+		}
+		return fmt.Errorf("unknown sessions command %q", args[0])
+	}
+
+	flags := flag.NewFlagSet("sessions revoke", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	ref := flags.String("user", "", "ID or email of the user")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(Stderr, sessionsUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *ref == "" {
+		flags.Usage()
+		return fmt.Errorf("no user given, set -user")
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	us, err := newUserService(db)
+	if err != nil {
+		return err
+	}
+	target, err := findUser(us.UserRepo, *ref)
+	if err != nil {
+		return err
+	}
+	if err := us.LogoutEverywhere(target.ID.String()); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]any{"id": target.ID, "sessionsRevoked": true})
+	}
+	fmt.Fprintf(Stdout, "ended all sessions of %s\n", target.Email)
+	return nil
+}
@@ -0,0 +1,400 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// userUsage describes `goauth user`
+const userUsage = `usage: goauth user <command> [-json] [flags] [user]
+
+<user> is a user ID or email.
+
+commands:
+  create [-role user|admin] [-password p | -passwordless] <email>
+                          create a user. Without -password, the password is
+                          read from the first line of stdin. With
+                          -passwordless, the user logs in with magic links
+  list [-limit n] [-offset n]
+                          list users ordered by email
+  show <user>             show a user and their active sessions
+  lock [-for duration] <user>
+                          lock a user out and end their sessions, until
+                          unlocked unless -for is given
+  unlock <user>           unlock a user and reset their failed logins
+  delete [-now] <user>    schedule a user for deletion after the grace period
+                          and email them a restore token, or delete them right
+                          away with -now
+  set-password [-password p] <user>
+                          replace a user's password, subject to the password
+                          policy, and end their sessions
+`
+
+// userFlags are the flags of every `goauth user` command. Each command only
+// defines the ones it uses.
+type userFlags struct {
+	asJSON   *bool
+	role     *string
+	password *string
+	noPass   *bool
+	limit    *int
+	offset   *int
+	lockFor  *time.Duration
+	now      *bool
+}
+
+// user implements `goauth user create|list|show|lock|unlock|delete|set-password`
+func user(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(Stderr, userUsage)
+		return fmt.Errorf("no user command given")
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("user "+command, flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	flags.Usage = func() {
+		fmt.Fprint(Stderr, userUsage)
+		flags.PrintDefaults()
+	}
+	f := userFlags{asJSON: flags.Bool("json", false, "print the result as JSON")}
+	nargs := 1
+	switch command {
+	case "create":
+		f.role = flags.String("role", models.RoleUser, "role of the new user, user or admin")
+		f.password = flags.String("password", "", "password of the new user (default: read from stdin)")
+		f.noPass = flags.Bool("passwordless", false, "create the user without a password, to log in with magic links")
+	case "list":
+		f.limit = flags.Int("limit", 100, "maximum number of users to list")
+		f.offset = flags.Int("offset", 0, "number of users to skip")
+		nargs = 0
+	case "lock":
+		f.lockFor = flags.Duration("for", 0, "how long to lock the user out, e.g. 24h (default: until unlocked)")
+	case "delete":
+		f.now = flags.Bool("now", false, "delete the user right away, without a grace period")
+	case "set-password":
+		f.password = flags.String("password", "", "new password (default: read from stdin)")
+	case "show", "unlock":
+	default:
+		fmt.Fprint(Stderr, userUsage)
+		return fmt.Errorf("unknown user command %q", command)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		if nargs == 0 {
+			return fmt.Errorf("unexpected arguments %q", flags.Args())
+		}
+		return fmt.Errorf("expected a user ID or email")
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	us, err := newUserService(db)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "create":
+		return userCreate(us, flags.Arg(0), f)
+	case "list":
+		return userList(us, f)
+	}
+
+	target, err := findUser(us.UserRepo, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "show":
+		return userShow(us, target, f)
+	case "lock":
+		return userLock(us, target, f)
+	case "unlock":
+		return userUnlock(us, target, f)
+	case "delete":
+		return userDelete(us, target, f)
+	default:
+		return userSetPassword(us, target, f)
+	}
+}
+
+// userCreate registers a user with a password from the flags or stdin, or
+// without a password
+func userCreate(us *services.UserService, email string, f userFlags) error {
+	if *f.role != models.RoleUser && *f.role != models.RoleAdmin {
+		return fmt.Errorf("unknown role %q, expected %s or %s", *f.role, models.RoleUser, models.RoleAdmin)
+	}
+	if *f.noPass {
+		if *f.password != "" {
+			return fmt.Errorf("-password and -passwordless cannot be combined")
+		}
+		if err := us.RegisterPasswordlessUser(email); err != nil {
+			return err
+		}
+	} else {
+		password, err := readPassword(*f.password)
+		if err != nil {
+			return err
+		}
+		if err := us.RegisterUser(email, password); err != nil {
+			return err
+		}
+	}
+	created, err := us.UserRepo.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if *f.role != models.RoleUser {
+		if err := us.UserRepo.UpdateUser(created.ID.String(), map[string]any{"role": *f.role}); err != nil {
+			return err
+		}
+		created.Role = *f.role
+	}
+	return printUser(created, f)
+}
+
+// userList prints a page of users
+func userList(us *services.UserService, f userFlags) error {
+	users, err := us.UserRepo.ListUsers(*f.limit, *f.offset)
+	if err != nil {
+		return err
+	}
+	if *f.asJSON {
+		list := make([]services.ExportUser, 0, len(users))
+		for i := range users {
+			list = append(list, services.NewExportUser(&users[i]))
+		}
+		return printJSON(list)
+	}
+
+	w := tabwriter.NewWriter(Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tROLE\tSTATUS\tLAST LOGIN")
+	for i := range users {
+		u := &users[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Role, userStatus(u), formatTime(u.LastLogin, "never"))
+	}
+	return w.Flush()
+}
+
+// userShow prints a user and their active sessions
+func userShow(us *services.UserService, target *models.User, f userFlags) error {
+	sessions, err := us.ListSessions(target.ID.String(), uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if *f.asJSON {
+		return printJSON(map[string]any{
+			"user":     services.NewExportUser(target),
+			"sessions": sessions,
+		})
+	}
+
+	if err := printUser(target, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "\n%d active sessions\n", len(sessions))
+	w := tabwriter.NewWriter(Stdout, 0, 4, 2, ' ', 0)
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%s\tcreated %s\texpires %s\n",
+			s.ID, s.CreatedAt.Format(time.RFC3339), s.ExpiresAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// userLock locks a user out, indefinitely unless -for is given, and ends
+// their sessions
+func userLock(us *services.UserService, target *models.User, f userFlags) error {
+	var until *time.Time
+	if *f.lockFor > 0 {
+		t := time.Now().UTC().Add(*f.lockFor)
+		until = &t
+	}
+	if err := us.UserRepo.LockAccountUntil(target.ID.String(), until); err != nil {
+		return err
+	}
+	if err := us.LogoutEverywhere(target.ID.String()); err != nil {
+		return err
+	}
+	return printUpdatedUser(us, target, f)
+}
+
+// userUnlock unlocks a user and resets their failed login count
+func userUnlock(us *services.UserService, target *models.User, f userFlags) error {
+	if err := us.UserRepo.UnlockAccount(target.ID.String()); err != nil {
+		return err
+	}
+	return printUpdatedUser(us, target, f)
+}
+
+// userDelete schedules a user for deletion, or with -now deletes them and
+// records the deletion like the purge job does
+func userDelete(us *services.UserService, target *models.User, f userFlags) error {
+	userID := target.ID.String()
+	if !*f.now {
+		purgeAfter, err := us.RequestAccountDeletion(userID)
+		if err != nil {
+			return err
+		}
+		if *f.asJSON {
+			return printJSON(map[string]any{"id": target.ID, "deleted": false, "purgeAfter": purgeAfter})
+		}
+		fmt.Fprintf(Stdout, "user %s scheduled for deletion after %s\n", target.Email, purgeAfter.Format(time.RFC3339))
+		return nil
+	}
+
+	ar, err := repository.NewAuditRepository(us.UserRepo.DB)
+	if err != nil {
+		return err
+	}
+	if err := us.PermanentlyDeleteUser(userID); err != nil {
+		return err
+	}
+	event := models.NewAuditEvent(models.AuditAccountDeleted, target.ID, "deleted with goauth user delete -now")
+	if err := ar.CreateAuditEvent(event); err != nil {
+		return err
+	}
+	if *f.asJSON {
+		return printJSON(map[string]any{"id": target.ID, "deleted": true})
+	}
+	fmt.Fprintf(Stdout, "user %s deleted\n", target.Email)
+	return nil
+}
+
+// userSetPassword replaces a user's password and ends their sessions
+func userSetPassword(us *services.UserService, target *models.User, f userFlags) error {
+	password, err := readPassword(*f.password)
+	if err != nil {
+		return err
+	}
+	userID := target.ID.String()
+	if err := us.UpdateUser(userID, map[string]any{"password": password}); err != nil {
+		return err
+	}
+	if err := us.LogoutEverywhere(userID); err != nil {
+		return err
+	}
+	if *f.asJSON {
+		return printJSON(map[string]any{"id": target.ID, "passwordChanged": true, "sessionsRevoked": true})
+	}
+	fmt.Fprintf(Stdout, "password changed for %s, all sessions ended\n", target.Email)
+	return nil
+}
+
+// newUserService creates a UserService with repositories on `db`. Commands
+// are run by operators, so errors are never hidden for enumeration resistance.
+func newUserService(db *gorm.DB) (*services.UserService, error) {
+	ur, err := repository.NewUserRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := repository.NewSessionRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	us, err := services.NewUserService(ur, sr)
+	if err != nil {
+		return nil, err
+	}
+	us.EnumerationResistant = false
+	return us, nil
+}
+
+// findUser looks a user up by ID, or by email if `ref` is not a UUID
+func findUser(ur *repository.UserRepository, ref string) (*models.User, error) {
+	var found *models.User
+	var err error
+	if _, parseErr := uuid.Parse(ref); parseErr == nil {
+		found, err = ur.GetUserByID(ref)
+	} else {
+		found, err = ur.GetUserByEmail(ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", apperrors.ErrUserNotFound, ref)
+	}
+	return found, nil
+}
+
+// readPassword returns the password from a flag, or else the first line of Stdin
+func readPassword(fromFlag string) (string, error) {
+	if fromFlag != "" {
+		return fromFlag, nil
+	}
+	line, err := bufio.NewReader(Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil {
+			return "", fmt.Errorf("no password given, pass -password or write it to stdin: %w", err)
+		}
+		return "", apperrors.ErrPasswordIsEmpty
+	}
+	return password, nil
+}
+
+// printUpdatedUser reloads a user after a change and prints them
+func printUpdatedUser(us *services.UserService, target *models.User, f userFlags) error {
+	updated, err := us.UserRepo.GetUserByID(target.ID.String())
+	if err != nil {
+		return err
+	}
+	return printUser(updated, f)
+}
+
+// printUser prints a user's profile, without credentials
+func printUser(u *models.User, f userFlags) error {
+	if *f.asJSON {
+		return printJSON(services.NewExportUser(u))
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%s\n", u.ID)
+	fmt.Fprintf(w, "email:\t%s\n", u.Email)
+	fmt.Fprintf(w, "role:\t%s\n", u.Role)
+	fmt.Fprintf(w, "status:\t%s\n", userStatus(u))
+	if u.AccountLocked {
+		fmt.Fprintf(w, "locked until:\t%s\n", formatTime(u.AccountLockedUntil, "unlocked"))
+	}
+	if u.PendingDeletion() {
+		fmt.Fprintf(w, "deleted after:\t%s\n", formatTime(u.PurgeAfter, "unknown"))
+	}
+	fmt.Fprintf(w, "failed logins:\t%d\n", u.FailedLoginAttempts)
+	fmt.Fprintf(w, "last login:\t%s\n", formatTime(u.LastLogin, "never"))
+	fmt.Fprintf(w, "password breached:\t%t\n", u.PasswordBreached)
+	return w.Flush()
+}
+
+// userStatus summarizes whether a user can log in
+func userStatus(u *models.User) string {
+	switch {
+	case u.PendingDeletion():
+		return "pending deletion"
+	case u.AccountLocked:
+		return "locked"
+	default:
+		return "active"
+	}
+}
+
+// formatTime formats an optional time, or returns `unset` if there is none
+func formatTime(t *time.Time, unset string) string {
+	if t == nil {
+		return unset
+	}
+	return t.UTC().Format(time.RFC3339)
+}
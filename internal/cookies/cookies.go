@@ -0,0 +1,246 @@
+package cookies
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Cookie name prefixes that browsers enforce. A `__Secure-` cookie must be
+// Secure, and a `__Host-` cookie must also have no Domain and the path `/`,
+// so a sibling subdomain or an insecure page cannot overwrite it.
+// Ref: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Set-Cookie#cookie_prefixes
+const (
+	PrefixHost   = "__Host-"
+	PrefixSecure = "__Secure-"
+)
+
+// Manager sets and reads the service's cookies with one set of attributes.
+// Cookies are written with net/http, so it works with any http.ResponseWriter,
+// including gin's `c.Writer`.
+type Manager struct {
+	Prefix   string
+	Domain   string
+	Path     string
+	SameSite http.SameSite
+	Secure   bool
+	// ExpiryCookie enables a companion cookie, readable by scripts, holding
+	// the session's expiry time so a SPA can tell when to log in again
+	ExpiryCookie bool
+}
+
+// NewManager returns a Manager with the default attributes: host-only,
+// path `/`, SameSite=Lax and Secure, without a prefix or the expiry cookie
+func NewManager() *Manager {
+	return &Manager{
+		Path:     config.DefaultCookiePath,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+	}
+}
+
+// NewManagerFromEnv builds a Manager from the cookie env variables
+func NewManagerFromEnv() (*Manager, error) {
+	m := NewManager()
+
+	switch prefix := os.Getenv(config.CookiePrefix); prefix {
+	case "":
+	case "host":
+		m.Prefix = PrefixHost
+	case "secure":
+		m.Prefix = PrefixSecure
+	default:
+		return nil, fmt.Errorf("%w: %s must be `host` or `secure`, got %q", apperrors.ErrCookieConfig, config.CookiePrefix, prefix)
+	}
+
+	m.Domain = os.Getenv(config.CookieDomain)
+	if path := os.Getenv(config.CookiePath); path != "" {
+		m.Path = path
+	}
+
+	switch sameSite := strings.ToLower(os.Getenv(config.CookieSameSite)); sameSite {
+	case "", "lax":
+		m.SameSite = http.SameSiteLaxMode
+	case "strict":
+		m.SameSite = http.SameSiteStrictMode
+	case "none":
+		m.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("%w: %s must be `lax`, `strict` or `none`, got %q", apperrors.ErrCookieConfig, config.CookieSameSite, sameSite)
+	}
+
+	m.Secure = os.Getenv(config.CookieSecure) != "false"
+	m.ExpiryCookie = os.Getenv(config.SessionExpiryCookie) == "true"
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks that the attributes are consistent with the prefix and
+// would be accepted by browsers
+func (m *Manager) Validate() error {
+	if m.Prefix != "" && m.Prefix != PrefixHost && m.Prefix != PrefixSecure {
+		return fmt.Errorf("%w: unknown prefix %q", apperrors.ErrCookieConfig, m.Prefix)
+	}
+	if m.Prefix != "" && !m.Secure {
+		return fmt.Errorf("%w: %s cookies must be Secure", apperrors.ErrCookieConfig, m.Prefix)
+	}
+	if m.Prefix == PrefixHost && (m.Domain != "" || m.Path != "/") {
+		return fmt.Errorf("%w: %s cookies must have no domain and the path `/`", apperrors.ErrCookieConfig, m.Prefix)
+	}
+	if m.SameSite == http.SameSiteNoneMode && !m.Secure {
+		return fmt.Errorf("%w: SameSite=None cookies must be Secure", apperrors.ErrCookieConfig)
+	}
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("%w: path %q must start with `/`", apperrors.ErrCookieConfig, m.Path)
+	}
+	if strings.ContainsAny(m.Domain, "/:; ") {
+		return fmt.Errorf("%w: domain %q must be a bare host name", apperrors.ErrCookieConfig, m.Domain)
+	}
+	return nil
+}
+
+// SessionCookieName returns the prefixed name of the session cookie
+func (m *Manager) SessionCookieName() string {
+	return m.Prefix + config.SessionCookieName
+}
+
+// CSRFCookieName returns the prefixed name of the CSRF cookie
+func (m *Manager) CSRFCookieName() string {
+	return m.Prefix + config.CSRFCookieName
+}
+
+// ExpiryCookieName returns the prefixed name of the session expiry cookie
+func (m *Manager) ExpiryCookieName() string {
+	return m.Prefix + config.SessionExpiryCookieName
+}
+
+// MagicLinkCookieName returns the prefixed name of the cookie binding magic
+// links to the browser that asked for them
+func (m *Manager) MagicLinkCookieName() string {
+	return m.Prefix + config.MagicLinkCookieName
+}
+
+// SessionToken returns the session token from the request's session cookie
+func (m *Manager) SessionToken(r *http.Request) (string, error) {
+	return value(r, m.SessionCookieName())
+}
+
+// CSRFToken returns the token from the request's CSRF cookie
+func (m *Manager) CSRFToken(r *http.Request) (string, error) {
+	return value(r, m.CSRFCookieName())
+}
+
+// MagicLinkToken returns the browser token from the request's magic link cookie
+func (m *Manager) MagicLinkToken(r *http.Request) (string, error) {
+	return value(r, m.MagicLinkCookieName())
+}
+
+// SetSession sets the session cookie to expire with the session, and the
+// expiry cookie if enabled
+func (m *Manager) SetSession(w http.ResponseWriter, token string, expiresAt time.Time) {
+	maxAge := int(time.Until(expiresAt).Seconds())
+	if maxAge <= 0 {
+		m.ClearSession(w)
+		return
+	}
+	m.set(w, m.SessionCookieName(), token, maxAge, true)
+	if m.ExpiryCookie {
+		m.set(w, m.ExpiryCookieName(), strconv.FormatInt(expiresAt.Unix(), 10), maxAge, false)
+	}
+}
+
+// ClearSession expires the session cookie and the expiry cookie
+func (m *Manager) ClearSession(w http.ResponseWriter) {
+	m.set(w, m.SessionCookieName(), "", -1, true)
+	if m.ExpiryCookie {
+		m.set(w, m.ExpiryCookieName(), "", -1, false)
+	}
+}
+
+// SetCSRF sets the CSRF cookie. Its token is handed to the client in a
+// response body, so scripts do not need to read the cookie.
+func (m *Manager) SetCSRF(w http.ResponseWriter, token string) {
+	m.set(w, m.CSRFCookieName(), token, config.SessionExpiration, true)
+}
+
+// SetMagicLink sets the cookie binding magic links to this browser, for as
+// long as the links can be used
+func (m *Manager) SetMagicLink(w http.ResponseWriter, token string) {
+	m.set(w, m.MagicLinkCookieName(), token, int(config.MagicLinkExpiration.Seconds()), true)
+}
+
+// ClearMagicLink expires the magic link cookie
+func (m *Manager) ClearMagicLink(w http.ResponseWriter) {
+	m.set(w, m.MagicLinkCookieName(), "", -1, true)
+}
+
+// set writes a cookie with the manager's attributes
+func (m *Manager) set(w http.ResponseWriter, name, value string, maxAge int, httpOnly bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Secure:   m.Secure,
+		HttpOnly: httpOnly,
+		SameSite: m.SameSite,
+	})
+}
+
+// value returns the value of the request's cookie `name`
+func value(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+var (
+	defaultManager *Manager
+	defaultMu      sync.RWMutex
+)
+
+// Default returns the process-wide Manager, building it from the environment
+// on first use. Invalid configuration falls back to the built-in defaults.
+func Default() *Manager {
+	defaultMu.RLock()
+	m := defaultManager
+	defaultMu.RUnlock()
+	if m != nil {
+		return m
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultManager == nil {
+		m, err := NewManagerFromEnv()
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid cookie config, using defaults")
+			m = NewManager()
+		}
+		defaultManager = m
+	}
+	return defaultManager
+}
+
+// SetDefault replaces the process-wide Manager. Passing nil resets it so the
+// next call to Default rebuilds it from the environment.
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
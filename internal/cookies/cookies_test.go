@@ -0,0 +1,205 @@
+package cookies_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/cookies"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestMain sets up the test environment for all tests in the `cookies_test` package.
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+
+	os.Exit(m.Run())
+}
+
+// findCookie returns the cookie `name` set on the response
+func findCookie(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// TestManager_SetSession tests the session cookie's attributes, the expiry
+// companion cookie, and reading the token back
+func TestManager_SetSession(t *testing.T) {
+	is := is.New(t)
+
+	m := cookies.NewManager()
+	m.Prefix = cookies.PrefixHost
+	m.SameSite = http.SameSiteStrictMode
+	m.ExpiryCookie = true
+	is.NoErr(m.Validate())
+	is.Equal(m.SessionCookieName(), "__Host-"+config.SessionCookieName)
+
+	expiresAt := time.Now().Add(time.Hour)
+	rr := httptest.NewRecorder()
+	m.SetSession(rr, "token", expiresAt)
+
+	session := findCookie(rr, m.SessionCookieName())
+	is.True(session != nil)
+	is.Equal(session.Value, "token")
+	is.Equal(session.Path, "/")
+	is.Equal(session.Domain, "")
+	is.True(session.Secure)
+	is.True(session.HttpOnly)
+	is.Equal(session.SameSite, http.SameSiteStrictMode)
+	is.True(session.MaxAge > 3590 && session.MaxAge <= 3600)
+
+	expiry := findCookie(rr, m.ExpiryCookieName())
+	is.True(expiry != nil)
+	is.True(!expiry.HttpOnly) // readable by scripts
+	is.Equal(expiry.Value, strconv.FormatInt(expiresAt.Unix(), 10))
+	is.Equal(expiry.MaxAge, session.MaxAge)
+
+	t.Run("read back", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(session)
+		token, err := m.SessionToken(req)
+		is.NoErr(err)
+		is.Equal(token, "token")
+
+		// The unprefixed name is a different cookie
+		req = httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: config.SessionCookieName, Value: "token"})
+		_, err = m.SessionToken(req)
+		is.Equal(err, http.ErrNoCookie)
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		m.ClearSession(rr)
+		is.Equal(findCookie(rr, m.SessionCookieName()).MaxAge, -1)
+		is.Equal(findCookie(rr, m.ExpiryCookieName()).MaxAge, -1)
+	})
+
+	t.Run("expired session clears the cookie", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		m.SetSession(rr, "token", time.Now().Add(-time.Minute))
+		is.Equal(findCookie(rr, m.SessionCookieName()).MaxAge, -1)
+	})
+
+	t.Run("no expiry cookie unless enabled", func(t *testing.T) {
+		m := cookies.NewManager()
+		rr := httptest.NewRecorder()
+		m.SetSession(rr, "token", expiresAt)
+		is.True(findCookie(rr, m.SessionCookieName()) != nil)
+		is.True(findCookie(rr, m.ExpiryCookieName()) == nil)
+	})
+}
+
+// TestManager_SetMagicLink tests the magic link cookie lasts as long as the
+// links, is hidden from scripts, and can be read back and cleared
+func TestManager_SetMagicLink(t *testing.T) {
+	is := is.New(t)
+
+	m := cookies.NewManager()
+	m.Prefix = cookies.PrefixSecure
+	is.Equal(m.MagicLinkCookieName(), "__Secure-"+config.MagicLinkCookieName)
+
+	rr := httptest.NewRecorder()
+	m.SetMagicLink(rr, "browser")
+	cookie := findCookie(rr, m.MagicLinkCookieName())
+	is.True(cookie != nil)
+	is.True(cookie.HttpOnly)
+	is.True(cookie.Secure)
+	is.Equal(cookie.MaxAge, int(config.MagicLinkExpiration.Seconds()))
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.AddCookie(cookie)
+	token, err := m.MagicLinkToken(req)
+	is.NoErr(err)
+	is.Equal(token, "browser")
+
+	rr = httptest.NewRecorder()
+	m.ClearMagicLink(rr)
+	is.Equal(findCookie(rr, m.MagicLinkCookieName()).MaxAge, -1)
+}
+
+// TestManager_Validate tests that attributes browsers would reject are refused
+func TestManager_Validate(t *testing.T) {
+	is := is.New(t)
+
+	invalid := map[string]*cookies.Manager{
+		"host prefix with domain":   {Prefix: cookies.PrefixHost, Domain: "example.com", Path: "/", Secure: true},
+		"host prefix with path":     {Prefix: cookies.PrefixHost, Path: "/auth", Secure: true},
+		"host prefix without TLS":   {Prefix: cookies.PrefixHost, Path: "/"},
+		"secure prefix without TLS": {Prefix: cookies.PrefixSecure, Path: "/"},
+		"unknown prefix":            {Prefix: "__Other-", Path: "/", Secure: true},
+		"SameSite=None without TLS": {Path: "/", SameSite: http.SameSiteNoneMode},
+		"relative path":             {Path: "auth", Secure: true},
+		"domain with scheme":        {Domain: "https://example.com", Path: "/", Secure: true},
+	}
+	for name, m := range invalid {
+		t.Run(name, func(t *testing.T) {
+			is.True(errors.Is(m.Validate(), apperrors.ErrCookieConfig))
+		})
+	}
+
+	valid := &cookies.Manager{Prefix: cookies.PrefixSecure, Domain: "example.com", Path: "/auth", Secure: true}
+	is.NoErr(valid.Validate())
+}
+
+// TestNewManagerFromEnv tests building the cookie settings from the environment
+func TestNewManagerFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("defaults", func(t *testing.T) {
+		m, err := cookies.NewManagerFromEnv()
+		is.NoErr(err)
+		is.Equal(m, cookies.NewManager())
+		is.Equal(m.SessionCookieName(), config.SessionCookieName)
+	})
+
+	t.Run("subdomain SSO", func(t *testing.T) {
+		t.Setenv(config.CookiePrefix, "secure")
+		t.Setenv(config.CookieDomain, "example.com")
+		t.Setenv(config.CookieSameSite, "Strict")
+		t.Setenv(config.SessionExpiryCookie, "true")
+		m, err := cookies.NewManagerFromEnv()
+		is.NoErr(err)
+		is.Equal(m.Prefix, cookies.PrefixSecure)
+		is.Equal(m.Domain, "example.com")
+		is.Equal(m.SameSite, http.SameSiteStrictMode)
+		is.True(m.ExpiryCookie)
+	})
+
+	t.Run("local development over HTTP", func(t *testing.T) {
+		t.Setenv(config.CookieSecure, "false")
+		m, err := cookies.NewManagerFromEnv()
+		is.NoErr(err)
+		is.True(!m.Secure)
+	})
+
+	invalid := map[string]map[string]string{
+		"unknown prefix":   {config.CookiePrefix: "__Host-"},
+		"unknown SameSite": {config.CookieSameSite: "sometimes"},
+		"host prefix with path": {
+			config.CookiePrefix: "host",
+			config.CookiePath:   "/auth",
+		},
+	}
+	for name, env := range invalid {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			_, err := cookies.NewManagerFromEnv()
+			is.True(errors.Is(err, apperrors.ErrCookieConfig))
+		})
+	}
+}
@@ -1,12 +1,22 @@
 package database
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 
 	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
 )
 
+// EmailCollision is a canonical email shared by more than one user
+type EmailCollision struct {
+	Canonical string
+	Emails    []string
+}
+
 // Migrate automigrates the database according to the User and Session models
 func Migrate(db *gorm.DB) error {
 	// uuid extension
@@ -15,17 +25,197 @@ func Migrate(db *gorm.DB) error {
 		return err
 	}
 
+	// backfill canonical emails before the unique index is created
+	if err := migrateEmailCanonical(db); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating canonical emails")
+		return err
+	}
+
 	// make User migrations
 	if err := db.AutoMigrate(&models.User{}); err != nil {
 		log.Fatal().Err(err).Msg("Error migrating User model")
 		return err
 	}
 
+	// hash the tokens of sessions created before token hashes were stored
+	if err := migrateSessionTokenHash(db); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating session tokens")
+		return err
+	}
+
+	// treat existing sessions as authenticated when they were created
+	if err := migrateSessionAuthTime(db); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating session auth times")
+		return err
+	}
+
 	// make Session migrations
 	if err := db.AutoMigrate(&models.Session{}); err != nil {
 		log.Fatal().Err(err).Msg("Error migrating Session model")
 		return err
 	}
 
+	// make PasswordHistory migrations
+	if err := db.AutoMigrate(&models.PasswordHistory{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating PasswordHistory model")
+		return err
+	}
+
+	// make AuditEvent migrations
+	if err := db.AutoMigrate(&models.AuditEvent{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating AuditEvent model")
+		return err
+	}
+
+	// make DataExport migrations
+	if err := db.AutoMigrate(&models.DataExport{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating DataExport model")
+		return err
+	}
+
+	// make AccessToken migrations
+	if err := db.AutoMigrate(&models.AccessToken{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating AccessToken model")
+		return err
+	}
+
+	// make ServiceAccount migrations
+	if err := db.AutoMigrate(&models.ServiceAccount{}, &models.ServiceAccountToken{}, &models.ClientAssertion{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating ServiceAccount models")
+		return err
+	}
+
+	// make Webhook migrations
+	if err := db.AutoMigrate(&models.WebhookSubscription{}, &models.WebhookEvent{}, &models.WebhookDelivery{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating Webhook models")
+		return err
+	}
+
+	// make MagicLinkToken migrations
+	if err := db.AutoMigrate(&models.MagicLinkToken{}); err != nil {
+		log.Fatal().Err(err).Msg("Error migrating MagicLinkToken model")
+		return err
+	}
+
+	return nil
+}
+
+// migrateEmailCanonical adds and fills `users.email_canonical` for users
+// created before the column existed. Users whose emails now share a canonical
+// form are reported and the migration stops, since creating the unique index
+// would fail and picking which account to keep is up to an operator.
+func migrateEmailCanonical(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.User{}) {
+		// A new database gets the column and index from AutoMigrate
+		return nil
+	}
+	if !migrator.HasColumn(&models.User{}, "EmailCanonical") {
+		if err := db.Exec(`ALTER TABLE users ADD COLUMN email_canonical varchar(255)`).Error; err != nil {
+			return err
+		}
+	}
+
+	// Normalization runs in Go, so fill the column row by row
+	var users []models.User
+	result := db.Select("id", "email").
+		Where("email_canonical IS NULL OR email_canonical = ''").
+		FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+			for _, user := range users {
+				err := tx.Model(&models.User{}).Where("id = ?", user.ID).
+					UpdateColumn("email_canonical", models.CanonicalEmail(user.Email)).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	collisions, err := FindEmailCollisions(db)
+	if err != nil {
+		return err
+	}
+	for _, c := range collisions {
+		log.Error().
+			Str("canonical", c.Canonical).
+			Str("emails", strings.Join(c.Emails, ", ")).
+			Msg("Users share a canonical email")
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("%w: %d collisions", apperrors.ErrEmailCollisions, len(collisions))
+	}
 	return nil
 }
+
+// migrateSessionTokenHash converts sessions from before `sessions.token_hash`
+// existed, when the token was `id.signature` and the ID was stored as is.
+// The ID was the token's secret, so it is hashed into `token_hash` and
+// replaced with a new random public ID. Existing cookies keep working.
+func migrateSessionTokenHash(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Session{}) || migrator.HasColumn(&models.Session{}, "TokenHash") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`ALTER TABLE sessions ADD COLUMN token_hash char(64)`).Error; err != nil {
+			return err
+		}
+		result := tx.Exec(`UPDATE sessions SET token_hash = encode(sha256(id::text::bytea), 'hex'), id = uuid_generate_v4()`)
+		if result.Error != nil {
+			return result.Error
+		}
+		log.Info().Int64("sessions", result.RowsAffected).Msg("Hashed existing session tokens")
+		return tx.Exec(`ALTER TABLE sessions ALTER COLUMN token_hash SET NOT NULL`).Error
+	})
+}
+
+// migrateSessionAuthTime adds `sessions.auth_time` for sessions created before
+// it existed. They are given their creation time rather than the column
+// default of now(), so old sessions do not count as recently authenticated.
+func migrateSessionAuthTime(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Session{}) || migrator.HasColumn(&models.Session{}, "AuthTime") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`ALTER TABLE sessions ADD COLUMN auth_time timestamp`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE sessions SET auth_time = created_at`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`ALTER TABLE sessions ALTER COLUMN auth_time SET NOT NULL`).Error
+	})
+}
+
+// FindEmailCollisions returns every canonical email used by more than one user
+func FindEmailCollisions(db *gorm.DB) ([]EmailCollision, error) {
+	var rows []struct {
+		EmailCanonical string
+		Email          string
+	}
+	err := db.Model(&models.User{}).
+		Select("email_canonical", "email").
+		Where("email_canonical IN (?)", db.Model(&models.User{}).
+			Select("email_canonical").
+			Group("email_canonical").
+			Having("COUNT(*) > 1")).
+		Order("email_canonical, email").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var collisions []EmailCollision
+	for _, row := range rows {
+		if n := len(collisions); n > 0 && collisions[n-1].Canonical == row.EmailCanonical {
+			collisions[n-1].Emails = append(collisions[n-1].Emails, row.Email)
+			continue
+		}
+		collisions = append(collisions, EmailCollision{Canonical: row.EmailCanonical, Emails: []string{row.Email}})
+	}
+	return collisions, nil
+}
@@ -0,0 +1,101 @@
+package database_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/database"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/testutils"
+)
+
+// TestFindEmailCollisions tests users sharing a canonical email are reported
+func TestFindEmailCollisions(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	t.Run("none on a migrated database", func(t *testing.T) {
+		collisions, err := database.FindEmailCollisions(tx)
+		is.NoErr(err)
+		is.Equal(len(collisions), 0)
+	})
+
+	t.Run("reports users created before canonical emails", func(t *testing.T) {
+		// Simulate rows from before the unique index existed. DDL is
+		// transactional in postgres, so the rollback restores the index.
+		is.NoErr(tx.Exec(`DROP INDEX idx_users_email_canonical`).Error)
+		for _, email := range []string{"Bob@Example.com", "bob@example.com", "alice@example.com"} {
+			is.NoErr(tx.Create(&models.User{Email: email, Password: "password"}).Error)
+		}
+
+		collisions, err := database.FindEmailCollisions(tx)
+		is.NoErr(err)
+		is.Equal(len(collisions), 1)
+		is.Equal(collisions[0].Canonical, "bob@example.com")
+		emails := collisions[0].Emails
+		sort.Strings(emails)
+		is.Equal(emails, []string{"Bob@Example.com", "bob@example.com"})
+	})
+}
+
+// TestMigrate_SessionTokenHash tests that sessions stored by their raw token
+// ID are converted to hashed tokens with a new public ID
+func TestMigrate_SessionTokenHash(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	user, err := models.NewUser("testMigrateSessionTokenHash@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(tx.Create(user).Error)
+
+	// Simulate a session from before token hashes, whose token was `id.signature`
+	is.NoErr(tx.Exec(`ALTER TABLE sessions DROP COLUMN token_hash`).Error)
+	oldID := uuid.New()
+	err = tx.Exec(`INSERT INTO sessions (id, user_id, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		oldID, user.ID, time.Now().UTC().Add(time.Hour), time.Now().UTC()).Error
+	is.NoErr(err)
+
+	is.NoErr(database.Migrate(tx))
+
+	// The old secret now only exists as a hash, under a new public ID
+	var session models.Session
+	is.NoErr(tx.Where("user_id = ?", user.ID).First(&session).Error)
+	is.Equal(session.TokenHash, models.HashSessionSecret(oldID.String()))
+	is.True(session.ID != oldID)
+}
+
+// TestMigrate_SessionAuthTime tests that sessions from before auth times were
+// recorded count as authenticated when they were created
+func TestMigrate_SessionAuthTime(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	user, err := models.NewUser("testMigrateSessionAuthTime@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(tx.Create(user).Error)
+
+	is.NoErr(tx.Exec(`ALTER TABLE sessions DROP COLUMN auth_time`).Error)
+	createdAt := time.Now().UTC().Add(-72 * time.Hour).Truncate(time.Second)
+	err = tx.Exec(`INSERT INTO sessions (id, token_hash, user_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		uuid.New(), models.HashSessionSecret("secret"), user.ID, time.Now().UTC().Add(time.Hour), createdAt).Error
+	is.NoErr(err)
+
+	is.NoErr(database.Migrate(tx))
+
+	var session models.Session
+	is.NoErr(tx.Where("user_id = ?", user.ID).First(&session).Error)
+	is.True(session.AuthTime.Equal(createdAt))
+}
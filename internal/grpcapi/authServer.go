@@ -0,0 +1,350 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+	goauthv1 "github.com/al-ce/goauth/pkg/proto/goauth/v1"
+)
+
+// AuthServer implements the goauth.v1 AuthService. Methods that need a
+// session read the caller from the context set by UnaryAuthInterceptor.
+type AuthServer struct {
+	goauthv1.UnimplementedAuthServiceServer
+	UserService          *services.UserService
+	IntrospectionService *services.IntrospectionService
+}
+
+// NewAuthServer returns a value of type AuthServer
+func NewAuthServer(us *services.UserService, ins *services.IntrospectionService) (*AuthServer, error) {
+	if us == nil {
+		return nil, apperrors.ErrUserServiceIsNil
+	}
+	if ins == nil {
+		return nil, apperrors.ErrIntrospectionServiceIsNil
+	}
+	return &AuthServer{UserService: us, IntrospectionService: ins}, nil
+}
+
+// Register creates a user, like POST /register
+func (as *AuthServer) Register(ctx context.Context, req *goauthv1.RegisterRequest) (*goauthv1.RegisterResponse, error) {
+	clientIP := clientIP(ctx)
+
+	if req.GetEmail() == "" || req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, apperrors.ErrMissingCredentials.Error())
+	}
+
+	if err := as.UserService.RegisterUser(req.GetEmail(), req.GetPassword()); err != nil {
+		log.Info().
+			Str("email", req.GetEmail()).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("User registration failed")
+		return nil, statusError(err)
+	}
+
+	log.Info().
+		Str("email", req.GetEmail()).
+		Str("clientIP", clientIP).
+		Msg("User registration success")
+
+	// The same answer whether or not the email was already registered
+	if as.UserService.EnumerationResistant {
+		return &goauthv1.RegisterResponse{Message: "Check your email to continue"}, nil
+	}
+	return &goauthv1.RegisterResponse{Message: fmt.Sprintf("User %s created", req.GetEmail())}, nil
+}
+
+// Login creates a session and returns its token, like POST /login
+func (as *AuthServer) Login(ctx context.Context, req *goauthv1.LoginRequest) (*goauthv1.LoginResponse, error) {
+	clientIP := clientIP(ctx)
+
+	if req.GetEmail() == "" || req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, apperrors.ErrMissingCredentials.Error())
+	}
+
+	sessionToken, err := as.UserService.LoginUser(req.GetEmail(), req.GetPassword())
+	if err != nil {
+		log.Info().
+			Str("email", req.GetEmail()).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Login failed")
+		return nil, statusError(err)
+	}
+	expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
+
+	log.Info().
+		Str("email", req.GetEmail()).
+		Str("clientIP", clientIP).
+		Msg("login success")
+
+	return &goauthv1.LoginResponse{
+		SessionToken: sessionToken,
+		ExpiresAt:    timestamppb.New(expiresAt),
+	}, nil
+}
+
+// Logout ends the session in the metadata, like POST /logout
+func (as *AuthServer) Logout(ctx context.Context, req *goauthv1.LogoutRequest) (*goauthv1.LogoutResponse, error) {
+	clientIP := clientIP(ctx)
+
+	sessionToken, ok := sessionToken(ctx)
+	if !ok {
+		log.Info().
+			Str("clientIP", clientIP).
+			Msg("Session token not found")
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+
+	if err := as.UserService.Logout(sessionToken); err != nil {
+		log.Error().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Logout failed")
+		return nil, statusError(err)
+	}
+
+	log.Info().
+		Str("clientIP", clientIP).
+		Msg("Logout success")
+
+	return &goauthv1.LogoutResponse{}, nil
+}
+
+// LogoutEverywhere ends every session of the caller, like POST /logouteverywhere
+func (as *AuthServer) LogoutEverywhere(ctx context.Context, req *goauthv1.LogoutEverywhereRequest) (*goauthv1.LogoutEverywhereResponse, error) {
+	id, err := callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID := id.UserID.String()
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP(ctx)).
+		Str("action", "logout_everywhere").
+		Msg("User logged out from all devices")
+
+	if err := as.UserService.LogoutEverywhere(userID); err != nil {
+		return nil, statusError(err)
+	}
+	return &goauthv1.LogoutEverywhereResponse{}, nil
+}
+
+// WhoAmI returns the caller's profile, like GET /whoami, or the service
+// account making the call
+func (as *AuthServer) WhoAmI(ctx context.Context, req *goauthv1.WhoAmIRequest) (*goauthv1.WhoAmIResponse, error) {
+	clientIP := clientIP(ctx)
+
+	id, err := callerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id.IsServiceAccount() {
+		log.Info().
+			Str("clientIP", clientIP).
+			Msg("service account profile request successful")
+
+		return &goauthv1.WhoAmIResponse{
+			PrincipalType:    id.Principal(),
+			ServiceAccountId: id.ServiceAccountID.String(),
+			ClientId:         id.ClientID,
+			Scopes:           id.Scopes,
+		}, nil
+	}
+	userID := id.UserID.String()
+
+	userProfile, err := as.UserService.GetUserProfile(userID)
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to get user profile")
+		return nil, statusError(err)
+	}
+
+	log.Info().
+		Str("clientIP", clientIP).
+		Msg("user profile request successful")
+
+	response := &goauthv1.WhoAmIResponse{
+		PrincipalType:    id.Principal(),
+		UserId:           userID,
+		Email:            userProfile.Email,
+		PasswordBreached: userProfile.PasswordBreached,
+	}
+	if userProfile.LastLogin != nil {
+		response.LastLogin = timestamppb.New(*userProfile.LastLogin)
+	}
+	return response, nil
+}
+
+// UpdateUser changes the caller's email or password, like POST /updateuser
+func (as *AuthServer) UpdateUser(ctx context.Context, req *goauthv1.UpdateUserRequest) (*goauthv1.UpdateUserResponse, error) {
+	clientIP := clientIP(ctx)
+
+	id, err := callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID := id.UserID.String()
+
+	requestData := make(map[string]any)
+	if req.GetEmail() != "" {
+		if _, err := mail.ParseAddress(req.GetEmail()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, apperrors.ErrEmailFormat.Error())
+		}
+		requestData["email"] = req.GetEmail()
+	}
+	if req.GetPassword() != "" {
+		requestData["password"] = req.GetPassword()
+	}
+	if len(requestData) == 0 {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Msg("attempt to update user with empty value")
+		return nil, status.Error(codes.InvalidArgument, "no valid fields provided")
+	}
+
+	// A session alone is not enough to replace the password
+	if req.GetPassword() != "" {
+		if req.GetCurrentPassword() == "" {
+			return nil, status.Error(codes.InvalidArgument, apperrors.ErrCurrentPasswordRequired.Error())
+		}
+		if err := as.UserService.CheckPassword(userID, req.GetCurrentPassword()); err != nil {
+			log.Info().
+				Str("userID", userID).
+				Str("clientIP", clientIP).
+				Str("error", err.Error()).
+				Msg("Password change with wrong current password")
+			return nil, statusError(err)
+		}
+	}
+
+	if err := as.UserService.UpdateUser(userID, requestData); err != nil {
+		log.Error().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to update user")
+		return nil, statusError(err)
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Msg("successfully updated user")
+
+	return &goauthv1.UpdateUserResponse{}, nil
+}
+
+// DeleteAccount schedules the caller's account for deletion, like
+// DELETE /deleteaccount
+func (as *AuthServer) DeleteAccount(ctx context.Context, req *goauthv1.DeleteAccountRequest) (*goauthv1.DeleteAccountResponse, error) {
+	clientIP := clientIP(ctx)
+
+	id, err := callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID := id.UserID.String()
+
+	purgeAfter, err := as.UserService.RequestAccountDeletion(userID)
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to schedule user deletion")
+		return nil, statusError(err)
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Time("purgeAfter", purgeAfter).
+		Msg("successfully scheduled user deletion")
+
+	return &goauthv1.DeleteAccountResponse{PurgeAfter: timestamppb.New(purgeAfter)}, nil
+}
+
+// ValidateSession reports whether a session token is active and whose it is,
+// like POST /introspect. The caller authenticates as an introspection client
+// with `Basic <id:secret>` in the `authorization` metadata.
+func (as *AuthServer) ValidateSession(ctx context.Context, req *goauthv1.ValidateSessionRequest) (*goauthv1.ValidateSessionResponse, error) {
+	clientIP := clientIP(ctx)
+
+	clientID, secret, ok := basicCredentials(ctx)
+	if !ok || !as.IntrospectionService.AuthenticateClient(clientID, secret) {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("clientID", clientID).
+			Msg("Introspection client authentication failed")
+		return nil, status.Error(codes.Unauthenticated, apperrors.ErrIntrospectionClient.Error())
+	}
+
+	if req.GetSessionToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, apperrors.ErrSessionIdIsEmpty.Error())
+	}
+
+	result, err := as.IntrospectionService.Introspect(clientID, req.GetSessionToken())
+	if err != nil {
+		log.Error().
+			Str("clientIP", clientIP).
+			Str("clientID", clientID).
+			Str("error", err.Error()).
+			Msg("Failed to validate session")
+		return nil, status.Error(codes.Internal, apperrors.ErrIntrospectionFailed.Error())
+	}
+	if !result.Active {
+		return &goauthv1.ValidateSessionResponse{Active: false}, nil
+	}
+	return &goauthv1.ValidateSessionResponse{
+		Active:    true,
+		UserId:    result.Subject,
+		Email:     result.Email,
+		Roles:     result.Roles,
+		ExpiresAt: timestamppb.New(time.Unix(result.ExpiresAt, 0)),
+	}, nil
+}
+
+// callerIdentity returns the caller authenticated by UnaryAuthInterceptor
+func callerIdentity(ctx context.Context) (identity.Identity, error) {
+	id, ok := identity.FromContext(ctx)
+	if !ok {
+		log.Info().
+			Str("clientIP", clientIP(ctx)).
+			Msg("identity not found in context")
+		return identity.Identity{}, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return id, nil
+}
+
+// callerUser returns the user authenticated by UnaryAuthInterceptor, refusing
+// service accounts like RequireAuth's user routes
+func callerUser(ctx context.Context) (identity.Identity, error) {
+	id, err := callerIdentity(ctx)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	if id.IsServiceAccount() {
+		log.Info().
+			Str("serviceAccountID", id.ServiceAccountID.String()).
+			Str("clientIP", clientIP(ctx)).
+			Msg("Service account denied user method")
+		return identity.Identity{}, status.Error(codes.PermissionDenied, apperrors.ErrUserRequired.Error())
+	}
+	return id, nil
+}
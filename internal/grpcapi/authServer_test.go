@@ -0,0 +1,241 @@
+package grpcapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/al-ce/goauth/internal/grpcapi"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/server"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	goauthv1 "github.com/al-ce/goauth/pkg/proto/goauth/v1"
+)
+
+func TestAuthServer_Register(t *testing.T) {
+	is := is.New(t)
+	_, client := setupClient(t)
+	ctx := context.Background()
+
+	email := "TestAuthServer_Register@test.com"
+	resp, err := client.Register(ctx, &goauthv1.RegisterRequest{Email: email, Password: testutils.TestingPassword})
+	is.NoErr(err)
+	is.Equal(resp.GetMessage(), "User "+email+" created")
+
+	t.Run("duplicate email", func(t *testing.T) {
+		_, err := client.Register(ctx, &goauthv1.RegisterRequest{Email: email, Password: testutils.TestingPassword})
+		is.Equal(status.Code(err), codes.AlreadyExists)
+	})
+
+	t.Run("password policy violations are in the details", func(t *testing.T) {
+		_, err := client.Register(ctx, &goauthv1.RegisterRequest{Email: "weak" + email, Password: "short"})
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.InvalidArgument)
+		is.Equal(st.Message(), apperrors.ErrPasswordPolicy.Error())
+		is.Equal(len(st.Details()), 1)
+		details, ok := st.Details()[0].(*errdetails.BadRequest)
+		is.True(ok)
+		is.True(len(details.GetFieldViolations()) > 0)
+	})
+}
+
+// TestAuthServer_Session tests logging in, using the session token in the
+// metadata and logging out
+func TestAuthServer_Session(t *testing.T) {
+	is := is.New(t)
+	apiServer, client := setupClient(t)
+	ctx := context.Background()
+
+	email := "TestAuthServer_Session@test.com"
+	user, err := models.NewUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(apiServer.DB.Create(user).Error)
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, err := client.Login(ctx, &goauthv1.LoginRequest{Email: email, Password: "wrong" + testutils.TestingPassword})
+		is.Equal(status.Code(err), codes.Unauthenticated)
+	})
+
+	login, err := client.Login(ctx, &goauthv1.LoginRequest{Email: email, Password: testutils.TestingPassword})
+	is.NoErr(err)
+	is.True(login.GetSessionToken() != "")
+	is.True(login.GetExpiresAt().AsTime().After(time.Now()))
+
+	t.Run("whoami", func(t *testing.T) {
+		resp, err := client.WhoAmI(bearer(login.GetSessionToken()), &goauthv1.WhoAmIRequest{})
+		is.NoErr(err)
+		is.Equal(resp.GetUserId(), user.ID.String())
+		is.Equal(resp.GetEmail(), email)
+		is.True(resp.GetLastLogin() != nil)
+	})
+
+	apiServer.GRPC.Auth.IntrospectionService.Clients = map[string]string{"billing": "s3cret"}
+
+	t.Run("validate session", func(t *testing.T) {
+		resp, err := client.ValidateSession(introspectionClient("billing", "s3cret"), &goauthv1.ValidateSessionRequest{SessionToken: login.GetSessionToken()})
+		is.NoErr(err)
+		is.True(resp.GetActive())
+		is.Equal(resp.GetUserId(), user.ID.String())
+		is.Equal(resp.GetEmail(), email)
+	})
+
+	t.Run("validating sessions needs client credentials", func(t *testing.T) {
+		req := &goauthv1.ValidateSessionRequest{SessionToken: login.GetSessionToken()}
+		_, err := client.ValidateSession(ctx, req)
+		is.Equal(status.Code(err), codes.Unauthenticated)
+		_, err = client.ValidateSession(introspectionClient("billing", "wrong"), req)
+		is.Equal(status.Code(err), codes.Unauthenticated)
+		_, err = client.ValidateSession(bearer(login.GetSessionToken()), req)
+		is.Equal(status.Code(err), codes.Unauthenticated)
+	})
+
+	t.Run("rotated session token is sent in the header", func(t *testing.T) {
+		// The session is more than halfway expired
+		err := apiServer.DB.Model(&models.Session{}).
+			Where("user_id = ?", user.ID).
+			Update("created_at", time.Now().UTC().Add(-time.Duration(30*24)*time.Hour)).Error
+		is.NoErr(err)
+
+		var header metadata.MD
+		_, err = client.WhoAmI(bearer(login.GetSessionToken()), &goauthv1.WhoAmIRequest{}, grpc.Header(&header))
+		is.NoErr(err)
+		rotated := header.Get(grpcapi.SessionTokenHeader)
+		is.Equal(len(rotated), 1)
+		is.True(rotated[0] != login.GetSessionToken())
+		is.Equal(len(header.Get(grpcapi.SessionExpiresHeader)), 1)
+
+		login.SessionToken = rotated[0]
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		_, err := client.Logout(bearer(login.GetSessionToken()), &goauthv1.LogoutRequest{})
+		is.NoErr(err)
+
+		_, err = client.WhoAmI(bearer(login.GetSessionToken()), &goauthv1.WhoAmIRequest{})
+		is.Equal(status.Code(err), codes.Unauthenticated)
+
+		resp, err := client.ValidateSession(introspectionClient("billing", "s3cret"), &goauthv1.ValidateSessionRequest{SessionToken: login.GetSessionToken()})
+		is.NoErr(err)
+		is.True(!resp.GetActive())
+	})
+}
+
+// TestAuthServer_RecentAuth tests that UpdateUser and DeleteAccount need a
+// recent login
+func TestAuthServer_RecentAuth(t *testing.T) {
+	is := is.New(t)
+	apiServer, client := setupClient(t)
+
+	email := "TestAuthServer_RecentAuth@test.com"
+	user, err := models.NewUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(apiServer.DB.Create(user).Error)
+
+	login, err := client.Login(context.Background(), &goauthv1.LoginRequest{Email: email, Password: testutils.TestingPassword})
+	is.NoErr(err)
+	ctx := bearer(login.GetSessionToken())
+
+	t.Run("password change needs the current password", func(t *testing.T) {
+		newPassword := "new" + testutils.TestingPassword
+		_, err := client.UpdateUser(ctx, &goauthv1.UpdateUserRequest{Password: &newPassword})
+		is.Equal(status.Code(err), codes.InvalidArgument)
+	})
+
+	t.Run("recent session is allowed", func(t *testing.T) {
+		newEmail := "updated" + email
+		_, err := client.UpdateUser(ctx, &goauthv1.UpdateUserRequest{Email: &newEmail})
+		is.NoErr(err)
+
+		resp, err := client.WhoAmI(ctx, &goauthv1.WhoAmIRequest{})
+		is.NoErr(err)
+		is.Equal(resp.GetEmail(), newEmail)
+	})
+
+	t.Run("stale session is refused", func(t *testing.T) {
+		// The login was long ago
+		err := apiServer.DB.Model(&models.Session{}).
+			Where("user_id = ?", user.ID).
+			Update("auth_time", time.Now().UTC().Add(-time.Hour)).Error
+		is.NoErr(err)
+
+		newEmail := "stolen@test.com"
+		_, err = client.UpdateUser(ctx, &goauthv1.UpdateUserRequest{Email: &newEmail})
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.PermissionDenied)
+		is.Equal(st.Message(), apperrors.ErrRecentAuthRequired.Error())
+
+		_, err = client.DeleteAccount(ctx, &goauthv1.DeleteAccountRequest{})
+		is.Equal(status.Code(err), codes.PermissionDenied)
+	})
+}
+
+// TestAuthServer_AccessToken tests calling methods with a personal access
+// token, limited to its scopes
+func TestAuthServer_AccessToken(t *testing.T) {
+	is := is.New(t)
+	apiServer, client := setupClient(t)
+
+	email := "TestAuthServer_AccessToken@test.com"
+	user, err := models.NewUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(apiServer.DB.Create(user).Error)
+
+	created, err := apiServer.HandlerRegistry.AccessToken.AccessTokenService.CreateAccessToken(user.ID.String(), "ci", []string{models.ScopeProfile}, 0)
+	is.NoErr(err)
+	ctx := bearer(created.Token)
+
+	resp, err := client.WhoAmI(ctx, &goauthv1.WhoAmIRequest{})
+	is.NoErr(err)
+	is.Equal(resp.GetEmail(), email)
+
+	_, err = client.LogoutEverywhere(ctx, &goauthv1.LogoutEverywhereRequest{})
+	st := status.Convert(err)
+	is.Equal(st.Code(), codes.PermissionDenied)
+	is.Equal(st.Message(), apperrors.ErrInsufficientScope.Error())
+
+	// Credential changes are for sessions only
+	_, err = client.DeleteAccount(ctx, &goauthv1.DeleteAccountRequest{})
+	is.Equal(status.Code(err), codes.PermissionDenied)
+}
+
+func TestAuthServer_DeleteAccount(t *testing.T) {
+	is := is.New(t)
+	apiServer, client := setupClient(t)
+
+	email := "TestAuthServer_DeleteAccount@test.com"
+	user, err := models.NewUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(apiServer.DB.Create(user).Error)
+
+	login, err := client.Login(context.Background(), &goauthv1.LoginRequest{Email: email, Password: testutils.TestingPassword})
+	is.NoErr(err)
+
+	resp, err := client.DeleteAccount(bearer(login.GetSessionToken()), &goauthv1.DeleteAccountRequest{})
+	is.NoErr(err)
+	is.True(resp.GetPurgeAfter().AsTime().After(time.Now()))
+
+	// The account cannot log in while it is pending deletion
+	_, err = client.Login(context.Background(), &goauthv1.LoginRequest{Email: email, Password: testutils.TestingPassword})
+	is.Equal(status.Code(err), codes.FailedPrecondition)
+}
+
+// setupClient returns an AuthService client of a gRPC server backed by the
+// test database, in a transaction rolled back when the test ends
+func setupClient(t *testing.T) (*server.APIServer, goauthv1.AuthServiceClient) {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	apiServer, conn := dialServer(t, tx)
+	return apiServer, goauthv1.NewAuthServiceClient(conn)
+}
@@ -0,0 +1,92 @@
+package grpcapi
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// statusError converts an error from the user service to a gRPC status, the
+// way the HTTP handlers choose a response status. Password policy violations
+// are listed as BadRequest field violations in the status details, and user
+// hook vetoes keep the hook's message.
+func statusError(err error) error {
+	if veto, ok := services.AsHookVeto(err); ok {
+		return status.Error(vetoCode(veto.Status), veto.Message)
+	}
+	if perr, ok := passwords.AsPolicyError(err); ok {
+		details := &errdetails.BadRequest{}
+		for _, v := range perr.Violations {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Message,
+				Reason:      v.Rule,
+			})
+		}
+		st, detailErr := status.New(codes.InvalidArgument, apperrors.ErrPasswordPolicy.Error()).WithDetails(details)
+		if detailErr != nil {
+			return status.Error(codes.InvalidArgument, apperrors.ErrPasswordPolicy.Error())
+		}
+		return st.Err()
+	}
+	return status.Error(statusCode(err), err.Error())
+}
+
+// statusCode returns the gRPC code of an error from the user service
+func statusCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, apperrors.ErrHashingBusy):
+		return codes.Unavailable
+	case errors.Is(err, apperrors.ErrInvalidLogin):
+		return codes.Unauthenticated
+	case errors.Is(err, apperrors.ErrRecentAuthRequired):
+		return codes.PermissionDenied
+	case errors.Is(err, apperrors.ErrDuplicateEmail):
+		return codes.AlreadyExists
+	case errors.Is(err, apperrors.ErrAccountIsLocked),
+		errors.Is(err, apperrors.ErrAccountPendingDeletion):
+		return codes.FailedPrecondition
+	case errors.Is(err, apperrors.ErrUserNotFound),
+		errors.Is(err, gorm.ErrRecordNotFound):
+		return codes.NotFound
+	case errors.Is(err, apperrors.ErrMissingCredentials),
+		errors.Is(err, apperrors.ErrEmailIsEmpty),
+		errors.Is(err, apperrors.ErrEmailMaxLength),
+		errors.Is(err, apperrors.ErrEmailFormat),
+		errors.Is(err, apperrors.ErrPasswordIsEmpty),
+		errors.Is(err, apperrors.ErrPasswordComplexity),
+		errors.Is(err, apperrors.ErrPasswordTooLong),
+		errors.Is(err, apperrors.ErrCurrentPasswordRequired):
+		return codes.InvalidArgument
+	default:
+		return codes.Internal
+	}
+}
+
+// vetoCode returns the gRPC code closest to the HTTP status of a user hook veto
+func vetoCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	default:
+		return codes.PermissionDenied
+	}
+}
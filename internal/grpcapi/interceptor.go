@@ -0,0 +1,178 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+	goauthv1 "github.com/al-ce/goauth/pkg/proto/goauth/v1"
+)
+
+// Metadata carrying session tokens
+const (
+	// AuthorizationMetadata holds the session, personal access token or
+	// service account token as `Bearer <token>`, or the introspection client
+	// credentials of ValidateSession as `Basic <base64 id:secret>`
+	AuthorizationMetadata = "authorization"
+	// SessionTokenHeader and SessionExpiresHeader are response headers with
+	// the token that replaces a rotated or re-signed one, and its expiry in
+	// Unix seconds
+	SessionTokenHeader   = "x-session-token"
+	SessionExpiresHeader = "x-session-expires"
+)
+
+// access is the check a method runs behind
+type access int
+
+const (
+	public access = iota
+	// authenticated methods need a valid session, like RequireAuth
+	authenticated
+	// recentAuth methods also need a recent login, like RequireRecentAuth
+	recentAuth
+)
+
+// methodAccess lists the AuthService methods that need a session. Other
+// methods, including the health and reflection services, are public.
+var methodAccess = map[string]access{
+	goauthv1.AuthService_LogoutEverywhere_FullMethodName: authenticated,
+	goauthv1.AuthService_WhoAmI_FullMethodName:           authenticated,
+	goauthv1.AuthService_UpdateUser_FullMethodName:       recentAuth,
+	goauthv1.AuthService_DeleteAccount_FullMethodName:    recentAuth,
+}
+
+// methodScope lists the scope a personal access token or service account
+// needs for a method, like the scopes of the HTTP routes. Methods without one
+// are for sessions only.
+var methodScope = map[string]string{
+	goauthv1.AuthService_LogoutEverywhere_FullMethodName: models.ScopeSessions,
+	goauthv1.AuthService_WhoAmI_FullMethodName:           models.ScopeProfile,
+}
+
+// UnaryAuthInterceptor authenticates calls to methods that need a session
+// with the session, personal access token or service account token in the
+// `authorization` metadata,
+// answering Unauthenticated or PermissionDenied like RequireAuth,
+// RequireScope and RequireRecentAuth answer 401 and 403. The caller's
+// identity.Identity is added to the call's context.
+func UnaryAuthInterceptor(am *middleware.AuthMiddleware) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		required := methodAccess[info.FullMethod]
+		if required == public {
+			return handler(ctx, req)
+		}
+
+		token, ok := sessionToken(ctx)
+		if !ok {
+			log.Debug().Str("method", info.FullMethod).Msg("No session token in metadata")
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		if models.IsAccessToken(token) || models.IsServiceAccountToken(token) {
+			id, ok := am.AuthenticateBearer(token, clientIP(ctx))
+			if !ok {
+				return nil, status.Error(codes.Unauthenticated, "unauthorized")
+			}
+			if !id.HasScope(methodScope[info.FullMethod]) {
+				log.Info().
+					Str("principal", id.Principal()).
+					Str("subject", id.Subject().String()).
+					Str("tokenID", id.AccessTokenID.String()).
+					Str("method", info.FullMethod).
+					Msg("Access token denied method outside its scopes")
+				return nil, status.Error(codes.PermissionDenied, apperrors.ErrInsufficientScope.Error())
+			}
+			return handler(identity.NewContext(ctx, id), req)
+		}
+
+		id, renewal, ok := am.AuthenticateToken(token)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		if renewal != nil {
+			header := metadata.Pairs(
+				SessionTokenHeader, renewal.Token,
+				SessionExpiresHeader, strconv.FormatInt(renewal.ExpiresAt.Unix(), 10),
+			)
+			if err := grpc.SetHeader(ctx, header); err != nil {
+				log.Debug().Err(err).Msg("Failed to send renewed session token")
+			}
+		}
+
+		if required == recentAuth && !id.AuthenticatedWithin(config.RecentAuthMaxAge) {
+			log.Info().
+				Str("userID", id.UserID.String()).
+				Str("clientIP", clientIP(ctx)).
+				Str("method", info.FullMethod).
+				Msg("Session denied sensitive method without recent authentication")
+			return nil, status.Error(codes.PermissionDenied, apperrors.ErrRecentAuthRequired.Error())
+		}
+
+		return handler(identity.NewContext(ctx, id), req)
+	}
+}
+
+// sessionToken reads the bearer token in the `authorization` metadata
+func sessionToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(AuthorizationMetadata)
+	if len(values) != 1 {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// basicCredentials reads the client ID and secret in the `authorization`
+// metadata, encoded like HTTP Basic authentication
+func basicCredentials(ctx context.Context) (string, string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	values := md.Get(AuthorizationMetadata)
+	if len(values) != 1 {
+		return "", "", false
+	}
+	scheme, encoded, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// clientIP returns the IP of the caller, for logging
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,59 @@
+// Package grpcapi serves the user endpoints over gRPC as the goauth.v1
+// AuthService, backed by the same services and session checks as the HTTP
+// routes, next to the standard health and reflection services.
+package grpcapi
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	goauthv1 "github.com/al-ce/goauth/pkg/proto/goauth/v1"
+)
+
+// Server is the gRPC server with the AuthService, health and reflection
+// services
+type Server struct {
+	*grpc.Server
+	Auth   *AuthServer
+	Health *health.Server
+}
+
+// NewServer returns a Server whose AuthService methods that need a session
+// are behind the same checks as RequireAuth and RequireRecentAuth
+func NewServer(
+	us *services.UserService,
+	ins *services.IntrospectionService,
+	am *middleware.AuthMiddleware,
+) (*Server, error) {
+	auth, err := NewAuthServer(us, ins)
+	if err != nil {
+		return nil, err
+	}
+	if am == nil {
+		return nil, apperrors.ErrAuthMiddlewareIsNil
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(UnaryAuthInterceptor(am)))
+	goauthv1.RegisterAuthServiceServer(srv, auth)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(goauthv1.AuthService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthServer)
+
+	reflection.Register(srv)
+
+	return &Server{Server: srv, Auth: auth, Health: healthServer}, nil
+}
+
+// GracefulStop reports the services as not serving, so health checking
+// clients move away, then stops accepting connections and waits for pending
+// calls to finish
+func (s *Server) GracefulStop() {
+	s.Health.Shutdown()
+	s.Server.GracefulStop()
+}
@@ -0,0 +1,188 @@
+package grpcapi_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/matryer/is"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/grpcapi"
+	"github.com/al-ce/goauth/internal/server"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	goauthv1 "github.com/al-ce/goauth/pkg/proto/goauth/v1"
+)
+
+// TestMain sets up the test environment for all tests in the `grpcapi_test` package.
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+	os.Exit(m.Run())
+}
+
+func TestGRPC_NewServer(t *testing.T) {
+	is := is.New(t)
+
+	_, err := grpcapi.NewServer(nil, nil, nil)
+	is.Equal(err, apperrors.ErrUserServiceIsNil)
+
+	apiServer, err := server.NewAPIServer(&gorm.DB{})
+	is.NoErr(err)
+	_, err = grpcapi.NewServer(apiServer.GRPC.Auth.UserService, apiServer.GRPC.Auth.IntrospectionService, nil)
+	is.Equal(err, apperrors.ErrAuthMiddlewareIsNil)
+}
+
+// TestGRPC_Health tests that the health service reports the AuthService as
+// serving until the server is stopped
+func TestGRPC_Health(t *testing.T) {
+	is := is.New(t)
+	apiServer, conn := dialServer(t, &gorm.DB{})
+
+	client := healthpb.NewHealthClient(conn)
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: goauthv1.AuthService_ServiceDesc.ServiceName,
+	})
+	is.NoErr(err)
+	is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_SERVING)
+
+	apiServer.GRPC.Health.Shutdown()
+	resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: goauthv1.AuthService_ServiceDesc.ServiceName,
+	})
+	is.NoErr(err)
+	is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// TestGRPC_Reflection tests that the reflection service lists the AuthService
+func TestGRPC_Reflection(t *testing.T) {
+	is := is.New(t)
+	_, conn := dialServer(t, &gorm.DB{})
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	is.NoErr(err)
+	is.NoErr(stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	is.NoErr(err)
+	is.NoErr(stream.CloseSend())
+
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.GetName())
+	}
+	is.True(slices.Contains(names, goauthv1.AuthService_ServiceDesc.ServiceName))
+	is.True(slices.Contains(names, healthpb.Health_ServiceDesc.ServiceName))
+}
+
+// TestGRPC_Unauthenticated tests that methods that need a session refuse calls
+// without a valid token in the metadata, before reaching the database
+func TestGRPC_Unauthenticated(t *testing.T) {
+	is := is.New(t)
+	_, conn := dialServer(t, &gorm.DB{})
+	client := goauthv1.NewAuthServiceClient(conn)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"no metadata", context.Background()},
+		{"not a bearer token", metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic abc")},
+		{"malformed token", bearer("not-a-session-token")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.WhoAmI(tt.ctx, &goauthv1.WhoAmIRequest{})
+			is.Equal(status.Code(err), codes.Unauthenticated)
+			_, err = client.LogoutEverywhere(tt.ctx, &goauthv1.LogoutEverywhereRequest{})
+			is.Equal(status.Code(err), codes.Unauthenticated)
+			_, err = client.UpdateUser(tt.ctx, &goauthv1.UpdateUserRequest{})
+			is.Equal(status.Code(err), codes.Unauthenticated)
+			_, err = client.DeleteAccount(tt.ctx, &goauthv1.DeleteAccountRequest{})
+			is.Equal(status.Code(err), codes.Unauthenticated)
+		})
+	}
+
+	t.Run("logout without a token", func(t *testing.T) {
+		_, err := client.Logout(context.Background(), &goauthv1.LogoutRequest{})
+		is.Equal(status.Code(err), codes.Unauthenticated)
+	})
+}
+
+// TestGRPC_InvalidArguments tests the checks made before calling the user
+// service
+func TestGRPC_InvalidArguments(t *testing.T) {
+	is := is.New(t)
+	apiServer, conn := dialServer(t, &gorm.DB{})
+	client := goauthv1.NewAuthServiceClient(conn)
+	apiServer.GRPC.Auth.IntrospectionService.Clients = map[string]string{"billing": "s3cret"}
+	billing := introspectionClient("billing", "s3cret")
+
+	_, err := client.Register(context.Background(), &goauthv1.RegisterRequest{Email: "missing@password.com"})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+	_, err = client.Login(context.Background(), &goauthv1.LoginRequest{Password: testutils.TestingPassword})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+	_, err = client.ValidateSession(billing, &goauthv1.ValidateSessionRequest{})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+
+	// Clients are authenticated before the token is checked
+	_, err = client.ValidateSession(context.Background(), &goauthv1.ValidateSessionRequest{})
+	is.Equal(status.Code(err), codes.Unauthenticated)
+
+	// A token with a bad signature is inactive without a database lookup
+	resp, err := client.ValidateSession(billing, &goauthv1.ValidateSessionRequest{SessionToken: "not-a-session-token"})
+	is.NoErr(err)
+	is.True(!resp.GetActive())
+}
+
+// dialServer serves the gRPC server of an APIServer on an in-process
+// listener and returns a client connection to it
+func dialServer(t *testing.T, db *gorm.DB) (*server.APIServer, *grpc.ClientConn) {
+	t.Helper()
+
+	apiServer, err := server.NewAPIServer(db)
+	if err != nil {
+		t.Fatalf("failed to init api server: %v", err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	go apiServer.GRPC.Serve(lis)
+	t.Cleanup(apiServer.Shutdown)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial gRPC server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return apiServer, conn
+}
+
+// bearer returns a context that sends `token` in the authorization metadata
+func bearer(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), grpcapi.AuthorizationMetadata, "Bearer "+token)
+}
+
+// introspectionClient returns a context that sends the credentials of an
+// introspection client in the authorization metadata
+func introspectionClient(clientID, secret string) context.Context {
+	credentials := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + secret))
+	return metadata.AppendToOutgoingContext(context.Background(), grpcapi.AuthorizationMetadata, "Basic "+credentials)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+type AccessTokenHandler struct {
+	AccessTokenService *services.AccessTokenService
+}
+
+func NewAccessTokenHandler(accessTokenService *services.AccessTokenService) (*AccessTokenHandler, error) {
+	if accessTokenService == nil {
+		return nil, apperrors.ErrAccessTokenServiceIsNil
+	}
+	return &AccessTokenHandler{AccessTokenService: accessTokenService}, nil
+}
+
+// CreateAccessToken godoc
+// @Summary Create a personal access token
+// @Schemes
+// @Description Create a token for scripts and CI jobs, sent as `Authorization: Bearer <token>`. The token is only shown in this response. Scopes are profile, sessions, export and admin (admins only). Without expiresInDays (1 to 366) the token is valid until revoked. Needs a session with a recent login.
+// @Accept json
+// @Produce json
+// @Param request body models.CreateAccessTokenRequest true "token name, scopes and optional expiry in days"
+// @Success 201 {object} models.AccessTokenResponse "the token and its details"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /tokens [post]
+func (th *AccessTokenHandler) CreateAccessToken(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+
+	var body models.CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	created, err := th.AccessTokenService.CreateAccessToken(userID, body.Name, body.Scopes, body.ExpiresInDays)
+	if err != nil {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to create access token")
+
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, apperrors.ErrAccessTokenAdmin):
+			status = http.StatusForbidden
+		case errors.Is(err, apperrors.ErrAccessTokenName),
+			errors.Is(err, apperrors.ErrAccessTokenScope),
+			errors.Is(err, apperrors.ErrAccessTokenNoScopes),
+			errors.Is(err, apperrors.ErrAccessTokenExpiry),
+			errors.Is(err, apperrors.ErrAccessTokenLimit):
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Str("tokenID", created.Info.ID.String()).
+		Msg("access token created")
+
+	// The token must not be cached by intermediaries
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, H{"token": created.Token, "accessToken": created.Info})
+}
+
+// ListAccessTokens godoc
+// @Summary List the user's personal access tokens
+// @Schemes
+// @Description List the logged in user's personal access tokens, newest first, with their scopes, expiry, and when and from which IP they were last used. Expired tokens are listed until revoked.
+// @Produce json
+// @Success 200 {object} models.AccessTokensResponse "response with accessTokens field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /tokens [get]
+func (th *AccessTokenHandler) ListAccessTokens(c *Exchange) {
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+
+	tokens, err := th.AccessTokenService.ListAccessTokens(userID)
+	if err != nil {
+		log.Error().
+			Str("userID", userID).
+			Str("clientIP", c.ClientIP()).
+			Str("error", err.Error()).
+			Msg("failed to list access tokens")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, H{"accessTokens": tokens})
+}
+
+// RevokeAccessToken godoc
+// @Summary Revoke one of the user's personal access tokens
+// @Schemes
+// @Description Delete one of the logged in user's personal access tokens by its ID. Requests made with it are refused from then on.
+// @Produce json
+// @Param id path string true "access token ID"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /tokens/{id} [delete]
+func (th *AccessTokenHandler) RevokeAccessToken(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+
+	if err := th.AccessTokenService.RevokeAccessToken(userID, c.Param("id")); err != nil {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to revoke access token")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, apperrors.ErrAccessTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Str("tokenID", c.Param("id")).
+		Msg("access token revoked")
+
+	c.JSON(http.StatusOK, H{"message": "access token revoked"})
+}
@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewAccessTokenHandler checks the NewAccessTokenHandler constructor
+func TestHandlers_NewAccessTokenHandler(t *testing.T) {
+	is := is.New(t)
+
+	th, err := handlers.NewAccessTokenHandler(nil)
+	is.Equal(th, nil)
+	is.Equal(err, apperrors.ErrAccessTokenServiceIsNil)
+}
+
+// TestAccessTokenHandler_AccessTokens checks creating personal access tokens
+// with a session, using them on the routes of their scopes, and revoking them
+func TestAccessTokenHandler_AccessTokens(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		email := "testAccessTokenHandler@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
+
+		loginRR, err := makeRequest(
+			server.Router,
+			"POST",
+			"/login",
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+		)
+		is.NoErr(err)
+		sessionCookie := getSessionCookie(loginRR)
+		is.True(sessionCookie != nil)
+
+		withSession := func(method, path string, body any) *httptest.ResponseRecorder {
+			jsonData, _ := json.Marshal(body)
+			req, err := http.NewRequest(method, path, bytes.NewBuffer(jsonData))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+		withToken := func(method, path, token string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest(method, path, nil)
+			is.NoErr(err)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := withSession("POST", "/tokens", models.CreateAccessTokenRequest{
+			Name:          "ci",
+			Scopes:        []string{models.ScopeProfile},
+			ExpiresInDays: 30,
+		})
+		is.Equal(rr.Code, http.StatusCreated)
+		is.Equal(rr.Header().Get("Cache-Control"), "no-store")
+		var created models.AccessTokenResponse
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &created))
+		is.True(models.IsAccessToken(created.Token))
+		is.Equal(created.AccessToken.Scopes, []string{models.ScopeProfile})
+
+		t.Run("invalid scope", func(t *testing.T) {
+			rr := withSession("POST", "/tokens", models.CreateAccessTokenRequest{Name: "ci", Scopes: []string{"everything"}})
+			is.Equal(rr.Code, http.StatusBadRequest)
+			rr = withSession("POST", "/tokens", models.CreateAccessTokenRequest{Name: "ci", Scopes: []string{models.ScopeAdmin}})
+			is.Equal(rr.Code, http.StatusForbidden)
+		})
+
+		t.Run("token reaches the routes of its scopes", func(t *testing.T) {
+			rr := withToken("GET", "/whoami", created.Token)
+			is.Equal(rr.Code, http.StatusOK)
+			var response map[string]any
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(response["email"], email)
+
+			rr = withToken("GET", "/sessions", created.Token)
+			is.Equal(rr.Code, http.StatusForbidden)
+			is.Equal(rr.Header().Get("WWW-Authenticate"), `Bearer error="insufficient_scope", scope="sessions"`)
+
+			// Token management and credential changes are for sessions only
+			is.Equal(withToken("GET", "/tokens", created.Token).Code, http.StatusForbidden)
+			is.Equal(withToken("DELETE", "/deleteaccount", created.Token).Code, http.StatusForbidden)
+		})
+
+		t.Run("the cookie does not authenticate requests with an authorization header", func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/whoami", nil)
+			is.NoErr(err)
+			req.AddCookie(sessionCookie)
+			req.Header.Set("Authorization", "Bearer "+models.AccessTokenPrefix+"unknown")
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+
+		t.Run("last use is recorded from the client IP resolved by the router", func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/whoami", nil)
+			is.NoErr(err)
+			req.RemoteAddr = "127.0.0.1:40000"
+			req.Header.Set("Authorization", "Bearer "+created.Token)
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// gin trusts the forwarded header from its trusted proxies, while
+			// net/http only sees the remote address
+			want := "203.0.113.7"
+			if adapter == adapterHTTP {
+				want = "127.0.0.1"
+			}
+			var token models.AccessToken
+			is.NoErr(server.DB.First(&token, "id = ?", created.AccessToken.ID).Error)
+			is.Equal(token.LastUsedIP, want)
+		})
+
+		t.Run("list shows the last use", func(t *testing.T) {
+			rr := withSession("GET", "/tokens", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			var response models.AccessTokensResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(len(response.AccessTokens), 1)
+			is.Equal(response.AccessTokens[0].ID, created.AccessToken.ID)
+			is.True(response.AccessTokens[0].LastUsedAt != nil)
+			is.True(!bytes.Contains(rr.Body.Bytes(), []byte(created.Token)))
+		})
+
+		t.Run("revoked token is refused", func(t *testing.T) {
+			rr := withSession("DELETE", "/tokens/"+created.AccessToken.ID.String(), nil)
+			is.Equal(rr.Code, http.StatusOK)
+			rr = withSession("DELETE", "/tokens/"+created.AccessToken.ID.String(), nil)
+			is.Equal(rr.Code, http.StatusNotFound)
+
+			is.Equal(withToken("GET", "/whoami", created.Token).Code, http.StatusUnauthorized)
+		})
+	})
+}
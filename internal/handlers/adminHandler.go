@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+type AdminHandler struct {
+	ImportService *services.ImportService
+}
+
+func NewAdminHandler(importService *services.ImportService) (*AdminHandler, error) {
+	if importService == nil {
+		return nil, apperrors.ErrImportServiceIsNil
+	}
+	return &AdminHandler{ImportService: importService}, nil
+}
+
+// ImportUsers godoc
+// @Summary bulk import users
+// @Schemes
+// @Description Import users with password hashes from another system. The body is JSONL or CSV
+// @Description (header `email,password_hash`). Supported hashes: argon2id/argon2i, bcrypt ($2a$/$2b$/$2y$),
+// @Description Django pbkdf2_sha256/pbkdf2_sha1/scrypt/argon2/bcrypt/bcrypt_sha256, and salted SHA.
+// @Description Imported users are rehashed with the current algorithm on their first login.
+// @Accept plain
+// @Produce json
+// @Param format query string false "jsonl or csv, defaults from Content-Type"
+// @Param dry_run query bool false "validate rows without inserting them"
+// @Success 200 {object} services.ImportReport "per-row import report"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "response with error field"
+// @Failure 413 {object} models.ErrorResponse "response with error field"
+// @Router /admin/users/import [post]
+func (ah *AdminHandler) ImportUsers(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	format := c.Query("format")
+	if format == "" {
+		format = services.ImportFormatJSONL
+		if strings.HasPrefix(c.ContentType(), "text/csv") {
+			format = services.ImportFormatCSV
+		}
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxImportBytes)
+	report, err := ah.ImportService.ImportUsers(body, format, dryRun)
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("User import failed")
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, H{"error": apperrors.ErrImportTooLarge.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	admin, _ := c.Identity()
+	log.Info().
+		Str("principal", admin.Principal()).
+		Str("subject", admin.Subject().String()).
+		Str("clientIP", clientIP).
+		Bool("dryRun", dryRun).
+		Int("total", report.Total).
+		Int("imported", report.Imported).
+		Int("failed", report.Failed).
+		Msg("User import complete")
+
+	c.JSON(http.StatusOK, report)
+}
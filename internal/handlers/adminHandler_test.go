@@ -0,0 +1,130 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewAdminHandler checks the NewAdminHandler constructor
+func TestHandlers_NewAdminHandler(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("err on nil import service", func(t *testing.T) {
+		ah, err := handlers.NewAdminHandler(nil)
+		is.Equal(ah, nil)
+		is.Equal(err, apperrors.ErrImportServiceIsNil)
+	})
+}
+
+// TestAdminHandler_ImportUsers checks the admin import endpoint
+func TestAdminHandler_ImportUsers(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		// Register an admin and a regular user
+		adminEmail := "testAdminHandlerImportUsersAdmin@test.com"
+		admin, err := models.NewUser(adminEmail, testutils.TestingPassword)
+		is.NoErr(err)
+		admin.Role = models.RoleAdmin
+		is.NoErr(server.DB.Create(admin).Error)
+
+		userEmail := "testAdminHandlerImportUsersUser@test.com"
+		user, err := models.NewUser(userEmail, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
+
+		login := func(email string) *http.Cookie {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+			return getSessionCookie(rr)
+		}
+		adminCookie := login(adminEmail)
+		userCookie := login(userEmail)
+
+		csvBody := "email,password_hash\n" +
+			"importedByAdmin@test.com,pbkdf2_sha256$1000$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk=\n" +
+			"badRow@test.com,notahash\n"
+
+		importRequest := func(cookie *http.Cookie, query string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("POST", "/admin/users/import"+query, strings.NewReader(csvBody))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "text/csv")
+			if cookie != nil {
+				req.AddCookie(cookie)
+				addCSRFToken(t, server.Router, req)
+			}
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		t.Run("unauthorized without session", func(t *testing.T) {
+			rr := importRequest(nil, "")
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+
+		t.Run("forbidden for non-admin", func(t *testing.T) {
+			rr := importRequest(userCookie, "")
+			is.Equal(rr.Code, http.StatusForbidden)
+		})
+
+		t.Run("dry run returns report", func(t *testing.T) {
+			rr := importRequest(adminCookie, "?dry_run=true")
+			is.Equal(rr.Code, http.StatusOK)
+
+			var report services.ImportReport
+			is.NoErr(json.NewDecoder(rr.Body).Decode(&report))
+			is.True(report.DryRun)
+			is.Equal(report.Total, 2)
+			is.Equal(report.Failed, 1)
+			is.Equal(report.Rows[0].Status, services.ImportStatusValid)
+			is.Equal(report.Rows[1].Status, services.ImportStatusFailed)
+
+			var count int64
+			server.DB.Model(&models.User{}).Where("email = ?", "importedByAdmin@test.com").Count(&count)
+			is.Equal(count, int64(0))
+		})
+
+		t.Run("imports valid rows", func(t *testing.T) {
+			rr := importRequest(adminCookie, "")
+			is.Equal(rr.Code, http.StatusOK)
+
+			var report services.ImportReport
+			is.NoErr(json.NewDecoder(rr.Body).Decode(&report))
+			is.Equal(report.Imported, 1)
+
+			// Imported user can log in with their existing password
+			loginRR, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: "importedByAdmin@test.com", Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(loginRR.Code, http.StatusOK)
+		})
+
+		t.Run("bad format", func(t *testing.T) {
+			rr := importRequest(adminCookie, "?format=xml")
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+	})
+}
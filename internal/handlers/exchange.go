@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// H is a JSON object in a response
+type H map[string]any
+
+// Exchange is one request and its response, independent of the router. The
+// handlers' request logic takes an Exchange, and Gin and HTTP adapt it to gin
+// and net/http, so both serve the same logic.
+type Exchange struct {
+	Writer  http.ResponseWriter
+	Request *http.Request
+
+	clientIP func() string
+	param    func(string) string
+	abort    func()
+}
+
+// Gin adapts a handler to a gin.HandlerFunc
+func Gin(h func(*Exchange)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h(&Exchange{
+			Writer:   c.Writer,
+			Request:  c.Request,
+			clientIP: c.ClientIP,
+			param:    c.Param,
+			abort:    c.Abort,
+		})
+	}
+}
+
+// HTTP adapts a handler to an http.Handler. Path parameters are read with
+// Request.PathValue, as set by http.ServeMux and chi.
+func HTTP(h func(*Exchange)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(&Exchange{
+			Writer:  w,
+			Request: r,
+			clientIP: func() string {
+				host, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					return r.RemoteAddr
+				}
+				return host
+			},
+			param: r.PathValue,
+			abort: func() {},
+		})
+	})
+}
+
+// ClientIP returns the IP of the client, as resolved by the router
+func (x *Exchange) ClientIP() string {
+	return x.clientIP()
+}
+
+// Param returns a path parameter
+func (x *Exchange) Param(name string) string {
+	return x.param(name)
+}
+
+// Query returns a query string parameter
+func (x *Exchange) Query(name string) string {
+	return x.Request.URL.Query().Get(name)
+}
+
+// DefaultQuery returns a query string parameter, or `def` if it is empty
+func (x *Exchange) DefaultQuery(name, def string) string {
+	if value := x.Query(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// ContentType returns the request's media type without parameters
+func (x *Exchange) ContentType() string {
+	return filterFlags(x.Request.Header.Get("Content-Type"))
+}
+
+// Identity returns the caller authenticated by the auth middleware
+func (x *Exchange) Identity() (identity.Identity, bool) {
+	return identity.FromContext(x.Request.Context())
+}
+
+// UserID returns the ID of the authenticated user, responding 401 if there
+// is none, and 403 if the caller is a service account
+func (x *Exchange) UserID() (string, bool) {
+	id, ok := x.SubjectIdentity()
+	if !ok {
+		return "", false
+	}
+	if id.IsServiceAccount() {
+		log.Info().
+			Str("serviceAccountID", id.ServiceAccountID.String()).
+			Str("clientIP", x.ClientIP()).
+			Msg("Service account denied user route")
+		x.AbortWithStatusJSON(http.StatusForbidden, H{"error": apperrors.ErrUserRequired.Error()})
+		return "", false
+	}
+	return id.UserID.String(), true
+}
+
+// SubjectIdentity returns the authenticated user or service account,
+// responding 401 if there is none
+func (x *Exchange) SubjectIdentity() (identity.Identity, bool) {
+	id, ok := x.Identity()
+	if !ok {
+		log.Info().
+			Str("clientIP", x.ClientIP()).
+			Msg("identity not found in context")
+		x.AbortWithStatusJSON(http.StatusUnauthorized, H{"error": "unauthorized"})
+		return identity.Identity{}, false
+	}
+	return id, true
+}
+
+// ShouldBindJSON decodes the JSON body into `obj` and checks its `binding`
+// struct tags
+func (x *Exchange) ShouldBindJSON(obj any) error {
+	return binding.JSON.Bind(x.Request, obj)
+}
+
+// Header sets a response header
+func (x *Exchange) Header(key, value string) {
+	x.Writer.Header().Set(key, value)
+}
+
+// JSON responds with `status` and `obj` as JSON
+func (x *Exchange) JSON(status int, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		x.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	x.Data(status, "application/json; charset=utf-8", data)
+}
+
+// Data responds with `status` and a body of the given content type
+func (x *Exchange) Data(status int, contentType string, data []byte) {
+	x.Header("Content-Type", contentType)
+	x.Writer.WriteHeader(status)
+	x.Writer.Write(data)
+}
+
+// AbortWithStatus responds with `status` and stops any later handlers
+func (x *Exchange) AbortWithStatus(status int) {
+	x.abort()
+	x.Writer.WriteHeader(status)
+}
+
+// AbortWithStatusJSON responds with `status` and `obj` as JSON and stops any
+// later handlers
+func (x *Exchange) AbortWithStatusJSON(status int, obj any) {
+	x.abort()
+	x.JSON(status, obj)
+}
+
+// filterFlags returns a media type without its parameters
+func filterFlags(content string) string {
+	for i, char := range content {
+		if char == ' ' || char == ';' {
+			return content[:i]
+		}
+	}
+	return content
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// ExportDownloadPath is the route that serves background exports by token
+const ExportDownloadPath = "/export/download"
+
+type ExportHandler struct {
+	ExportService *services.ExportService
+	// DownloadPath is the path of the DownloadExport route in download URLs,
+	// including any prefix the routes are mounted under
+	DownloadPath string
+}
+
+func NewExportHandler(exportService *services.ExportService) (*ExportHandler, error) {
+	if exportService == nil {
+		return nil, apperrors.ErrExportServiceIsNil
+	}
+	return &ExportHandler{ExportService: exportService, DownloadPath: ExportDownloadPath}, nil
+}
+
+// ExportData godoc
+// @Summary Export the user's data
+// @Schemes
+// @Description Download a copy of the logged in user's personal data: profile (without the password hash), sessions, audit events and linked identities. For large accounts, request a background export with POST /export instead. Sessions must have logged in or reauthenticated in the last 5 minutes.
+// @Produce json,application/zip
+// @Param format query string false "json (default) or zip"
+// @Success 200 {file} file "the export as an attachment"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /export [get]
+func (eh *ExportHandler) ExportData(c *Exchange) {
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+	eh.sendExport(c, userID, userID)
+}
+
+// RequestExport godoc
+// @Summary Request a background export of the user's data
+// @Schemes
+// @Description Queue an export of the logged in user's personal data. Poll the returned download URL: it answers 202 until the export is ready, and expires after 24 hours. Sessions must have logged in or reauthenticated in the last 5 minutes.
+// @Accept json
+// @Produce json
+// @Param request body models.ExportRequest false "json (default) or zip"
+// @Success 202 {object} models.ExportRequestResponse "the export ID, status and download URL"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /export [post]
+func (eh *ExportHandler) RequestExport(c *Exchange) {
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+	eh.queueExport(c, userID, userID, bodyExportFormat(c))
+}
+
+// DownloadExport godoc
+// @Summary Download a background export
+// @Schemes
+// @Description Download an export requested with POST /export or POST /admin/users/{id}/export. The token in the link is the only credential needed.
+// @Produce json,application/zip
+// @Param token query string true "download token from the export's download URL"
+// @Success 200 {file} file "the export as an attachment"
+// @Success 202 {object} models.ErrorResponse "the export is still being generated"
+// @Failure 404 {object} models.ErrorResponse "unknown token or expired link"
+// @Failure 500 {object} models.ErrorResponse "the export could not be generated"
+// @Router /export/download [get]
+func (eh *ExportHandler) DownloadExport(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	export, err := eh.ExportService.DownloadExport(c.Query("token"))
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Export download failed")
+
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, apperrors.ErrExportNotReady):
+			status = http.StatusAccepted
+		case errors.Is(err, apperrors.ErrExportNotFound):
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", export.UserID.String()).
+		Str("exportID", export.ID.String()).
+		Str("clientIP", clientIP).
+		Msg("Export downloaded")
+
+	writeExport(c, export.Format, export.Data, *export.CompletedAt)
+}
+
+// AdminExportData godoc
+// @Summary Export a user's data
+// @Schemes
+// @Description Download a copy of a user's personal data, e.g. to answer a data subject access request.
+// @Produce json,application/zip
+// @Param id path string true "user ID"
+// @Param format query string false "json (default) or zip"
+// @Success 200 {file} file "the export as an attachment"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "response with error field"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Router /admin/users/{id}/export [get]
+func (eh *ExportHandler) AdminExportData(c *Exchange) {
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	eh.sendExport(c, c.Param("id"), admin.Subject().String())
+}
+
+// AdminRequestExport godoc
+// @Summary Request a background export of a user's data
+// @Schemes
+// @Description Queue an export of a user's personal data. The download URL answers 202 until the export is ready, and expires after 24 hours.
+// @Accept json
+// @Produce json
+// @Param id path string true "user ID"
+// @Param request body models.ExportRequest false "json (default) or zip"
+// @Success 202 {object} models.ExportRequestResponse "the export ID, status and download URL"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "response with error field"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Router /admin/users/{id}/export [post]
+func (eh *ExportHandler) AdminRequestExport(c *Exchange) {
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	eh.queueExport(c, c.Param("id"), admin.Subject().String(), bodyExportFormat(c))
+}
+
+// sendExport responds with a user's data in the format from the query string
+func (eh *ExportHandler) sendExport(c *Exchange, userID, requestedBy string) {
+	clientIP := c.ClientIP()
+	format := c.DefaultQuery("format", services.ExportFormatJSON)
+
+	data, err := eh.ExportService.ExportData(userID, requestedBy, format)
+	if err != nil {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Data export failed")
+		c.AbortWithStatusJSON(exportErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("requestedBy", requestedBy).
+		Str("clientIP", clientIP).
+		Msg("Data exported")
+
+	writeExport(c, format, data, time.Now().UTC())
+}
+
+// queueExport requests a background export and responds with its download URL
+func (eh *ExportHandler) queueExport(c *Exchange, userID, requestedBy, format string) {
+	clientIP := c.ClientIP()
+
+	request, err := eh.ExportService.RequestExport(userID, requestedBy, format)
+	if err != nil {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Data export request failed")
+		c.AbortWithStatusJSON(exportErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("requestedBy", requestedBy).
+		Str("exportID", request.ID.String()).
+		Str("clientIP", clientIP).
+		Msg("Data export requested")
+
+	c.JSON(http.StatusAccepted, H{
+		"id":          request.ID,
+		"status":      request.Status,
+		"expiresAt":   request.ExpiresAt,
+		"downloadUrl": eh.DownloadPath + "?token=" + url.QueryEscape(request.Token),
+	})
+}
+
+// bodyExportFormat reads the optional format of an export request body
+func bodyExportFormat(c *Exchange) string {
+	var body struct {
+		Format string `json:"format"`
+	}
+	c.ShouldBindJSON(&body)
+	if body.Format == "" {
+		return services.ExportFormatJSON
+	}
+	return body.Format
+}
+
+// exportErrorStatus maps export errors to HTTP statuses
+func exportErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrExportFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, apperrors.ErrUserNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeExport sends export data as a file attachment
+func writeExport(c *Exchange, format string, data []byte, exportedAt time.Time) {
+	filename := fmt.Sprintf("goauth-export-%s.%s", exportedAt.Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, services.ExportContentType(format), data)
+}
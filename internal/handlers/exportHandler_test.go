@@ -0,0 +1,172 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewExportHandler checks the NewExportHandler constructor
+func TestHandlers_NewExportHandler(t *testing.T) {
+	is := is.New(t)
+
+	eh, err := handlers.NewExportHandler(nil)
+	is.Equal(eh, nil)
+	is.Equal(err, apperrors.ErrExportServiceIsNil)
+}
+
+// TestExportHandler_Export checks the user and admin export endpoints and
+// downloading a background export
+func TestExportHandler_Export(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		adminEmail := "testExportHandlerAdmin@test.com"
+		admin, err := models.NewUser(adminEmail, testutils.TestingPassword)
+		is.NoErr(err)
+		admin.Role = models.RoleAdmin
+		is.NoErr(server.DB.Create(admin).Error)
+
+		userEmail := "testExportHandlerUser@test.com"
+		user, err := models.NewUser(userEmail, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
+
+		login := func(email string) *http.Cookie {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+			return getSessionCookie(rr)
+		}
+		adminCookie := login(adminEmail)
+		userCookie := login(userEmail)
+
+		request := func(cookie *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest(method, path, strings.NewReader(body))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+			if cookie != nil {
+				req.AddCookie(cookie)
+				addCSRFToken(t, server.Router, req)
+			}
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		t.Run("unauthorized without session", func(t *testing.T) {
+			rr := request(nil, "GET", "/export", "")
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+
+		t.Run("json export", func(t *testing.T) {
+			rr := request(userCookie, "GET", "/export", "")
+			is.Equal(rr.Code, http.StatusOK)
+			is.True(strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment"))
+			is.True(!strings.Contains(rr.Body.String(), user.Password))
+
+			var bundle services.ExportBundle
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &bundle))
+			is.Equal(bundle.User.Email, userEmail)
+			is.Equal(len(bundle.Sessions), 1)
+			is.Equal(len(bundle.Identities), 0)
+		})
+
+		t.Run("zip export", func(t *testing.T) {
+			rr := request(userCookie, "GET", "/export?format=zip", "")
+			is.Equal(rr.Code, http.StatusOK)
+			is.Equal(rr.Header().Get("Content-Type"), "application/zip")
+
+			zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+			is.NoErr(err)
+			is.Equal(len(zr.File), 1)
+			f, err := zr.File[0].Open()
+			is.NoErr(err)
+			data, err := io.ReadAll(f)
+			is.NoErr(err)
+			is.True(strings.Contains(string(data), userEmail))
+		})
+
+		t.Run("unknown format", func(t *testing.T) {
+			rr := request(userCookie, "GET", "/export?format=xml", "")
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+
+		t.Run("background export", func(t *testing.T) {
+			rr := request(userCookie, "POST", "/export", `{"format": "json"}`)
+			is.Equal(rr.Code, http.StatusAccepted)
+			var response map[string]any
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			downloadURL := response["downloadUrl"].(string)
+
+			// The link needs no session, and waits for the job
+			rr = request(nil, "GET", downloadURL, "")
+			is.Equal(rr.Code, http.StatusAccepted)
+
+			_, err := server.HandlerRegistry.Export.ExportService.ProcessPendingExports(10)
+			is.NoErr(err)
+
+			rr = request(nil, "GET", downloadURL, "")
+			is.Equal(rr.Code, http.StatusOK)
+			is.True(strings.Contains(rr.Body.String(), userEmail))
+
+			rr = request(nil, "GET", "/export/download?token=wrong", "")
+			is.Equal(rr.Code, http.StatusNotFound)
+		})
+
+		t.Run("stale sessions must reauthenticate", func(t *testing.T) {
+			staleEmail := "testExportHandlerStale@test.com"
+			stale, err := models.NewUser(staleEmail, testutils.TestingPassword)
+			is.NoErr(err)
+			is.NoErr(server.DB.Create(stale).Error)
+			staleCookie := login(staleEmail)
+			err = server.DB.Model(&models.Session{}).
+				Where("user_id = ?", stale.ID).
+				Update("auth_time", time.Now().UTC().Add(-time.Hour)).Error
+			is.NoErr(err)
+
+			rr := request(staleCookie, "GET", "/export", "")
+			is.Equal(rr.Code, http.StatusForbidden)
+			rr = request(staleCookie, "POST", "/export", `{"format": "json"}`)
+			is.Equal(rr.Code, http.StatusForbidden)
+		})
+
+		t.Run("admin export", func(t *testing.T) {
+			path := "/admin/users/" + user.ID.String() + "/export"
+			rr := request(userCookie, "GET", path, "")
+			is.Equal(rr.Code, http.StatusForbidden)
+
+			rr = request(adminCookie, "GET", path, "")
+			is.Equal(rr.Code, http.StatusOK)
+			var bundle services.ExportBundle
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &bundle))
+			is.Equal(bundle.User.ID, user.ID)
+
+			rr = request(adminCookie, "POST", path, "")
+			is.Equal(rr.Code, http.StatusAccepted)
+
+			rr = request(adminCookie, "GET", "/admin/users/00000000-0000-0000-0000-000000000000/export", "")
+			is.Equal(rr.Code, http.StatusNotFound)
+		})
+	})
+}
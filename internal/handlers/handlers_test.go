@@ -15,10 +15,29 @@ type UserCredentialsRequest struct {
 	Password string
 }
 
+// Adapters the handler tests run against
+const (
+	adapterGin  = "gin"
+	adapterHTTP = "net/http"
+)
+
+// adapters lists every adapter the handler tests run against
+var adapters = []string{adapterGin, adapterHTTP}
+
 func TestMain(m *testing.M) {
 	testutils.TestEnvSetup()
 
 	gin.SetMode(gin.TestMode)
 	gin.DefaultWriter = io.Discard
+
 	os.Exit(m.Run())
 }
+
+// forEachAdapter runs `test` as a subtest for each adapter, named after it
+func forEachAdapter(t *testing.T, test func(t *testing.T, adapter string)) {
+	for _, adapter := range adapters {
+		t.Run(adapter, func(t *testing.T) {
+			test(t, adapter)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// maxIntrospectionBytes is the largest request body accepted by Introspect
+const maxIntrospectionBytes = 8 << 10
+
+type IntrospectionHandler struct {
+	IntrospectionService *services.IntrospectionService
+}
+
+func NewIntrospectionHandler(introspectionService *services.IntrospectionService) (*IntrospectionHandler, error) {
+	if introspectionService == nil {
+		return nil, apperrors.ErrIntrospectionServiceIsNil
+	}
+	return &IntrospectionHandler{IntrospectionService: introspectionService}, nil
+}
+
+// Introspect godoc
+// @Summary Introspect a session token
+// @Schemes
+// @Description RFC 7662 token introspection for other backend services. The caller authenticates with HTTP Basic using an `id:secret` pair from INTROSPECTION_CLIENTS and posts the session token as the form field `token`. Answers `{"active": false}` for malformed, expired, revoked or unknown tokens, and otherwise the user ID (`sub`), email, roles and expiry. The session is not rotated. With INTROSPECTION_CACHE_SECONDS set, results are reused per caller and token for that long.
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "session token to introspect"
+// @Success 200 {object} models.IntrospectionResponse "whether the token is active and whose it is"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "invalid client credentials"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /introspect [post]
+func (ih *IntrospectionHandler) Introspect(c *Exchange) {
+	clientIP := c.ClientIP()
+	// Results must not be cached by intermediaries
+	c.Header("Cache-Control", "no-store")
+
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok || !ih.IntrospectionService.AuthenticateClient(clientID, secret) {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("clientID", clientID).
+			Msg("Introspection client authentication failed")
+		c.Header("WWW-Authenticate", `Basic realm="introspect"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, H{"error": apperrors.ErrIntrospectionClient.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIntrospectionBytes)
+	token := c.Request.PostFormValue("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": apperrors.ErrSessionIdIsEmpty.Error()})
+		return
+	}
+
+	response, err := ih.IntrospectionService.Introspect(clientID, token)
+	if err != nil {
+		log.Error().
+			Str("clientIP", clientIP).
+			Str("clientID", clientID).
+			Str("error", err.Error()).
+			Msg("Failed to introspect token")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": apperrors.ErrIntrospectionFailed.Error()})
+		return
+	}
+
+	log.Debug().
+		Str("clientID", clientID).
+		Bool("active", response.Active).
+		Msg("Token introspected")
+	c.JSON(http.StatusOK, response)
+}
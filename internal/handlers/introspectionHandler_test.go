@@ -0,0 +1,98 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewIntrospectionHandler checks the NewIntrospectionHandler constructor
+func TestHandlers_NewIntrospectionHandler(t *testing.T) {
+	is := is.New(t)
+
+	ih, err := handlers.NewIntrospectionHandler(nil)
+	is.Equal(ih, nil)
+	is.Equal(err, apperrors.ErrIntrospectionServiceIsNil)
+}
+
+// TestIntrospectionHandler_Introspect checks introspecting session tokens
+// with client credentials
+func TestIntrospectionHandler_Introspect(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+		server.HandlerRegistry.Introspection.IntrospectionService.Clients = map[string]string{"billing": "s3cret"}
+
+		email := "testIntrospectionHandler@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
+
+		rr, err := makeRequest(
+			server.Router,
+			"POST",
+			"/login",
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+		)
+		is.NoErr(err)
+		is.Equal(rr.Code, http.StatusOK)
+		token := getSessionCookie(rr).Value
+
+		introspect := func(clientID, secret, token string) *httptest.ResponseRecorder {
+			form := url.Values{"token": {token}}
+			req := httptest.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if clientID != "" {
+				req.SetBasicAuth(clientID, secret)
+			}
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		t.Run("active token", func(t *testing.T) {
+			rr := introspect("billing", "s3cret", token)
+			is.Equal(rr.Code, http.StatusOK)
+			is.Equal(rr.Header().Get("Cache-Control"), "no-store")
+
+			var response models.IntrospectionResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.True(response.Active)
+			is.Equal(response.Subject, user.ID.String())
+			is.Equal(response.Email, email)
+			is.Equal(response.Roles, []string{models.RoleUser})
+			is.True(response.ExpiresAt > 0)
+		})
+
+		t.Run("inactive tokens", func(t *testing.T) {
+			for _, token := range []string{"not-a-token", token + "x"} {
+				rr := introspect("billing", "s3cret", token)
+				is.Equal(rr.Code, http.StatusOK)
+				is.Equal(strings.TrimSpace(rr.Body.String()), `{"active":false}`)
+			}
+		})
+
+		t.Run("missing token", func(t *testing.T) {
+			rr := introspect("billing", "s3cret", "")
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+
+		t.Run("invalid client credentials", func(t *testing.T) {
+			for _, creds := range [][2]string{{"", ""}, {"billing", "wrong"}, {"search", "s3cret"}} {
+				rr := introspect(creds[0], creds[1], token)
+				is.Equal(rr.Code, http.StatusUnauthorized)
+				is.True(rr.Header().Get("WWW-Authenticate") != "")
+			}
+		})
+	})
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// maxTokenRequestBytes is the largest request body accepted by Token
+const maxTokenRequestBytes = 16 << 10
+
+// OAuth 2.0 error codes answered by Token, from RFC 6749
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthServerError          = "server_error"
+)
+
+type ServiceAccountHandler struct {
+	ServiceAccountService *services.ServiceAccountService
+}
+
+func NewServiceAccountHandler(serviceAccountService *services.ServiceAccountService) (*ServiceAccountHandler, error) {
+	if serviceAccountService == nil {
+		return nil, apperrors.ErrServiceAccountServiceIsNil
+	}
+	return &ServiceAccountHandler{ServiceAccountService: serviceAccountService}, nil
+}
+
+// Token godoc
+// @Summary Get an access token for a service account
+// @Schemes
+// @Description OAuth 2.0 client credentials grant (RFC 6749 section 4.4). Service accounts with a secret authenticate with HTTP Basic or the `client_id` and `client_secret` form fields; those with a public key send a `private_key_jwt` client assertion (RFC 7523) whose audience is TOKEN_ENDPOINT_URL. `scope` optionally narrows the token to some of the account's scopes. The token is sent as `Authorization: Bearer <token>` and expires after 15 minutes.
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "client_credentials"
+// @Param scope formData string false "space separated scopes"
+// @Param client_id formData string false "client ID, unless sent with HTTP Basic"
+// @Param client_secret formData string false "client secret, unless sent with HTTP Basic"
+// @Param client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param client_assertion formData string false "signed JWT for private_key_jwt"
+// @Success 200 {object} models.TokenResponse "the access token and its lifetime in seconds"
+// @Failure 400 {object} models.OAuthErrorResponse "invalid_request, invalid_scope or unsupported_grant_type"
+// @Failure 401 {object} models.OAuthErrorResponse "invalid_client"
+// @Failure 500 {object} models.OAuthErrorResponse "server_error"
+// @Router /oauth/token [post]
+func (sh *ServiceAccountHandler) Token(c *Exchange) {
+	clientIP := c.ClientIP()
+	// Tokens must not be cached by intermediaries
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTokenRequestBytes)
+	if err := c.Request.ParseForm(); err != nil {
+		oauthError(c, http.StatusBadRequest, oauthInvalidRequest, apperrors.ErrInvalidTokenRequest)
+		return
+	}
+	form := c.Request.PostForm
+	if form.Get("grant_type") != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, oauthUnsupportedGrantType, apperrors.ErrUnsupportedGrantType)
+		return
+	}
+
+	basicID, basicSecret, hasBasic := c.Request.BasicAuth()
+	clientID := form.Get("client_id")
+	assertionType := form.Get("client_assertion_type")
+	hasSecret := form.Get("client_secret") != ""
+	hasAssertion := assertionType != "" || form.Get("client_assertion") != ""
+
+	// Clients must use exactly one authentication method
+	methods := 0
+	for _, used := range []bool{hasBasic, hasSecret, hasAssertion} {
+		if used {
+			methods++
+		}
+	}
+	if methods != 1 || (hasAssertion && assertionType != services.ClientAssertionType) {
+		oauthError(c, http.StatusBadRequest, oauthInvalidRequest, apperrors.ErrInvalidTokenRequest)
+		return
+	}
+
+	var account *models.ServiceAccount
+	var err error
+	switch {
+	case hasBasic:
+		clientID = basicID
+		account, err = sh.ServiceAccountService.AuthenticateClientSecret(basicID, basicSecret)
+	case hasSecret:
+		account, err = sh.ServiceAccountService.AuthenticateClientSecret(clientID, form.Get("client_secret"))
+	default:
+		account, err = sh.ServiceAccountService.AuthenticateClientAssertion(clientID, form.Get("client_assertion"))
+	}
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("clientID", clientID).
+			Str("error", err.Error()).
+			Msg("Service account authentication failed")
+
+		if hasBasic {
+			c.Header("WWW-Authenticate", `Basic realm="token"`)
+		}
+		// The reason an assertion was refused is only logged
+		oauthError(c, http.StatusUnauthorized, oauthInvalidClient, apperrors.ErrInvalidClient)
+		return
+	}
+
+	issued, err := sh.ServiceAccountService.IssueToken(account, strings.Fields(form.Get("scope")))
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("clientID", account.ClientID).
+			Str("error", err.Error()).
+			Msg("Failed to issue service account token")
+
+		if errors.Is(err, apperrors.ErrInvalidScope) {
+			oauthError(c, http.StatusBadRequest, oauthInvalidScope, err)
+			return
+		}
+		oauthError(c, http.StatusInternalServerError, oauthServerError, apperrors.ErrIssuingToken)
+		return
+	}
+
+	log.Info().
+		Str("clientIP", clientIP).
+		Str("clientID", account.ClientID).
+		Strs("scopes", issued.Scopes).
+		Msg("Service account token issued")
+
+	c.JSON(http.StatusOK, models.TokenResponse{
+		AccessToken: issued.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(issued.ExpiresAt).Round(time.Second).Seconds()),
+		Scope:       strings.Join(issued.Scopes, " "),
+	})
+}
+
+// CreateServiceAccount godoc
+// @Summary Create a service account
+// @Schemes
+// @Description Create a service account for a machine caller. Scopes are profile and admin. With a PEM encoded RSA (2048 bits or more), P-256 or Ed25519 `publicKey`, the account authenticates with `private_key_jwt`; otherwise it gets a client secret, which is only shown in this response.
+// @Accept json
+// @Produce json
+// @Param request body models.CreateServiceAccountRequest true "name, scopes and optional public key"
+// @Success 201 {object} models.ServiceAccountResponse "the client secret and the service account"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/serviceaccounts [post]
+func (sh *ServiceAccountHandler) CreateServiceAccount(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	var body models.CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	created, err := sh.ServiceAccountService.CreateServiceAccount(adminID, body.Name, body.Scopes, body.PublicKey)
+	if err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to create service account")
+
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, apperrors.ErrServiceAccountName),
+			errors.Is(err, apperrors.ErrServiceAccountScope),
+			errors.Is(err, apperrors.ErrServiceAccountNoScopes),
+			errors.Is(err, apperrors.ErrServiceAccountPublicKey):
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("serviceAccountID", created.Info.ID.String()).
+		Str("clientID", created.Info.ClientID).
+		Msg("service account created")
+
+	// The secret must not be cached by intermediaries
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, models.ServiceAccountResponse{ClientSecret: created.ClientSecret, ServiceAccount: created.Info})
+}
+
+// ListServiceAccounts godoc
+// @Summary List service accounts
+// @Schemes
+// @Description List every service account, newest first, with its client ID, authentication method, scopes and when it last got a token
+// @Produce json
+// @Success 200 {object} models.ServiceAccountsResponse "response with serviceAccounts field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/serviceaccounts [get]
+func (sh *ServiceAccountHandler) ListServiceAccounts(c *Exchange) {
+	accounts, err := sh.ServiceAccountService.ListServiceAccounts()
+	if err != nil {
+		log.Error().
+			Str("clientIP", c.ClientIP()).
+			Str("error", err.Error()).
+			Msg("failed to list service accounts")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ServiceAccountsResponse{ServiceAccounts: accounts})
+}
+
+// RotateServiceAccountSecret godoc
+// @Summary Rotate a service account's client secret
+// @Schemes
+// @Description Give a service account a new client secret, which is only shown in this response. The old secret and the tokens issued with it stop working right away.
+// @Produce json
+// @Param id path string true "service account ID"
+// @Success 200 {object} models.ServiceAccountResponse "the new client secret and the service account"
+// @Failure 400 {object} models.ErrorResponse "the service account authenticates with a public key"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/serviceaccounts/{id}/secret [post]
+func (sh *ServiceAccountHandler) RotateServiceAccountSecret(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	rotated, err := sh.ServiceAccountService.RotateServiceAccountSecret(adminID, c.Param("id"))
+	if err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to rotate service account secret")
+		c.AbortWithStatusJSON(serviceAccountErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("serviceAccountID", rotated.Info.ID.String()).
+		Msg("service account secret rotated")
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.ServiceAccountResponse{ClientSecret: rotated.ClientSecret, ServiceAccount: rotated.Info})
+}
+
+// DeleteServiceAccount godoc
+// @Summary Delete a service account
+// @Schemes
+// @Description Delete a service account by its ID. Its tokens stop working right away.
+// @Produce json
+// @Param id path string true "service account ID"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/serviceaccounts/{id} [delete]
+func (sh *ServiceAccountHandler) DeleteServiceAccount(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	if err := sh.ServiceAccountService.DeleteServiceAccount(adminID, c.Param("id")); err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to delete service account")
+		c.AbortWithStatusJSON(serviceAccountErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("serviceAccountID", c.Param("id")).
+		Msg("service account deleted")
+
+	c.JSON(http.StatusOK, H{"message": "service account deleted"})
+}
+
+// serviceAccountErrorStatus maps service account management errors to
+// response statuses
+func serviceAccountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrServiceAccountNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperrors.ErrServiceAccountNoSecret):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// oauthError responds like RFC 6749 section 5.2, with an OAuth error code and
+// the error as its description
+func oauthError(c *Exchange, status int, code string, err error) {
+	c.AbortWithStatusJSON(status, models.OAuthErrorResponse{Error: code, ErrorDescription: err.Error()})
+}
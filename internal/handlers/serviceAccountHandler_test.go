@@ -0,0 +1,217 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewServiceAccountHandler checks the NewServiceAccountHandler constructor
+func TestHandlers_NewServiceAccountHandler(t *testing.T) {
+	is := is.New(t)
+
+	sh, err := handlers.NewServiceAccountHandler(nil)
+	is.Equal(sh, nil)
+	is.Equal(err, apperrors.ErrServiceAccountServiceIsNil)
+}
+
+// TestServiceAccountHandler_ServiceAccounts checks creating service accounts
+// as an admin, exchanging their client credentials for access tokens, and
+// what those tokens can reach
+func TestServiceAccountHandler_ServiceAccounts(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		email := "testServiceAccountHandler@test.com"
+		admin, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		admin.Role = models.RoleAdmin
+		is.NoErr(server.DB.Create(admin).Error)
+
+		loginRR, err := makeRequest(
+			server.Router,
+			"POST",
+			"/login",
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+		)
+		is.NoErr(err)
+		sessionCookie := getSessionCookie(loginRR)
+		is.True(sessionCookie != nil)
+
+		withSession := func(method, path string, body any) *httptest.ResponseRecorder {
+			jsonData, _ := json.Marshal(body)
+			req, err := http.NewRequest(method, path, bytes.NewBuffer(jsonData))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+		withToken := func(method, path, token string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest(method, path, nil)
+			is.NoErr(err)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+		tokenRequest := func(form url.Values, clientID, secret string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if clientID != "" {
+				req.SetBasicAuth(clientID, secret)
+			}
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := withSession("POST", "/admin/serviceaccounts", models.CreateServiceAccountRequest{
+			Name:   "billing",
+			Scopes: []string{models.ScopeProfile, models.ScopeAdmin},
+		})
+		is.Equal(rr.Code, http.StatusCreated)
+		is.Equal(rr.Header().Get("Cache-Control"), "no-store")
+		var created models.ServiceAccountResponse
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &created))
+		is.True(strings.HasPrefix(created.ClientSecret, models.ClientSecretPrefix))
+		clientID := created.ServiceAccount.ClientID
+
+		t.Run("invalid accounts", func(t *testing.T) {
+			rr := withSession("POST", "/admin/serviceaccounts", models.CreateServiceAccountRequest{Name: "billing", Scopes: []string{models.ScopeSessions}})
+			is.Equal(rr.Code, http.StatusBadRequest)
+			rr = withSession("POST", "/admin/serviceaccounts", models.CreateServiceAccountRequest{Name: "billing", Scopes: []string{models.ScopeProfile}, PublicKey: "not a key"})
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+
+		t.Run("token endpoint errors", func(t *testing.T) {
+			form := url.Values{"grant_type": {"client_credentials"}}
+			rr := tokenRequest(form, clientID, "wrong")
+			is.Equal(rr.Code, http.StatusUnauthorized)
+			is.True(strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Basic"))
+			var oauthErr models.OAuthErrorResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &oauthErr))
+			is.Equal(oauthErr.Error, "invalid_client")
+
+			rr = tokenRequest(url.Values{"grant_type": {"password"}}, clientID, created.ClientSecret)
+			is.Equal(rr.Code, http.StatusBadRequest)
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &oauthErr))
+			is.Equal(oauthErr.Error, "unsupported_grant_type")
+
+			form.Set("scope", models.ScopeExport)
+			rr = tokenRequest(form, clientID, created.ClientSecret)
+			is.Equal(rr.Code, http.StatusBadRequest)
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &oauthErr))
+			is.Equal(oauthErr.Error, "invalid_scope")
+		})
+
+		// The secret can also be sent in the form
+		rr = tokenRequest(url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {created.ClientSecret},
+			"scope":         {models.ScopeProfile},
+		}, "", "")
+		is.Equal(rr.Code, http.StatusOK)
+		is.Equal(rr.Header().Get("Cache-Control"), "no-store")
+		var profileToken models.TokenResponse
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &profileToken))
+		is.True(models.IsServiceAccountToken(profileToken.AccessToken))
+		is.Equal(profileToken.TokenType, "Bearer")
+		is.Equal(profileToken.Scope, models.ScopeProfile)
+
+		t.Run("whoami tells machines from users", func(t *testing.T) {
+			rr := withToken("GET", "/whoami", profileToken.AccessToken)
+			is.Equal(rr.Code, http.StatusOK)
+			var response map[string]any
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(response["principalType"], "service_account")
+			is.Equal(response["clientID"], clientID)
+			is.Equal(response["email"], nil)
+
+			rr = withSession("GET", "/whoami", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(response["principalType"], "user")
+		})
+
+		t.Run("tokens only reach the routes of their scopes", func(t *testing.T) {
+			is.Equal(withToken("GET", "/sessions", profileToken.AccessToken).Code, http.StatusForbidden)
+			is.Equal(withToken("DELETE", "/deleteaccount", profileToken.AccessToken).Code, http.StatusForbidden)
+			is.Equal(withToken("GET", "/admin/serviceaccounts", profileToken.AccessToken).Code, http.StatusForbidden)
+		})
+
+		t.Run("admin tokens cannot manage service accounts", func(t *testing.T) {
+			rr := tokenRequest(url.Values{"grant_type": {"client_credentials"}}, clientID, created.ClientSecret)
+			is.Equal(rr.Code, http.StatusOK)
+			var adminToken models.TokenResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &adminToken))
+			is.Equal(adminToken.Scope, "admin profile")
+
+			rr = withToken("GET", "/admin/users/"+admin.ID.String()+"/export", adminToken.AccessToken)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Otherwise a leaked admin token could mint credentials for itself
+			is.Equal(withToken("GET", "/admin/serviceaccounts", adminToken.AccessToken).Code, http.StatusForbidden)
+			is.Equal(withToken("POST", "/admin/serviceaccounts/"+created.ServiceAccount.ID.String()+"/secret", adminToken.AccessToken).Code, http.StatusForbidden)
+			is.Equal(withToken("GET", "/admin/webhooks", adminToken.AccessToken).Code, http.StatusForbidden)
+		})
+
+		t.Run("admin sessions list service accounts", func(t *testing.T) {
+			rr := withSession("GET", "/admin/serviceaccounts", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			var response models.ServiceAccountsResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(len(response.ServiceAccounts), 1)
+			is.True(!bytes.Contains(rr.Body.Bytes(), []byte(created.ClientSecret)))
+		})
+
+		t.Run("rotating the secret revokes tokens", func(t *testing.T) {
+			rr := withSession("POST", "/admin/serviceaccounts/"+created.ServiceAccount.ID.String()+"/secret", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			var rotated models.ServiceAccountResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &rotated))
+			is.True(rotated.ClientSecret != created.ClientSecret)
+
+			is.Equal(withToken("GET", "/whoami", profileToken.AccessToken).Code, http.StatusUnauthorized)
+			form := url.Values{"grant_type": {"client_credentials"}}
+			is.Equal(tokenRequest(form, clientID, created.ClientSecret).Code, http.StatusUnauthorized)
+			is.Equal(tokenRequest(form, clientID, rotated.ClientSecret).Code, http.StatusOK)
+		})
+
+		t.Run("deleted accounts are refused", func(t *testing.T) {
+			path := "/admin/serviceaccounts/" + created.ServiceAccount.ID.String()
+			is.Equal(withSession("DELETE", path, nil).Code, http.StatusOK)
+			is.Equal(withSession("DELETE", path, nil).Code, http.StatusNotFound)
+		})
+
+		t.Run("stale admin sessions must reauthenticate", func(t *testing.T) {
+			err := server.DB.Model(&models.Session{}).
+				Where("user_id = ?", admin.ID).
+				Update("auth_time", time.Now().UTC().Add(-time.Hour)).Error
+			is.NoErr(err)
+
+			rr := withSession("GET", "/admin/serviceaccounts", nil)
+			is.Equal(rr.Code, http.StatusForbidden)
+			is.True(strings.Contains(rr.Body.String(), apperrors.ErrRecentAuthRequired.Error()))
+			rr = withSession("POST", "/admin/serviceaccounts", models.CreateServiceAccountRequest{Name: "billing", Scopes: []string{models.ScopeProfile}})
+			is.Equal(rr.Code, http.StatusForbidden)
+		})
+	})
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 
+	"github.com/al-ce/goauth/internal/cookies"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/services"
 	"github.com/al-ce/goauth/pkg/apperrors"
 	"github.com/al-ce/goauth/pkg/config"
@@ -23,18 +27,48 @@ func NewUserHandler(userService *services.UserService) (*UserHandler, error) {
 	return &UserHandler{UserService: userService}, nil
 }
 
+// abortWithUserError aborts with a password policy violation as a 400 listing
+// each failed rule, a full password hashing queue as a 503 with `Retry-After`,
+// a user hook veto with the hook's status and message, a failed user hook as
+// a 500, or with `status` for any other error
+func abortWithUserError(c *Exchange, status int, err error) {
+	if veto, ok := services.AsHookVeto(err); ok {
+		c.AbortWithStatusJSON(veto.Status, H{"error": veto.Message})
+		return
+	}
+	if errors.Is(err, apperrors.ErrHookFailed) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, apperrors.ErrHashingBusy) {
+		c.Header("Retry-After", strconv.Itoa(config.HashRetryAfter))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, H{"error": err.Error()})
+		return
+	}
+	if perr, ok := passwords.AsPolicyError(err); ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{
+			"error":  apperrors.ErrPasswordPolicy.Error(),
+			"fields": perr.Violations,
+		})
+		return
+	}
+	c.AbortWithStatusJSON(status, H{"error": err.Error()})
+}
+
 // RegisterUser godoc
 // @Summary register a new user
 // @Schemes
-// @Description Add a new user to the database from a valid email and password
+// @Description Add a new user to the database from a valid email and password. With ENUMERATION_PROTECTION enabled the response is always "Check your email to continue" and the owner of an existing account is notified by email instead.
 // @Accept json
 // @Produce json
 // @Param request body models.UserCredentialsRequest true "User registration credentials"
 // @Success 200 {object} models.MessageResponse "response with message field"
-// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 400 {object} models.PolicyErrorResponse "response with error field and, for password policy violations, a fields list"
+// @Failure 403 {object} models.ErrorResponse "refused by a user hook, which may answer another 4xx status"
 // @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Failure 503 {object} models.ErrorResponse "password hashing queue is full, retry after the Retry-After header"
 // @Router /register [post]
-func (uh *UserHandler) RegisterUser(c *gin.Context) {
+func (uh *UserHandler) RegisterUser(c *Exchange) {
 	var body struct {
 		Email    string `json:"email" binding:"required"`
 		Password string `json:"password" binding:"required"`
@@ -50,7 +84,7 @@ func (uh *UserHandler) RegisterUser(c *gin.Context) {
 			Str("error", err.Error()).
 			Msg("Bad user registration request")
 		err = apperrors.ErrMissingCredentials
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
 		return
 	}
 
@@ -62,7 +96,7 @@ func (uh *UserHandler) RegisterUser(c *gin.Context) {
 			Str("error", err.Error()).
 			Msg("User registration failed")
 
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithUserError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -72,7 +106,12 @@ func (uh *UserHandler) RegisterUser(c *gin.Context) {
 		Str("clientIP", clientIP).
 		Msg("User registration success")
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("User %s created", body.Email)})
+	// The same answer whether or not the email was already registered
+	if uh.UserService.EnumerationResistant {
+		c.JSON(http.StatusOK, H{"message": "Check your email to continue"})
+		return
+	}
+	c.JSON(http.StatusOK, H{"message": fmt.Sprintf("User %s created", body.Email)})
 }
 
 // LoginUser godoc
@@ -85,8 +124,10 @@ func (uh *UserHandler) RegisterUser(c *gin.Context) {
 // @Success 200 {object} models.MessageResponse "response with message field"
 // @Failure 400 {object} models.ErrorResponse "response with error field"
 // @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "refused by a user hook, which may answer another 4xx status"
+// @Failure 503 {object} models.ErrorResponse "password hashing queue is full, retry after the Retry-After header"
 // @Router /login [post]
-func (uh *UserHandler) Login(c *gin.Context) {
+func (uh *UserHandler) Login(c *Exchange) {
 	var body struct {
 		Email    string `json:"email" binding:"required"`
 		Password string `json:"password" binding:"required"`
@@ -103,7 +144,7 @@ func (uh *UserHandler) Login(c *gin.Context) {
 			Msg("Bad user login request")
 
 		err = apperrors.ErrMissingCredentials
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
 		return
 	}
 
@@ -123,20 +164,138 @@ func (uh *UserHandler) Login(c *gin.Context) {
 			status = http.StatusBadRequest
 		}
 
-		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		abortWithUserError(c, status, err)
 		return
 	}
 
 	// Set session cookie
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie(config.SessionCookieName, sessionToken, config.SessionExpiration, "", "", true, true)
+	expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
+	cookies.Default().SetSession(c.Writer, sessionToken, expiresAt)
 
 	log.Info().
 		Str("email", body.Email).
 		Str("clientIP", clientIP).
 		Msg("login success")
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, H{
+		"message": "login success",
+	})
+}
+
+// RequestMagicLink godoc
+// @Summary email a login link
+// @Schemes
+// @Description Email a single-use login link to an account, for passwordless login. The link expires after 15 minutes and only works in the browser that asked for it, which is bound to it with a cookie. Asking again from the same browser keeps the cookie, so earlier links still work. The response is the same whether or not the account exists; nothing is sent to locked accounts, accounts pending deletion, after 3 links to the account or after 10 requests from the client's IP address in 15 minutes.
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkRequest true "email of the account"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /login/magic [post]
+func (uh *UserHandler) RequestMagicLink(c *Exchange) {
+	var body struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	clientIP := c.ClientIP()
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Bad magic link request")
+
+		err = apperrors.ErrEmailIsEmpty
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	// Keep the browser token of earlier requests, so their links still work
+	browserToken, _ := cookies.Default().MagicLinkToken(c.Request)
+	browserToken, err := uh.UserService.RequestMagicLink(body.Email, browserToken, clientIP)
+	if err != nil {
+		log.Info().
+			Str("email", body.Email).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Magic link request failed")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, apperrors.ErrEmailIsEmpty) || errors.Is(err, apperrors.ErrEmailFormat) {
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	// Bind the link to this browser. The cookie is set whether or not a link
+	// was sent, so it does not tell either.
+	cookies.Default().SetMagicLink(c.Writer, browserToken)
+
+	log.Info().
+		Str("email", body.Email).
+		Str("clientIP", clientIP).
+		Msg("Magic link requested")
+
+	c.JSON(http.StatusOK, H{"message": "Check your email to continue"})
+}
+
+// MagicLinkLogin godoc
+// @Summary login with a magic link
+// @Schemes
+// @Description Login with the token of an emailed magic link, from the browser that asked for it. The link can only be used once.
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkLoginRequest true "magic link token"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 400 {object} models.ErrorResponse "the link is invalid, expired, used, or from another browser, or the account is locked"
+// @Failure 403 {object} models.ErrorResponse "refused by a user hook, which may answer another 4xx status"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /login/magic/verify [post]
+func (uh *UserHandler) MagicLinkLogin(c *Exchange) {
+	var body struct {
+		Token string `json:"token"`
+	}
+
+	clientIP := c.ClientIP()
+
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		err := apperrors.ErrMagicLinkInvalid
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	// A missing cookie fails like a cookie from another browser
+	browserToken, _ := cookies.Default().MagicLinkToken(c.Request)
+
+	sessionToken, err := uh.UserService.LoginWithMagicLink(body.Token, browserToken)
+	if err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("Magic link login failed")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, apperrors.ErrMagicLinkInvalid) ||
+			errors.Is(err, apperrors.ErrAccountIsLocked) ||
+			errors.Is(err, apperrors.ErrAccountPendingDeletion) {
+			status = http.StatusBadRequest
+		}
+		abortWithUserError(c, status, err)
+		return
+	}
+
+	// Set session cookie, and drop the binding cookie now the link is used
+	expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
+	cookies.Default().SetSession(c.Writer, sessionToken, expiresAt)
+	cookies.Default().ClearMagicLink(c.Writer)
+
+	log.Info().
+		Str("clientIP", clientIP).
+		Msg("login success")
+
+	c.JSON(http.StatusOK, H{
 		"message": "login success",
 	})
 }
@@ -149,16 +308,16 @@ func (uh *UserHandler) Login(c *gin.Context) {
 // @Failure 401 {object} models.ErrorResponse "unauthorized - cookie not found"
 // @Failure 500 {object} models.ErrorResponse "response with error field"
 // @Router /logout [post]
-func (uh *UserHandler) Logout(c *gin.Context) {
+func (uh *UserHandler) Logout(c *Exchange) {
 	clientIP := c.ClientIP()
 
-	sessionToken, err := c.Cookie(config.SessionCookieName)
+	sessionToken, err := cookies.Default().SessionToken(c.Request)
 	if err != nil {
 		log.Info().
 			Str("clientIP", clientIP).
 			Str("error", err.Error()).
 			Msg("Cookie not found")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		c.JSON(http.StatusUnauthorized, H{"error": "unauthorized"})
 		return
 	}
 
@@ -168,17 +327,17 @@ func (uh *UserHandler) Logout(c *gin.Context) {
 			Str("error", err.Error()).
 			Msg("Logout failed")
 
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
 		return
 	}
 
-	c.SetCookie(config.SessionCookieName, "", -1, "", "", true, true)
+	cookies.Default().ClearSession(c.Writer)
 
 	log.Info().
 		Str("clientIP", clientIP).
 		Msg("Logout success")
 
-	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+	c.JSON(http.StatusOK, H{"message": "logged out successfully"})
 }
 
 // LogoutEverywhere godoc
@@ -190,19 +349,11 @@ func (uh *UserHandler) Logout(c *gin.Context) {
 // @Failure 401 {object} models.ErrorResponse "response with error field"
 // @Failure 500 {object} models.ErrorResponse "response with error field"
 // @Router /logouteverywhere [post]
-func (uh *UserHandler) LogoutEverywhere(c *gin.Context) {
-	clientIP := c.ClientIP()
-
-	userIDStr, exists := c.Get("userID")
-	if !exists {
-		log.Info().
-			Str("clientIP", clientIP).
-			Msg("userID not found in context")
-
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+func (uh *UserHandler) LogoutEverywhere(c *Exchange) {
+	userID, ok := c.UserID()
+	if !ok {
 		return
 	}
-	userID := userIDStr.(string)
 
 	log.Info().
 		Str("userID", userID).
@@ -211,36 +362,120 @@ func (uh *UserHandler) LogoutEverywhere(c *gin.Context) {
 		Msg("User logged out from all devices")
 
 	if err := uh.UserService.LogoutEverywhere(userID); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+
+	cookies.Default().ClearSession(c.Writer)
+	c.JSON(http.StatusOK, H{"message": "logged out everywhere"})
+}
+
+// ListSessions godoc
+// @Summary List the user's sessions
+// @Schemes
+// @Description List the logged in user's unexpired sessions by public session ID, newest first, marking the session making the request as current
+// @Produce json
+// @Success 200 {object} models.SessionsResponse "response with sessions field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /sessions [get]
+func (uh *UserHandler) ListSessions(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+	current, _ := c.Identity()
+
+	sessions, err := uh.UserService.ListSessions(userID, current.SessionID)
+	if err != nil {
+		log.Error().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to list sessions")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, H{"sessions": sessions})
+}
+
+// RevokeSession godoc
+// @Summary Revoke one of the user's sessions
+// @Schemes
+// @Description End one of the logged in user's sessions by its public session ID, e.g. to sign out a lost device
+// @Produce json
+// @Param id path string true "public session ID"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /sessions/{id} [delete]
+func (uh *UserHandler) RevokeSession(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	userID, ok := c.UserID()
+	if !ok {
 		return
 	}
 
-	c.SetCookie(config.SessionCookieName, "", -1, "", "", true, true)
-	c.JSON(http.StatusOK, gin.H{"message": "logged out everywhere"})
+	if err := uh.UserService.RevokeSession(userID, c.Param("id")); err != nil {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to revoke session")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, apperrors.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Str("sessionID", c.Param("id")).
+		Msg("session revoked")
+
+	c.JSON(http.StatusOK, H{"message": "session revoked"})
 }
 
 // WhoAmI godoc
 // @Summary Get information about the currently logged in user
 // @Schemes
-// @Description Get a user's client IP, email, last login time, and user ID (can be extended)
+// @Description Get a user's client IP, email, last login time, and user ID (can be extended). `principalType` is `user`, or `service_account` for service accounts, which get their service account ID, client ID and scopes instead.
 // @Produce json
 // @Success 200 {object} models.MessageResponse "response with message field"
 // @Failure 400 {object} models.ErrorResponse "response with error field"
 // @Failure 401 {object} models.ErrorResponse "response with error field"
 // @Router /whoami [get]
-func (uh *UserHandler) WhoAmI(c *gin.Context) {
+func (uh *UserHandler) WhoAmI(c *Exchange) {
 	clientIP := c.ClientIP()
 
-	userIDStr, exists := c.Get("userID")
-	if !exists {
+	id, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	if id.IsServiceAccount() {
 		log.Info().
 			Str("clientIP", clientIP).
-			Msg("userID not found in context")
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			Msg("service account profile request successful")
+
+		c.JSON(http.StatusOK, H{
+			"principalType":    id.Principal(),
+			"clientIP":         clientIP,
+			"clientID":         id.ClientID,
+			"scopes":           id.Scopes,
+			"serviceAccountID": id.ServiceAccountID.String(),
+		})
 		return
 	}
-
-	userID := userIDStr.(string)
+	userID := id.UserID.String()
 	userProfile, err := uh.UserService.GetUserProfile(userID)
 	if err != nil {
 		log.Info().
@@ -255,44 +490,104 @@ func (uh *UserHandler) WhoAmI(c *gin.Context) {
 		Str("clientIP", clientIP).
 		Msg("user profile request successful")
 
-	c.JSON(http.StatusOK, gin.H{
-		"clientIP":  clientIP,
-		"email":     userProfile.Email,
-		"lastLogin": userProfile.LastLogin,
-		"userID":    userID,
+	c.JSON(http.StatusOK, H{
+		"principalType":    id.Principal(),
+		"clientIP":         clientIP,
+		"email":            userProfile.Email,
+		"lastLogin":        userProfile.LastLogin,
+		"passwordBreached": userProfile.PasswordBreached,
+		"userID":           userID,
 	})
 }
 
-// UpdateUser godoc
-// @Summary update user credentials
+// Reauthenticate godoc
+// @Summary confirm the user's password
 // @Schemes
-// @Description Update a user's email or password in the database
+// @Description Verify the logged in user's password again, allowing the session to change credentials or delete the account for the next few minutes. Wrong passwords count towards the account lockout.
 // @Accept json
 // @Produce json
-// @Param request body models.UserCredentialsRequest true "User registration credentials"
+// @Param request body models.ReauthenticateRequest true "current password"
 // @Success 200 {object} models.MessageResponse "response with message field"
 // @Failure 400 {object} models.ErrorResponse "response with error field"
 // @Failure 401 {object} models.ErrorResponse "response with error field"
 // @Failure 500 {object} models.ErrorResponse "response with error field"
-// @Router /updateuser [post]
-func (uh *UserHandler) UpdateUser(c *gin.Context) {
+// @Failure 503 {object} models.ErrorResponse "password hashing queue is full, retry after the Retry-After header"
+// @Router /reauthenticate [post]
+func (uh *UserHandler) Reauthenticate(c *Exchange) {
 	clientIP := c.ClientIP()
 
-	userIDStr, exists := c.Get("userID")
-	if !exists {
+	userID, ok := c.UserID()
+	if !ok {
+		return
+	}
+	current, _ := c.Identity()
+
+	var body struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": apperrors.ErrPasswordIsEmpty.Error()})
+		return
+	}
+
+	if err := uh.UserService.Reauthenticate(userID, current.SessionID, body.Password); err != nil {
 		log.Info().
+			Str("userID", userID).
 			Str("clientIP", clientIP).
-			Msg("userID not found in context")
+			Str("error", err.Error()).
+			Msg("Reauthentication failed")
+		abortWithUserError(c, passwordCheckStatus(err), err)
+		return
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Msg("Reauthentication success")
 
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+	c.JSON(http.StatusOK, H{"message": "reauthenticated"})
+}
+
+// passwordCheckStatus returns the response status for an error from checking
+// a logged in user's password
+func passwordCheckStatus(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrInvalidLogin):
+		return http.StatusUnauthorized
+	case errors.Is(err, apperrors.ErrAccountIsLocked), errors.Is(err, apperrors.ErrPasswordIsEmpty):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UpdateUser godoc
+// @Summary update user credentials
+// @Schemes
+// @Description Update a user's email or password in the database. Requires a session that logged in or reauthenticated in the last 5 minutes, and the current password to change the password.
+// @Accept json
+// @Produce json
+// @Param request body models.UpdateUserRequest true "new email or password"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 400 {object} models.PolicyErrorResponse "response with error field and, for password policy violations, a fields list"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "the session must reauthenticate first, or a user hook refused"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Failure 503 {object} models.ErrorResponse "password hashing queue is full, retry after the Retry-After header"
+// @Router /updateuser [post]
+func (uh *UserHandler) UpdateUser(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	userID, ok := c.UserID()
+	if !ok {
 		return
 	}
-	userID := userIDStr.(string)
 
 	// Only accept email or password
 	var body struct {
-		Email    string `json:"email,omitempty" binding:"omitempty,email"`
-		Password string `json:"password,omitempty" binding:"omitempty,min=8"`
+		Email           string `json:"email,omitempty" binding:"omitempty,email"`
+		Password        string `json:"password,omitempty" binding:"omitempty"`
+		CurrentPassword string `json:"currentPassword,omitempty" binding:"omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&body); err != nil {
@@ -303,7 +598,7 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 			Msg("Bad user update request")
 
 		err = apperrors.ErrMissingCredentials
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
 		return
 	}
 
@@ -321,10 +616,28 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 			Str("clientIP", clientIP).
 			Msg("attempt to update user with empty value")
 
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no valid fields provided"})
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": "no valid fields provided"})
 		return
 	}
 
+	// A session alone is not enough to replace the password
+	if body.Password != "" {
+		if body.CurrentPassword == "" {
+			err := apperrors.ErrCurrentPasswordRequired
+			c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+			return
+		}
+		if err := uh.UserService.CheckPassword(userID, body.CurrentPassword); err != nil {
+			log.Info().
+				Str("userID", userID).
+				Str("clientIP", clientIP).
+				Str("error", err.Error()).
+				Msg("Password change with wrong current password")
+			abortWithUserError(c, passwordCheckStatus(err), err)
+			return
+		}
+	}
+
 	if err := uh.UserService.UpdateUser(userID, requestData); err != nil {
 		log.Error().
 			Str("email", body.Email).
@@ -332,7 +645,7 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 			Str("error", err.Error()).
 			Msg("failed to update user")
 
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithUserError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -341,49 +654,100 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 		Str("clientIP", clientIP).
 		Msg("successfully updated user")
 
-	c.JSON(http.StatusOK, gin.H{"message": "user updated"})
+	c.JSON(http.StatusOK, H{"message": "user updated"})
 }
 
-// PermanentlyDeleteUser godoc
+// DeleteAccount godoc
 // @Summary Delete a user
 // @Schemes
-// @Description Delete a user from the database permanently along with all their sessions
+// @Description Schedule the user's account for permanent deletion at the end of the grace period (ACCOUNT_DELETION_GRACE_DAYS, 30 days by default). All sessions are revoked, login is refused, and an email with a restore token is sent. Requires a session that logged in or reauthenticated in the last 5 minutes.
 // @Produce json
-// @Success 200 {object} models.MessageResponse "response with message field"
+// @Success 200 {object} models.DeletionScheduledResponse "response with message field and the time the account will be deleted"
 // @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "the session must reauthenticate first, or a user hook refused"
 // @Failure 500 {object} models.ErrorResponse "response with error field"
 // @Router /deleteaccount [DELETE]
-func (uh *UserHandler) PermanentlyDeleteUser(c *gin.Context) {
+func (uh *UserHandler) DeleteAccount(c *Exchange) {
 	clientIP := c.ClientIP()
-	userIDStr, exists := c.Get("userID")
-	if !exists {
+	id, ok := c.Identity()
+	if !ok {
 		log.Info().
 			Str("clientIP", clientIP).
-			Msg("userID not found in context")
-
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			Msg("identity not found in context")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, H{})
 		return
 	}
-	userID := userIDStr.(string)
-	err := uh.UserService.PermanentlyDeleteUser(userID)
+	userID := id.UserID.String()
+	purgeAfter, err := uh.UserService.RequestAccountDeletion(userID)
 	if err != nil {
 		log.Info().
 			Str("clientIP", clientIP).
 			Str("error", err.Error()).
-			Msg("failed to delete user")
+			Msg("failed to schedule user deletion")
 
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{})
+		if _, vetoed := services.AsHookVeto(err); vetoed {
+			abortWithUserError(c, http.StatusForbidden, err)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{})
 		return
 	}
 
-	// Account no longer exists, so we can clear cookie
-	// NOTE: we are assuming the database will delete all associated sessions once the
-	// corresponding user row is deleted
-	c.SetCookie(config.SessionCookieName, "", -1, "", "", true, true)
+	// The user's sessions were revoked, so we can clear cookie
+	cookies.Default().ClearSession(c.Writer)
+
+	log.Info().
+		Str("userID", userID).
+		Str("clientIP", clientIP).
+		Time("purgeAfter", purgeAfter).
+		Msg("successfully scheduled user deletion")
+
+	c.JSON(http.StatusOK, H{
+		"message":    "account scheduled for deletion",
+		"purgeAfter": purgeAfter,
+	})
+}
+
+// RestoreAccount godoc
+// @Summary Restore a user
+// @Schemes
+// @Description Cancel the pending deletion of an account with the restore token from the deletion email. The user can log in again afterwards.
+// @Accept json
+// @Produce json
+// @Param request body models.RestoreAccountRequest true "restore token"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 400 {object} models.ErrorResponse "the token is invalid or the grace period has ended"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /restoreaccount [post]
+func (uh *UserHandler) RestoreAccount(c *Exchange) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	clientIP := c.ClientIP()
+
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		err := apperrors.ErrRestoreTokenInvalid
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	if err := uh.UserService.RestoreAccount(body.Token); err != nil {
+		log.Info().
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to restore user")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, apperrors.ErrRestoreTokenInvalid) {
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, H{"error": err.Error()})
+		return
+	}
 
 	log.Info().
 		Str("clientIP", clientIP).
-		Msg("successfully deleted user")
+		Msg("successfully restored user")
 
-	c.JSON(http.StatusOK, gin.H{"message": "account deleted"})
+	c.JSON(http.StatusOK, H{"message": "account restored"})
 }
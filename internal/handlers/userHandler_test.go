@@ -2,18 +2,26 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/matryer/is"
 
+	"github.com/al-ce/goauth/internal/cookies"
 	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/mailer"
 	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/repository"
 	"github.com/al-ce/goauth/internal/server"
 	"github.com/al-ce/goauth/internal/services"
@@ -40,443 +48,715 @@ func TestHandlers_NewUserHandler(t *testing.T) {
 
 // TestUserHandle_RegisterUser checks that an http request can add a user to the database
 func TestUserHandler_RegisterUser(t *testing.T) {
-	is := is.New(t)
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+
+		email := "testRegisterUser@test.com"
+
+		t.Run("no email", func(t *testing.T) {
+			server := setupServer(t, adapter)
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/register",
+				UserCredentialsRequest{Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+		t.Run("no password", func(t *testing.T) {
+			server := setupServer(t, adapter)
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/register",
+				UserCredentialsRequest{Email: email},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
 
-	email := "testRegisterUser@test.com"
+		t.Run("valid request", func(t *testing.T) {
+			server := setupServer(t, adapter)
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/register",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Check user is actually in database
+			var user models.User
+			result := server.DB.First(&user, "email = ?", email)
+			is.NoErr(result.Error)
+			is.Equal(user.Email, email)
+		})
 
-	t.Run("no email", func(t *testing.T) {
-		server := setupServer(t)
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/register",
-			UserCredentialsRequest{Password: testutils.TestingPassword},
-		)
-		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusBadRequest)
-	})
-	t.Run("no password", func(t *testing.T) {
-		server := setupServer(t)
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/register",
-			UserCredentialsRequest{Email: email},
-		)
-		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusBadRequest)
+		t.Run("password policy violation", func(t *testing.T) {
+			server := setupServer(t, adapter)
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/register",
+				UserCredentialsRequest{Email: email, Password: "short"},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+
+			var response models.PolicyErrorResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(response.Error, apperrors.ErrPasswordPolicy.Error())
+			is.True(len(response.Fields) > 0)
+			is.Equal(response.Fields[0].Field, "password")
+			is.Equal(response.Fields[0].Rule, passwords.RuleMinLength)
+		})
 	})
+}
 
-	t.Run("valid request", func(t *testing.T) {
-		server := setupServer(t)
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/register",
-			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
-		)
-		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusOK)
+// TestUserHandler_EnumerationResistant checks responses do not reveal whether an account exists
+func TestUserHandler_EnumerationResistant(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+
+		t.Setenv(config.EnumerationProtection, "true")
+		server := setupServer(t, adapter)
+		email := "testEnumerationHandler@test.com"
+
+		t.Run("registration answers the same for new and existing emails", func(t *testing.T) {
+			first, err := makeRequest(server.Router, "POST", "/register",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword})
+			is.NoErr(err)
+			second, err := makeRequest(server.Router, "POST", "/register",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword})
+			is.NoErr(err)
+
+			is.Equal(first.Code, http.StatusOK)
+			is.Equal(second.Code, first.Code)
+			is.Equal(second.Body.String(), first.Body.String())
+		})
 
-		// Check user is actually in database
-		var user models.User
-		result := server.DB.First(&user, "email = ?", email)
-		is.NoErr(result.Error)
-		is.Equal(user.Email, email)
+		t.Run("login answers the same for unknown users and wrong passwords", func(t *testing.T) {
+			unknown, err := makeRequest(server.Router, "POST", "/login",
+				UserCredentialsRequest{Email: "doesNotExist@test.com", Password: testutils.TestingPassword})
+			is.NoErr(err)
+			wrong, err := makeRequest(server.Router, "POST", "/login",
+				UserCredentialsRequest{Email: email, Password: "wrong" + testutils.TestingPassword})
+			is.NoErr(err)
+
+			is.Equal(unknown.Code, http.StatusUnauthorized)
+			is.Equal(wrong.Code, unknown.Code)
+			is.Equal(wrong.Body.String(), unknown.Body.String())
+		})
 	})
 }
 
 func TestUserHandler_Login(t *testing.T) {
-	is := is.New(t)
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
 
-	server := setupServer(t)
-
-	// Register two test users directly to the DB
-	email1 := "testUserHandlerLoginUser@test.com"
-	password1 := testutils.TestingPassword // strong password for validator
-	email2 := "SECONDARYtestUserHandlerLoginUser@test.com"
-	password2 := "SECONDARY" + testutils.TestingPassword
-	user1, err := models.NewUser(email1, password1)
-	is.NoErr(err)
-	user2, err := models.NewUser(email2, password2)
-	is.NoErr(err)
-	err = server.DB.Create(user1).Error
-	is.NoErr(err)
-	err = server.DB.Create(user2).Error
-	is.NoErr(err)
-
-	t.Run("valid request", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Email: email1, Password: password1},
-		)
+		server := setupServer(t, adapter)
+
+		// Register two test users directly to the DB
+		email1 := "testUserHandlerLoginUser@test.com"
+		password1 := testutils.TestingPassword // strong password for validator
+		email2 := "SECONDARYtestUserHandlerLoginUser@test.com"
+		password2 := "SECONDARY" + testutils.TestingPassword
+		user1, err := models.NewUser(email1, password1)
+		is.NoErr(err)
+		user2, err := models.NewUser(email2, password2)
+		is.NoErr(err)
+		err = server.DB.Create(user1).Error
+		is.NoErr(err)
+		err = server.DB.Create(user2).Error
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusOK)
 
-		// Check cookie is set
-		sessionCookie := getSessionCookie(rr)
-		is.True(sessionCookie != nil)
+		t.Run("valid request", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1, Password: password1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Check cookie is set
+			sessionCookie := getSessionCookie(rr)
+			is.True(sessionCookie != nil)
+
+			// Session Token is valid and only its hash is stored
+			tokenHash, err := models.ParseSessionToken(sessionCookie.Value)
+			is.NoErr(err)
+			var session models.Session
+			err = server.DB.Where("token_hash = ?", tokenHash).First(&session).Error
+			is.NoErr(err)
+			is.Equal(session.UserID, user1.ID)
+		})
 
-		// Session Token is valid
-		sessionToken := sessionCookie.Value
-		parts := strings.Split(sessionToken, ".")
-		is.True(len(parts) == 2)
-		sessionID, signature := parts[0], parts[1]
-		parsedID, err := uuid.Parse(sessionID)
-		is.NoErr(err)
-		is.True(models.ValidateSessionID(parsedID, signature))
+		t.Run("configured cookie policy", func(t *testing.T) {
+			manager := cookies.NewManager()
+			manager.Prefix = cookies.PrefixHost
+			manager.ExpiryCookie = true
+			cookies.SetDefault(manager)
+			t.Cleanup(func() { cookies.SetDefault(nil) })
+
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1, Password: password1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+
+			var sessionCookie, expiryCookie *http.Cookie
+			for _, cookie := range rr.Result().Cookies() {
+				switch cookie.Name {
+				case "__Host-" + config.SessionCookieName:
+					sessionCookie = cookie
+				case "__Host-" + config.SessionExpiryCookieName:
+					expiryCookie = cookie
+				}
+			}
+			is.True(sessionCookie != nil)
+			is.True(sessionCookie.HttpOnly)
+			is.Equal(sessionCookie.Path, "/")
+
+			// SPAs can read when the session expires
+			is.True(expiryCookie != nil)
+			is.True(!expiryCookie.HttpOnly)
+			expiresAt, err := strconv.ParseInt(expiryCookie.Value, 10, 64)
+			is.NoErr(err)
+			is.True(expiresAt > time.Now().Unix())
+		})
+
+		t.Run("no email", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Password: password1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+		t.Run("no password", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+		t.Run("non-existent user", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: "doesNotExist@test.com", Password: password1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+		t.Run("incorrect password", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1, Password: "notthepassword"},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+		t.Run("existing password, mismatched existing user", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1, Password: password2},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+		t.Run("hashing queue full", func(t *testing.T) {
+			testutils.SaturateHashing(t)
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email1, Password: password1},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusServiceUnavailable)
+			is.Equal(rr.Header().Get("Retry-After"), strconv.Itoa(config.HashRetryAfter))
+		})
 	})
+}
 
-	t.Run("no email", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Password: password1},
-		)
+func TestUserHandler_Logout(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		// Register a test user
+		email := "testUserHandlerLogoutUser@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusBadRequest)
-	})
-	t.Run("no password", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Email: email1},
-		)
+		err = server.DB.Create(user).Error
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusBadRequest)
+
+		t.Run("valid token", func(t *testing.T) {
+			// Login test user
+			loginRR, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+
+			// Get cookie
+			sessionCookie := getSessionCookie(loginRR)
+			is.True(sessionCookie != nil)
+
+			// Logout
+			req, err := http.NewRequest("POST", "/logout", nil)
+			is.NoErr(err)
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Check cookie is cleared
+			logoutCookie := getSessionCookie(rr)
+			is.True(logoutCookie != nil)
+			is.Equal(logoutCookie.MaxAge, -1)
+
+			// Check response message
+			var response map[string]string
+			err = json.NewDecoder(rr.Body).Decode(&response)
+			is.NoErr(err)
+			is.Equal(response["message"], "logged out successfully")
+		})
+
+		t.Run("missing CSRF token", func(t *testing.T) {
+			loginRR, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			sessionCookie := getSessionCookie(loginRR)
+
+			// A cross-site form post carries the session cookie but no token
+			req, err := http.NewRequest("POST", "/logout", nil)
+			is.NoErr(err)
+			req.AddCookie(sessionCookie)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusForbidden)
+
+			// Session is still valid
+			req, err = http.NewRequest("GET", "/whoami", nil)
+			is.NoErr(err)
+			req.AddCookie(sessionCookie)
+			rr = httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+		})
+
+		t.Run("no token", func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/logout", nil)
+			is.NoErr(err)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+
+		t.Run("invalid token", func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/logout", nil)
+			is.NoErr(err)
+
+			// Create an invalid cookie
+			invalidCookie := &http.Cookie{
+				Name:     config.SessionCookieName,
+				Value:    "invalid-token",
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+			}
+			req.AddCookie(invalidCookie)
+			addCSRFToken(t, server.Router, req)
+
+			// Perform request
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusInternalServerError)
+		})
 	})
-	t.Run("non-existent user", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Email: "doesNotExist@test.com", Password: password1},
-		)
+}
+
+func TestUserHandler_LogoutEverywhere(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		// Register a test user
+		email := "testUserHandlerLogoutEverywhere@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusBadRequest)
-	})
-	t.Run("incorrect password", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Email: email1, Password: "notthepassword"},
-		)
+		err = server.DB.Create(user).Error
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusUnauthorized)
-	})
-	t.Run("existing password, mismatched existing user", func(t *testing.T) {
+
+		// Login on one "device"
 		rr, err := makeRequest(
 			server.Router,
 			"POST",
 			"/login",
-			UserCredentialsRequest{Email: email1, Password: password2},
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
 		)
 		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusUnauthorized)
-	})
-}
-
-func TestUserHandler_Logout(t *testing.T) {
-	is := is.New(t)
-	server := setupServer(t)
+		is.Equal(rr.Code, http.StatusOK)
 
-	// Register a test user
-	email := "testUserHandlerLogoutUser@test.com"
-	user, err := models.NewUser(email, testutils.TestingPassword)
-	is.NoErr(err)
-	err = server.DB.Create(user).Error
-	is.NoErr(err)
+		// Get token from cookies
+		firstCookie := getSessionCookie(rr)
+		is.True(firstCookie != nil)
+		firstToken := firstCookie.Value
+		is.True(firstToken != "")
 
-	t.Run("valid token", func(t *testing.T) {
-		// Login test user
-		loginRR, err := makeRequest(
+		// Login on another "device"
+		rr, err = makeRequest(
 			server.Router,
 			"POST",
 			"/login",
 			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
 		)
 		is.NoErr(err)
-
-		// Get cookie
-		sessionCookie := getSessionCookie(loginRR)
-		is.True(sessionCookie != nil)
-
-		// Logout
-		req, err := http.NewRequest("POST", "/logout", nil)
-		is.NoErr(err)
-		req.AddCookie(sessionCookie)
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
 		is.Equal(rr.Code, http.StatusOK)
 
-		// Check cookie is cleared
-		logoutCookie := getSessionCookie(rr)
-		is.True(logoutCookie != nil)
-		is.Equal(logoutCookie.MaxAge, -1)
+		// Get token from cookies
+		secondCookie := getSessionCookie(rr)
+		is.True(secondCookie != nil)
+		secondToken := secondCookie.Value
+		is.True(secondToken != "")
+		is.True(firstToken != secondToken)
+
+		t.Run("successfully logout everywhere", func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/logouteverywhere", nil)
+			is.NoErr(err)
+
+			// Add auth cookie
+			req.AddCookie(firstCookie)
+			addCSRFToken(t, server.Router, req)
+
+			// Logout everywhere
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Cookie is cleared
+			clearedCookie := getSessionCookie(rr)
+			is.True(clearedCookie != nil)
+			is.Equal(clearedCookie.MaxAge, -1) // Cookie should be expired
+
+			// Verify response body
+			var response map[string]string
+			err = json.NewDecoder(rr.Body).Decode(&response)
+			is.NoErr(err)
+			is.Equal(response["message"], "logged out everywhere")
+
+			// Check first token is invalidated
+			req, err = http.NewRequest("POST", "/logouteverywhere", nil)
+			is.NoErr(err)
+			req.AddCookie(firstCookie)
+			addCSRFToken(t, server.Router, req)
+			rr = httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+
+			// Check second token is invalidated
+			req, err = http.NewRequest("POST", "/logouteverywhere", nil)
+			is.NoErr(err)
+			req.AddCookie(secondCookie)
+			addCSRFToken(t, server.Router, req)
+			rr = httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
 
-		// Check response message
-		var response map[string]string
-		err = json.NewDecoder(rr.Body).Decode(&response)
-		is.NoErr(err)
-		is.Equal(response["message"], "logged out successfully")
-	})
+		t.Run("logout everywhere without token", func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/logouteverywhere", nil)
+			is.NoErr(err)
 
-	t.Run("no token", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/logout", nil)
-		is.NoErr(err)
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
 
-		is.Equal(rr.Code, http.StatusUnauthorized)
-	})
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
 
-	t.Run("invalid token", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/logout", nil)
-		is.NoErr(err)
+		t.Run("logout everywhere with invalid token", func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/logouteverywhere", nil)
+			is.NoErr(err)
 
-		// Create an invalid cookie
-		invalidCookie := &http.Cookie{
-			Name:     config.SessionCookieName,
-			Value:    "invalid-token",
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   true,
-		}
-		req.AddCookie(invalidCookie)
+			req.AddCookie(&http.Cookie{
+				Name:     config.SessionCookieName,
+				Value:    "invalid-token",
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+			})
+			addCSRFToken(t, server.Router, req)
 
-		// Perform request
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusInternalServerError)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
 	})
 }
 
-func TestUserHandler_LogoutEverywhere(t *testing.T) {
-	is := is.New(t)
-	server := setupServer(t)
-
-	// Register a test user
-	email := "testUserHandlerLogoutEverywhere@test.com"
-	user, err := models.NewUser(email, testutils.TestingPassword)
-	is.NoErr(err)
-	err = server.DB.Create(user).Error
-	is.NoErr(err)
-
-	// Login on one "device"
-	rr, err := makeRequest(
-		server.Router,
-		"POST",
-		"/login",
-		UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
-	)
-	is.NoErr(err)
-	is.Equal(rr.Code, http.StatusOK)
-
-	// Get token from cookies
-	firstCookie := getSessionCookie(rr)
-	is.True(firstCookie != nil)
-	firstToken := firstCookie.Value
-	is.True(firstToken != "")
-
-	// Login on another "device"
-	rr, err = makeRequest(
-		server.Router,
-		"POST",
-		"/login",
-		UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
-	)
-	is.NoErr(err)
-	is.Equal(rr.Code, http.StatusOK)
-
-	// Get token from cookies
-	secondCookie := getSessionCookie(rr)
-	is.True(secondCookie != nil)
-	secondToken := secondCookie.Value
-	is.True(secondToken != "")
-	is.True(firstToken != secondToken)
+func TestUserHandler_Sessions(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
 
-	t.Run("successfully logout everywhere", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/logouteverywhere", nil)
+		// Register a test user and login on two "devices"
+		email := "testUserHandlerSessions@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		err = server.DB.Create(user).Error
 		is.NoErr(err)
 
-		// Add auth cookie
-		req.AddCookie(firstCookie)
-
-		// Logout everywhere
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusOK)
+		var cookies []*http.Cookie
+		for range 2 {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+			cookies = append(cookies, getSessionCookie(rr))
+		}
 
-		// Cookie is cleared
-		clearedCookie := getSessionCookie(rr)
-		is.True(clearedCookie != nil)
-		is.Equal(clearedCookie.MaxAge, -1) // Cookie should be expired
+		listSessions := func(cookie *http.Cookie) (*httptest.ResponseRecorder, models.SessionsResponse) {
+			req, err := http.NewRequest("GET", "/sessions", nil)
+			is.NoErr(err)
+			req.AddCookie(cookie)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			var response models.SessionsResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			return rr, response
+		}
 
-		// Verify response body
-		var response map[string]string
-		err = json.NewDecoder(rr.Body).Decode(&response)
-		is.NoErr(err)
-		is.Equal(response["message"], "logged out everywhere")
+		var otherID uuid.UUID
+		t.Run("lists sessions by public ID", func(t *testing.T) {
+			rr, response := listSessions(cookies[0])
+			is.Equal(rr.Code, http.StatusOK)
+			is.Equal(len(response.Sessions), 2)
+
+			current := 0
+			for _, session := range response.Sessions {
+				// The public ID is not part of any token
+				for _, cookie := range cookies {
+					is.True(!strings.Contains(cookie.Value, session.ID.String()))
+				}
+				if session.Current {
+					current++
+				} else {
+					otherID = session.ID
+				}
+			}
+			is.Equal(current, 1)
+		})
 
-		// Check first token is invalidated
-		req, err = http.NewRequest("POST", "/logouteverywhere", nil)
-		is.NoErr(err)
-		req.AddCookie(firstCookie)
-		rr = httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusUnauthorized)
+		t.Run("revokes another session", func(t *testing.T) {
+			req, err := http.NewRequest("DELETE", "/sessions/"+otherID.String(), nil)
+			is.NoErr(err)
+			req.AddCookie(cookies[0])
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// The revoked session's token no longer works
+			rr, _ = listSessions(cookies[1])
+			is.Equal(rr.Code, http.StatusUnauthorized)
+			rr, response := listSessions(cookies[0])
+			is.Equal(rr.Code, http.StatusOK)
+			is.Equal(len(response.Sessions), 1)
+		})
 
-		// Check second token is invalidated
-		req, err = http.NewRequest("POST", "/logouteverywhere", nil)
-		is.NoErr(err)
-		req.AddCookie(secondCookie)
-		rr = httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusUnauthorized)
+		t.Run("unknown session", func(t *testing.T) {
+			for _, id := range []string{uuid.NewString(), "not-a-uuid"} {
+				req, err := http.NewRequest("DELETE", "/sessions/"+id, nil)
+				is.NoErr(err)
+				req.AddCookie(cookies[0])
+				addCSRFToken(t, server.Router, req)
+				rr := httptest.NewRecorder()
+				server.Router.ServeHTTP(rr, req)
+				is.Equal(rr.Code, http.StatusNotFound)
+			}
+		})
 	})
+}
 
-	t.Run("logout everywhere without token", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/logouteverywhere", nil)
-		is.NoErr(err)
-
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
+func TestUserHandler_DeleteAccount(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
 
-		is.Equal(rr.Code, http.StatusUnauthorized)
-	})
+		server := setupServer(t, adapter)
 
-	t.Run("logout everywhere with invalid token", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/logouteverywhere", nil)
+		// Register a test user
+		email := "TestUserHandler_DeleteAccount@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		err = server.DB.Create(user).Error
 		is.NoErr(err)
 
-		req.AddCookie(&http.Cookie{
-			Name:     config.SessionCookieName,
-			Value:    "invalid-token",
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   true,
+		// Read registered user from DB so we can get its ID
+		var dbUser models.User
+		server.DB.First(&dbUser, "email = ?", user.Email)
+
+		t.Run("set identity in request context", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodDelete, "/deleteAccountValid", nil)
+			req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{UserID: dbUser.ID}))
+			serve(adapter, server.HandlerRegistry.User.DeleteAccount, rr, req)
+			is.Equal(http.StatusOK, rr.Code)
+
+			// The account is kept until the grace period ends
+			var pending models.User
+			is.NoErr(server.DB.First(&pending, "id = ?", dbUser.ID).Error)
+			is.True(pending.PendingDeletion())
+			is.True(pending.PurgeAfter.After(time.Now()))
 		})
 
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
+		t.Run("non-existent user ID", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodDelete, "/deleteAccountInvalidID", nil)
+			req = req.WithContext(identity.NewContext(req.Context(), identity.Identity{UserID: uuid.New()}))
+			serve(adapter, server.HandlerRegistry.User.DeleteAccount, rr, req)
+			is.Equal(http.StatusInternalServerError, rr.Code)
+		})
 
-		is.Equal(rr.Code, http.StatusUnauthorized)
+		t.Run("no identity in request context", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodDelete, "/deleteAccountNoUserID", nil)
+			serve(adapter, server.HandlerRegistry.User.DeleteAccount, rr, req)
+			is.Equal(http.StatusUnauthorized, rr.Code)
+
+			var response map[string]any
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			is.Equal(0, len(response))
+		})
 	})
 }
 
-func TestUserHandler_PermanentlyDeleteUser(t *testing.T) {
-	is := is.New(t)
-
-	server := setupServer(t)
+// TestUserHandler_RestoreAccount tests that an account scheduled for deletion
+// cannot log in until it is restored
+func TestUserHandler_RestoreAccount(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
 
-	// Register a test user
-	email := "TestUserHandler_PermanentlyDeleteUser@test.com"
-	user, err := models.NewUser(email, testutils.TestingPassword)
-	is.NoErr(err)
-	err = server.DB.Create(user).Error
-	is.NoErr(err)
+		email := "testUserHandlerRestoreAccount@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
 
-	// Read registered user from DB so we can get its ID
-	var dbUser models.User
-	server.DB.First(&dbUser, "email = ?", user.Email)
+		credentials := map[string]string{"email": email, "password": testutils.TestingPassword}
+		loginRR, err := makeRequest(server.Router, "POST", "/login", credentials)
+		is.NoErr(err)
+		sessionCookie := getSessionCookie(loginRR)
+		is.True(sessionCookie != nil)
 
-	t.Run("set userID in gin context", func(t *testing.T) {
-		path := "/deleteAccountValid"
+		// Delete the account through the API
+		req, err := http.NewRequest(http.MethodDelete, "/deleteaccount", nil)
+		is.NoErr(err)
+		req.AddCookie(sessionCookie)
+		addCSRFToken(t, server.Router, req)
 		rr := httptest.NewRecorder()
-		_, r := gin.CreateTestContext(rr)
-
-		r.DELETE(path, func(c *gin.Context) {
-			c.Set("userID", dbUser.ID.String())
-			server.HandlerRegistry.User.PermanentlyDeleteUser(c)
-		})
+		server.Router.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusOK)
+		is.Equal(getSessionCookie(rr).MaxAge, -1)
 
-		req, _ := http.NewRequest(http.MethodDelete, path, nil)
-		r.ServeHTTP(rr, req)
-		is.Equal(http.StatusOK, rr.Code)
-	})
+		var response map[string]any
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+		is.True(response["purgeAfter"] != nil)
 
-	t.Run("non-existent user ID", func(t *testing.T) {
-		path := "/deleteAccountInvalidID"
-		rr := httptest.NewRecorder()
-		_, r := gin.CreateTestContext(rr)
+		// The session was revoked and login is refused
+		req, _ = http.NewRequest("GET", "/whoami", nil)
+		req.AddCookie(sessionCookie)
+		rr = httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusUnauthorized)
 
-		r.DELETE(path, func(c *gin.Context) {
-			randUUID := uuid.New()
-			c.Set("userID", randUUID.String())
-			server.HandlerRegistry.User.PermanentlyDeleteUser(c)
-		})
+		rr, err = makeRequest(server.Router, "POST", "/login", credentials)
+		is.NoErr(err)
+		is.Equal(rr.Code, http.StatusBadRequest)
+		is.True(strings.Contains(rr.Body.String(), apperrors.ErrAccountPendingDeletion.Error()))
 
-		req, _ := http.NewRequest(http.MethodDelete, path, nil)
-		r.ServeHTTP(rr, req)
-		is.Equal(http.StatusInternalServerError, rr.Code)
-	})
+		// The emailed token is not readable here, so replace it with a known one
+		token, tokenHash, err := models.GenerateRestoreToken()
+		is.NoErr(err)
+		ur, err := repository.NewUserRepository(server.DB)
+		is.NoErr(err)
+		err = ur.ScheduleDeletion(user.ID.String(), tokenHash, time.Now(), time.Now().Add(time.Hour))
+		is.NoErr(err)
 
-	t.Run("no userID in gin context", func(t *testing.T) {
-		path := "/deleteAccountNoUserID"
-		rr := httptest.NewRecorder()
-		_, r := gin.CreateTestContext(rr)
+		t.Run("invalid token", func(t *testing.T) {
+			rr, err := makeRequest(server.Router, "POST", "/restoreaccount", map[string]string{"token": "wrong"})
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
 
-		r.DELETE(path, func(c *gin.Context) {
-			server.HandlerRegistry.User.PermanentlyDeleteUser(c)
+			rr, err = makeRequest(server.Router, "POST", "/restoreaccount", map[string]string{})
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusBadRequest)
 		})
 
-		req, _ := http.NewRequest(http.MethodDelete, path, nil)
-		r.ServeHTTP(rr, req)
-		is.Equal(http.StatusUnauthorized, rr.Code)
+		t.Run("restore", func(t *testing.T) {
+			rr, err := makeRequest(server.Router, "POST", "/restoreaccount", map[string]string{"token": token})
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
 
-		var response map[string]any
-		json.Unmarshal(rr.Body.Bytes(), &response)
-		is.Equal(0, len(response))
+			rr, err = makeRequest(server.Router, "POST", "/login", credentials)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+		})
 	})
 }
 
 func TestUserHandler_UpdateUser(t *testing.T) {
-	is := is.New(t)
-	server := setupServer(t)
-
-	// Register a test user
-	email := "testUpdateUser@test.com"
-	user, err := models.NewUser(email, testutils.TestingPassword)
-	is.NoErr(err)
-	err = server.DB.Create(user).Error
-	is.NoErr(err)
-
-	// Login test user
-	_rr, err := makeRequest(
-		server.Router,
-		"POST",
-		"/login",
-		UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
-	)
-	is.NoErr(err)
-	is.Equal(_rr.Code, http.StatusOK)
-
-	// Get token from cookies
-	sessionCookie := getSessionCookie(_rr)
-	is.True(sessionCookie != nil)
-	sessionToken := sessionCookie.Value
-	is.True(sessionToken != "")
-
-	t.Run("update email and password", func(t *testing.T) {
-		// Create update request body
-		newEmail := "newemail2@test.com"
-		newPassword := "AnotherSecure" + testutils.TestingPassword
-		updateBody := map[string]string{
-			"email":    newEmail,
-			"password": newPassword,
-		}
-		jsonData, _ := json.Marshal(updateBody)
-		body := bytes.NewBuffer(jsonData)
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
 
-		// Create request
-		req, err := http.NewRequest("POST", "/updateuser", body)
+		// Register a test user
+		email := "testUpdateUser@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		err = server.DB.Create(user).Error
 		is.NoErr(err)
-		req.Header.Set("Content-Type", "application/json")
+
+		// Login test user
+		_rr, err := makeRequest(
+			server.Router,
+			"POST",
+			"/login",
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+		)
+		is.NoErr(err)
+		is.Equal(_rr.Code, http.StatusOK)
 
 		// Get token from cookies
 		sessionCookie := getSessionCookie(_rr)
@@ -484,121 +764,421 @@ func TestUserHandler_UpdateUser(t *testing.T) {
 		sessionToken := sessionCookie.Value
 		is.True(sessionToken != "")
 
-		// Add auth cookie to update request
-		req.AddCookie(sessionCookie)
+		t.Run("update email and password", func(t *testing.T) {
+			// Create update request body
+			newEmail := "newemail2@test.com"
+			newPassword := "AnotherSecure" + testutils.TestingPassword
+			updateBody := map[string]string{
+				"email":           newEmail,
+				"password":        newPassword,
+				"currentPassword": testutils.TestingPassword,
+			}
+			jsonData, _ := json.Marshal(updateBody)
+			body := bytes.NewBuffer(jsonData)
+
+			// Create request
+			req, err := http.NewRequest("POST", "/updateuser", body)
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+
+			// Get token from cookies
+			sessionCookie := getSessionCookie(_rr)
+			is.True(sessionCookie != nil)
+			sessionToken := sessionCookie.Value
+			is.True(sessionToken != "")
+
+			// Add auth cookie to update request
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+
+			// Make request
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+
+			// Check we can login with the new credentials
+			_rr, err = makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: newEmail, Password: newPassword},
+			)
+			is.NoErr(err)
+			is.Equal(_rr.Code, http.StatusOK)
+		})
 
-		// Make request
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusOK)
+		t.Run("password change needs the current password", func(t *testing.T) {
+			for _, current := range []string{"", "wrong" + testutils.TestingPassword} {
+				jsonData, _ := json.Marshal(map[string]string{
+					"password":        "YetAnother" + testutils.TestingPassword,
+					"currentPassword": current,
+				})
+				req, err := http.NewRequest("POST", "/updateuser", bytes.NewBuffer(jsonData))
+				is.NoErr(err)
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(sessionCookie)
+				addCSRFToken(t, server.Router, req)
+
+				rr := httptest.NewRecorder()
+				server.Router.ServeHTTP(rr, req)
+				if current == "" {
+					is.Equal(rr.Code, http.StatusBadRequest)
+				} else {
+					is.Equal(rr.Code, http.StatusUnauthorized)
+				}
+			}
+		})
+
+		t.Run("update with empty request", func(t *testing.T) {
+			// Create empty request body
+			updateBody := map[string]string{}
+			jsonData, _ := json.Marshal(updateBody)
+			body := bytes.NewBuffer(jsonData)
+
+			// Create request
+			req, err := http.NewRequest("POST", "/updateuser", body)
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+
+			// Add auth cookie
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+
+			// Make request
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusBadRequest)
+
+			var response map[string]string
+			err = json.NewDecoder(rr.Body).Decode(&response)
+			is.NoErr(err)
+			is.Equal(response["error"], "no valid fields provided")
+		})
+
+		t.Run("update without auth", func(t *testing.T) {
+			// Create request body
+			updateBody := map[string]string{
+				"email": "valid@email.com",
+			}
+			jsonData, _ := json.Marshal(updateBody)
+			body := bytes.NewBuffer(jsonData)
+
+			// Create request
+			req, err := http.NewRequest("POST", "/updateuser", body)
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+
+			// Make request
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
+	})
+}
+
+// TestUserHandler_Reauthenticate tests that sensitive routes need a recent
+// authentication, which `/reauthenticate` provides
+func TestUserHandler_Reauthenticate(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
 
-		// Check we can login with the new credentials
-		_rr, err = makeRequest(
+		email := "testUserHandlerReauthenticate@test.com"
+		user, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		is.NoErr(server.DB.Create(user).Error)
+
+		loginRR, err := makeRequest(
 			server.Router,
 			"POST",
 			"/login",
-			UserCredentialsRequest{Email: newEmail, Password: newPassword},
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
 		)
 		is.NoErr(err)
-		is.Equal(_rr.Code, http.StatusOK)
-	})
-
-	t.Run("update with empty request", func(t *testing.T) {
-		// Create empty request body
-		updateBody := map[string]string{}
-		jsonData, _ := json.Marshal(updateBody)
-		body := bytes.NewBuffer(jsonData)
+		sessionCookie := getSessionCookie(loginRR)
+		is.True(sessionCookie != nil)
 
-		// Create request
-		req, err := http.NewRequest("POST", "/updateuser", body)
+		// The login was long ago
+		err = server.DB.Model(&models.Session{}).
+			Where("user_id = ?", user.ID).
+			Update("auth_time", time.Now().UTC().Add(-time.Hour)).Error
 		is.NoErr(err)
-		req.Header.Set("Content-Type", "application/json")
 
-		// Add auth cookie
-		req.AddCookie(sessionCookie)
+		request := func(method, path string, body any) *httptest.ResponseRecorder {
+			jsonData, _ := json.Marshal(body)
+			req, err := http.NewRequest(method, path, bytes.NewBuffer(jsonData))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
 
-		// Make request
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusBadRequest)
+		t.Run("stale session is refused", func(t *testing.T) {
+			rr := request("POST", "/updateuser", map[string]string{"email": "stolen@test.com"})
+			is.Equal(rr.Code, http.StatusForbidden)
+			rr = request("DELETE", "/deleteaccount", nil)
+			is.Equal(rr.Code, http.StatusForbidden)
 
-		var response map[string]string
-		err = json.NewDecoder(rr.Body).Decode(&response)
-		is.NoErr(err)
-		is.Equal(response["error"], "no valid fields provided")
-	})
+			var response map[string]string
+			is.NoErr(json.NewDecoder(rr.Body).Decode(&response))
+			is.Equal(response["error"], apperrors.ErrRecentAuthRequired.Error())
+		})
 
-	t.Run("update without auth", func(t *testing.T) {
-		// Create request body
-		updateBody := map[string]string{
-			"email": "valid@email.com",
-		}
-		jsonData, _ := json.Marshal(updateBody)
-		body := bytes.NewBuffer(jsonData)
+		t.Run("wrong password", func(t *testing.T) {
+			rr := request("POST", "/reauthenticate", map[string]string{"password": "wrong" + testutils.TestingPassword})
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
 
-		// Create request
-		req, err := http.NewRequest("POST", "/updateuser", body)
-		is.NoErr(err)
-		req.Header.Set("Content-Type", "application/json")
+		t.Run("reauthenticated session is allowed", func(t *testing.T) {
+			rr := request("POST", "/reauthenticate", map[string]string{"password": testutils.TestingPassword})
+			is.Equal(rr.Code, http.StatusOK)
 
-		// Make request
-		rr := httptest.NewRecorder()
-		server.Router.ServeHTTP(rr, req)
-		is.Equal(rr.Code, http.StatusUnauthorized)
+			rr = request("POST", "/updateuser", map[string]string{"email": "reauthenticated@test.com"})
+			is.Equal(rr.Code, http.StatusOK)
+		})
 	})
 }
 
 func TestHandlers_WhoAmi(t *testing.T) {
-	is := is.New(t)
-	server := setupServer(t)
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		t.Run("unauthorized when not logged in", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"GET",
+				"/whoami",
+				UserCredentialsRequest{Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusUnauthorized)
+		})
 
-	t.Run("unauthorized when not logged in", func(t *testing.T) {
-		rr, err := makeRequest(
-			server.Router,
-			"GET",
-			"/whoami",
-			UserCredentialsRequest{Password: testutils.TestingPassword},
-		)
-		is.NoErr(err)
-		is.Equal(rr.Code, http.StatusUnauthorized)
+		t.Run("gets login JSON data for logged in user", func(t *testing.T) {
+			// Register a test user
+			email := "testUserHandlerWhoAmI@test.com"
+			user, err := models.NewUser(email, testutils.TestingPassword)
+			is.NoErr(err)
+			err = server.DB.Create(user).Error
+			is.NoErr(err)
+			// Login test user
+			loginRR, err := makeRequest(
+				server.Router,
+				"POST",
+				"/login",
+				UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+
+			// Get cookie
+			sessionCookie := getSessionCookie(loginRR)
+			is.True(sessionCookie != nil)
+
+			// Get whoami data
+			req, err := http.NewRequest("GET", "/whoami", nil)
+			is.NoErr(err)
+			req.AddCookie(sessionCookie)
+			whoamiRR := httptest.NewRecorder()
+			server.Router.ServeHTTP(whoamiRR, req)
+			is.Equal(whoamiRR.Code, http.StatusOK)
+
+			// Check response message
+			var response map[string]string
+			err = json.NewDecoder(whoamiRR.Body).Decode(&response)
+			is.NoErr(err)
+			is.Equal(response["email"], email)
+		})
 	})
+}
 
-	t.Run("gets login JSON data for logged in user", func(t *testing.T) {
-		// Register a test user
-		email := "testUserHandlerWhoAmI@test.com"
-		user, err := models.NewUser(email, testutils.TestingPassword)
+// domainHooks refuses registrations from a domain and logins that fail
+// `allowLogin`
+type domainHooks struct {
+	services.NopUserHooks
+	blocked    string
+	allowLogin func(email string) bool
+}
+
+func (h domainHooks) PreRegister(_ context.Context, e *services.RegisterEvent) error {
+	if strings.HasSuffix(e.Email, "@"+h.blocked) {
+		return services.Veto(http.StatusForbidden, "registrations from this domain are closed")
+	}
+	return nil
+}
+
+func (h domainHooks) PreLogin(_ context.Context, e *services.LoginEvent) error {
+	if !h.allowLogin(e.Email) {
+		return services.Veto(http.StatusPaymentRequired, "subscription expired")
+	}
+	return nil
+}
+
+// TestUserHandler_HookVeto tests that user hook vetoes are answered with the
+// hook's status and message, and failed hooks with a 500
+func TestUserHandler_HookVeto(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+		us := server.HandlerRegistry.User.UserService
+
+		email := "testUserHandlerHookVeto@test.com"
+		is.NoErr(us.RegisterUser(email, testutils.TestingPassword))
+
+		allowed := false
+		us.Hooks = []services.UserHooks{domainHooks{
+			blocked:    "blocked.com",
+			allowLogin: func(string) bool { return allowed },
+		}}
+
+		rr, err := makeRequest(server.Router, "POST", "/register", UserCredentialsRequest{Email: "someone@blocked.com", Password: testutils.TestingPassword})
 		is.NoErr(err)
-		err = server.DB.Create(user).Error
+		is.Equal(rr.Code, http.StatusForbidden)
+		var response map[string]string
+		is.NoErr(json.NewDecoder(rr.Body).Decode(&response))
+		is.Equal(response["error"], "registrations from this domain are closed")
+
+		rr, err = makeRequest(server.Router, "POST", "/login", UserCredentialsRequest{Email: email, Password: testutils.TestingPassword})
 		is.NoErr(err)
-		// Login test user
-		loginRR, err := makeRequest(
-			server.Router,
-			"POST",
-			"/login",
-			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
-		)
+		is.Equal(rr.Code, http.StatusPaymentRequired)
+		is.True(getSessionCookie(rr) == nil)
+
+		allowed = true
+		rr, err = makeRequest(server.Router, "POST", "/login", UserCredentialsRequest{Email: email, Password: testutils.TestingPassword})
 		is.NoErr(err)
+		is.Equal(rr.Code, http.StatusOK)
 
-		// Get cookie
-		sessionCookie := getSessionCookie(loginRR)
-		is.True(sessionCookie != nil)
+		// Hooks that fail without a veto fail closed
+		us.Hooks = append(us.Hooks, failingHooks{})
+		rr, err = makeRequest(server.Router, "POST", "/login", UserCredentialsRequest{Email: email, Password: testutils.TestingPassword})
+		is.NoErr(err)
+		is.Equal(rr.Code, http.StatusInternalServerError)
+		is.NoErr(json.NewDecoder(rr.Body).Decode(&response))
+		is.Equal(response["error"], apperrors.ErrHookFailed.Error())
+	})
+}
 
-		// Get whoami data
-		req, err := http.NewRequest("GET", "/whoami", nil)
+// TestUserHandler_MagicLink tests passwordless login through the magic link
+// endpoints, with the link bound to the requesting browser by a cookie
+func TestUserHandler_MagicLink(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+		us := server.HandlerRegistry.User.UserService
+		sent := make(chan mailer.Message, 1)
+		us.Mailer = recordingMailer(sent)
+
+		email := "testUserHandlerMagicLink@test.com"
+		is.NoErr(us.RegisterPasswordlessUser(email))
+
+		// Unknown emails get the same answer and a cookie, but no email
+		rr, err := makeRequest(server.Router, "POST", "/login/magic", map[string]string{"email": "doesNotExist@test.com"})
 		is.NoErr(err)
-		req.AddCookie(sessionCookie)
-		whoamiRR := httptest.NewRecorder()
-		server.Router.ServeHTTP(whoamiRR, req)
-		is.Equal(whoamiRR.Code, http.StatusOK)
+		is.Equal(rr.Code, http.StatusOK)
+		otherBrowser := findCookie(rr, config.MagicLinkCookieName)
+		is.True(otherBrowser != nil)
 
-		// Check response message
-		var response map[string]string
-		err = json.NewDecoder(whoamiRR.Body).Decode(&response)
+		rr, err = makeRequest(server.Router, "POST", "/login/magic", map[string]string{"email": "not an email"})
 		is.NoErr(err)
-		is.Equal(response["email"], email)
+		is.Equal(rr.Code, http.StatusBadRequest)
+
+		rr, err = makeRequest(server.Router, "POST", "/login/magic", map[string]string{"email": email})
+		is.NoErr(err)
+		is.Equal(rr.Code, http.StatusOK)
+		browser := findCookie(rr, config.MagicLinkCookieName)
+		is.True(browser != nil)
+		is.True(browser.HttpOnly)
+
+		var msg mailer.Message
+		select {
+		case msg = <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no email sent")
+		}
+		_, token, ok := strings.Cut(msg.Body, "use this code: ")
+		is.True(ok)
+		token, _, _ = strings.Cut(token, "\n")
+
+		verify := func(cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(map[string]string{"token": token})
+			is.NoErr(err)
+			req := httptest.NewRequest("POST", "/login/magic/verify", bytes.NewReader(body))
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		t.Run("asking again keeps the browser's cookie", func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"email": email})
+			is.NoErr(err)
+			req := httptest.NewRequest("POST", "/login/magic", bytes.NewReader(body))
+			req.AddCookie(browser)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, http.StatusOK)
+			is.Equal(findCookie(rr, config.MagicLinkCookieName).Value, browser.Value)
+
+			select {
+			case <-sent:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no email sent")
+			}
+		})
+
+		t.Run("another browser is refused", func(t *testing.T) {
+			rr := verify(otherBrowser, token)
+			is.Equal(rr.Code, http.StatusBadRequest)
+			is.True(getSessionCookie(rr) == nil)
+
+			rr = verify(nil, token)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+
+		t.Run("the requesting browser logs in once", func(t *testing.T) {
+			rr := verify(browser, token)
+			is.Equal(rr.Code, http.StatusOK)
+			is.True(getSessionCookie(rr) != nil)
+			is.Equal(findCookie(rr, config.MagicLinkCookieName).MaxAge, -1)
+
+			rr = verify(browser, token)
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
 	})
 }
 
+// recordingMailer sends every message to a channel
+type recordingMailer chan mailer.Message
+
+func (m recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// findCookie returns the cookie `name` set on the response
+func findCookie(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// failingHooks fails every login
+type failingHooks struct {
+	services.NopUserHooks
+}
+
+func (failingHooks) PreLogin(context.Context, *services.LoginEvent) error {
+	return errors.New("entitlements service unavailable")
+}
+
 func setupUserHandler(t *testing.T) *handlers.UserHandler {
 	t.Helper()
 
@@ -625,7 +1205,13 @@ func setupUserHandler(t *testing.T) *handlers.UserHandler {
 	return uh
 }
 
-func setupServer(t *testing.T) *server.APIServer {
+// testServer serves the API server's routes through the adapter under test
+type testServer struct {
+	*server.APIServer
+	Router http.Handler
+}
+
+func setupServer(t *testing.T, adapter string) *testServer {
 	testDB := testutils.TestDBSetup()
 	tx := testDB.Begin()
 	t.Cleanup(func() { tx.Rollback() })
@@ -634,11 +1220,25 @@ func setupServer(t *testing.T) *server.APIServer {
 	if err != nil {
 		t.Fatalf("failed to init api server: %v", err)
 	}
+	if adapter == adapterHTTP {
+		return &testServer{APIServer: server, Router: server.HTTPHandler("")}
+	}
 	server.SetupRoutes()
-	return server
+	return &testServer{APIServer: server, Router: server.Router}
 }
 
-func makeRequest(router *gin.Engine, method, path string, body any) (*httptest.ResponseRecorder, error) {
+// serve runs `h` through `adapter`
+func serve(adapter string, h func(*handlers.Exchange), rr http.ResponseWriter, req *http.Request) {
+	if adapter == adapterHTTP {
+		handlers.HTTP(h).ServeHTTP(rr, req)
+		return
+	}
+	_, r := gin.CreateTestContext(rr.(*httptest.ResponseRecorder))
+	r.Handle(req.Method, req.URL.Path, handlers.Gin(h))
+	r.ServeHTTP(rr, req)
+}
+
+func makeRequest(router http.Handler, method, path string, body any) (*httptest.ResponseRecorder, error) {
 	jsonData, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -669,3 +1269,20 @@ func getSessionCookie(rr *httptest.ResponseRecorder) *http.Cookie {
 	}
 	return sessionCookie
 }
+
+// addCSRFToken gets a CSRF token from `/csrf` and adds it to the request's
+// cookie and header, as needed for state-changing requests with a session cookie
+func addCSRFToken(t *testing.T, router http.Handler, req *http.Request) {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/csrf", nil))
+	var response map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to get CSRF token: %v", err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == config.CSRFCookieName {
+			req.AddCookie(cookie)
+		}
+	}
+	req.Header.Set(config.CSRFHeaderName, response["csrfToken"])
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+type WebhookHandler struct {
+	WebhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) (*WebhookHandler, error) {
+	if webhookService == nil {
+		return nil, apperrors.ErrWebhookServiceIsNil
+	}
+	return &WebhookHandler{WebhookService: webhookService}, nil
+}
+
+// CreateWebhook godoc
+// @Summary Create a webhook subscription
+// @Schemes
+// @Description Subscribe an http(s) endpoint to account events: user.registered, user.email_changed, user.deletion_scheduled, user.restored and user.deleted. Payloads are signed with a secret, which is only shown in this response.
+// @Accept json
+// @Produce json
+// @Param request body models.CreateWebhookRequest true "endpoint URL, event types and optional description"
+// @Success 201 {object} models.WebhookResponse "the signing secret and the subscription"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/webhooks [post]
+func (wh *WebhookHandler) CreateWebhook(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	var body models.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+
+	created, err := wh.WebhookService.CreateWebhook(adminID, body.URL, body.Events, body.Description)
+	if err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to create webhook")
+		c.AbortWithStatusJSON(webhookErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("webhookID", created.Info.ID.String()).
+		Msg("webhook created")
+
+	// The secret must not be cached by intermediaries
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, models.WebhookResponse{Secret: created.Secret, Webhook: created.Info})
+}
+
+// ListWebhooks godoc
+// @Summary List webhook subscriptions
+// @Schemes
+// @Description List every webhook subscription, newest first, without its secret
+// @Produce json
+// @Success 200 {object} models.WebhooksResponse "response with webhooks field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/webhooks [get]
+func (wh *WebhookHandler) ListWebhooks(c *Exchange) {
+	webhooks, err := wh.WebhookService.ListWebhooks()
+	if err != nil {
+		log.Error().
+			Str("clientIP", c.ClientIP()).
+			Str("error", err.Error()).
+			Msg("failed to list webhooks")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.WebhooksResponse{Webhooks: webhooks})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook subscription
+// @Schemes
+// @Description Delete a webhook subscription by its ID, along with its pending and past deliveries
+// @Produce json
+// @Param id path string true "webhook subscription ID"
+// @Success 200 {object} models.MessageResponse "response with message field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/webhooks/{id} [delete]
+func (wh *WebhookHandler) DeleteWebhook(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	if err := wh.WebhookService.DeleteWebhook(adminID, c.Param("id")); err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to delete webhook")
+		c.AbortWithStatusJSON(webhookErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("webhookID", c.Param("id")).
+		Msg("webhook deleted")
+
+	c.JSON(http.StatusOK, H{"message": "webhook deleted"})
+}
+
+// ListWebhookDeliveries godoc
+// @Summary List the deliveries of a webhook subscription
+// @Schemes
+// @Description List the latest 100 deliveries to a webhook subscription, newest first, with their attempts and last response. Dead deliveries failed every attempt and can be redelivered.
+// @Produce json
+// @Param id path string true "webhook subscription ID"
+// @Param status query string false "only deliveries with this status: pending, delivered or dead"
+// @Success 200 {object} models.WebhookDeliveriesResponse "response with deliveries field"
+// @Failure 400 {object} models.ErrorResponse "response with error field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/webhooks/{id}/deliveries [get]
+func (wh *WebhookHandler) ListWebhookDeliveries(c *Exchange) {
+	deliveries, err := wh.WebhookService.ListDeliveries(c.Param("id"), c.Query("status"))
+	if err != nil {
+		log.Info().
+			Str("clientIP", c.ClientIP()).
+			Str("error", err.Error()).
+			Msg("failed to list webhook deliveries")
+		c.AbortWithStatusJSON(webhookErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.WebhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+// RedeliverWebhook godoc
+// @Summary Redeliver a webhook delivery
+// @Schemes
+// @Description Queue a dead or delivered delivery to be sent again right away, with a fresh set of attempts. The receiver gets the same delivery ID and payload.
+// @Produce json
+// @Param id path string true "webhook subscription ID"
+// @Param deliveryId path string true "webhook delivery ID"
+// @Success 200 {object} models.WebhookDeliveryResponse "response with delivery field"
+// @Failure 401 {object} models.ErrorResponse "response with error field"
+// @Failure 403 {object} models.ErrorResponse "not an admin session, or the session must reauthenticate first"
+// @Failure 404 {object} models.ErrorResponse "response with error field"
+// @Failure 409 {object} models.ErrorResponse "the delivery is already pending"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /admin/webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (wh *WebhookHandler) RedeliverWebhook(c *Exchange) {
+	clientIP := c.ClientIP()
+
+	admin, ok := c.SubjectIdentity()
+	if !ok {
+		return
+	}
+	adminID := admin.Subject().String()
+
+	delivery, err := wh.WebhookService.Redeliver(c.Param("id"), c.Param("deliveryId"))
+	if err != nil {
+		log.Info().
+			Str("userID", adminID).
+			Str("clientIP", clientIP).
+			Str("error", err.Error()).
+			Msg("failed to redeliver webhook")
+		c.AbortWithStatusJSON(webhookErrorStatus(err), H{"error": err.Error()})
+		return
+	}
+
+	log.Info().
+		Str("userID", adminID).
+		Str("clientIP", clientIP).
+		Str("deliveryID", delivery.ID.String()).
+		Msg("webhook delivery queued again")
+
+	c.JSON(http.StatusOK, models.WebhookDeliveryResponse{Delivery: *delivery})
+}
+
+// webhookErrorStatus maps webhook management errors to response statuses
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperrors.ErrWebhookNotFound),
+		errors.Is(err, apperrors.ErrWebhookDeliveryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperrors.ErrWebhookDeliveryPending):
+		return http.StatusConflict
+	case errors.Is(err, apperrors.ErrWebhookURL),
+		errors.Is(err, apperrors.ErrWebhookEvent),
+		errors.Is(err, apperrors.ErrWebhookNoEvents),
+		errors.Is(err, apperrors.ErrWebhookDescription),
+		errors.Is(err, apperrors.ErrWebhookDeliveryStatus):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestHandlers_NewWebhookHandler checks the NewWebhookHandler constructor
+func TestHandlers_NewWebhookHandler(t *testing.T) {
+	is := is.New(t)
+
+	wh, err := handlers.NewWebhookHandler(nil)
+	is.Equal(wh, nil)
+	is.Equal(err, apperrors.ErrWebhookServiceIsNil)
+}
+
+// TestWebhookHandler_Webhooks checks managing webhook subscriptions as an
+// admin, that registrations are queued for them, and redelivery errors
+func TestWebhookHandler_Webhooks(t *testing.T) {
+	forEachAdapter(t, func(t *testing.T, adapter string) {
+		is := is.New(t)
+		server := setupServer(t, adapter)
+
+		email := "testWebhookHandler@test.com"
+		admin, err := models.NewUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		admin.Role = models.RoleAdmin
+		is.NoErr(server.DB.Create(admin).Error)
+
+		loginRR, err := makeRequest(
+			server.Router,
+			"POST",
+			"/login",
+			UserCredentialsRequest{Email: email, Password: testutils.TestingPassword},
+		)
+		is.NoErr(err)
+		sessionCookie := getSessionCookie(loginRR)
+		is.True(sessionCookie != nil)
+
+		withSession := func(method, path string, body any) *httptest.ResponseRecorder {
+			jsonData, _ := json.Marshal(body)
+			req, err := http.NewRequest(method, path, bytes.NewBuffer(jsonData))
+			is.NoErr(err)
+			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(sessionCookie)
+			addCSRFToken(t, server.Router, req)
+			rr := httptest.NewRecorder()
+			server.Router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := withSession("POST", "/admin/webhooks", models.CreateWebhookRequest{
+			URL:    "https://crm.example.com/hooks",
+			Events: []string{models.WebhookUserRegistered},
+		})
+		is.Equal(rr.Code, http.StatusCreated)
+		is.Equal(rr.Header().Get("Cache-Control"), "no-store")
+		var created models.WebhookResponse
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &created))
+		is.True(strings.HasPrefix(created.Secret, models.WebhookSecretPrefix))
+		webhookPath := "/admin/webhooks/" + created.Webhook.ID.String()
+
+		t.Run("invalid subscriptions", func(t *testing.T) {
+			rr := withSession("POST", "/admin/webhooks", models.CreateWebhookRequest{URL: "crm.example.com", Events: []string{models.WebhookUserRegistered}})
+			is.Equal(rr.Code, http.StatusBadRequest)
+			rr = withSession("POST", "/admin/webhooks", models.CreateWebhookRequest{URL: "https://crm.example.com/hooks", Events: []string{"user.verified"}})
+			is.Equal(rr.Code, http.StatusBadRequest)
+		})
+
+		t.Run("lists subscriptions without their secret", func(t *testing.T) {
+			rr := withSession("GET", "/admin/webhooks", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			var response models.WebhooksResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(len(response.Webhooks), 1)
+			is.True(!bytes.Contains(rr.Body.Bytes(), []byte(created.Secret)))
+		})
+
+		t.Run("registrations are queued", func(t *testing.T) {
+			rr, err := makeRequest(
+				server.Router,
+				"POST",
+				"/register",
+				UserCredentialsRequest{Email: "testWebhookHandlerNew@test.com", Password: testutils.TestingPassword},
+			)
+			is.NoErr(err)
+			is.Equal(rr.Code, http.StatusOK)
+
+			rr = withSession("GET", webhookPath+"/deliveries?status=pending", nil)
+			is.Equal(rr.Code, http.StatusOK)
+			var response models.WebhookDeliveriesResponse
+			is.NoErr(json.Unmarshal(rr.Body.Bytes(), &response))
+			is.Equal(len(response.Deliveries), 1)
+			is.Equal(response.Deliveries[0].EventType, models.WebhookUserRegistered)
+
+			// Pending deliveries are already queued
+			rr = withSession("POST", webhookPath+"/deliveries/"+response.Deliveries[0].ID.String()+"/redeliver", nil)
+			is.Equal(rr.Code, http.StatusConflict)
+
+			is.Equal(withSession("GET", webhookPath+"/deliveries?status=failed", nil).Code, http.StatusBadRequest)
+		})
+
+		t.Run("deleted subscriptions are not found", func(t *testing.T) {
+			is.Equal(withSession("DELETE", webhookPath, nil).Code, http.StatusOK)
+			is.Equal(withSession("DELETE", webhookPath, nil).Code, http.StatusNotFound)
+			is.Equal(withSession("GET", webhookPath+"/deliveries", nil).Code, http.StatusNotFound)
+		})
+
+		t.Run("stale admin sessions must reauthenticate", func(t *testing.T) {
+			err := server.DB.Model(&models.Session{}).
+				Where("user_id = ?", admin.ID).
+				Update("auth_time", time.Now().UTC().Add(-time.Hour)).Error
+			is.NoErr(err)
+
+			rr := withSession("GET", "/admin/webhooks", nil)
+			is.Equal(rr.Code, http.StatusForbidden)
+			is.True(strings.Contains(rr.Body.String(), apperrors.ErrRecentAuthRequired.Error()))
+		})
+	})
+}
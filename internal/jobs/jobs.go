@@ -10,11 +10,13 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
 	"github.com/al-ce/goauth/pkg/config"
 )
 
-// StartJobs starts jobs with a context from main
-func StartJobs(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB) {
+// StartJobs starts jobs with a context from main. The purge of deleted
+// accounts runs the delete `hooks`.
+func StartJobs(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, hooks []services.UserHooks) {
 
 	// NOTE: Add goroutines here for any future jobs
 
@@ -23,6 +25,42 @@ func StartJobs(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB) {
 		defer wg.Done()
 		UnlockExpiredLocks(ctx, config.AccountUnlockPeriod, db)
 	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		PurgeDeletedAccounts(ctx, config.AccountPurgePeriod, db, hooks)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ProcessDataExports(ctx, config.DataExportPeriod, db)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		PurgeServiceAccountTokens(ctx, config.ServiceAccountTokenPurgePeriod, db)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		PurgeMagicLinkTokens(ctx, config.MagicLinkPurgePeriod, db)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		DispatchWebhooks(ctx, config.WebhookDispatchPeriod, db)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		PurgeWebhookDeliveries(ctx, config.WebhookPurgePeriod, db)
+	}()
 }
 
 // UnlockExpiredLocks calls the repo method to unlock all accounts whose
@@ -62,3 +100,330 @@ func unlockHelper(ur *repository.UserRepository) {
 		log.Info().Msg(fmt.Sprintf("[Jobs] [UnlockExpiredLocks] %d rows affected", affected))
 	}
 }
+
+// PurgeDeletedAccounts permanently deletes accounts whose deletion grace
+// period has ended every `period`, running the delete `hooks` and recording
+// an audit event for each
+func PurgeDeletedAccounts(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+	hooks []services.UserHooks,
+) {
+	us, err := newPurgeService(db, hooks)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init user service: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial purge before starting the ticker
+	purgeHelper(us)
+
+	for {
+		select {
+		case <-ticker.C:
+			purgeHelper(us)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [PurgeDeletedAccounts] Stopping job")
+			return
+		}
+	}
+}
+
+func purgeHelper(us *services.UserService) {
+	purged, err := us.PurgeDeletedAccounts(time.Now().UTC())
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+	} else {
+		log.Info().Msg(fmt.Sprintf("[Jobs] [PurgeDeletedAccounts] %d accounts deleted", purged))
+	}
+}
+
+// PurgeDeletedAccountsOnce deletes the accounts whose grace period ended
+// before `now`, running the delete `hooks`. See
+// UserService.PurgeDeletedAccounts.
+func PurgeDeletedAccountsOnce(db *gorm.DB, now time.Time, hooks []services.UserHooks) (int, error) {
+	us, err := newPurgeService(db, hooks)
+	if err != nil {
+		return 0, err
+	}
+	return us.PurgeDeletedAccounts(now)
+}
+
+// newPurgeService creates a UserService with repositories on `db` that runs
+// `hooks`
+func newPurgeService(db *gorm.DB, hooks []services.UserHooks) (*services.UserService, error) {
+	ur, err := repository.NewUserRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := repository.NewSessionRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	us, err := services.NewUserService(ur, sr)
+	if err != nil {
+		return nil, err
+	}
+	us.Hooks = hooks
+	return us, nil
+}
+
+// PurgeServiceAccountTokens deletes expired service account tokens and client
+// assertion IDs every `period`
+func PurgeServiceAccountTokens(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+) {
+	sr, err := repository.NewServiceAccountRepository(db)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init service account repo: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial purge before starting the ticker
+	serviceAccountTokenHelper(sr)
+
+	for {
+		select {
+		case <-ticker.C:
+			serviceAccountTokenHelper(sr)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [PurgeServiceAccountTokens] Stopping job")
+			return
+		}
+	}
+}
+
+func serviceAccountTokenHelper(sr *repository.ServiceAccountRepository) {
+	deleted, err := sr.DeleteExpiredServiceAccountTokens(time.Now().UTC())
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+	} else if deleted > 0 {
+		log.Info().Msg(fmt.Sprintf("[Jobs] [PurgeServiceAccountTokens] %d expired tokens deleted", deleted))
+	}
+}
+
+// PurgeMagicLinkTokens deletes magic link tokens that have expired and no
+// longer count towards the request limit every `period`
+func PurgeMagicLinkTokens(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+) {
+	mr, err := repository.NewMagicLinkRepository(db)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init magic link repo: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial purge before starting the ticker
+	magicLinkHelper(mr)
+
+	for {
+		select {
+		case <-ticker.C:
+			magicLinkHelper(mr)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [PurgeMagicLinkTokens] Stopping job")
+			return
+		}
+	}
+}
+
+func magicLinkHelper(mr *repository.MagicLinkRepository) {
+	before := time.Now().UTC().Add(-max(config.MagicLinkExpiration, config.MagicLinkRequestWindow))
+	deleted, err := mr.DeleteStaleMagicLinkTokens(before)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+	} else if deleted > 0 {
+		log.Info().Msg(fmt.Sprintf("[Jobs] [PurgeMagicLinkTokens] %d stale tokens deleted", deleted))
+	}
+}
+
+// webhookBatchSize is the number of due webhook deliveries sent per batch
+const webhookBatchSize = 10
+
+// DispatchWebhooks sends the webhook deliveries that are due every `period`.
+// Each instance of the server can run it: deliveries are claimed before they
+// are sent.
+func DispatchWebhooks(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+) {
+	ws, err := newWebhookService(db)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init webhook service: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial run before starting the ticker
+	webhookHelper(ctx, ws)
+
+	for {
+		select {
+		case <-ticker.C:
+			webhookHelper(ctx, ws)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [DispatchWebhooks] Stopping job")
+			return
+		}
+	}
+}
+
+func webhookHelper(ctx context.Context, ws *services.WebhookService) {
+	// Keep going while full batches are due
+	for ctx.Err() == nil {
+		attempted, err := ws.DispatchPending(ctx, webhookBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+			}
+			return
+		}
+		if attempted > 0 {
+			log.Info().Msg(fmt.Sprintf("[Jobs] [DispatchWebhooks] %d deliveries attempted", attempted))
+		}
+		if attempted < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// PurgeWebhookDeliveries deletes delivered and dead webhook deliveries past
+// their retention, and the events left without deliveries, every `period`
+func PurgeWebhookDeliveries(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+) {
+	ws, err := newWebhookService(db)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init webhook service: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial purge before starting the ticker
+	webhookPurgeHelper(ws)
+
+	for {
+		select {
+		case <-ticker.C:
+			webhookPurgeHelper(ws)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [PurgeWebhookDeliveries] Stopping job")
+			return
+		}
+	}
+}
+
+func webhookPurgeHelper(ws *services.WebhookService) {
+	deliveries, events, err := ws.PurgeFinished(time.Now().UTC())
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+	} else if deliveries > 0 || events > 0 {
+		log.Info().Msg(fmt.Sprintf("[Jobs] [PurgeWebhookDeliveries] %d deliveries and %d events deleted", deliveries, events))
+	}
+}
+
+// newWebhookService creates a WebhookService with repositories on `db`
+func newWebhookService(db *gorm.DB) (*services.WebhookService, error) {
+	wr, err := repository.NewWebhookRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := repository.NewAuditRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewWebhookService(wr, ar)
+}
+
+// dataExportBatchSize is the number of pending exports generated per run
+const dataExportBatchSize = 10
+
+// ProcessDataExports generates pending data exports and deletes those whose
+// download link has expired every `period`
+func ProcessDataExports(
+	ctx context.Context,
+	period time.Duration,
+	db *gorm.DB,
+) {
+	es, err := newExportService(db)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] Could not init export service: %s", err.Error()))
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// Perform an initial run before starting the ticker
+	exportHelper(es)
+
+	for {
+		select {
+		case <-ticker.C:
+			exportHelper(es)
+		case <-ctx.Done():
+			log.Info().Msg("[Jobs] [ProcessDataExports] Stopping job")
+			return
+		}
+	}
+}
+
+func exportHelper(es *services.ExportService) {
+	deleted, err := es.ExportRepo.DeleteExpiredExports(time.Now().UTC())
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+	} else if deleted > 0 {
+		log.Info().Msg(fmt.Sprintf("[Jobs] [ProcessDataExports] %d expired exports deleted", deleted))
+	}
+
+	// Keep going while full batches are pending
+	for {
+		processed, err := es.ProcessPendingExports(dataExportBatchSize)
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("[Jobs] [ERROR] %s", err.Error()))
+			return
+		}
+		if processed > 0 {
+			log.Info().Msg(fmt.Sprintf("[Jobs] [ProcessDataExports] %d exports processed", processed))
+		}
+		if processed < dataExportBatchSize {
+			return
+		}
+	}
+}
+
+// newExportService creates an ExportService with repositories on `db`
+func newExportService(db *gorm.DB) (*services.ExportService, error) {
+	ur, err := repository.NewUserRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := repository.NewSessionRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := repository.NewAuditRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	er, err := repository.NewExportRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewExportService(ur, sr, ar, er)
+}
@@ -0,0 +1,79 @@
+package jobs_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/jobs"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+)
+
+// TestMain sets up the test environment for all tests in the `jobs_test` package.
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+
+	os.Exit(m.Run())
+}
+
+// TestPurgeDeletedAccountsOnce tests that accounts past their grace period
+// are deleted with an audit event and a webhook event, and others are kept
+func TestPurgeDeletedAccountsOnce(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	ur, err := repository.NewUserRepository(tx)
+	is.NoErr(err)
+	ar, err := repository.NewAuditRepository(tx)
+	is.NoErr(err)
+	wr, err := repository.NewWebhookRepository(tx)
+	is.NoErr(err)
+
+	subscription, err := models.NewWebhookSubscription("https://crm.example.com/hooks", []string{models.WebhookUserDeleted}, "secret", "", uuid.New())
+	is.NoErr(err)
+	is.NoErr(wr.CreateWebhookSubscription(subscription))
+
+	schedule := func(email string, purgeAfter time.Time) *models.User {
+		user := &models.User{Email: email, Password: testutils.TestingPassword}
+		is.NoErr(ur.RegisterUser(user))
+		_, tokenHash, err := models.GenerateRestoreToken()
+		is.NoErr(err)
+		is.NoErr(ur.ScheduleDeletion(user.ID.String(), tokenHash, time.Now(), purgeAfter))
+		return user
+	}
+	expired := schedule("testPurgeExpired@test.com", time.Now().Add(-time.Minute))
+	pending := schedule("testPurgePending@test.com", time.Now().Add(time.Hour))
+
+	purged, err := jobs.PurgeDeletedAccountsOnce(tx, time.Now(), nil)
+	is.NoErr(err)
+	is.Equal(purged, 1)
+
+	_, err = ur.GetUserByID(expired.ID.String())
+	is.True(err != nil)
+	_, err = ur.GetUserByID(pending.ID.String())
+	is.NoErr(err)
+
+	events, err := ar.GetAuditEventsByUserID(expired.ID.String())
+	is.NoErr(err)
+	is.Equal(len(events), 1)
+	is.Equal(events[0].Type, models.AuditAccountDeleted)
+
+	events, err = ar.GetAuditEventsByUserID(pending.ID.String())
+	is.NoErr(err)
+	is.Equal(len(events), 0)
+
+	deliveries, err := wr.GetWebhookDeliveries(subscription.ID, models.WebhookDeliveryPending, 10)
+	is.NoErr(err)
+	is.Equal(len(deliveries), 1)
+	is.Equal(deliveries[0].Event.Type, models.WebhookUserDeleted)
+	is.True(strings.Contains(deliveries[0].Event.Payload, expired.ID.String()))
+}
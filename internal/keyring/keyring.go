@@ -0,0 +1,263 @@
+package keyring
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	passwordvalidator "github.com/wagslane/go-password-validator"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// keyIDPattern keeps key IDs free of the `.` that separates token parts
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Key is an HMAC key used to sign session tokens
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Keyring holds the session signing keys. Tokens are signed with the Active
+// key and verified against any key in the ring, so a new key can be rolled
+// out to every instance before it is promoted, and a retired key keeps
+// verifying existing sessions until they are re-signed or expire.
+type Keyring struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// Sign returns the token `kid.secret.signature` for a session secret, signed
+// with the active key
+func (k *Keyring) Sign(secret string) (string, error) {
+	key, ok := k.key(k.Active)
+	if !ok {
+		return "", fmt.Errorf("%w: active key %q", apperrors.ErrKeyNotFound, k.Active)
+	}
+	return key.ID + "." + secret + "." + sign(key, key.ID+"."+secret), nil
+}
+
+// Verify checks a token's signature and returns its secret and the ID of the
+// key that signed it. Tokens from before key IDs, `secret.signature`, are
+// checked against every key and report an empty key ID.
+func (k *Keyring) Verify(token string) (secret string, keyID string, err error) {
+	parts := strings.Split(token, ".")
+	switch len(parts) {
+	case 3:
+		keyID, secret = parts[0], parts[1]
+		if keyID == "" || secret == "" {
+			return "", "", apperrors.ErrInvalidTokenFormat
+		}
+		key, ok := k.key(keyID)
+		if !ok || !hmac.Equal([]byte(parts[2]), []byte(sign(key, keyID+"."+secret))) {
+			return "", "", apperrors.ErrInvalidTokenSignature
+		}
+		return secret, keyID, nil
+	case 2:
+		secret = parts[0]
+		if secret == "" {
+			return "", "", apperrors.ErrInvalidTokenFormat
+		}
+		for _, key := range k.Keys {
+			if hmac.Equal([]byte(parts[1]), []byte(sign(key, secret))) {
+				return secret, "", nil
+			}
+		}
+		return "", "", apperrors.ErrInvalidTokenSignature
+	default:
+		return "", "", apperrors.ErrInvalidTokenFormat
+	}
+}
+
+// Validate checks that the active key exists, key IDs are unique and usable
+// in tokens, and every secret is complex enough to sign with
+func (k *Keyring) Validate() error {
+	if _, ok := k.key(k.Active); !ok {
+		return fmt.Errorf("%w: active key %q is not in the keyring", apperrors.ErrKeyringConfig, k.Active)
+	}
+	seen := make(map[string]bool, len(k.Keys))
+	for _, key := range k.Keys {
+		if !keyIDPattern.MatchString(key.ID) {
+			return fmt.Errorf("%w: key ID %q must be 1-64 letters, digits, `-` or `_`", apperrors.ErrKeyringConfig, key.ID)
+		}
+		if seen[key.ID] {
+			return fmt.Errorf("%w: duplicate key ID %q", apperrors.ErrKeyringConfig, key.ID)
+		}
+		seen[key.ID] = true
+		if err := passwordvalidator.Validate(key.Secret, config.MinEntropyBits); err != nil {
+			return fmt.Errorf("%w: key %q is not complex enough: %s", apperrors.ErrKeyringConfig, key.ID, err)
+		}
+	}
+	return nil
+}
+
+// Generate adds a new random key to the keyring without making it active.
+// An empty `id` is replaced with the current UTC time.
+func (k *Keyring) Generate(id string) (Key, error) {
+	if id == "" {
+		id = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if !keyIDPattern.MatchString(id) {
+		return Key{}, fmt.Errorf("%w: key ID %q must be 1-64 letters, digits, `-` or `_`", apperrors.ErrKeyringConfig, id)
+	}
+	if _, ok := k.key(id); ok {
+		return Key{}, fmt.Errorf("%w: duplicate key ID %q", apperrors.ErrKeyringConfig, id)
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return Key{}, err
+	}
+	key := Key{ID: id, Secret: base64.RawURLEncoding.EncodeToString(random)}
+	k.Keys = append(k.Keys, key)
+	return key, nil
+}
+
+// Promote makes the key `id` the one new tokens are signed with
+func (k *Keyring) Promote(id string) error {
+	if _, ok := k.key(id); !ok {
+		return fmt.Errorf("%w: %q", apperrors.ErrKeyNotFound, id)
+	}
+	k.Active = id
+	return nil
+}
+
+// key returns the key with ID `id`
+func (k *Keyring) key(id string) (Key, bool) {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// sign returns the HMAC-SHA256 signature of `message`
+// Ref: https://www.okta.com/identity-101/hmac/
+func sign(key Key, message string) string {
+	h := hmac.New(sha256.New, []byte(key.Secret))
+	h.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Load reads a JSON keyring
+func Load(r io.Reader) (*Keyring, error) {
+	var k Keyring
+	if err := json.NewDecoder(r).Decode(&k); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperrors.ErrKeyringConfig, err)
+	}
+	return &k, nil
+}
+
+// LoadFile reads a JSON keyring from a file
+func LoadFile(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// SaveFile writes the keyring to a file readable only by its owner. The file
+// is replaced in one step, so a running server never reads half a keyring.
+func (k *Keyring) SaveFile(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// NewFromEnv loads the keyring from `SESSION_KEYRING_FILE` or
+// `SESSION_KEYRING`, or else makes a keyring of the single `SESSION_KEY`.
+// The keyring is not validated.
+func NewFromEnv() (*Keyring, error) {
+	if path := os.Getenv(config.SessionKeyringFile); path != "" {
+		k, err := LoadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config.SessionKeyringFile, err)
+		}
+		return k, nil
+	}
+	if inline := os.Getenv(config.SessionKeyring); inline != "" {
+		k, err := Load(strings.NewReader(inline))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config.SessionKeyring, err)
+		}
+		return k, nil
+	}
+	if secret := os.Getenv(config.SessionKey); secret != "" {
+		return &Keyring{
+			Active: config.DefaultSessionKeyID,
+			Keys:   []Key{{ID: config.DefaultSessionKeyID, Secret: secret}},
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: set %s, %s or %s", apperrors.ErrKeyringConfig,
+		config.SessionKeyringFile, config.SessionKeyring, config.SessionKey)
+}
+
+var (
+	defaultKeyring *Keyring
+	defaultMu      sync.RWMutex
+)
+
+// Default returns the process-wide Keyring, loading it from the environment
+// on first use. If it cannot be loaded, the keyring is empty and every token
+// is rejected.
+func Default() *Keyring {
+	defaultMu.RLock()
+	k := defaultKeyring
+	defaultMu.RUnlock()
+	if k != nil {
+		return k
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultKeyring == nil {
+		k, err := NewFromEnv()
+		if err != nil {
+			log.Error().Err(err).Msg("Could not load session keyring, rejecting all sessions")
+			k = &Keyring{}
+		}
+		defaultKeyring = k
+	}
+	return defaultKeyring
+}
+
+// SetDefault replaces the process-wide Keyring. Passing nil resets it so the
+// next call to Default reloads it from the environment.
+func SetDefault(k *Keyring) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultKeyring = k
+}
@@ -0,0 +1,188 @@
+package keyring_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/keyring"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestMain sets up the test environment for all tests in the `keyring_test` package.
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+
+	os.Exit(m.Run())
+}
+
+// TestKeyring_Verify tests that tokens verify against active and retired
+// keys and report which key signed them
+func TestKeyring_Verify(t *testing.T) {
+	is := is.New(t)
+
+	ring := &keyring.Keyring{}
+	old, err := ring.Generate("old")
+	is.NoErr(err)
+	is.NoErr(ring.Promote(old.ID))
+	oldToken, err := ring.Sign("secret")
+	is.NoErr(err)
+	is.True(strings.HasPrefix(oldToken, "old.secret."))
+
+	_, err = ring.Generate("new")
+	is.NoErr(err)
+	is.NoErr(ring.Promote("new"))
+
+	t.Run("active key", func(t *testing.T) {
+		token, err := ring.Sign("secret")
+		is.NoErr(err)
+		secret, keyID, err := ring.Verify(token)
+		is.NoErr(err)
+		is.Equal(secret, "secret")
+		is.Equal(keyID, "new")
+	})
+
+	t.Run("retired key", func(t *testing.T) {
+		secret, keyID, err := ring.Verify(oldToken)
+		is.NoErr(err)
+		is.Equal(secret, "secret")
+		is.Equal(keyID, "old")
+	})
+
+	t.Run("token without key ID", func(t *testing.T) {
+		// `kid.secret.signature` minus the key ID is not the legacy
+		// format, whose signature covers only the secret
+		_, _, err := ring.Verify(strings.TrimPrefix(oldToken, "old."))
+		is.Equal(err, apperrors.ErrInvalidTokenSignature)
+	})
+
+	t.Run("legacy token without key ID", func(t *testing.T) {
+		// Tokens issued before key IDs were `secret.HMAC(secret)`
+		h := hmac.New(sha256.New, []byte(old.Secret))
+		h.Write([]byte("secret"))
+		legacy := "secret." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+
+		secret, keyID, err := ring.Verify(legacy)
+		is.NoErr(err)
+		is.Equal(secret, "secret")
+		is.Equal(keyID, "")
+	})
+
+	t.Run("removed key", func(t *testing.T) {
+		current := &keyring.Keyring{Active: "new", Keys: ring.Keys[1:]}
+		_, _, err := current.Verify(oldToken)
+		is.Equal(err, apperrors.ErrInvalidTokenSignature)
+	})
+
+	t.Run("key ID swapped", func(t *testing.T) {
+		_, _, err := ring.Verify("new" + strings.TrimPrefix(oldToken, "old"))
+		is.Equal(err, apperrors.ErrInvalidTokenSignature)
+	})
+
+	invalidFormat := []string{"", "secret", ".secret.sig", "old..sig", "a.b.c.d"}
+	for _, token := range invalidFormat {
+		_, _, err := ring.Verify(token)
+		is.Equal(err, apperrors.ErrInvalidTokenFormat)
+	}
+}
+
+// TestKeyring_Validate tests keyring validation
+func TestKeyring_Validate(t *testing.T) {
+	is := is.New(t)
+
+	ring := &keyring.Keyring{}
+	key, err := ring.Generate("")
+	is.NoErr(err)
+	is.NoErr(ring.Promote(key.ID))
+	is.NoErr(ring.Validate())
+
+	invalid := map[string]*keyring.Keyring{
+		"no active key":      {Keys: ring.Keys},
+		"unknown active key": {Active: "missing", Keys: ring.Keys},
+		"weak secret":        {Active: "weak", Keys: []keyring.Key{{ID: "weak", Secret: "password"}}},
+		"bad key ID":         {Active: "a.b", Keys: []keyring.Key{{ID: "a.b", Secret: key.Secret}}},
+		"duplicate key ID":   {Active: key.ID, Keys: []keyring.Key{key, key}},
+	}
+	for name, ring := range invalid {
+		t.Run(name, func(t *testing.T) {
+			err := ring.Validate()
+			is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+		})
+	}
+
+	t.Run("generate refuses duplicate and invalid IDs", func(t *testing.T) {
+		_, err := ring.Generate(key.ID)
+		is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+		_, err = ring.Generate("a.b")
+		is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+	})
+
+	t.Run("promote refuses unknown keys", func(t *testing.T) {
+		err := ring.Promote("missing")
+		is.True(errors.Is(err, apperrors.ErrKeyNotFound))
+	})
+}
+
+// TestNewFromEnv tests loading the keyring from a file, inline JSON or the
+// single session key
+func TestNewFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	ring := &keyring.Keyring{}
+	key, err := ring.Generate("filekey")
+	is.NoErr(err)
+	is.NoErr(ring.Promote(key.ID))
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	is.NoErr(ring.SaveFile(path))
+
+	t.Run("saved file is private", func(t *testing.T) {
+		info, err := os.Stat(path)
+		is.NoErr(err)
+		is.Equal(info.Mode().Perm(), os.FileMode(0o600))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Setenv(config.SessionKeyringFile, path)
+		t.Setenv(config.SessionKeyring, `{"active":"inline","keys":[]}`)
+		loaded, err := keyring.NewFromEnv()
+		is.NoErr(err)
+		is.Equal(loaded.Active, "filekey")
+		is.Equal(loaded.Keys, ring.Keys)
+	})
+
+	t.Run("inline", func(t *testing.T) {
+		t.Setenv(config.SessionKeyring, `{"active":"inline","keys":[{"id":"inline","secret":"s"}]}`)
+		loaded, err := keyring.NewFromEnv()
+		is.NoErr(err)
+		is.Equal(loaded.Active, "inline")
+	})
+
+	t.Run("session key", func(t *testing.T) {
+		t.Setenv(config.SessionKey, "sessionkey")
+		loaded, err := keyring.NewFromEnv()
+		is.NoErr(err)
+		is.Equal(loaded.Active, config.DefaultSessionKeyID)
+		is.Equal(loaded.Keys, []keyring.Key{{ID: config.DefaultSessionKeyID, Secret: "sessionkey"}})
+	})
+
+	t.Run("nothing configured", func(t *testing.T) {
+		t.Setenv(config.SessionKey, "")
+		_, err := keyring.NewFromEnv()
+		is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Setenv(config.SessionKeyring, `{"active":`)
+		_, err := keyring.NewFromEnv()
+		is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+	})
+}
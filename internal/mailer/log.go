@@ -0,0 +1,24 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LogMailer writes email to the log instead of sending it, for development
+// and deployments without an SMTP server
+type LogMailer struct {
+	From string
+}
+
+// Send logs the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Info().
+		Str("from", m.From).
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("body", msg.Body).
+		Msg("Email not sent, no SMTP server configured")
+	return nil
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"os"
+
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email out of band, e.g. to notify account owners
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv returns an SMTPMailer if `SMTP_HOST` is set, or a LogMailer otherwise
+func NewFromEnv() Mailer {
+	from := os.Getenv(config.MailFrom)
+	if from == "" {
+		from = config.DefaultMailFrom
+	}
+
+	host := os.Getenv(config.SMTPHost)
+	if host == "" {
+		return &LogMailer{From: from}
+	}
+	port := os.Getenv(config.SMTPPort)
+	if port == "" {
+		port = config.DefaultSMTPPort
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv(config.SMTPUsername),
+		Password: os.Getenv(config.SMTPPassword),
+		From:     from,
+	}
+}
@@ -0,0 +1,109 @@
+package mailer_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/mailer"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestNewFromEnv tests the mailer is picked from the environment
+func TestNewFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("logs without smtp host", func(t *testing.T) {
+		t.Setenv(config.SMTPHost, "")
+		_, ok := mailer.NewFromEnv().(*mailer.LogMailer)
+		is.True(ok)
+	})
+
+	t.Run("smtp with host", func(t *testing.T) {
+		t.Setenv(config.SMTPHost, "mail.test")
+		t.Setenv(config.MailFrom, "auth@test.com")
+		m, ok := mailer.NewFromEnv().(*mailer.SMTPMailer)
+		is.True(ok)
+		is.Equal(m.Port, config.DefaultSMTPPort)
+		is.Equal(m.From, "auth@test.com")
+	})
+}
+
+// TestSMTPMailer_Send tests a message is delivered to an SMTP server
+func TestSMTPMailer_Send(t *testing.T) {
+	is := is.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan []string, 1)
+	go fakeSMTPServer(listener, received)
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	is.NoErr(err)
+	m := &mailer.SMTPMailer{Host: host, Port: port, From: "auth@test.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = m.Send(ctx, mailer.Message{
+		To:      "owner@test.com",
+		Subject: "Hello\r\nBcc: injected@test.com",
+		Body:    "line one\nline two",
+	})
+	is.NoErr(err)
+
+	lines := <-received
+	transcript := strings.Join(lines, "\n")
+	is.True(strings.Contains(transcript, "MAIL FROM:<auth@test.com>"))
+	is.True(strings.Contains(transcript, "RCPT TO:<owner@test.com>"))
+	is.True(strings.Contains(transcript, "Subject: Hello  Bcc: injected@test.com"))
+	is.True(!strings.Contains(transcript, "\nBcc:"))
+	is.True(strings.Contains(transcript, "line one\nline two"))
+}
+
+// fakeSMTPServer accepts one connection, answers every command with success
+// and sends the lines it received once the client quits
+func fakeSMTPServer(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var lines []string
+	reader := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ready")
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		switch {
+		case inData && line == ".":
+			inData = false
+			reply("250 queued")
+		case inData:
+		case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+			reply("250 localhost")
+		case line == "DATA":
+			inData = true
+			reply("354 go ahead")
+		case line == "QUIT":
+			reply("221 bye")
+			received <- lines
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+	received <- lines
+}
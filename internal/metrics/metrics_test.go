@@ -0,0 +1,56 @@
+package metrics_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/metrics"
+	"github.com/al-ce/goauth/internal/testutils"
+)
+
+// TestMain sets up the test environment for all tests in the `metrics_test` package.
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+
+	os.Exit(m.Run())
+}
+
+// TestHistogram tests that observations are written as cumulative buckets
+func TestHistogram(t *testing.T) {
+	is := is.New(t)
+
+	h := metrics.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b strings.Builder
+	h.Write(&b, "wait_seconds", "Time spent waiting.")
+	is.Equal(b.String(), `# HELP wait_seconds Time spent waiting.
+# TYPE wait_seconds histogram
+wait_seconds_bucket{le="0.1"} 1
+wait_seconds_bucket{le="1"} 2
+wait_seconds_bucket{le="+Inf"} 3
+wait_seconds_sum 2.55
+wait_seconds_count 3
+`)
+}
+
+// TestWriteGauge tests the gauge and counter text format
+func TestWriteGauge(t *testing.T) {
+	is := is.New(t)
+
+	var b strings.Builder
+	metrics.WriteGauge(&b, "in_flight", "Running now.", 3)
+	metrics.WriteCounter(&b, "rejected_total", "Refused.", 7)
+	is.Equal(b.String(), `# HELP in_flight Running now.
+# TYPE in_flight gauge
+in_flight 3
+# HELP rejected_total Refused.
+# TYPE rejected_total counter
+rejected_total 7
+`)
+}
@@ -0,0 +1,202 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestCSRFMiddleware_RequireCSRF tests the origin and double-submit token
+// checks on state-changing requests
+func TestCSRFMiddleware_RequireCSRF(t *testing.T) {
+	is := is.New(t)
+
+	csrf, err := middleware.NewCSRFMiddleware([]string{"https://app.example.com", " http://localhost:5173/ "})
+	is.NoErr(err)
+
+	router := gin.New()
+	router.Use(csrf.RequireCSRF())
+	router.GET("/csrf", func(c *gin.Context) {
+		token, err := middleware.IssueCSRFToken(c.Writer, c.Request)
+		is.NoErr(err)
+		c.String(http.StatusOK, token)
+	})
+	router.GET("/resource", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/resource", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Get a CSRF token and its cookie
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/csrf", nil))
+	is.Equal(rr.Code, http.StatusOK)
+	token := rr.Body.String()
+	var csrfCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == config.CSRFCookieName {
+			csrfCookie = cookie
+		}
+	}
+	is.True(csrfCookie != nil)
+	is.Equal(csrfCookie.Value, token)
+	is.True(csrfCookie.HttpOnly)
+	is.True(csrfCookie.Secure)
+
+	sessionCookie := &http.Cookie{Name: config.SessionCookieName, Value: "session"}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		cookies []*http.Cookie
+		want    int
+	}{
+		{
+			name:   "safe method needs no token",
+			method: "GET",
+			headers: map[string]string{
+				"Origin": "https://evil.example.com",
+			},
+			cookies: []*http.Cookie{sessionCookie},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "no session cookie needs no token",
+			method:  "POST",
+			headers: map[string]string{"Origin": "https://app.example.com"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "session cookie without token",
+			method:  "POST",
+			headers: map[string]string{"Origin": "https://app.example.com"},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "header without cookie",
+			method:  "POST",
+			headers: map[string]string{config.CSRFHeaderName: token},
+			cookies: []*http.Cookie{sessionCookie},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "mismatched token",
+			method:  "DELETE",
+			headers: map[string]string{config.CSRFHeaderName: token[1:] + "A"},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "attacker chosen cookie and header",
+			method:  "POST",
+			headers: map[string]string{config.CSRFHeaderName: "x"},
+			cookies: []*http.Cookie{sessionCookie, {Name: config.CSRFCookieName, Value: "x"}},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "matching token from allowed origin",
+			method: "POST",
+			headers: map[string]string{
+				"Origin":              "https://APP.example.com",
+				config.CSRFHeaderName: token,
+			},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "matching token from allowed referer",
+			method: "POST",
+			headers: map[string]string{
+				"Referer":             "http://localhost:5173/settings?tab=account",
+				config.CSRFHeaderName: token,
+			},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "matching token from same host",
+			method: "POST",
+			headers: map[string]string{
+				"Origin":              "https://example.com",
+				config.CSRFHeaderName: token,
+			},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "matching token from disallowed origin",
+			method: "POST",
+			headers: map[string]string{
+				"Origin":              "https://evil.example.com",
+				config.CSRFHeaderName: token,
+			},
+			cookies: []*http.Cookie{sessionCookie, csrfCookie},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "disallowed origin without session cookie",
+			method:  "POST",
+			headers: map[string]string{"Origin": "https://evil.example.com"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "opaque origin",
+			method:  "POST",
+			headers: map[string]string{"Origin": "null"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "authorization header is exempt",
+			method: "POST",
+			headers: map[string]string{
+				"Origin":        "https://evil.example.com",
+				"Authorization": "Bearer token",
+			},
+			cookies: []*http.Cookie{sessionCookie},
+			want:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/resource", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			for _, cookie := range tt.cookies {
+				req.AddCookie(cookie)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			is.Equal(rr.Code, tt.want)
+		})
+	}
+
+	t.Run("existing token is kept", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/csrf", nil)
+		req.AddCookie(csrfCookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		is.Equal(rr.Body.String(), token)
+	})
+}
+
+// TestNewCSRFMiddleware tests that malformed allowed origins are rejected
+func TestNewCSRFMiddleware(t *testing.T) {
+	is := is.New(t)
+
+	_, err := middleware.NewCSRFMiddleware([]string{"*"})
+	is.NoErr(err)
+
+	for _, origin := range []string{"app.example.com", "ftp://example.com", ""} {
+		_, err := middleware.NewCSRFMiddleware([]string{origin})
+		is.True(errors.Is(err, apperrors.ErrCSRFConfig))
+	}
+}
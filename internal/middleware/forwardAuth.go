@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Headers set by ForwardAuth for the reverse proxy to pass on to the app
+const (
+	ForwardAuthUserIDHeader   = "X-Auth-User-Id"
+	ForwardAuthEmailHeader    = "X-Auth-Email"
+	ForwardAuthRolesHeader    = "X-Auth-Roles"
+	ForwardAuthRedirectHeader = "X-Auth-Redirect"
+)
+
+// ForwardAuth godoc
+// @Summary Verify a proxied request
+// @Schemes
+// @Description Forward auth endpoint for nginx auth_request, Traefik ForwardAuth and Caddy forward_auth. Validates the session cookie like every protected route and answers 200 with the X-Auth-User-Id, X-Auth-Email and X-Auth-Roles headers. A rotated or re-signed session cookie is set on the response for the proxy to pass back to the browser. Otherwise answers 401, with the login URL in X-Auth-Redirect if FORWARD_AUTH_LOGIN_URL is set. The login URL's rd parameter holds the original URL, rebuilt from X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Uri (or X-Original-Uri).
+// @Produce json
+// @Success 200 "authenticated, see the X-Auth-* headers"
+// @Failure 401 {object} models.ForwardAuthErrorResponse "response with error and optional loginUrl fields"
+// @Router /auth/verify [get]
+func (am *AuthMiddleware) ForwardAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		am.ForwardAuthHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// ForwardAuthHTTP is the net/http adapter of ForwardAuth
+func (am *AuthMiddleware) ForwardAuthHTTP(w http.ResponseWriter, r *http.Request) {
+	// The proxy's subrequest must never be answered from a cache
+	w.Header().Set("Cache-Control", "no-store")
+
+	// The Authorization header of a proxied request is meant for the app
+	r, ok := am.authenticateCookie(w, r)
+	if !ok {
+		am.rejectForwardAuth(w, r)
+		return
+	}
+
+	id, _ := identity.FromContext(r.Context())
+	user, err := am.UserRepo.GetUserByID(id.UserID.String())
+	if err != nil {
+		log.Debug().Err(err).Msg("User not found")
+		am.rejectForwardAuth(w, r)
+		return
+	}
+
+	w.Header().Set(ForwardAuthUserIDHeader, user.ID.String())
+	w.Header().Set(ForwardAuthEmailHeader, user.Email)
+	w.Header().Set(ForwardAuthRolesHeader, user.Role)
+	w.WriteHeader(http.StatusOK)
+}
+
+// rejectForwardAuth answers 401, pointing to the login page with the
+// original URL to return to if a login URL is configured
+func (am *AuthMiddleware) rejectForwardAuth(w http.ResponseWriter, r *http.Request) {
+	body := map[string]string{"error": "unauthorized"}
+	if am.LoginURL != "" {
+		loginURL := ForwardAuthRedirectURL(am.LoginURL, r)
+		w.Header().Set(ForwardAuthRedirectHeader, loginURL)
+		body["loginUrl"] = loginURL
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ForwardAuthRedirectURL adds the original URL of a proxied request to
+// `loginURL` as the `rd` query parameter. Proxies send the original URL in
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Uri, or X-Original-Uri
+// as commonly set for nginx. Without a host only the path is kept, and
+// without either the login URL is returned unchanged.
+func ForwardAuthRedirectURL(loginURL string, r *http.Request) string {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-Uri")
+	}
+	original := uri
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if proto == "" {
+			proto = "https"
+		}
+		if uri == "" {
+			uri = "/"
+		}
+		original = proto + "://" + host + uri
+	}
+	if original == "" {
+		return loginURL
+	}
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return loginURL
+	}
+	query := u.Query()
+	query.Set(config.ForwardAuthRedirectParam, original)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ForwardAuthLoginURLFromEnv reads and validates `FORWARD_AUTH_LOGIN_URL`
+func ForwardAuthLoginURLFromEnv() (string, error) {
+	loginURL := os.Getenv(config.ForwardAuthLoginURL)
+	if loginURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%w: %q", apperrors.ErrForwardAuthConfig, loginURL)
+	}
+	return loginURL, nil
+}
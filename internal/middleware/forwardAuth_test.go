@@ -0,0 +1,178 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestForwardAuthRedirectURL tests rebuilding the original URL of a proxied
+// request into the login URL
+func TestForwardAuthRedirectURL(t *testing.T) {
+	is := is.New(t)
+	loginURL := "https://auth.example.com/login?theme=dark"
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name: "traefik headers",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "app.example.com",
+				"X-Forwarded-Uri":   "/dashboard?tab=2",
+			},
+			want: "https://app.example.com/dashboard?tab=2",
+		},
+		{
+			name: "nginx original uri",
+			headers: map[string]string{
+				"X-Forwarded-Host": "app.example.com",
+				"X-Original-Uri":   "/reports",
+			},
+			want: "https://app.example.com/reports",
+		},
+		{
+			name:    "path only",
+			headers: map[string]string{"X-Forwarded-Uri": "/reports"},
+			want:    "/reports",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth/verify", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			u, err := url.Parse(middleware.ForwardAuthRedirectURL(loginURL, r))
+			is.NoErr(err)
+			is.Equal(u.Host, "auth.example.com")
+			is.Equal(u.Query().Get("theme"), "dark")
+			is.Equal(u.Query().Get(config.ForwardAuthRedirectParam), tt.want)
+		})
+	}
+
+	t.Run("without original url", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/auth/verify", nil)
+		is.Equal(middleware.ForwardAuthRedirectURL(loginURL, r), loginURL)
+	})
+}
+
+// TestForwardAuthLoginURLFromEnv tests validating `FORWARD_AUTH_LOGIN_URL`
+func TestForwardAuthLoginURLFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv(config.ForwardAuthLoginURL, "")
+	loginURL, err := middleware.ForwardAuthLoginURLFromEnv()
+	is.NoErr(err)
+	is.Equal(loginURL, "")
+
+	t.Setenv(config.ForwardAuthLoginURL, "https://auth.example.com/login")
+	loginURL, err = middleware.ForwardAuthLoginURLFromEnv()
+	is.NoErr(err)
+	is.Equal(loginURL, "https://auth.example.com/login")
+
+	for _, invalid := range []string{"/login", "ftp://auth.example.com", "https://"} {
+		t.Setenv(config.ForwardAuthLoginURL, invalid)
+		_, err = middleware.ForwardAuthLoginURLFromEnv()
+		is.True(errors.Is(err, apperrors.ErrForwardAuthConfig))
+	}
+}
+
+// TestMiddlewareAuth_ForwardAuth tests the `/auth/verify` responses for
+// authenticated, rotated and unauthenticated sessions
+func TestMiddlewareAuth_ForwardAuth(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	defer tx.Rollback()
+
+	authMw, err := middleware.NewAuthMiddleware(tx)
+	is.NoErr(err)
+	authMw.LoginURL = "https://auth.example.com/login"
+	sessionRepo, err := repository.NewSessionRepository(tx)
+	is.NoErr(err)
+
+	router := gin.New()
+	router.GET("/auth/verify", authMw.ForwardAuth())
+
+	email := "TestMiddlewareAuth_ForwardAuth@test.com"
+	user, err := models.NewUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	user.Role = models.RoleAdmin
+	is.NoErr(tx.Create(user).Error)
+
+	// createSession saves a session created `age` ago that expires in `ttl`
+	createSession := func(age, ttl time.Duration) string {
+		sessionToken, tokenHash, err := models.GenerateSessionToken()
+		is.NoErr(err)
+		createdAt := time.Now().UTC().Add(-age)
+		session, err := models.NewSession(user.ID, tokenHash, createdAt.Add(age+ttl))
+		is.NoErr(err)
+		session.CreatedAt = createdAt
+		is.NoErr(sessionRepo.CreateSession(session))
+		return sessionToken
+	}
+	verify := func(sessionToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/verify", nil)
+		req.Header.Set("X-Forwarded-Host", "app.example.com")
+		req.Header.Set("X-Forwarded-Uri", "/dashboard")
+		if sessionToken != "" {
+			req.AddCookie(&http.Cookie{Name: config.SessionCookieName, Value: sessionToken})
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("with valid session", func(t *testing.T) {
+		rr := verify(createSession(time.Minute, time.Hour))
+		is.Equal(rr.Code, http.StatusOK)
+		is.Equal(rr.Header().Get(middleware.ForwardAuthUserIDHeader), user.ID.String())
+		is.Equal(rr.Header().Get(middleware.ForwardAuthEmailHeader), email)
+		is.Equal(rr.Header().Get(middleware.ForwardAuthRolesHeader), models.RoleAdmin)
+		is.Equal(rr.Header().Get("Set-Cookie"), "")
+	})
+
+	t.Run("rotates halfway expired session", func(t *testing.T) {
+		rr := verify(createSession(6*time.Minute, 4*time.Minute))
+		is.Equal(rr.Code, http.StatusOK)
+		var rotated bool
+		for _, cookie := range rr.Result().Cookies() {
+			rotated = rotated || cookie.Name == config.SessionCookieName
+		}
+		is.True(rotated)
+	})
+
+	t.Run("without session", func(t *testing.T) {
+		rr := verify("")
+		is.Equal(rr.Code, http.StatusUnauthorized)
+		is.Equal(rr.Header().Get(middleware.ForwardAuthUserIDHeader), "")
+
+		loginURL := rr.Header().Get(middleware.ForwardAuthRedirectHeader)
+		u, err := url.Parse(loginURL)
+		is.NoErr(err)
+		is.Equal(u.Query().Get(config.ForwardAuthRedirectParam), "https://app.example.com/dashboard")
+
+		var body map[string]string
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &body))
+		is.Equal(body["loginUrl"], loginURL)
+	})
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// RequireAdmin is a middleware that only lets users with the admin role
+// through, and service accounts granted the admin scope. It must run after
+// RequireAuth, which sets the caller's identity.
+func (am *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !am.requireAdmin(c.Writer, c.Request) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdminHTTP is the net/http adapter of RequireAdmin
+func (am *AuthMiddleware) RequireAdminHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if am.requireAdmin(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// requireAdmin checks the caller is an admin, responding 401 or 403 if not
+func (am *AuthMiddleware) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	id, ok := identity.FromContext(r.Context())
+	if !ok {
+		log.Debug().Msg("identity not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if id.IsServiceAccount() {
+		if !id.HasScope(models.ScopeAdmin) {
+			log.Info().
+				Str("serviceAccountID", id.ServiceAccountID.String()).
+				Str("clientIP", clientIP(r)).
+				Msg("Service account denied admin route")
+			writeError(w, http.StatusForbidden, apperrors.ErrAdminRequired)
+			return false
+		}
+		return true
+	}
+	userID := id.UserID.String()
+
+	user, err := am.UserRepo.GetUserByID(userID)
+	if err != nil {
+		log.Debug().Err(err).Msg("User not found")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	if user.Role != models.RoleAdmin {
+		log.Info().
+			Str("userID", userID).
+			Str("clientIP", clientIP(r)).
+			Msg("Non-admin user denied admin route")
+		writeError(w, http.StatusForbidden, apperrors.ErrAdminRequired)
+		return false
+	}
+	return true
+}
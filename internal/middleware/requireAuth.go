@@ -1,15 +1,17 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 
+	"github.com/al-ce/goauth/internal/cookies"
+	"github.com/al-ce/goauth/internal/identity"
 	"github.com/al-ce/goauth/internal/models"
 	"github.com/al-ce/goauth/internal/repository"
 	"github.com/al-ce/goauth/internal/services"
@@ -18,8 +20,14 @@ import (
 )
 
 type AuthMiddleware struct {
-	UserRepo    *repository.UserRepository
-	SessionRepo *repository.SessionRepository
+	UserRepo     *repository.UserRepository
+	SessionRepo  *repository.SessionRepository
+	AccessTokens *services.AccessTokenService
+	// ServiceAccounts authenticates the tokens issued to service accounts
+	ServiceAccounts *services.ServiceAccountService
+	// LoginURL is where ForwardAuth sends unauthenticated users, from
+	// `FORWARD_AUTH_LOGIN_URL`. Empty means no redirect is suggested.
+	LoginURL string
 }
 
 func NewAuthMiddleware(db *gorm.DB) (*AuthMiddleware, error) {
@@ -34,86 +42,256 @@ func NewAuthMiddleware(db *gorm.DB) (*AuthMiddleware, error) {
 	if err != nil {
 		return nil, err
 	}
+	tr, err := repository.NewAccessTokenRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := repository.NewAuditRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := services.NewAccessTokenService(ur, tr, ar)
+	if err != nil {
+		return nil, err
+	}
+	sar, err := repository.NewServiceAccountRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	sas, err := services.NewServiceAccountService(sar, ar)
+	if err != nil {
+		return nil, err
+	}
+	loginURL, err := ForwardAuthLoginURLFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	return &AuthMiddleware{
-		UserRepo:    ur,
-		SessionRepo: sr,
+		UserRepo:        ur,
+		SessionRepo:     sr,
+		AccessTokens:    ts,
+		ServiceAccounts: sas,
+		LoginURL:        loginURL,
 	}, nil
 }
 
 // RequireAuth is a middleware used to authorize users with session tokens from
 // the cookie, checking if the session in the database matching the token is
-// valid and not expired. The session is rotated if it is halfway expired.
+// valid and not expired. The session is rotated if it is halfway expired, and
+// otherwise re-signed if its token was signed with a retired session key.
+// Requests with an `Authorization` header are authorized with the personal
+// access token or service account token in it instead, and never with the
+// cookie. The caller's identity.Identity is added to the request's context.
 func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get cookie from request
-		sessionToken, err := c.Cookie(config.SessionCookieName)
-		if err != nil {
-			log.Debug().Err(err).Msg("No auth cookie found")
+		r, ok := am.authenticate(c.Writer, c.Request, c.ClientIP())
+		if !ok {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		c.Request = r
+		c.Next()
+	}
+}
 
-		// Split the session token
-		parts := strings.Split(sessionToken, ".")
-		if len(parts) != 2 {
-			log.Debug().Msg("Invalid token format")
-			c.AbortWithStatus(http.StatusUnauthorized)
+// RequireAuthHTTP is the net/http adapter of RequireAuth. Tokens' last use is
+// recorded from the request's remote address, so run a real-IP middleware
+// first if goauth is behind a proxy.
+func (am *AuthMiddleware) RequireAuthHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := am.authenticate(w, r, clientIP(r))
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		sessionID, signature := parts[0], parts[1]
-		parsedID, err := uuid.Parse(sessionID)
-		if err != nil {
-			log.Debug().Msg("Invalid token format")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSession is RequireAuth for sessions only, ignoring any
+// `Authorization` header, for routes outside the auth service whose hosts
+// have no use for the access token scopes
+func (am *AuthMiddleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, ok := am.authenticateCookie(c.Writer, c.Request)
+		if !ok {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		c.Request = r
+		c.Next()
+	}
+}
 
-		// Verify the HMAC signature
-		if !models.ValidateSessionID(parsedID, signature) {
-			log.Debug().Msg("Invalid token signature")
-			c.AbortWithStatus(http.StatusUnauthorized)
+// RequireSessionHTTP is the net/http adapter of RequireSession
+func (am *AuthMiddleware) RequireSessionHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, ok := am.authenticateCookie(w, r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// Get session from database
-		session, err := am.SessionRepo.GetUnexpiredSessionByID(parsedID)
-		if err != nil {
-			log.Debug().Err(err).Msg("Session not found")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+// authenticate validates the bearer token or session cookie for
+// RequireAuth, with `clientIP` resolved by the router. It returns the request
+// with the caller's identity in its context, or false if the request is not
+// authenticated.
+func (am *AuthMiddleware) authenticate(w http.ResponseWriter, r *http.Request, clientIP string) (*http.Request, bool) {
+	// Requests with the header skip the CSRF check, so the cookie must not
+	// authenticate them
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			log.Debug().Msg("Unsupported authorization scheme")
+			return r, false
 		}
+		id, ok := am.AuthenticateBearer(token, clientIP)
+		if !ok {
+			return r, false
+		}
+		return r.WithContext(identity.NewContext(r.Context(), id)), true
+	}
+	return am.authenticateCookie(w, r)
+}
 
-		// Check if session is expired
-		if time.Now().UTC().After(session.ExpiresAt) {
-			log.Debug().Msg("Session expired")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+// authenticateCookie validates the session cookie for RequireAuth and
+// ForwardAuth, setting any rotated or re-signed cookie on the response. It
+// returns the request with the caller's identity in its context, or false if
+// the request is not authenticated.
+func (am *AuthMiddleware) authenticateCookie(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	// Get cookie from request
+	sessionToken, err := cookies.Default().SessionToken(r)
+	if err != nil {
+		log.Debug().Err(err).Msg("No auth cookie found")
+		return r, false
+	}
+
+	id, renewal, ok := am.AuthenticateToken(sessionToken)
+	if !ok {
+		return r, false
+	}
+	if renewal != nil {
+		cookies.Default().SetSession(w, renewal.Token, renewal.ExpiresAt)
+	}
+	return r.WithContext(identity.NewContext(r.Context(), id)), true
+}
+
+// Renewal is a session token that replaces the one a client sent, because
+// the session was rotated or the token was signed with a retired key
+type Renewal struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// AuthenticateToken checks that the session matching a token is valid and not
+// expired, for every transport that carries session tokens. The session is
+// rotated if it is halfway expired, and otherwise re-signed if its token was
+// signed with a retired session key; the client must then replace its token
+// with the returned Renewal.
+func (am *AuthMiddleware) AuthenticateToken(sessionToken string) (identity.Identity, *Renewal, bool) {
+	// Verify the token format and HMAC signature
+	tokenHash, err := models.ParseSessionToken(sessionToken)
+	if err != nil {
+		log.Debug().Err(err).Msg("Invalid session token")
+		return identity.Identity{}, nil, false
+	}
+
+	// Get session from database
+	session, err := am.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+	if err != nil {
+		log.Debug().Err(err).Msg("Session not found")
+		return identity.Identity{}, nil, false
+	}
+
+	// Check if session is expired
+	if time.Now().UTC().After(session.ExpiresAt) {
+		log.Debug().Msg("Session expired")
+		return identity.Identity{}, nil, false
+	}
+
+	var renewal *Renewal
+	// Rotate session if halfway expired
+	halfway := session.CreatedAt.Add(session.ExpiresAt.Sub(session.CreatedAt) / 2)
+	if time.Now().UTC().After(halfway) {
+		userService, err := services.NewUserService(am.UserRepo, am.SessionRepo)
+		if err != nil {
+			log.Debug().Err(err).Msg("Failed to rotate session")
+			return identity.Identity{}, nil, false
 		}
 
-		c.Set("userID", session.UserID.String())
-
-		// Rotate session if halfway expired
-		halfway := session.CreatedAt.Add(session.ExpiresAt.Sub(session.CreatedAt) / 2)
-		if time.Now().UTC().After(halfway) {
-			userService, err := services.NewUserService(am.UserRepo, am.SessionRepo)
-			if err != nil {
-				log.Debug().Err(err).Msg("Failed to rotate session")
-				c.AbortWithStatus(http.StatusUnauthorized)
-				return
-			}
-
-			// Rotate session
-			newSessionToken, err := userService.RotateSession(parsedID)
-			if err != nil {
-				log.Debug().Err(err).Msg("Failed to rotate session")
-				c.AbortWithStatus(http.StatusUnauthorized)
-				return
-			} else {
-				c.SetSameSite(http.SameSiteStrictMode)
-				c.SetCookie(config.SessionCookieName, newSessionToken, int(config.SessionExpiration), "", "", true, true)
-			}
+		// Rotate session
+		newSessionToken, err := userService.RotateSession(session.ID)
+		if err != nil {
+			log.Debug().Err(err).Msg("Failed to rotate session")
+			return identity.Identity{}, nil, false
 		}
+		expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
+		renewal = &Renewal{Token: newSessionToken, ExpiresAt: expiresAt}
+	} else if resignedToken, ok := models.ResignSessionToken(sessionToken); ok {
+		// Move tokens signed with a retired key onto the active key
+		renewal = &Renewal{Token: resignedToken, ExpiresAt: session.ExpiresAt}
+	}
 
-		c.Next()
+	return identity.Identity{
+		UserID:        session.UserID,
+		SessionID:     session.ID,
+		AuthTime:      session.AuthTime,
+		SessionClaims: session.Claims,
+	}, renewal, true
+}
+
+// AuthenticateAccessToken checks that a personal access token is valid and
+// not expired, for every transport that accepts bearer tokens, recording its
+// use from `clientIP`. The identity carries the token's scopes.
+func (am *AuthMiddleware) AuthenticateAccessToken(token, clientIP string) (identity.Identity, bool) {
+	accessToken, err := am.AccessTokens.Authenticate(token, clientIP)
+	if err != nil {
+		log.Debug().Err(err).Msg("Invalid access token")
+		return identity.Identity{}, false
 	}
+	return identity.Identity{
+		UserID:        accessToken.UserID,
+		AccessTokenID: accessToken.ID,
+		Scopes:        accessToken.ScopeList(),
+	}, true
+}
+
+// AuthenticateServiceAccountToken checks that a token issued to a service
+// account is valid and not expired, for every transport that accepts bearer
+// tokens. The identity carries the service account and the token's scopes.
+func (am *AuthMiddleware) AuthenticateServiceAccountToken(token string) (identity.Identity, bool) {
+	accessToken, err := am.ServiceAccounts.Authenticate(token)
+	if err != nil {
+		log.Debug().Err(err).Msg("Invalid service account token")
+		return identity.Identity{}, false
+	}
+	return identity.Identity{
+		ServiceAccountID: accessToken.ServiceAccountID,
+		ClientID:         accessToken.ServiceAccount.ClientID,
+		Scopes:           accessToken.ScopeList(),
+	}, true
+}
+
+// AuthenticateBearer checks a personal access token or service account
+// token, telling them apart by their prefix. Session tokens are refused.
+func (am *AuthMiddleware) AuthenticateBearer(token, clientIP string) (identity.Identity, bool) {
+	switch {
+	case models.IsAccessToken(token):
+		return am.AuthenticateAccessToken(token, clientIP)
+	case models.IsServiceAccountToken(token):
+		return am.AuthenticateServiceAccountToken(token)
+	}
+	log.Debug().Msg("Unsupported bearer token")
+	return identity.Identity{}, false
+}
+
+// writeError responds with `status` and the error as `{"error": "..."}`, like
+// gin's AbortWithStatusJSON, for checks shared by the gin and net/http adapters
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 }
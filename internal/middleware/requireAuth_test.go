@@ -8,9 +8,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/matryer/is"
 
+	"github.com/al-ce/goauth/internal/keyring"
 	"github.com/al-ce/goauth/internal/middleware"
 	"github.com/al-ce/goauth/internal/models"
 	"github.com/al-ce/goauth/internal/repository"
@@ -49,14 +49,13 @@ func TestMiddlewareAuth_RequireAuth(t *testing.T) {
 	is.NoErr(err)
 
 	// Generate a test token
-	sessionID, signature, err := models.GenerateSessionID()
+	sessionToken, tokenHash, err := models.GenerateSessionToken()
 	is.NoErr(err)
-	sessionToken := sessionID.String() + "." + signature
 
 	// Create a session record for this token
 	session, err := models.NewSession(
 		user.ID,
-		sessionID,
+		tokenHash,
 		time.Now().UTC().Add(time.Hour*24),
 	)
 	is.NoErr(err)
@@ -96,14 +95,13 @@ func TestMiddlewareAuth_RequireAuth(t *testing.T) {
 
 	t.Run("with expired token in db", func(t *testing.T) {
 		// Generate new session token with same claims
-		sessionID, signature, err := models.GenerateSessionID()
+		expiredSessionToken, tokenHash, err := models.GenerateSessionToken()
 		is.NoErr(err)
-		expiredSessionToken := sessionID.String() + "." + signature
 
 		// Create a session with an expired token
 		expiredSession, err := models.NewSession(
 			user.ID,
-			sessionID,
+			tokenHash,
 			time.Now().UTC().Add(-1*time.Hour),
 		)
 		is.NoErr(err)
@@ -156,9 +154,8 @@ func TestMiddlewareAuth_RequireAuth_SessionRotation(t *testing.T) {
 	is.NoErr(err)
 
 	// Generate a test token
-	oldSessionID, signature, err := models.GenerateSessionID()
+	sessionToken, tokenHash, err := models.GenerateSessionToken()
 	is.NoErr(err)
-	sessionToken := oldSessionID.String() + "." + signature
 
 
 
@@ -169,7 +166,7 @@ func TestMiddlewareAuth_RequireAuth_SessionRotation(t *testing.T) {
 	// Create a session record for this token
 	session, err := models.NewSession(
 		user.ID,
-		oldSessionID,
+		tokenHash,
 		now.Add(time.Minute * 10),
 	)
 	session.CreatedAt = now
@@ -180,7 +177,7 @@ func TestMiddlewareAuth_RequireAuth_SessionRotation(t *testing.T) {
 	is.NoErr(err)
 
 	// Check that the session is in the database
-	updatedSession, err := sessionRepo.GetUnexpiredSessionByID(oldSessionID)
+	updatedSession, err := sessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
 	is.NoErr(err)
 
 	// Calculate halfway point
@@ -215,23 +212,19 @@ func TestMiddlewareAuth_RequireAuth_SessionRotation(t *testing.T) {
 		is.True(newTokenFromCookie != "")
 
 		// New Session Token is valid
-		parts := strings.Split(newTokenFromCookie, ".")
-		is.True(len(parts) == 2)
-		newSessionID, newSignature := parts[0], parts[1]
-		parsedID, err := uuid.Parse(newSessionID)
+		newTokenHash, err := models.ParseSessionToken(newTokenFromCookie)
 		is.NoErr(err)
-		is.True(models.ValidateSessionID(parsedID, newSignature))
 
 		// Check that the new token is different from the old one
 		is.True(newTokenFromCookie != "")
 		is.True(newTokenFromCookie != sessionToken)
 
 		// Check that the old session is deleted
-		_, err = sessionRepo.GetUnexpiredSessionByID(oldSessionID)
+		_, err = sessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
 		is.True(err != nil)
 
 		// Check that the new, rotated session is created
-		newSession, err := sessionRepo.GetUnexpiredSessionByID(parsedID)
+		newSession, err := sessionRepo.GetUnexpiredSessionByTokenHash(newTokenHash)
 		is.NoErr(err)
 		is.Equal(user.ID, newSession.UserID)
 
@@ -241,6 +234,69 @@ func TestMiddlewareAuth_RequireAuth_SessionRotation(t *testing.T) {
 		// Rotated session should have the same user ID
 		is.Equal(user.ID, newSession.UserID)
 		// Rotated session should have a different token
-		is.True(newSession.ID != oldSessionID)
+		is.True(newSession.ID != session.ID)
 	})
 }
+
+// TestMiddlewareAuth_RequireAuth_ResignsRetiredKey tests that a token signed
+// with a retired session key is accepted and re-signed with the active key
+func TestMiddlewareAuth_RequireAuth_ResignsRetiredKey(t *testing.T) {
+	is := is.New(t)
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	defer tx.Rollback()
+
+	authMw, err := middleware.NewAuthMiddleware(tx)
+	is.NoErr(err)
+	sessionRepo, err := repository.NewSessionRepository(tx)
+	is.NoErr(err)
+
+	router := gin.New()
+	router.GET("/protected", authMw.RequireAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	user, err := models.NewUser("TestMiddlewareAuth_ResignsRetiredKey@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	err = tx.Create(user).Error
+	is.NoErr(err)
+
+	// Sign a session token with the old key
+	ring := &keyring.Keyring{}
+	_, err = ring.Generate("old")
+	is.NoErr(err)
+	is.NoErr(ring.Promote("old"))
+	keyring.SetDefault(ring)
+	t.Cleanup(func() { keyring.SetDefault(nil) })
+
+	sessionToken, tokenHash, err := models.GenerateSessionToken()
+	is.NoErr(err)
+	session, err := models.NewSession(user.ID, tokenHash, time.Now().UTC().Add(time.Hour*24))
+	is.NoErr(err)
+	err = sessionRepo.CreateSession(session)
+	is.NoErr(err)
+
+	// Rotate to a new key
+	_, err = ring.Generate("new")
+	is.NoErr(err)
+	is.NoErr(ring.Promote("new"))
+
+	req, err := http.NewRequest("GET", "/protected", nil)
+	is.NoErr(err)
+	req.AddCookie(&http.Cookie{Name: config.SessionCookieName, Value: sessionToken})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	is.Equal(http.StatusOK, rr.Code)
+
+	// Same session, token now signed with the new key
+	var resigned string
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == config.SessionCookieName {
+			resigned = cookie.Value
+		}
+	}
+	is.True(strings.HasPrefix(resigned, "new."))
+	resignedHash, err := models.ParseSessionToken(resigned)
+	is.NoErr(err)
+	is.Equal(resignedHash, tokenHash)
+}
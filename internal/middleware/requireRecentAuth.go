@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// RequireRecentAuth is a middleware that only lets sessions through whose
+// user logged in or reauthenticated within `maxAge`, so a stolen session
+// cannot take over or destroy the account. Access tokens and service
+// accounts have no login to be recent, and are only let through by routes
+// that grant them a scope. It must run after RequireAuth, which sets the
+// session's auth time.
+func (am *AuthMiddleware) RequireRecentAuth(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireRecentAuth(c.Writer, c.Request, maxAge) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRecentAuthHTTP is the net/http adapter of RequireRecentAuth
+func (am *AuthMiddleware) RequireRecentAuthHTTP(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requireRecentAuth(w, r, maxAge) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// requireRecentAuth checks the session authenticated within `maxAge`,
+// responding 401 or 403 if not
+func requireRecentAuth(w http.ResponseWriter, r *http.Request, maxAge time.Duration) bool {
+	id, ok := identity.FromContext(r.Context())
+	if !ok {
+		log.Debug().Msg("identity not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	if id.IsAccessToken() || id.IsServiceAccount() {
+		return true
+	}
+	if !id.AuthenticatedWithin(maxAge) {
+		log.Info().
+			Str("userID", id.UserID.String()).
+			Str("clientIP", clientIP(r)).
+			Msg("Session denied sensitive route without recent authentication")
+		writeError(w, http.StatusForbidden, apperrors.ErrRecentAuthRequired)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/middleware"
+)
+
+// TestMiddlewareAuth_RequireRecentAuth tests that only sessions authenticated
+// within the max age reach the handler, with the gin and net/http adapters
+func TestMiddlewareAuth_RequireRecentAuth(t *testing.T) {
+	is := is.New(t)
+
+	// RequireRecentAuth only reads the auth time set by RequireAuth
+	authMw := &middleware.AuthMiddleware{}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// tokenAuthTime marks requests made with an access token, which has no
+	// auth time
+	tokenAuthTime := &time.Time{}
+
+	// withAuthTime stands in for RequireAuth
+	withAuthTime := func(r *http.Request, authTime *time.Time) *http.Request {
+		if authTime == nil {
+			return r
+		}
+		id := identity.Identity{UserID: uuid.New(), SessionID: uuid.New(), AuthTime: *authTime}
+		if authTime == tokenAuthTime {
+			id = identity.Identity{UserID: uuid.New(), AccessTokenID: uuid.New()}
+		}
+		return r.WithContext(identity.NewContext(r.Context(), id))
+	}
+
+	adapters := map[string]func(authTime *time.Time) http.Handler{
+		"gin": func(authTime *time.Time) http.Handler {
+			router := gin.New()
+			router.POST("/sensitive", func(c *gin.Context) {
+				c.Request = withAuthTime(c.Request, authTime)
+			}, authMw.RequireRecentAuth(5*time.Minute), gin.WrapF(ok))
+			return router
+		},
+		"net/http": func(authTime *time.Time) http.Handler {
+			next := authMw.RequireRecentAuthHTTP(5 * time.Minute)(http.HandlerFunc(ok))
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, withAuthTime(r, authTime))
+			})
+		},
+	}
+
+	for name, handler := range adapters {
+		t.Run(name, func(t *testing.T) {
+			request := func(authTime *time.Time) int {
+				rr := httptest.NewRecorder()
+				handler(authTime).ServeHTTP(rr, httptest.NewRequest("POST", "/sensitive", nil))
+				return rr.Code
+			}
+
+			recent := time.Now().UTC().Add(-time.Minute)
+			is.Equal(request(&recent), http.StatusOK)
+
+			stale := time.Now().UTC().Add(-6 * time.Minute)
+			is.Equal(request(&stale), http.StatusForbidden)
+
+			// Tokens are limited by their scopes instead
+			is.Equal(request(tokenAuthTime), http.StatusOK)
+
+			// Without RequireAuth there is no auth time
+			is.Equal(request(nil), http.StatusUnauthorized)
+		})
+	}
+}
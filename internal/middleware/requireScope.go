@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// RequireScope is a middleware that lets sessions through, and personal
+// access tokens and service accounts only if they were granted `scope`. An
+// empty scope lets sessions through only. It must run after RequireAuth,
+// which sets the token's scopes.
+func (am *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c.Writer, c.Request, scope) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopeHTTP is the net/http adapter of RequireScope
+func (am *AuthMiddleware) RequireScopeHTTP(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requireScope(w, r, scope) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// requireScope checks the caller may use a route that needs `scope`,
+// responding 401 or 403 if not. The 403 names the scope in a
+// `WWW-Authenticate` header, as described in RFC 6750.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	id, ok := identity.FromContext(r.Context())
+	if !ok {
+		log.Debug().Msg("identity not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	if !id.HasScope(scope) {
+		log.Info().
+			Str("principal", id.Principal()).
+			Str("subject", id.Subject().String()).
+			Str("tokenID", id.AccessTokenID.String()).
+			Str("clientIP", clientIP(r)).
+			Str("scope", scope).
+			Msg("Access token denied route outside its scopes")
+		challenge := `Bearer error="insufficient_scope"`
+		if scope != "" {
+			challenge += fmt.Sprintf(`, scope="%s"`, scope)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeError(w, http.StatusForbidden, apperrors.ErrInsufficientScope)
+		return false
+	}
+	return true
+}
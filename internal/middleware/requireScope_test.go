@@ -0,0 +1,84 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/models"
+)
+
+// TestMiddlewareAuth_RequireScope tests that sessions reach every route and
+// access tokens and service accounts only the routes of their scopes, with
+// the gin and net/http adapters
+func TestMiddlewareAuth_RequireScope(t *testing.T) {
+	is := is.New(t)
+
+	// RequireScope only reads the identity set by RequireAuth
+	authMw := &middleware.AuthMiddleware{}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// withIdentity stands in for RequireAuth
+	withIdentity := func(r *http.Request, id *identity.Identity) *http.Request {
+		if id == nil {
+			return r
+		}
+		return r.WithContext(identity.NewContext(r.Context(), *id))
+	}
+
+	adapters := map[string]func(scope string, id *identity.Identity) http.Handler{
+		"gin": func(scope string, id *identity.Identity) http.Handler {
+			router := gin.New()
+			router.GET("/scoped", func(c *gin.Context) {
+				c.Request = withIdentity(c.Request, id)
+			}, authMw.RequireScope(scope), gin.WrapF(ok))
+			return router
+		},
+		"net/http": func(scope string, id *identity.Identity) http.Handler {
+			next := authMw.RequireScopeHTTP(scope)(http.HandlerFunc(ok))
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, withIdentity(r, id))
+			})
+		},
+	}
+
+	session := &identity.Identity{UserID: uuid.New(), SessionID: uuid.New()}
+	token := &identity.Identity{UserID: uuid.New(), AccessTokenID: uuid.New(), Scopes: []string{models.ScopeProfile}}
+	machine := &identity.Identity{ServiceAccountID: uuid.New(), ClientID: "goauth_sa_test", Scopes: []string{models.ScopeAdmin}}
+
+	for name, handler := range adapters {
+		t.Run(name, func(t *testing.T) {
+			request := func(scope string, id *identity.Identity) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				handler(scope, id).ServeHTTP(rr, httptest.NewRequest("GET", "/scoped", nil))
+				return rr
+			}
+
+			is.Equal(request(models.ScopeProfile, session).Code, http.StatusOK)
+			is.Equal(request("", session).Code, http.StatusOK)
+			is.Equal(request(models.ScopeProfile, token).Code, http.StatusOK)
+
+			rr := request(models.ScopeExport, token)
+			is.Equal(rr.Code, http.StatusForbidden)
+			is.Equal(rr.Header().Get("WWW-Authenticate"), `Bearer error="insufficient_scope", scope="export"`)
+
+			// Routes without a scope are for sessions only
+			is.Equal(request("", token).Code, http.StatusForbidden)
+
+			is.Equal(request(models.ScopeAdmin, machine).Code, http.StatusOK)
+			is.Equal(request(models.ScopeProfile, machine).Code, http.StatusForbidden)
+			is.Equal(request("", machine).Code, http.StatusForbidden)
+
+			// Without RequireAuth there is no identity
+			is.Equal(request(models.ScopeProfile, nil).Code, http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// AccessTokenPrefix starts every personal access token, so they can be told
+// apart from session tokens in an `Authorization` header and found by secret
+// scanners
+const AccessTokenPrefix = "goauth_pat_"
+
+// accessTokenSecretBytes is the number of random bytes in a personal access token
+const accessTokenSecretBytes = 32
+
+// Scopes a personal access token can be granted. Each protected route needs
+// one of them; routes without a scope are for sessions only.
+const (
+	// ScopeProfile reads the user's profile with `/whoami`
+	ScopeProfile = "profile"
+	// ScopeSessions lists and revokes the user's sessions
+	ScopeSessions = "sessions"
+	// ScopeExport exports the user's data
+	ScopeExport = "export"
+	// ScopeAdmin calls the admin routes, for users with the admin role
+	ScopeAdmin = "admin"
+)
+
+// AccessTokenScopes lists the scopes a personal access token can be granted
+var AccessTokenScopes = []string{ScopeProfile, ScopeSessions, ScopeExport, ScopeAdmin}
+
+// AccessToken represents a personal access token in the `access_tokens`
+// table, for scripts and CI jobs acting as a user. Like sessions, the token
+// is never stored: TokenHash is a SHA-256 digest of it. Scopes is a space
+// separated list. A token without ExpiresAt is valid until revoked.
+type AccessToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	TokenHash  string     `gorm:"type:char(64);not null;uniqueIndex"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	User       *User      `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
+	Name       string     `gorm:"type:varchar(100);not null"`
+	Scopes     string     `gorm:"type:text;not null"`
+	ExpiresAt  *time.Time `gorm:"type:timestamp"`
+	LastUsedAt *time.Time `gorm:"type:timestamp"`
+	LastUsedIP string     `gorm:"type:varchar(45);not null;default:''"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// AccessTokenInfo is the public view of a personal access token, for listing
+// a user's tokens. The token itself is only shown when it is created.
+type AccessTokenInfo struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIP string     `json:"lastUsedIp"`
+}
+
+// NewAccessToken creates a new AccessToken value for a user from the hash of
+// the token, its name and scopes, and an optional expiration time
+func NewAccessToken(userID uuid.UUID, tokenHash, name string, scopes []string, expiresAt *time.Time) (*AccessToken, error) {
+	if userID == uuid.Nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	if tokenHash == "" {
+		return nil, apperrors.ErrSessionIdIsEmpty
+	}
+	if err := ValidateAccessTokenScopes(scopes); err != nil {
+		return nil, err
+	}
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+	return &AccessToken{
+		ID:        uuid.New(),
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// ScopeList returns the token's scopes
+func (t *AccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// Info returns the public view of the token
+func (t *AccessToken) Info() AccessTokenInfo {
+	return AccessTokenInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     t.ScopeList(),
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP,
+	}
+}
+
+// ValidateAccessTokenScopes checks that a token is granted at least one scope
+// and only known ones
+func ValidateAccessTokenScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return apperrors.ErrAccessTokenNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AccessTokenScopes, scope) {
+			return apperrors.ErrAccessTokenScope
+		}
+	}
+	return nil
+}
+
+// GenerateAccessToken creates a new random personal access token and the hash
+// to store it by
+func GenerateAccessToken() (token string, tokenHash string, err error) {
+	random := make([]byte, accessTokenSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	token = AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	return token, HashAccessToken(token), nil
+}
+
+// IsAccessToken reports whether a bearer token is a personal access token
+// rather than a session token
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// HashAccessToken returns the digest of a personal access token to look it up
+// by
+func HashAccessToken(token string) string {
+	return HashSessionSecret(token)
+}
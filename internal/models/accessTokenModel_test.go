@@ -0,0 +1,73 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestAccessTokenModel_GenerateAccessToken tests that tokens are random,
+// carry the prefix and are stored by their hash
+func TestAccessTokenModel_GenerateAccessToken(t *testing.T) {
+	is := is.New(t)
+
+	token, tokenHash, err := models.GenerateAccessToken()
+	is.NoErr(err)
+	is.True(strings.HasPrefix(token, models.AccessTokenPrefix))
+	is.True(models.IsAccessToken(token))
+	is.Equal(tokenHash, models.HashAccessToken(token))
+	is.Equal(len(tokenHash), 64)
+
+	other, _, err := models.GenerateAccessToken()
+	is.NoErr(err)
+	is.True(token != other)
+
+	// Session tokens are `kid.secret.signature`
+	is.True(!models.IsAccessToken("default.c2VjcmV0.c2lnbmF0dXJl"))
+}
+
+func TestAccessTokenModel_NewAccessToken(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("new valid token", func(t *testing.T) {
+		expiresAt := time.Now().Add(24 * time.Hour)
+		token, err := models.NewAccessToken(uuid.New(), models.HashAccessToken("token"), "ci", []string{models.ScopeProfile, models.ScopeExport}, &expiresAt)
+		is.NoErr(err)
+		is.True(token.ID != uuid.Nil)
+		is.Equal(token.Scopes, "profile export")
+		is.Equal(token.ScopeList(), []string{models.ScopeProfile, models.ScopeExport})
+		is.Equal(token.ExpiresAt.Location(), time.UTC)
+
+		info := token.Info()
+		is.Equal(info.ID, token.ID)
+		is.Equal(info.Name, "ci")
+		is.Equal(info.Scopes, token.ScopeList())
+	})
+
+	t.Run("token without expiry", func(t *testing.T) {
+		token, err := models.NewAccessToken(uuid.New(), models.HashAccessToken("token"), "ci", []string{models.ScopeProfile}, nil)
+		is.NoErr(err)
+		is.True(token.ExpiresAt == nil)
+	})
+
+	t.Run("fails when user ID is empty", func(t *testing.T) {
+		_, err := models.NewAccessToken(uuid.Nil, models.HashAccessToken("token"), "ci", []string{models.ScopeProfile}, nil)
+		is.Equal(err, apperrors.ErrUserIdEmpty)
+	})
+
+	t.Run("fails without scopes", func(t *testing.T) {
+		_, err := models.NewAccessToken(uuid.New(), models.HashAccessToken("token"), "ci", nil, nil)
+		is.Equal(err, apperrors.ErrAccessTokenNoScopes)
+	})
+
+	t.Run("fails with an unknown scope", func(t *testing.T) {
+		_, err := models.NewAccessToken(uuid.New(), models.HashAccessToken("token"), "ci", []string{"write:everything"}, nil)
+		is.Equal(err, apperrors.ErrAccessTokenScope)
+	})
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit event types
+const (
+	// AuditAccountDeleted is recorded when an account is permanently deleted
+	// at the end of its grace period
+	AuditAccountDeleted = "account.deleted"
+	// AuditDataExported is recorded when a copy of a user's data is generated
+	AuditDataExported = "data.exported"
+	// AuditAccessTokenCreated and AuditAccessTokenRevoked are recorded when a
+	// user creates or revokes a personal access token
+	AuditAccessTokenCreated = "access_token.created"
+	AuditAccessTokenRevoked = "access_token.revoked"
+	// AuditServiceAccountCreated, AuditServiceAccountDeleted and
+	// AuditServiceAccountSecretRotated are recorded when an admin manages a
+	// service account. The event's user is the admin.
+	AuditServiceAccountCreated       = "service_account.created"
+	AuditServiceAccountDeleted       = "service_account.deleted"
+	AuditServiceAccountSecretRotated = "service_account.secret_rotated"
+	// AuditWebhookCreated and AuditWebhookDeleted are recorded when an admin
+	// manages a webhook subscription. The event's user is the admin.
+	AuditWebhookCreated = "webhook.created"
+	AuditWebhookDeleted = "webhook.deleted"
+)
+
+// AuditEvent represents a security relevant event in the `audit_events`
+// table. UserID has no foreign key so that events outlive the accounts they
+// describe.
+type AuditEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Type      string    `gorm:"type:varchar(64);not null;index"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Details   string    `gorm:"type:text;not null;default:''"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// NewAuditEvent creates a new AuditEvent value of the given type about a user
+func NewAuditEvent(eventType string, userID uuid.UUID, details string) *AuditEvent {
+	return &AuditEvent{
+		Type:      eventType,
+		UserID:    userID,
+		Details:   details,
+		CreatedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Data export statuses
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+// DataExport represents a copy of a user's personal data generated in the
+// background, in the `data_exports` table. It is downloaded with a token
+// whose hash is TokenHash until ExpiresAt, after which it is deleted.
+// RequestedBy is the user, admin or service account who asked for it. A
+// pending export is generated by the job that claimed it until ClaimedUntil.
+type DataExport struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index"`
+	User         *User      `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
+	RequestedBy  uuid.UUID  `gorm:"type:uuid;not null"`
+	Format       string     `gorm:"type:varchar(8);not null"`
+	Status       string     `gorm:"type:varchar(16);not null;index"`
+	TokenHash    string     `gorm:"type:char(64);not null;uniqueIndex"`
+	Data         []byte     `gorm:"type:bytea"`
+	Error        string     `gorm:"type:text;not null;default:''"`
+	CreatedAt    time.Time  `gorm:"type:timestamp;not null;default:now()"`
+	ClaimedUntil *time.Time `gorm:"type:timestamp"`
+	CompletedAt  *time.Time `gorm:"type:timestamp"`
+	ExpiresAt    time.Time  `gorm:"type:timestamp;not null;index"`
+}
+
+// NewDataExport creates a new pending DataExport value for a user, with the
+// hash of its download token and the time the download link expires
+func NewDataExport(userID, requestedBy uuid.UUID, format, tokenHash string, expiresAt time.Time) *DataExport {
+	return &DataExport{
+		UserID:      userID,
+		RequestedBy: requestedBy,
+		Format:      format,
+		Status:      ExportStatusPending,
+		TokenHash:   tokenHash,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   expiresAt.UTC(),
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type UserCredentialsRequest struct {
     Email    string `json:"email" binding:"required"`
     Password string `json:"password" binding:"required"`
@@ -12,3 +14,141 @@ type MessageResponse struct {
 type ErrorResponse struct {
     Error string `json:"error"`
 }
+
+type FieldError struct {
+    Field   string `json:"field" example:"password"`
+    Rule    string `json:"rule" example:"min_length"`
+    Message string `json:"message" example:"Password must be at least 8 characters"`
+}
+
+type PolicyErrorResponse struct {
+    Error  string       `json:"error"`
+    Fields []FieldError `json:"fields,omitempty"`
+}
+
+type SessionsResponse struct {
+    Sessions []SessionInfo `json:"sessions"`
+}
+
+type CSRFTokenResponse struct {
+    CSRFToken string `json:"csrfToken"`
+}
+
+type ForwardAuthErrorResponse struct {
+    Error    string `json:"error"`
+    LoginURL string `json:"loginUrl,omitempty"`
+}
+
+type UpdateUserRequest struct {
+    Email           string `json:"email,omitempty"`
+    Password        string `json:"password,omitempty"`
+    CurrentPassword string `json:"currentPassword,omitempty"`
+}
+
+type ReauthenticateRequest struct {
+    Password string `json:"password"`
+}
+
+type DeletionScheduledResponse struct {
+    Message    string    `json:"message"`
+    PurgeAfter time.Time `json:"purgeAfter"`
+}
+
+type RestoreAccountRequest struct {
+    Token string `json:"token"`
+}
+
+type MagicLinkRequest struct {
+    Email string `json:"email"`
+}
+
+type MagicLinkLoginRequest struct {
+    Token string `json:"token"`
+}
+
+type ExportRequest struct {
+    Format string `json:"format,omitempty" example:"json"`
+}
+
+type ExportRequestResponse struct {
+    ID          string    `json:"id"`
+    Status      string    `json:"status" example:"pending"`
+    ExpiresAt   time.Time `json:"expiresAt"`
+    DownloadURL string    `json:"downloadUrl" example:"/export/download?token=..."`
+}
+
+type IntrospectionResponse struct {
+    Active    bool              `json:"active"`
+    Subject   string            `json:"sub,omitempty" example:"5f0c0a3e-8d2b-4c1e-9a57-2b1f6f0e4c2d"`
+    Email     string            `json:"email,omitempty"`
+    Roles     []string          `json:"roles,omitempty" example:"user"`
+    ExpiresAt int64             `json:"exp,omitempty" example:"1767225600"`
+    IssuedAt  int64             `json:"iat,omitempty" example:"1766620800"`
+    TokenType string            `json:"token_type,omitempty" example:"session"`
+    Claims    map[string]string `json:"claims,omitempty"`
+}
+
+type CreateAccessTokenRequest struct {
+    Name          string   `json:"name" example:"ci"`
+    Scopes        []string `json:"scopes" example:"profile,export"`
+    ExpiresInDays int      `json:"expiresInDays,omitempty" example:"90"`
+}
+
+type AccessTokenResponse struct {
+    Token       string          `json:"token" example:"goauth_pat_..."`
+    AccessToken AccessTokenInfo `json:"accessToken"`
+}
+
+type AccessTokensResponse struct {
+    AccessTokens []AccessTokenInfo `json:"accessTokens"`
+}
+
+type CreateServiceAccountRequest struct {
+    Name      string   `json:"name" example:"billing"`
+    Scopes    []string `json:"scopes" example:"profile"`
+    PublicKey string   `json:"publicKey,omitempty" example:"-----BEGIN PUBLIC KEY-----\n..."`
+}
+
+type ServiceAccountResponse struct {
+    ClientSecret   string             `json:"clientSecret,omitempty" example:"goauth_cs_..."`
+    ServiceAccount ServiceAccountInfo `json:"serviceAccount"`
+}
+
+type ServiceAccountsResponse struct {
+    ServiceAccounts []ServiceAccountInfo `json:"serviceAccounts"`
+}
+
+type TokenResponse struct {
+    AccessToken string `json:"access_token" example:"goauth_sat_..."`
+    TokenType   string `json:"token_type" example:"Bearer"`
+    ExpiresIn   int    `json:"expires_in" example:"900"`
+    Scope       string `json:"scope" example:"profile"`
+}
+
+type OAuthErrorResponse struct {
+    Error            string `json:"error" example:"invalid_client"`
+    ErrorDescription string `json:"error_description,omitempty" example:"Invalid client credentials"`
+}
+
+type CreateWebhookRequest struct {
+    URL         string   `json:"url" example:"https://billing.example.com/hooks/goauth"`
+    Events      []string `json:"events" example:"user.registered"`
+    Description string   `json:"description,omitempty" example:"billing"`
+}
+
+type WebhookResponse struct {
+    Secret  string                  `json:"secret,omitempty" example:"goauth_whsec_..."`
+    Webhook WebhookSubscriptionInfo `json:"webhook"`
+}
+
+type WebhooksResponse struct {
+    Webhooks []WebhookSubscriptionInfo `json:"webhooks"`
+}
+
+type WebhookDeliveryResponse struct {
+    Delivery WebhookDeliveryInfo `json:"delivery"`
+}
+
+type WebhookDeliveriesResponse struct {
+    Deliveries []WebhookDeliveryInfo `json:"deliveries"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// magicLinkTokenBytes is the number of random bytes in a magic link token and
+// in the browser token it is bound to
+const magicLinkTokenBytes = 32
+
+// MagicLinkToken represents an emailed login link in the `magic_link_tokens`
+// table. Neither token is stored: TokenHash is the digest of the token in the
+// link, and BrowserHash the digest of the token in the cookie of the browser
+// that asked for it. A link is used once, by that browser, before ExpiresAt.
+// Requests that sent no link are stored without a UserID, so they count
+// towards the limit of their ClientIP but cannot log in.
+type MagicLinkToken struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID      *uuid.UUID `gorm:"type:uuid;index"`
+	User        *User      `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
+	ClientIP    string     `gorm:"type:varchar(45);not null;default:'';index"`
+	TokenHash   string     `gorm:"type:char(64);not null;uniqueIndex"`
+	BrowserHash string     `gorm:"type:char(64);not null"`
+	ExpiresAt   time.Time  `gorm:"type:timestamp;not null"`
+	UsedAt      *time.Time `gorm:"type:timestamp"`
+	CreatedAt   time.Time  `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// NewMagicLinkToken creates a new MagicLinkToken value for a user from the
+// hashes of the link and browser tokens, the IP address that asked for it and
+// an expiration time
+func NewMagicLinkToken(userID uuid.UUID, tokenHash, browserHash, clientIP string, expiresAt time.Time) (*MagicLinkToken, error) {
+	if userID == uuid.Nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	token, err := NewUnsentMagicLinkToken(tokenHash, browserHash, clientIP, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	token.UserID = &userID
+	return token, nil
+}
+
+// NewUnsentMagicLinkToken creates a new MagicLinkToken value for a request
+// that sent no link, which only counts towards the limit of `clientIP`
+func NewUnsentMagicLinkToken(tokenHash, browserHash, clientIP string, expiresAt time.Time) (*MagicLinkToken, error) {
+	if tokenHash == "" || browserHash == "" {
+		return nil, apperrors.ErrMagicLinkInvalid
+	}
+	if expiresAt.IsZero() {
+		return nil, apperrors.ErrExpiresAtIsEmpty
+	}
+	return &MagicLinkToken{
+		ID:          uuid.New(),
+		ClientIP:    clientIP,
+		TokenHash:   tokenHash,
+		BrowserHash: browserHash,
+		ExpiresAt:   expiresAt.UTC(),
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// GenerateMagicLinkToken creates a random token for a magic link or for the
+// browser it is bound to, and the hash of the token to store
+func GenerateMagicLinkToken() (token string, tokenHash string, err error) {
+	random := make([]byte, magicLinkTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(random)
+	return token, HashMagicLinkToken(token), nil
+}
+
+// ValidMagicLinkToken reports whether `token` has the form of a token made
+// by GenerateMagicLinkToken
+func ValidMagicLinkToken(token string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(decoded) == magicLinkTokenBytes
+}
+
+// HashMagicLinkToken returns the digest of a magic link or browser token
+func HashMagicLinkToken(token string) string {
+	return HashSessionSecret(token)
+}
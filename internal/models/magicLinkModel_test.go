@@ -0,0 +1,84 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestMagicLinkModel_GenerateMagicLinkToken tests that tokens are random and
+// stored by their hash
+func TestMagicLinkModel_GenerateMagicLinkToken(t *testing.T) {
+	is := is.New(t)
+
+	token, tokenHash, err := models.GenerateMagicLinkToken()
+	is.NoErr(err)
+	is.Equal(len(tokenHash), 64)
+	is.Equal(models.HashMagicLinkToken(token), tokenHash)
+
+	other, _, err := models.GenerateMagicLinkToken()
+	is.NoErr(err)
+	is.True(other != token)
+}
+
+// TestMagicLinkModel_ValidMagicLinkToken tests that only generated tokens are
+// valid
+func TestMagicLinkModel_ValidMagicLinkToken(t *testing.T) {
+	is := is.New(t)
+
+	token, _, err := models.GenerateMagicLinkToken()
+	is.NoErr(err)
+	is.True(models.ValidMagicLinkToken(token))
+
+	is.True(!models.ValidMagicLinkToken(""))
+	is.True(!models.ValidMagicLinkToken("browser"))
+	is.True(!models.ValidMagicLinkToken(token[:len(token)-1]))
+	is.True(!models.ValidMagicLinkToken(token + "="))
+}
+
+func TestMagicLinkModel_NewMagicLinkToken(t *testing.T) {
+	is := is.New(t)
+
+	tokenHash := models.HashMagicLinkToken("token")
+	browserHash := models.HashMagicLinkToken("browser")
+	expiresAt := time.Now().Add(time.Minute)
+
+	t.Run("new valid token", func(t *testing.T) {
+		userID := uuid.New()
+		token, err := models.NewMagicLinkToken(userID, tokenHash, browserHash, "203.0.113.7", expiresAt)
+		is.NoErr(err)
+		is.True(token.ID != uuid.Nil)
+		is.Equal(*token.UserID, userID)
+		is.Equal(token.ClientIP, "203.0.113.7")
+		is.Equal(token.TokenHash, tokenHash)
+		is.Equal(token.BrowserHash, browserHash)
+		is.Equal(token.ExpiresAt.Location(), time.UTC)
+		is.True(token.UsedAt == nil)
+	})
+
+	t.Run("new unsent token", func(t *testing.T) {
+		token, err := models.NewUnsentMagicLinkToken(tokenHash, browserHash, "203.0.113.7", expiresAt)
+		is.NoErr(err)
+		is.True(token.UserID == nil)
+		is.Equal(token.ClientIP, "203.0.113.7")
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		_, err := models.NewMagicLinkToken(uuid.Nil, tokenHash, browserHash, "", expiresAt)
+		is.Equal(err, apperrors.ErrUserIdEmpty)
+
+		_, err = models.NewMagicLinkToken(uuid.New(), tokenHash, "", "", expiresAt)
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+
+		_, err = models.NewMagicLinkToken(uuid.New(), tokenHash, browserHash, "", time.Time{})
+		is.Equal(err, apperrors.ErrExpiresAtIsEmpty)
+
+		_, err = models.NewUnsentMagicLinkToken("", browserHash, "", expiresAt)
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+	})
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordHistory represents a previous password hash of a user in the
+// `password_histories` table, kept to prevent password reuse
+type PasswordHistory struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	User      *User     `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
+	Hash      string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()"`
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// Prefixes of the credentials of service accounts, so they can be told apart
+// from other tokens and found by secret scanners
+const (
+	// ClientIDPrefix starts every service account's client ID
+	ClientIDPrefix = "goauth_sa_"
+	// ClientSecretPrefix starts every service account's client secret
+	ClientSecretPrefix = "goauth_cs_"
+	// ServiceAccountTokenPrefix starts every access token issued to a
+	// service account
+	ServiceAccountTokenPrefix = "goauth_sat_"
+)
+
+// serviceAccountSecretBytes is the number of random bytes in a client secret
+// or service account token
+const serviceAccountSecretBytes = 32
+
+// How a service account authenticates to `/oauth/token`
+const (
+	// AuthMethodClientSecret accounts send their client secret with HTTP
+	// Basic or in the form, like `client_secret_basic` and
+	// `client_secret_post`
+	AuthMethodClientSecret = "client_secret"
+	// AuthMethodPrivateKeyJWT accounts send a JWT signed with the private key
+	// of their registered public key, as described in RFC 7523
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+)
+
+// ServiceAccountScopes lists the scopes a service account can be granted.
+// The other scopes act on a user's own account, which service accounts don't
+// have.
+var ServiceAccountScopes = []string{ScopeProfile, ScopeAdmin}
+
+// ServiceAccount represents a machine caller in the `service_accounts` table.
+// It authenticates with its ClientID and either a secret, stored as a SHA-256
+// digest in SecretHash, or a JWT signed by the key matching PublicKey. Scopes
+// is a space separated list. CreatedBy is the admin who created it.
+type ServiceAccount struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	ClientID   string     `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Name       string     `gorm:"type:varchar(100);not null"`
+	AuthMethod string     `gorm:"type:varchar(32);not null"`
+	SecretHash string     `gorm:"type:varchar(64);not null;default:''"`
+	PublicKey  string     `gorm:"type:text;not null;default:''"`
+	Scopes     string     `gorm:"type:text;not null"`
+	CreatedBy  uuid.UUID  `gorm:"type:uuid;not null"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null;default:now()"`
+	LastUsedAt *time.Time `gorm:"type:timestamp"`
+}
+
+// ServiceAccountInfo is the public view of a service account. The client
+// secret is only shown when it is created or rotated.
+type ServiceAccountInfo struct {
+	ID         uuid.UUID  `json:"id"`
+	ClientID   string     `json:"clientId"`
+	Name       string     `json:"name"`
+	AuthMethod string     `json:"authMethod"`
+	Scopes     []string   `json:"scopes"`
+	PublicKey  string     `json:"publicKey,omitempty"`
+	CreatedBy  uuid.UUID  `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// ServiceAccountToken represents a short-lived access token issued to a
+// service account in the `service_account_tokens` table. Like sessions, the
+// token is never stored: TokenHash is a SHA-256 digest of it.
+type ServiceAccountToken struct {
+	ID               uuid.UUID       `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	TokenHash        string          `gorm:"type:char(64);not null;uniqueIndex"`
+	ServiceAccountID uuid.UUID       `gorm:"type:uuid;not null;index"`
+	ServiceAccount   *ServiceAccount `gorm:"foreignKey:ServiceAccountID;references:ID;constraint:OnDelete:CASCADE;"`
+	Scopes           string          `gorm:"type:text;not null"`
+	ExpiresAt        time.Time       `gorm:"type:timestamp;not null;index"`
+	CreatedAt        time.Time       `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// ClientAssertion records the `jti` of a `private_key_jwt` client assertion
+// in the `client_assertions` table until it expires, so each assertion can
+// only be used once
+type ClientAssertion struct {
+	ServiceAccountID uuid.UUID       `gorm:"type:uuid;primaryKey"`
+	ServiceAccount   *ServiceAccount `gorm:"foreignKey:ServiceAccountID;references:ID;constraint:OnDelete:CASCADE;"`
+	JTI              string          `gorm:"type:varchar(255);primaryKey"`
+	ExpiresAt        time.Time       `gorm:"type:timestamp;not null;index"`
+}
+
+// NewServiceAccount creates a new ServiceAccount value with a new client ID.
+// Accounts with a public key authenticate with `private_key_jwt`, and others
+// with the client secret whose hash is given.
+func NewServiceAccount(name string, scopes []string, secretHash, publicKey string, createdBy uuid.UUID) (*ServiceAccount, error) {
+	if err := ValidateServiceAccountScopes(scopes); err != nil {
+		return nil, err
+	}
+	if createdBy == uuid.Nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	authMethod := AuthMethodClientSecret
+	if publicKey != "" {
+		authMethod = AuthMethodPrivateKeyJWT
+		secretHash = ""
+	} else if secretHash == "" {
+		return nil, apperrors.ErrSecretHashIsEmpty
+	}
+	clientID, err := GenerateClientID()
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAccount{
+		ID:         uuid.New(),
+		ClientID:   clientID,
+		Name:       name,
+		AuthMethod: authMethod,
+		SecretHash: secretHash,
+		PublicKey:  publicKey,
+		Scopes:     strings.Join(scopes, " "),
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// ScopeList returns the service account's scopes
+func (sa *ServiceAccount) ScopeList() []string {
+	return strings.Fields(sa.Scopes)
+}
+
+// Info returns the public view of the service account
+func (sa *ServiceAccount) Info() ServiceAccountInfo {
+	return ServiceAccountInfo{
+		ID:         sa.ID,
+		ClientID:   sa.ClientID,
+		Name:       sa.Name,
+		AuthMethod: sa.AuthMethod,
+		Scopes:     sa.ScopeList(),
+		PublicKey:  sa.PublicKey,
+		CreatedBy:  sa.CreatedBy,
+		CreatedAt:  sa.CreatedAt,
+		LastUsedAt: sa.LastUsedAt,
+	}
+}
+
+// NewServiceAccountToken creates a new ServiceAccountToken value for a
+// service account from the hash of the token, its scopes and expiration time
+func NewServiceAccountToken(serviceAccountID uuid.UUID, tokenHash string, scopes []string, expiresAt time.Time) (*ServiceAccountToken, error) {
+	if serviceAccountID == uuid.Nil {
+		return nil, apperrors.ErrServiceAccountIdEmpty
+	}
+	if tokenHash == "" {
+		return nil, apperrors.ErrSessionIdIsEmpty
+	}
+	if expiresAt.IsZero() {
+		return nil, apperrors.ErrExpiresAtIsEmpty
+	}
+	return &ServiceAccountToken{
+		ID:               uuid.New(),
+		TokenHash:        tokenHash,
+		ServiceAccountID: serviceAccountID,
+		Scopes:           strings.Join(scopes, " "),
+		ExpiresAt:        expiresAt.UTC(),
+		CreatedAt:        time.Now().UTC(),
+	}, nil
+}
+
+// ScopeList returns the token's scopes
+func (t *ServiceAccountToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// ValidateServiceAccountScopes checks that a service account is granted at
+// least one scope and only ones it can use
+func ValidateServiceAccountScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return apperrors.ErrServiceAccountNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(ServiceAccountScopes, scope) {
+			return apperrors.ErrServiceAccountScope
+		}
+	}
+	return nil
+}
+
+// GenerateClientID creates a new random client ID
+func GenerateClientID() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return ClientIDPrefix + hex.EncodeToString(random), nil
+}
+
+// GenerateClientSecret creates a new random client secret and the hash to
+// store it by
+func GenerateClientSecret() (secret string, secretHash string, err error) {
+	return generatePrefixedSecret(ClientSecretPrefix)
+}
+
+// GenerateServiceAccountToken creates a new random service account token and
+// the hash to store it by
+func GenerateServiceAccountToken() (token string, tokenHash string, err error) {
+	return generatePrefixedSecret(ServiceAccountTokenPrefix)
+}
+
+// IsServiceAccountToken reports whether a bearer token was issued to a
+// service account
+func IsServiceAccountToken(token string) bool {
+	return strings.HasPrefix(token, ServiceAccountTokenPrefix)
+}
+
+// HashServiceAccountSecret returns the digest of a client secret or service
+// account token to look it up by
+func HashServiceAccountSecret(secret string) string {
+	return HashSessionSecret(secret)
+}
+
+// generatePrefixedSecret creates a random secret starting with `prefix`
+func generatePrefixedSecret(prefix string) (string, string, error) {
+	random := make([]byte, serviceAccountSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret := prefix + base64.RawURLEncoding.EncodeToString(random)
+	return secret, HashServiceAccountSecret(secret), nil
+}
@@ -0,0 +1,99 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestServiceAccountModel_GenerateCredentials tests that client IDs, secrets
+// and tokens are random, carry their prefixes and are stored by their hash
+func TestServiceAccountModel_GenerateCredentials(t *testing.T) {
+	is := is.New(t)
+
+	clientID, err := models.GenerateClientID()
+	is.NoErr(err)
+	is.True(strings.HasPrefix(clientID, models.ClientIDPrefix))
+
+	secret, secretHash, err := models.GenerateClientSecret()
+	is.NoErr(err)
+	is.True(strings.HasPrefix(secret, models.ClientSecretPrefix))
+	is.Equal(secretHash, models.HashServiceAccountSecret(secret))
+
+	token, tokenHash, err := models.GenerateServiceAccountToken()
+	is.NoErr(err)
+	is.True(models.IsServiceAccountToken(token))
+	is.True(!models.IsAccessToken(token))
+	is.Equal(len(tokenHash), 64)
+
+	other, _, err := models.GenerateServiceAccountToken()
+	is.NoErr(err)
+	is.True(token != other)
+
+	// Neither personal access tokens nor client secrets are service account tokens
+	pat, _, err := models.GenerateAccessToken()
+	is.NoErr(err)
+	is.True(!models.IsServiceAccountToken(pat))
+	is.True(!models.IsServiceAccountToken(secret))
+}
+
+func TestServiceAccountModel_NewServiceAccount(t *testing.T) {
+	is := is.New(t)
+	admin := uuid.New()
+
+	t.Run("account with a secret", func(t *testing.T) {
+		account, err := models.NewServiceAccount("billing", []string{models.ScopeProfile}, models.HashServiceAccountSecret("secret"), "", admin)
+		is.NoErr(err)
+		is.True(account.ID != uuid.Nil)
+		is.True(strings.HasPrefix(account.ClientID, models.ClientIDPrefix))
+		is.Equal(account.AuthMethod, models.AuthMethodClientSecret)
+		is.Equal(account.ScopeList(), []string{models.ScopeProfile})
+
+		info := account.Info()
+		is.Equal(info.ClientID, account.ClientID)
+		is.Equal(info.CreatedBy, admin)
+		is.Equal(info.PublicKey, "")
+	})
+
+	t.Run("account with a public key has no secret", func(t *testing.T) {
+		account, err := models.NewServiceAccount("billing", []string{models.ScopeAdmin}, models.HashServiceAccountSecret("secret"), "-----BEGIN PUBLIC KEY-----", admin)
+		is.NoErr(err)
+		is.Equal(account.AuthMethod, models.AuthMethodPrivateKeyJWT)
+		is.Equal(account.SecretHash, "")
+	})
+
+	t.Run("fails without a secret or public key", func(t *testing.T) {
+		_, err := models.NewServiceAccount("billing", []string{models.ScopeProfile}, "", "", admin)
+		is.Equal(err, apperrors.ErrSecretHashIsEmpty)
+	})
+
+	t.Run("fails with user scopes", func(t *testing.T) {
+		_, err := models.NewServiceAccount("billing", []string{models.ScopeSessions}, models.HashServiceAccountSecret("secret"), "", admin)
+		is.Equal(err, apperrors.ErrServiceAccountScope)
+
+		_, err = models.NewServiceAccount("billing", nil, models.HashServiceAccountSecret("secret"), "", admin)
+		is.Equal(err, apperrors.ErrServiceAccountNoScopes)
+	})
+}
+
+func TestServiceAccountModel_NewServiceAccountToken(t *testing.T) {
+	is := is.New(t)
+
+	expiresAt := time.Now().Add(15 * time.Minute)
+	token, err := models.NewServiceAccountToken(uuid.New(), models.HashServiceAccountSecret("token"), []string{models.ScopeProfile, models.ScopeAdmin}, expiresAt)
+	is.NoErr(err)
+	is.Equal(token.Scopes, "profile admin")
+	is.Equal(token.ExpiresAt.Location(), time.UTC)
+
+	_, err = models.NewServiceAccountToken(uuid.Nil, models.HashServiceAccountSecret("token"), nil, expiresAt)
+	is.Equal(err, apperrors.ErrServiceAccountIdEmpty)
+
+	_, err = models.NewServiceAccountToken(uuid.New(), models.HashServiceAccountSecret("token"), nil, time.Time{})
+	is.Equal(err, apperrors.ErrExpiresAtIsEmpty)
+}
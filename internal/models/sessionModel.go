@@ -1,64 +1,116 @@
 package models
 
 import (
-	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"os"
+	"encoding/hex"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/al-ce/goauth/internal/keyring"
 	"github.com/al-ce/goauth/pkg/apperrors"
-	"github.com/al-ce/goauth/pkg/config"
 )
 
-// Session represents a session in the `sessions` table
+// sessionSecretBytes is the number of random bytes in a session token's secret
+const sessionSecretBytes = 32
+
+// Session represents a session in the `sessions` table. The session token is
+// never stored: TokenHash is a SHA-256 digest of its secret, so reading the
+// table is not enough to hijack a session. ID is a public identifier used to
+// list and revoke sessions. AuthTime is when the user last proved who they
+// are in this session, by logging in or reauthenticating. Claims are added by
+// the login hooks and returned by introspection.
 type Session struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
-	User      *User     `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
-	ExpiresAt time.Time `gorm:"type:timestamp;not null"`
-	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()"`
+	ID        uuid.UUID         `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	TokenHash string            `gorm:"type:char(64);not null;uniqueIndex"`
+	UserID    uuid.UUID         `gorm:"type:uuid;not null;index"`
+	User      *User             `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;"`
+	ExpiresAt time.Time         `gorm:"type:timestamp;not null"`
+	CreatedAt time.Time         `gorm:"type:timestamp;not null;default:now()"`
+	AuthTime  time.Time         `gorm:"type:timestamp;not null;default:now()"`
+	Claims    map[string]string `gorm:"type:text;serializer:json"`
+}
+
+// SessionInfo is the public view of a session, for listing a user's sessions
+type SessionInfo struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current"`
 }
 
-// NewSession creates a new Session value from a user id, a session id, and an expiration time
-func NewSession(userID uuid.UUID, sessionID uuid.UUID, expiresAt time.Time) (*Session, error) {
+// NewSession creates a new Session value with a new public ID from a user id,
+// the hash of the session token's secret, and an expiration time. The user is
+// taken to have just authenticated.
+func NewSession(userID uuid.UUID, tokenHash string, expiresAt time.Time) (*Session, error) {
 	if userID == uuid.Nil {
 		return nil, apperrors.ErrUserIdEmpty
 	}
-	if sessionID == uuid.Nil {
+	if tokenHash == "" {
 		return nil, apperrors.ErrSessionIdIsEmpty
 	}
 	if expiresAt.IsZero() {
 		return nil, apperrors.ErrExpiresAtIsEmpty
 	}
 
+	now := time.Now().UTC()
 	return &Session{
+		ID:        uuid.New(),
+		TokenHash: tokenHash,
 		UserID:    userID,
-		ID:        sessionID,
 		ExpiresAt: expiresAt.UTC(),
-		CreatedAt: time.Now().UTC(),
+		CreatedAt: now,
+		AuthTime:  now,
 	}, nil
 }
 
-// GenerateSessionID creates a new random session ID
-func GenerateSessionID() (uuid.UUID, string, error) {
-	sessionID := uuid.New()
-	signature := createHMAC(sessionID.String())
-	return sessionID, signature, nil
+// GenerateSessionToken creates a new random session token of the form
+// `kid.secret.signature`, signed with the active session key, and the hash of
+// the secret to store in the session
+func GenerateSessionToken() (token string, tokenHash string, err error) {
+	random := make([]byte, sessionSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(random)
+	token, err = keyring.Default().Sign(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashSessionSecret(secret), nil
+}
+
+// ParseSessionToken verifies a session token's format and signature and
+// returns the hash of its secret, to look the session up by
+func ParseSessionToken(token string) (string, error) {
+	secret, _, err := keyring.Default().Verify(token)
+	if err != nil {
+		return "", err
+	}
+	return HashSessionSecret(secret), nil
 }
 
-// ValidateSessionID verifies that a session ID matches its signature
-func ValidateSessionID(sessionID uuid.UUID, signature string) bool {
-	expectedSignature := createHMAC(sessionID.String())
-	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+// ResignSessionToken returns the token re-signed with the active session key
+// if it was signed with another key, so retired keys can be phased out
+// without ending sessions. The secret, and so the stored session, is unchanged.
+func ResignSessionToken(token string) (string, bool) {
+	ring := keyring.Default()
+	secret, keyID, err := ring.Verify(token)
+	if err != nil || keyID == ring.Active {
+		return "", false
+	}
+	resigned, err := ring.Sign(secret)
+	if err != nil {
+		return "", false
+	}
+	return resigned, true
 }
 
-// createHMAC generates an HMAC signature for a session ID
-// Ref: https://www.okta.com/identity-101/hmac/
-func createHMAC(sessionID string) string {
-	h := hmac.New(sha256.New, []byte(os.Getenv(config.SessionKey)))
-	h.Write([]byte(sessionID))
-	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+// HashSessionSecret returns the hex encoded SHA-256 digest of a session secret.
+// The secret is random and high-entropy, so a fast unsalted hash is enough.
+func HashSessionSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
@@ -1,12 +1,14 @@
 package models_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/matryer/is"
 
+	"github.com/al-ce/goauth/internal/keyring"
 	"github.com/al-ce/goauth/internal/models"
 	"github.com/al-ce/goauth/internal/testutils"
 	"github.com/al-ce/goauth/pkg/apperrors"
@@ -19,26 +21,105 @@ func TestSessionModel_NewSession(t *testing.T) {
 
 	// Valid uuid and non-empty session id should return non-nil Session value, nil error
 	t.Run("new valid session", func(t *testing.T) {
-		session, err := models.NewSession(uuid.New(), uuid.New(), time.Now().UTC().Add(24*time.Hour))
+		session, err := models.NewSession(uuid.New(), models.HashSessionSecret("secret"), time.Now().UTC().Add(24*time.Hour))
 		is.True(session != nil)
 		is.NoErr(err)
+		is.True(session.ID != uuid.Nil)
+		// A new session has just been authenticated
+		is.Equal(session.AuthTime, session.CreatedAt)
 	})
 
 	t.Run("fails when user ID is empty", func(t *testing.T) {
-		_, err := models.NewSession(uuid.Nil, uuid.New(), time.Now().UTC().Add(24*time.Hour))
+		_, err := models.NewSession(uuid.Nil, models.HashSessionSecret("secret"), time.Now().UTC().Add(24*time.Hour))
 		is.Equal(err, apperrors.ErrUserIdEmpty)
 	})
 
 	t.Run("fails when session is empty", func(t *testing.T) {
-		_, err := models.NewSession(uuid.New(), uuid.Nil, time.Now().UTC().Add(24*time.Hour))
+		_, err := models.NewSession(uuid.New(), "", time.Now().UTC().Add(24*time.Hour))
 		is.Equal(err, apperrors.ErrSessionIdIsEmpty)
 	})
 	t.Run("fails when expiration time is empty", func(t *testing.T) {
-		_, err := models.NewSession(uuid.New(), uuid.New(), time.Time{})
+		_, err := models.NewSession(uuid.New(), models.HashSessionSecret("secret"), time.Time{})
 		is.Equal(err, apperrors.ErrExpiresAtIsEmpty)
 	})
 }
 
+// TestSessionModel_SessionToken tests that generated session tokens parse to
+// the stored hash and that tampered tokens are rejected
+func TestSessionModel_SessionToken(t *testing.T) {
+	is := is.New(t)
+
+	token, tokenHash, err := models.GenerateSessionToken()
+	is.NoErr(err)
+	secret, _, _ := strings.Cut(token, ".")
+	is.True(!strings.Contains(tokenHash, secret))
+
+	t.Run("parses to the stored hash", func(t *testing.T) {
+		parsedHash, err := models.ParseSessionToken(token)
+		is.NoErr(err)
+		is.Equal(parsedHash, tokenHash)
+		is.Equal(len(parsedHash), 64)
+	})
+
+	t.Run("tokens are unique", func(t *testing.T) {
+		other, otherHash, err := models.GenerateSessionToken()
+		is.NoErr(err)
+		is.True(other != token)
+		is.True(otherHash != tokenHash)
+	})
+
+	invalidFormat := map[string]string{
+		"empty":        "",
+		"no signature": secret,
+		"empty secret": "." + secret,
+		"extra part":   token + ".extra",
+	}
+	for name, value := range invalidFormat {
+		t.Run(name, func(t *testing.T) {
+			_, err := models.ParseSessionToken(value)
+			is.Equal(err, apperrors.ErrInvalidTokenFormat)
+		})
+	}
+
+	t.Run("tampered secret", func(t *testing.T) {
+		_, signature, _ := strings.Cut(token, ".")
+		_, err := models.ParseSessionToken("x" + secret + "." + signature)
+		is.Equal(err, apperrors.ErrInvalidTokenSignature)
+	})
+}
+
+// TestSessionModel_ResignSessionToken tests that tokens signed with a retired
+// key are re-signed with the active key without changing the session
+func TestSessionModel_ResignSessionToken(t *testing.T) {
+	is := is.New(t)
+
+	ring := &keyring.Keyring{}
+	_, err := ring.Generate("old")
+	is.NoErr(err)
+	is.NoErr(ring.Promote("old"))
+	keyring.SetDefault(ring)
+	t.Cleanup(func() { keyring.SetDefault(nil) })
+
+	token, tokenHash, err := models.GenerateSessionToken()
+	is.NoErr(err)
+	_, ok := models.ResignSessionToken(token)
+	is.True(!ok) // already signed with the active key
+
+	_, err = ring.Generate("new")
+	is.NoErr(err)
+	is.NoErr(ring.Promote("new"))
+
+	resigned, ok := models.ResignSessionToken(token)
+	is.True(ok)
+	is.True(strings.HasPrefix(resigned, "new."))
+	resignedHash, err := models.ParseSessionToken(resigned)
+	is.NoErr(err)
+	is.Equal(resignedHash, tokenHash)
+
+	_, ok = models.ResignSessionToken("invalid")
+	is.True(!ok)
+}
+
 // TestSessionModel_CascadeToSessions tests that deleting a user in the
 // database scrubs any associated sessions by OnDelete-Cascade
 func TestSessionModel_CascadeToSessions(t *testing.T) {
@@ -61,7 +142,7 @@ func TestSessionModel_CascadeToSessions(t *testing.T) {
 		for range 3 {
 			session, err := models.NewSession(
 				testUser.ID,
-				uuid.New(),
+				models.HashSessionSecret(uuid.NewString()),
 				time.Now().UTC().Add(1*time.Hour),
 			)
 			is.NoErr(err)
@@ -1,49 +1,141 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"net/mail"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	passwordvalidator "github.com/wagslane/go-password-validator"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
 
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/pkg/apperrors"
 	"github.com/al-ce/goauth/pkg/config"
 )
 
-// User represents a user in the `users` table.
+// Roles a user can hold
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// restoreTokenBytes is the number of random bytes in an account restore token
+const restoreTokenBytes = 32
+
+// User represents a user in the `users` table. An account scheduled for
+// deletion keeps its row until PurgeAfter, and can be restored until then with
+// the token whose hash is RestoreTokenHash. Password is empty for accounts
+// without a password.
 type User struct {
 	ID                  uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
 	Email               string     `gorm:"type:varchar(255);not null;unique"`
+	EmailCanonical      string     `gorm:"type:varchar(255);not null;uniqueIndex"`
 	Password            string     `gorm:"type:text;not null"`
 	LastLogin           *time.Time `gorm:"type:timestamp"`
 	FailedLoginAttempts int        `gorm:"type:integer;default:0"`
 	AccountLocked       bool       `gorm:"type:boolean;default:false"`
 	AccountLockedUntil  *time.Time `gorm:"type:timestamp"`
+	Role                string     `gorm:"type:varchar(32);not null;default:'user'"`
+	PasswordBreached    bool       `gorm:"type:boolean;not null;default:false"`
+	DeletionRequestedAt *time.Time `gorm:"type:timestamp"`
+	PurgeAfter          *time.Time `gorm:"type:timestamp;index"`
+	RestoreTokenHash    *string    `gorm:"type:char(64);uniqueIndex"`
 }
 
 // NewUser creates a new User value from an email and password.
 func NewUser(email string, password string) (*User, error) {
-	// Hash password
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Validate email
+	email = strings.TrimSpace(email)
+	if err := ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	// Enforce the password policy
+	if err := passwords.ActivePolicy().Check(password, email); err != nil {
+		return nil, err
+	}
+
+	// Hash password with the configured algorithm
+	hash, err := passwords.Default().Hash(password)
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate email
-	if _, err := mail.ParseAddress(email); err != nil {
-		return nil, apperrors.ErrEmailFormat
+	return &User{Email: email, EmailCanonical: CanonicalEmail(email), Password: hash}, nil
+}
+
+// NewPasswordlessUser creates a new User value from an email, without a
+// password. The user logs in with magic links.
+func NewPasswordlessUser(email string) (*User, error) {
+	email = strings.TrimSpace(email)
+	if err := ValidateEmail(email); err != nil {
+		return nil, err
 	}
+	return &User{Email: email, EmailCanonical: CanonicalEmail(email)}, nil
+}
 
-	if len(email) > 254 {
-		return nil, apperrors.ErrEmailMaxLength
+// HasPassword reports whether the user has a password to log in with
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// BeforeSave keeps the canonical email in sync when a User value is created or saved
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	if u.Email != "" {
+		u.EmailCanonical = CanonicalEmail(u.Email)
+	}
+	return nil
+}
+
+// PendingDeletion reports whether the account is scheduled for deletion
+func (u *User) PendingDeletion() bool {
+	return u.DeletionRequestedAt != nil
+}
+
+// GenerateRestoreToken creates a random token to restore an account scheduled
+// for deletion, and the hash of the token to store with the user
+func GenerateRestoreToken() (token string, tokenHash string, err error) {
+	random := make([]byte, restoreTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
 	}
+	token = base64.RawURLEncoding.EncodeToString(random)
+	return token, HashRestoreToken(token), nil
+}
 
-	// Enforce minimum password complexity
-	if err = passwordvalidator.Validate(password, config.MinEntropyBits); err != nil {
-		return nil, apperrors.ErrPasswordComplexity
+// HashRestoreToken returns the digest of a restore token to look the user up
+// by. Like session secrets, restore tokens are random enough for SHA-256.
+func HashRestoreToken(token string) string {
+	return HashSessionSecret(token)
+}
+
+// CanonicalEmail returns the form of an email used to identify a user:
+// trimmed, Unicode NFC normalized, with a lowercased domain and, unless
+// `EMAIL_LOWERCASE_LOCAL_PART` is `false`, a lowercased local part
+func CanonicalEmail(email string) string {
+	email = norm.NFC.String(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
 	}
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if os.Getenv(config.EmailLowercaseLocalPart) != "false" {
+		local = strings.ToLower(local)
+	}
+	return local + "@" + domain
+}
 
-	return &User{Email: email, Password: string(hash)}, err
+// ValidateEmail checks that an email is well-formed and within the RFC3696 length limit
+func ValidateEmail(email string) error {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return apperrors.ErrEmailFormat
+	}
+	if len(email) > 254 {
+		return apperrors.ErrEmailMaxLength
+	}
+	return nil
 }
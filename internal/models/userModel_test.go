@@ -4,11 +4,12 @@ import (
 	"testing"
 
 	"github.com/matryer/is"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/testutils"
 	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
 )
 
 // TestNewUser tests new user creation in the `models` package.
@@ -24,8 +25,9 @@ func TestNewUser(t *testing.T) {
 
 		// User value holds passed email and password
 		is.Equal(user.Email, validEmail)
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(testutils.TestingPassword))
+		match, _, err := passwords.Default().Verify(testutils.TestingPassword, user.Password)
 		is.NoErr(err)
+		is.True(match)
 
 	})
 
@@ -70,6 +72,8 @@ func TestNewUser(t *testing.T) {
 		"tooShort":    "short",
 		"sequential":  "12345678",
 		"repeating":   "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"common":      "qwertyuiopasdfghjklzxcvbnm",
+		"hasEmail":    "test" + testutils.TestingPassword,
 	}
 
 	for name, password := range invalidPasswords {
@@ -79,3 +83,68 @@ func TestNewUser(t *testing.T) {
 		})
 	}
 }
+
+// TestNewPasswordlessUser tests users created without a password have none
+func TestNewPasswordlessUser(t *testing.T) {
+	is := is.New(t)
+
+	user, err := models.NewPasswordlessUser(" Bob@Example.com ")
+	is.NoErr(err)
+	is.Equal(user.Email, "Bob@Example.com")
+	is.Equal(user.EmailCanonical, "bob@example.com")
+	is.Equal(user.Password, "")
+	is.True(!user.HasPassword())
+
+	_, err = models.NewPasswordlessUser("not an email")
+	is.Equal(err, apperrors.ErrEmailFormat)
+
+	user, err = models.NewUser("bob@example.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.True(user.HasPassword())
+}
+
+// TestCanonicalEmail tests the canonical form used to identify users
+func TestCanonicalEmail(t *testing.T) {
+	is := is.New(t)
+
+	cases := map[string][2]string{
+		"lowercases":       {"Bob@Example.COM", "bob@example.com"},
+		"trims":            {"  bob@example.com\t", "bob@example.com"},
+		"nfc":              {"Jose\u0301@example.com", "jos\u00e9@example.com"},
+		"lastAt":           {`"a@b"@Example.com`, `"a@b"@example.com`},
+		"unicodeDomain":    {"bob@BÜCHER.de", "bob@bücher.de"},
+		"alreadyCanonical": {"bob@example.com", "bob@example.com"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			is.Equal(models.CanonicalEmail(c[0]), c[1])
+		})
+	}
+
+	t.Run("case-sensitive local part", func(t *testing.T) {
+		t.Setenv(config.EmailLowercaseLocalPart, "false")
+		is.Equal(models.CanonicalEmail("Bob@Example.COM"), "Bob@example.com")
+	})
+
+	t.Run("new user keeps display form", func(t *testing.T) {
+		user, err := models.NewUser(" Bob@Example.com ", testutils.TestingPassword)
+		is.NoErr(err)
+		is.Equal(user.Email, "Bob@Example.com")
+		is.Equal(user.EmailCanonical, "bob@example.com")
+	})
+}
+
+// TestGenerateRestoreToken tests that restore tokens are random and looked up
+// by their hash
+func TestGenerateRestoreToken(t *testing.T) {
+	is := is.New(t)
+
+	token, tokenHash, err := models.GenerateRestoreToken()
+	is.NoErr(err)
+	is.Equal(len(tokenHash), 64)
+	is.Equal(models.HashRestoreToken(token), tokenHash)
+
+	other, _, err := models.GenerateRestoreToken()
+	is.NoErr(err)
+	is.True(other != token)
+}
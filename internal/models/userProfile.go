@@ -5,4 +5,7 @@ import "time"
 type UserProfile struct {
 	Email     string     `gorm:"type:varchar(255);not null;unique"`
 	LastLogin *time.Time `gorm:"type:timestamp"`
+	// PasswordBreached is set at login when the password is found in the
+	// breached password dataset
+	PasswordBreached bool
 }
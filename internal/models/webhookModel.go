@@ -0,0 +1,266 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// Webhook event types. Each is sent to the subscriptions that list it.
+const (
+	// WebhookUserRegistered is sent when a user registers
+	WebhookUserRegistered = "user.registered"
+	// WebhookUserEmailChanged is sent when a user changes their email
+	WebhookUserEmailChanged = "user.email_changed"
+	// WebhookUserDeletionScheduled is sent when a user asks for their account
+	// to be deleted, at the start of the grace period
+	WebhookUserDeletionScheduled = "user.deletion_scheduled"
+	// WebhookUserRestored is sent when a user cancels the deletion of their
+	// account during the grace period
+	WebhookUserRestored = "user.restored"
+	// WebhookUserDeleted is sent when an account is permanently deleted
+	WebhookUserDeleted = "user.deleted"
+)
+
+// WebhookEventTypes lists the event types a subscription can list
+var WebhookEventTypes = []string{
+	WebhookUserRegistered,
+	WebhookUserEmailChanged,
+	WebhookUserDeletionScheduled,
+	WebhookUserRestored,
+	WebhookUserDeleted,
+}
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// WebhookDeliveryDead deliveries failed every attempt and are only sent
+	// again if an admin redelivers them
+	WebhookDeliveryDead = "dead"
+)
+
+// WebhookSecretPrefix starts every webhook signing secret
+const WebhookSecretPrefix = "goauth_whsec_"
+
+// WebhookSubscription represents an endpoint that receives webhook events in
+// the `webhook_subscriptions` table. Events is a space separated list of
+// event types. Secret signs the payloads, so unlike other credentials it is
+// stored as is. CreatedBy is the admin who created it.
+type WebhookSubscription struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	URL         string    `gorm:"type:varchar(2048);not null"`
+	Events      string    `gorm:"type:text;not null"`
+	Secret      string    `gorm:"type:varchar(128);not null"`
+	Description string    `gorm:"type:varchar(100);not null;default:''"`
+	CreatedBy   uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt   time.Time `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// WebhookSubscriptionInfo is the public view of a webhook subscription. The
+// secret is only shown when it is created.
+type WebhookSubscriptionInfo struct {
+	ID          uuid.UUID `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	CreatedBy   uuid.UUID `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// WebhookEvent represents an event in the `webhook_events` table, the
+// outbox that webhook deliveries are sent from. It is written in the same
+// transaction as the change it describes. Payload is the JSON request body.
+type WebhookEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Type      string    `gorm:"type:varchar(64);not null;index"`
+	Payload   string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// WebhookDelivery represents the delivery of an event to a subscription in
+// the `webhook_deliveries` table. Pending deliveries are sent once
+// NextAttemptAt has passed, and retried with backoff until they are
+// delivered or dead.
+type WebhookDelivery struct {
+	ID             uuid.UUID            `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	EventID        uuid.UUID            `gorm:"type:uuid;not null;index"`
+	Event          *WebhookEvent        `gorm:"foreignKey:EventID;references:ID;constraint:OnDelete:CASCADE;"`
+	SubscriptionID uuid.UUID            `gorm:"type:uuid;not null;index"`
+	Subscription   *WebhookSubscription `gorm:"foreignKey:SubscriptionID;references:ID;constraint:OnDelete:CASCADE;"`
+	Status         string               `gorm:"type:varchar(16);not null;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts       int                  `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time            `gorm:"type:timestamp;not null;index:idx_webhook_deliveries_due,priority:2"`
+	LastAttemptAt  *time.Time           `gorm:"type:timestamp"`
+	LastStatusCode int                  `gorm:"not null;default:0"`
+	LastError      string               `gorm:"type:text;not null;default:''"`
+	DeliveredAt    *time.Time           `gorm:"type:timestamp"`
+	CreatedAt      time.Time            `gorm:"type:timestamp;not null;default:now()"`
+}
+
+// WebhookDeliveryInfo is the public view of a webhook delivery
+type WebhookDeliveryInfo struct {
+	ID             uuid.UUID  `json:"id"`
+	EventID        uuid.UUID  `json:"eventId"`
+	EventType      string     `json:"eventType,omitempty"`
+	SubscriptionID uuid.UUID  `json:"subscriptionId"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// WebhookPayload is the JSON body of a webhook request
+type WebhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// WebhookUserData is the data of the user events. PreviousEmail is only set
+// for `user.email_changed`, and PurgeAfter for `user.deletion_scheduled`.
+type WebhookUserData struct {
+	UserID        uuid.UUID  `json:"userId"`
+	Email         string     `json:"email"`
+	PreviousEmail string     `json:"previousEmail,omitempty"`
+	PurgeAfter    *time.Time `json:"purgeAfter,omitempty"`
+}
+
+// NewWebhookSubscription creates a new WebhookSubscription value for the
+// given endpoint, event types and signing secret
+func NewWebhookSubscription(url string, events []string, secret, description string, createdBy uuid.UUID) (*WebhookSubscription, error) {
+	if err := ValidateWebhookEvents(events); err != nil {
+		return nil, err
+	}
+	if createdBy == uuid.Nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	if secret == "" {
+		return nil, apperrors.ErrWebhookSecretIsEmpty
+	}
+	return &WebhookSubscription{
+		ID:          uuid.New(),
+		URL:         url,
+		Events:      strings.Join(slices.Compact(slices.Sorted(slices.Values(events))), " "),
+		Secret:      secret,
+		Description: description,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// EventList returns the event types the subscription receives
+func (ws *WebhookSubscription) EventList() []string {
+	return strings.Fields(ws.Events)
+}
+
+// Receives reports whether the subscription receives events of a type
+func (ws *WebhookSubscription) Receives(eventType string) bool {
+	return slices.Contains(ws.EventList(), eventType)
+}
+
+// Info returns the public view of the subscription
+func (ws *WebhookSubscription) Info() WebhookSubscriptionInfo {
+	return WebhookSubscriptionInfo{
+		ID:          ws.ID,
+		URL:         ws.URL,
+		Events:      ws.EventList(),
+		Description: ws.Description,
+		CreatedBy:   ws.CreatedBy,
+		CreatedAt:   ws.CreatedAt,
+	}
+}
+
+// NewWebhookEvent creates a new WebhookEvent value of a type, with a payload
+// wrapping `data`
+func NewWebhookEvent(eventType string, data any) (*WebhookEvent, error) {
+	if !slices.Contains(WebhookEventTypes, eventType) {
+		return nil, apperrors.ErrWebhookEvent
+	}
+	event := &WebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        event.ID,
+		Type:      eventType,
+		CreatedAt: event.CreatedAt,
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = string(payload)
+	return event, nil
+}
+
+// NewWebhookDelivery creates a new pending WebhookDelivery value of an event
+// to a subscription, due right away
+func NewWebhookDelivery(eventID, subscriptionID uuid.UUID) *WebhookDelivery {
+	now := time.Now().UTC()
+	return &WebhookDelivery{
+		ID:             uuid.New(),
+		EventID:        eventID,
+		SubscriptionID: subscriptionID,
+		Status:         WebhookDeliveryPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+}
+
+// Info returns the public view of the delivery
+func (wd *WebhookDelivery) Info() WebhookDeliveryInfo {
+	info := WebhookDeliveryInfo{
+		ID:             wd.ID,
+		EventID:        wd.EventID,
+		SubscriptionID: wd.SubscriptionID,
+		Status:         wd.Status,
+		Attempts:       wd.Attempts,
+		NextAttemptAt:  wd.NextAttemptAt,
+		LastAttemptAt:  wd.LastAttemptAt,
+		LastStatusCode: wd.LastStatusCode,
+		LastError:      wd.LastError,
+		DeliveredAt:    wd.DeliveredAt,
+		CreatedAt:      wd.CreatedAt,
+	}
+	if wd.Event != nil {
+		info.EventType = wd.Event.Type
+	}
+	return info
+}
+
+// ValidateWebhookEvents checks that a subscription lists at least one event
+// type and only known ones
+func ValidateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return apperrors.ErrWebhookNoEvents
+	}
+	for _, event := range events {
+		if !slices.Contains(WebhookEventTypes, event) {
+			return apperrors.ErrWebhookEvent
+		}
+	}
+	return nil
+}
+
+// GenerateWebhookSecret creates a new random webhook signing secret
+func GenerateWebhookSecret() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return WebhookSecretPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,80 @@
+package models_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestWebhookModel_NewWebhookSubscription(t *testing.T) {
+	is := is.New(t)
+	admin := uuid.New()
+
+	secret, err := models.GenerateWebhookSecret()
+	is.NoErr(err)
+	is.True(strings.HasPrefix(secret, models.WebhookSecretPrefix))
+
+	t.Run("sorts and deduplicates event types", func(t *testing.T) {
+		events := []string{models.WebhookUserDeleted, models.WebhookUserRegistered, models.WebhookUserDeleted}
+		subscription, err := models.NewWebhookSubscription("https://example.com/hooks", events, secret, "billing", admin)
+		is.NoErr(err)
+		is.Equal(subscription.EventList(), []string{models.WebhookUserDeleted, models.WebhookUserRegistered})
+		is.True(subscription.Receives(models.WebhookUserRegistered))
+		is.True(!subscription.Receives(models.WebhookUserEmailChanged))
+
+		info := subscription.Info()
+		is.Equal(info.URL, "https://example.com/hooks")
+		is.Equal(info.CreatedBy, admin)
+	})
+
+	t.Run("fails with unknown or no event types", func(t *testing.T) {
+		_, err := models.NewWebhookSubscription("https://example.com/hooks", []string{"user.verified"}, secret, "", admin)
+		is.Equal(err, apperrors.ErrWebhookEvent)
+		_, err = models.NewWebhookSubscription("https://example.com/hooks", nil, secret, "", admin)
+		is.Equal(err, apperrors.ErrWebhookNoEvents)
+	})
+
+	t.Run("fails without a secret", func(t *testing.T) {
+		_, err := models.NewWebhookSubscription("https://example.com/hooks", []string{models.WebhookUserDeleted}, "", "", admin)
+		is.Equal(err, apperrors.ErrWebhookSecretIsEmpty)
+	})
+}
+
+func TestWebhookModel_NewWebhookEvent(t *testing.T) {
+	is := is.New(t)
+	userID := uuid.New()
+
+	event, err := models.NewWebhookEvent(models.WebhookUserEmailChanged, models.WebhookUserData{
+		UserID:        userID,
+		Email:         "new@example.com",
+		PreviousEmail: "old@example.com",
+	})
+	is.NoErr(err)
+
+	var payload struct {
+		ID   uuid.UUID              `json:"id"`
+		Type string                 `json:"type"`
+		Data models.WebhookUserData `json:"data"`
+	}
+	is.NoErr(json.Unmarshal([]byte(event.Payload), &payload))
+	is.Equal(payload.ID, event.ID)
+	is.Equal(payload.Type, models.WebhookUserEmailChanged)
+	is.Equal(payload.Data.UserID, userID)
+	is.Equal(payload.Data.PreviousEmail, "old@example.com")
+
+	// Fields of other events are left out
+	is.True(!strings.Contains(event.Payload, "purgeAfter"))
+
+	_, err = models.NewWebhookEvent("user.verified", nil)
+	is.Equal(err, apperrors.ErrWebhookEvent)
+
+	delivery := models.NewWebhookDelivery(event.ID, uuid.New())
+	is.Equal(delivery.Status, models.WebhookDeliveryPending)
+	is.True(!delivery.NextAttemptAt.IsZero())
+}
@@ -0,0 +1,179 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// argon2idPrefix starts every PHC string produced by Argon2idHasher
+const argon2idPrefix = "$argon2id$"
+
+// Upper bounds on the work an imported or configured argon2id hash can make
+// us do on every login
+const (
+	argon2MaxMemory      = 1 << 20 // KiB, 1 GiB
+	argon2MaxIterations  = 10
+	argon2MaxParallelism = 16
+)
+
+// Argon2idParams are the cost parameters for argon2id
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams returns the argon2id parameters used when none are configured
+func DefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Memory:      config.DefaultArgon2MemoryKiB,
+		Iterations:  config.DefaultArgon2Iterations,
+		Parallelism: config.DefaultArgon2Parallelism,
+		SaltLength:  config.Argon2SaltLength,
+		KeyLength:   config.Argon2KeyLength,
+	}
+}
+
+// validate rejects parameters argon2 cannot run with, that are too weak to be
+// useful, or that are too costly to verify
+func (p Argon2idParams) validate() error {
+	switch {
+	case p.Iterations < 1:
+		return fmt.Errorf("%w: argon2id iterations must be at least 1", apperrors.ErrHashParams)
+	case p.Iterations > argon2MaxIterations:
+		return fmt.Errorf("%w: argon2id iterations must be at most %d", apperrors.ErrHashParams, argon2MaxIterations)
+	case p.Parallelism < 1:
+		return fmt.Errorf("%w: argon2id parallelism must be at least 1", apperrors.ErrHashParams)
+	case p.Parallelism > argon2MaxParallelism:
+		return fmt.Errorf("%w: argon2id parallelism must be at most %d", apperrors.ErrHashParams, argon2MaxParallelism)
+	case p.Memory < 8*uint32(p.Parallelism):
+		return fmt.Errorf("%w: argon2id memory must be at least 8 KiB per lane", apperrors.ErrHashParams)
+	case p.Memory > argon2MaxMemory:
+		return fmt.Errorf("%w: argon2id memory must be at most %d KiB", apperrors.ErrHashParams, argon2MaxMemory)
+	case p.SaltLength < 8:
+		return fmt.Errorf("%w: argon2id salt must be at least 8 bytes", apperrors.ErrHashParams)
+	case p.KeyLength < 16:
+		return fmt.Errorf("%w: argon2id key must be at least 16 bytes", apperrors.ErrHashParams)
+	}
+	return nil
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoding them as PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// with the salt and key in unpadded standard base64
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+// NewArgon2idHasher returns an Argon2idHasher after validating its parameters
+func NewArgon2idHasher(params Argon2idParams) (*Argon2idHasher, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return &Argon2idHasher{Params: params}, nil
+}
+
+// Hash derives an argon2id key from the password with a random salt
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	p := h.Params
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return encodeArgon2id(p, salt, key), nil
+}
+
+// Verify recomputes the key with the parameters and salt stored in the hash
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, salt, key, err := decodeArgon2(encoded, "argon2id")
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// Identifies reports whether the hash is an argon2id PHC string
+func (h *Argon2idHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// Validate checks the hash parses as an argon2id PHC string
+func (h *Argon2idHasher) Validate(encoded string) error {
+	_, _, _, err := decodeArgon2(encoded, "argon2id")
+	return err
+}
+
+// NeedsRehash reports whether the hash parameters differ from the configured ones
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, _, _, err := decodeArgon2(encoded, "argon2id")
+	if err != nil {
+		return true
+	}
+	return p != h.Params
+}
+
+// encodeArgon2id formats a derived key as a PHC string
+func encodeArgon2id(p Argon2idParams, salt, key []byte) string {
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+// decodeArgon2 parses an argon2 PHC string of the given variant (`argon2id`
+// or `argon2i`) back into its parameters, salt and key
+func decodeArgon2(encoded, variant string) (Argon2idParams, []byte, []byte, error) {
+	var p Argon2idParams
+
+	// "", variant, "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != variant {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	// Costly parameters are well-formed, but would be paid on every login
+	if p.Memory > argon2MaxMemory || p.Iterations > argon2MaxIterations || p.Parallelism > argon2MaxParallelism {
+		return p, nil, nil, apperrors.ErrHashParams
+	}
+	if err := p.validate(); err != nil {
+		return p, nil, nil, apperrors.ErrHashMalformed
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,86 @@
+package passwords_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestArgon2idHasher tests hashing and verification with argon2id
+func TestArgon2idHasher(t *testing.T) {
+	is := is.New(t)
+
+	params := passwords.DefaultArgon2idParams()
+	params.Memory = 64
+	params.Iterations = 1
+	hasher, err := passwords.NewArgon2idHasher(params)
+	is.NoErr(err)
+
+	hash, err := hasher.Hash(testutils.TestingPassword)
+	is.NoErr(err)
+
+	t.Run("encodes as PHC string", func(t *testing.T) {
+		is.True(strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"))
+		is.True(hasher.Identifies(hash))
+	})
+
+	t.Run("salts every hash", func(t *testing.T) {
+		other, err := hasher.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+		is.True(other != hash)
+	})
+
+	t.Run("verifies correct password", func(t *testing.T) {
+		match, err := hasher.Verify(testutils.TestingPassword, hash)
+		is.NoErr(err)
+		is.True(match)
+	})
+
+	t.Run("rejects incorrect password", func(t *testing.T) {
+		match, err := hasher.Verify("notthepassword", hash)
+		is.NoErr(err)
+		is.True(!match)
+	})
+
+	t.Run("errors on malformed hash", func(t *testing.T) {
+		malformed := map[string]string{
+			"missingKey":  "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0",
+			"badVersion":  "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"badParams":   "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"badEncoding": "$argon2id$v=19$m=64,t=1,p=1$!!!$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+		}
+		for name, encoded := range malformed {
+			t.Run(name, func(t *testing.T) {
+				_, err := hasher.Verify(testutils.TestingPassword, encoded)
+				is.Equal(err, apperrors.ErrHashMalformed)
+			})
+		}
+	})
+
+	t.Run("needs rehash when params change", func(t *testing.T) {
+		is.True(!hasher.NeedsRehash(hash))
+
+		stronger := params
+		stronger.Iterations = 2
+		strongerHasher, err := passwords.NewArgon2idHasher(stronger)
+		is.NoErr(err)
+		is.True(strongerHasher.NeedsRehash(hash))
+
+		// Old hashes still verify under the new params
+		match, err := strongerHasher.Verify(testutils.TestingPassword, hash)
+		is.NoErr(err)
+		is.True(match)
+	})
+
+	t.Run("rejects weak params", func(t *testing.T) {
+		weak := params
+		weak.Iterations = 0
+		_, err := passwords.NewArgon2idHasher(weak)
+		is.True(err != nil)
+	})
+}
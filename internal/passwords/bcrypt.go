@@ -0,0 +1,85 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// BcryptHasher hashes passwords with bcrypt. bcrypt only reads the first 72
+// bytes of a password, so longer passwords are refused rather than truncated.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher returns a BcryptHasher after validating its cost
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if err := validateBcryptCost(cost); err != nil {
+		return nil, err
+	}
+	return &BcryptHasher{Cost: cost}, nil
+}
+
+// Hash hashes the password with the configured cost
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > config.BcryptMaxPasswordBytes {
+		return "", apperrors.ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify compares the password with the hash. Passwords over the 72 byte
+// limit never match, since bcrypt would only compare their first 72 bytes.
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if len(password) > config.BcryptMaxPasswordBytes {
+		return false, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, apperrors.ErrHashMalformed
+	}
+	return true, nil
+}
+
+// Identifies reports whether the hash is in modular crypt format for any bcrypt variant
+func (h *BcryptHasher) Identifies(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the hash parses as a bcrypt hash
+func (h *BcryptHasher) Validate(encoded string) error {
+	if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+		return apperrors.ErrHashMalformed
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash cost differs from the configured cost
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// validateBcryptCost checks the cost is within the range bcrypt accepts
+func validateBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("%w: bcrypt cost must be between %d and %d", apperrors.ErrHashParams, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
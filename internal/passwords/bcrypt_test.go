@@ -0,0 +1,63 @@
+package passwords_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestBcryptHasher tests hashing and verification with bcrypt
+func TestBcryptHasher(t *testing.T) {
+	is := is.New(t)
+
+	hasher, err := passwords.NewBcryptHasher(4)
+	is.NoErr(err)
+
+	hash, err := hasher.Hash(testutils.TestingPassword)
+	is.NoErr(err)
+
+	t.Run("verifies correct password", func(t *testing.T) {
+		is.True(hasher.Identifies(hash))
+		match, err := hasher.Verify(testutils.TestingPassword, hash)
+		is.NoErr(err)
+		is.True(match)
+	})
+
+	t.Run("rejects incorrect password", func(t *testing.T) {
+		match, err := hasher.Verify("notthepassword", hash)
+		is.NoErr(err)
+		is.True(!match)
+	})
+
+	t.Run("refuses to hash passwords over 72 bytes", func(t *testing.T) {
+		_, err := hasher.Hash(strings.Repeat("a", 73))
+		is.Equal(err, apperrors.ErrPasswordTooLong)
+	})
+
+	t.Run("does not match on a shared 72 byte prefix", func(t *testing.T) {
+		prefix := strings.Repeat("b", 72)
+		prefixHash, err := hasher.Hash(prefix)
+		is.NoErr(err)
+
+		match, err := hasher.Verify(prefix+"suffix", prefixHash)
+		is.NoErr(err)
+		is.True(!match)
+	})
+
+	t.Run("needs rehash when cost changes", func(t *testing.T) {
+		is.True(!hasher.NeedsRehash(hash))
+		stronger, err := passwords.NewBcryptHasher(5)
+		is.NoErr(err)
+		is.True(stronger.NeedsRehash(hash))
+	})
+
+	t.Run("rejects out of range cost", func(t *testing.T) {
+		_, err := passwords.NewBcryptHasher(99)
+		is.True(err != nil)
+	})
+}
@@ -0,0 +1,206 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// hibpLineMax is an upper bound on the length of a `HASH:COUNT` line,
+// including a possible `\r\n`
+const hibpLineMax = 64
+
+// BreachChecker reports whether a password appears in a breached password dataset
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+	io.Closer
+}
+
+// OpenBreachChecker opens a local Have I Been Pwned dataset at `path`. A
+// directory is read as range files named by hash prefix (e.g. `21BD1.txt`)
+// holding `SUFFIX:COUNT` lines. A file is read as a binary index if it starts
+// with the index header, or as `HASH:COUNT` lines ordered by hash otherwise.
+// Hashes seen fewer than `minCount` times are ignored; for an index that
+// threshold was applied when it was built.
+func OpenBreachChecker(path string, minCount int) (BreachChecker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &HIBPRangeDir{Dir: path, MinCount: minCount}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(breachIndexMagic))
+	if _, err := io.ReadFull(f, header); err == nil && string(header) == breachIndexMagic {
+		return openBreachIndex(f, info.Size())
+	}
+	return &HIBPFile{file: f, size: info.Size(), MinCount: minCount}, nil
+}
+
+// sha1Hex returns the uppercase hex SHA-1 of a password, as used by HIBP
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseHIBPLine splits a `HASH:COUNT` line
+func parseHIBPLine(line []byte) (string, int, error) {
+	hash, count, found := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+	if !found {
+		return "", 0, apperrors.ErrBreachFormat
+	}
+	n, err := strconv.Atoi(string(count))
+	if err != nil {
+		return "", 0, apperrors.ErrBreachFormat
+	}
+	return strings.ToUpper(string(hash)), n, nil
+}
+
+// HIBPFile looks up passwords in a `HASH:COUNT` file ordered by hash, such as
+// `pwned-passwords-sha1-ordered-by-hash.txt`, by binary search on the file
+type HIBPFile struct {
+	MinCount int
+
+	file *os.File
+	size int64
+}
+
+// IsBreached reports whether the password's hash is in the file at least MinCount times
+func (h *HIBPFile) IsBreached(password string) (bool, error) {
+	target := sha1Hex(password)
+
+	// Find the first line whose hash is not below the target
+	var searchErr error
+	offset := sort.Search(int(h.size)+1, func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		line, err := h.lineFrom(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		if line == nil {
+			return true
+		}
+		hash, _, err := parseHIBPLine(line)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return hash >= target
+	})
+	if searchErr != nil {
+		return false, searchErr
+	}
+
+	line, err := h.lineFrom(int64(offset))
+	if err != nil || line == nil {
+		return false, err
+	}
+	hash, count, err := parseHIBPLine(line)
+	if err != nil {
+		return false, err
+	}
+	return hash == target && count >= h.MinCount, nil
+}
+
+// lineFrom returns the first line starting at or after `offset`, or nil past
+// the last line of the file
+func (h *HIBPFile) lineFrom(offset int64) ([]byte, error) {
+	start := offset
+	if offset > 0 {
+		// Read from the byte before so a line starting exactly at `offset` is found
+		start = offset - 1
+	}
+	buf := make([]byte, 2*hibpLineMax)
+	n, err := h.file.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	if offset > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return nil, nil
+		}
+		buf = buf[i+1:]
+	}
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	// Only trailing blank lines are expected, so treat them as the end
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return nil, nil
+	}
+	return buf, nil
+}
+
+// Close closes the underlying file
+func (h *HIBPFile) Close() error {
+	return h.file.Close()
+}
+
+// HIBPRangeDir looks up passwords in a directory of HIBP range files, as
+// written by the official downloader. Each file is named by a 5 character
+// hash prefix and holds `SUFFIX:COUNT` lines.
+type HIBPRangeDir struct {
+	Dir      string
+	MinCount int
+}
+
+// IsBreached reports whether the password's hash is in its range file at least MinCount times
+func (h *HIBPRangeDir) IsBreached(password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(h.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Fall back to extensionless range files
+		f, err = os.Open(filepath.Join(h.Dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lineSuffix, count, err := parseHIBPLine(line)
+		if err != nil {
+			return false, err
+		}
+		if lineSuffix == suffix {
+			return count >= h.MinCount, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Close is a no-op; range files are opened per lookup
+func (h *HIBPRangeDir) Close() error {
+	return nil
+}
@@ -0,0 +1,162 @@
+package passwords_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// breachedPasswords are written to the test datasets with their counts
+var breachedPasswords = map[string]int{
+	"hunter2":         1000,
+	"Tr0ub4dour&3":    40,
+	"rarelyBreached!": 1,
+}
+
+// hibpLines returns `HASH:COUNT` lines ordered by hash for the breached
+// passwords plus unrelated filler hashes
+func hibpLines() []string {
+	var lines []string
+	for pw, count := range breachedPasswords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Upper(pw), count))
+	}
+	for i := range 500 {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Upper(fmt.Sprintf("filler-%d", i)), i+1))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func sha1Upper(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// TestBreachCheckers tests each dataset layout finds breached passwords
+func TestBreachCheckers(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	lines := hibpLines()
+
+	// Full `HASH:COUNT` file with CRLF line endings and a trailing newline
+	textPath := filepath.Join(dir, "pwned.txt")
+	is.NoErr(os.WriteFile(textPath, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+
+	// Range directory
+	rangeDir := filepath.Join(dir, "ranges")
+	is.NoErr(os.Mkdir(rangeDir, 0o700))
+	ranges := map[string][]string{}
+	for _, line := range lines {
+		ranges[line[:5]] = append(ranges[line[:5]], line[5:])
+	}
+	for prefix, suffixes := range ranges {
+		is.NoErr(os.WriteFile(filepath.Join(rangeDir, prefix+".txt"), []byte(strings.Join(suffixes, "\n")), 0o600))
+	}
+
+	// Binary index
+	indexPath := filepath.Join(dir, "pwned.idx")
+	var index bytes.Buffer
+	written, err := passwords.BuildBreachIndex(strings.NewReader(strings.Join(lines, "\n")), &index, 1)
+	is.NoErr(err)
+	is.Equal(written, len(lines))
+	is.NoErr(os.WriteFile(indexPath, index.Bytes(), 0o600))
+
+	for name, path := range map[string]string{"text": textPath, "ranges": rangeDir, "index": indexPath} {
+		t.Run(name, func(t *testing.T) {
+			checker, err := passwords.OpenBreachChecker(path, 1)
+			is.NoErr(err)
+			t.Cleanup(func() { checker.Close() })
+
+			for pw := range breachedPasswords {
+				breached, err := checker.IsBreached(pw)
+				is.NoErr(err)
+				is.True(breached)
+			}
+			// Every line is reachable, including the first and last
+			for i := range 500 {
+				breached, err := checker.IsBreached(fmt.Sprintf("filler-%d", i))
+				is.NoErr(err)
+				is.True(breached)
+			}
+			breached, err := checker.IsBreached(testutils.TestingPassword)
+			is.NoErr(err)
+			is.True(!breached)
+		})
+	}
+
+	t.Run("min count", func(t *testing.T) {
+		for _, path := range []string{textPath, rangeDir} {
+			checker, err := passwords.OpenBreachChecker(path, 10)
+			is.NoErr(err)
+			breached, err := checker.IsBreached("rarelyBreached!")
+			is.NoErr(err)
+			is.True(!breached)
+			breached, err = checker.IsBreached("hunter2")
+			is.NoErr(err)
+			is.True(breached)
+			checker.Close()
+		}
+	})
+}
+
+// TestBuildBreachIndex tests index building rejects bad input
+func TestBuildBreachIndex(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("min count skips rare hashes", func(t *testing.T) {
+		var index bytes.Buffer
+		written, err := passwords.BuildBreachIndex(strings.NewReader(strings.Join(hibpLines(), "\n")), &index, 100)
+		is.NoErr(err)
+		is.Equal(written, 401+1) // fillers seen 100-500 times plus hunter2
+	})
+
+	t.Run("unsorted input", func(t *testing.T) {
+		lines := hibpLines()
+		lines[0], lines[1] = lines[1], lines[0]
+		_, err := passwords.BuildBreachIndex(strings.NewReader(strings.Join(lines, "\n")), &bytes.Buffer{}, 1)
+		is.True(errors.Is(err, apperrors.ErrBreachUnsorted))
+	})
+
+	t.Run("malformed line", func(t *testing.T) {
+		_, err := passwords.BuildBreachIndex(strings.NewReader("not a hash\n"), &bytes.Buffer{}, 1)
+		is.True(errors.Is(err, apperrors.ErrBreachFormat))
+	})
+}
+
+// TestPolicy_Breached tests the policy rejects breached passwords from the env dataset
+func TestPolicy_Breached(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	is.NoErr(os.WriteFile(path, []byte(strings.Join(hibpLines(), "\n")), 0o600))
+	t.Setenv(config.BreachedPasswordsFile, path)
+
+	policy, err := passwords.NewPolicyFromEnv()
+	is.NoErr(err)
+	t.Cleanup(func() { policy.Breached.Close() })
+
+	err = policy.Check("Tr0ub4dour&3", "")
+	is.True(errors.Is(err, apperrors.ErrPasswordBreached))
+	is.NoErr(policy.Check(testutils.TestingPassword, ""))
+
+	t.Run("missing dataset", func(t *testing.T) {
+		t.Setenv(config.BreachedPasswordsFile, filepath.Join(t.TempDir(), "missing.txt"))
+		_, err := passwords.NewPolicyFromEnv()
+		is.True(errors.Is(err, apperrors.ErrPasswordPolicyConfig))
+	})
+}
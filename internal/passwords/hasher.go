@@ -0,0 +1,264 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Verifier checks passwords against hashes in one encoded format
+type Verifier interface {
+	// Verify reports whether a password matches an encoded hash
+	Verify(password, encoded string) (bool, error)
+	// Identifies reports whether an encoded hash belongs to this format
+	Identifies(encoded string) bool
+	// Validate reports whether an encoded hash is well-formed without
+	// running the (deliberately slow) hash function
+	Validate(encoded string) error
+}
+
+// Hasher is a Verifier that can also produce new hashes
+type Hasher interface {
+	Verifier
+	// Hash returns the encoded hash of a password
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether an encoded hash was made with parameters
+	// other than the ones this hasher is configured with
+	NeedsRehash(encoded string) bool
+}
+
+// Manager hashes new passwords with a default Hasher and dispatches
+// verification to whichever registered Verifier identifies the stored hash
+type Manager struct {
+	Default   Hasher
+	Verifiers []Verifier
+	// Pool bounds concurrent hashing and verification; nil runs them inline
+	Pool *Pool
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewManager returns a Manager that hashes with `def` and can additionally
+// verify hashes made by any of `verifiers`
+func NewManager(def Hasher, verifiers ...Verifier) *Manager {
+	return &Manager{Default: def, Verifiers: verifiers}
+}
+
+// Hash hashes a password with the default Hasher
+func (m *Manager) Hash(password string) (hash string, err error) {
+	if poolErr := m.do(func() { hash, err = m.Default.Hash(password) }); poolErr != nil {
+		return "", poolErr
+	}
+	return hash, err
+}
+
+// Verify checks a password against an encoded hash. On a match, `rehash` is
+// true if the hash should be replaced with one from the default Hasher.
+func (m *Manager) Verify(password, encoded string) (match bool, rehash bool, err error) {
+	verifier := m.verifierFor(encoded)
+	if verifier == nil {
+		return false, false, apperrors.ErrHashFormatUnknown
+	}
+	if poolErr := m.do(func() { match, err = verifier.Verify(password, encoded) }); poolErr != nil {
+		return false, false, poolErr
+	}
+	if err != nil || !match {
+		return false, false, err
+	}
+	rehash = verifier != Verifier(m.Default) || m.Default.NeedsRehash(encoded)
+	return true, rehash, nil
+}
+
+// VerifyDummy verifies a password against a hash of a random password made
+// by the default Hasher. It always fails, but takes as long as a real
+// verification, so requests for unknown users cannot be told apart by timing.
+// It returns ErrHashingBusy when the Pool is full, as Verify would.
+func (m *Manager) VerifyDummy(password string) error {
+	m.dummyOnce.Do(func() {
+		random := make([]byte, 32)
+		rand.Read(random)
+		m.dummyHash, _ = m.Default.Hash(hex.EncodeToString(random))
+	})
+	if m.dummyHash == "" {
+		return nil
+	}
+	return m.do(func() { m.Default.Verify(password, m.dummyHash) })
+}
+
+// do runs f through the Pool, if there is one
+func (m *Manager) do(f func()) error {
+	if m.Pool == nil {
+		f()
+		return nil
+	}
+	return m.Pool.Do(f)
+}
+
+// Validate checks that an encoded hash is in a recognized format and well-formed
+func (m *Manager) Validate(encoded string) error {
+	verifier := m.verifierFor(encoded)
+	if verifier == nil {
+		return apperrors.ErrHashFormatUnknown
+	}
+	return verifier.Validate(encoded)
+}
+
+// verifierFor returns the Verifier that identifies an encoded hash, preferring the default
+func (m *Manager) verifierFor(encoded string) Verifier {
+	if m.Default.Identifies(encoded) {
+		return m.Default
+	}
+	for _, h := range m.Verifiers {
+		if h.Identifies(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+var (
+	defaultManager *Manager
+	defaultMu      sync.RWMutex
+)
+
+// Default returns the process-wide Manager, building it from the environment
+// on first use. Invalid configuration falls back to the built-in defaults.
+func Default() *Manager {
+	defaultMu.RLock()
+	m := defaultManager
+	defaultMu.RUnlock()
+	if m != nil {
+		return m
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultManager == nil {
+		m, err := NewManagerFromEnv()
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid password hashing config, using defaults")
+			m = newManager(DefaultArgon2idParams(), config.DefaultBcryptCost, "argon2id")
+			m.Pool, _ = NewPool(DefaultHashConcurrency(), config.DefaultHashQueueDepth)
+		}
+		defaultManager = m
+	}
+	return defaultManager
+}
+
+// DefaultHashConcurrency is the number of password hashes computed at once
+// when `HASH_CONCURRENCY` is not set: half the CPUs, so a burst of logins
+// leaves the other half to handle requests
+func DefaultHashConcurrency() int {
+	return max(1, runtime.NumCPU()/2)
+}
+
+// SetDefault replaces the process-wide Manager. Passing nil resets it so the
+// next call to Default rebuilds it from the environment.
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+// NewManagerFromEnv builds a Manager from the password hashing env variables
+// in `config`. Both argon2id and bcrypt hashes can always be verified, as can
+// the legacy formats accepted by the user import. Hashing runs through a Pool
+// sized by `HASH_CONCURRENCY` and `HASH_QUEUE_DEPTH`.
+func NewManagerFromEnv() (*Manager, error) {
+	params := DefaultArgon2idParams()
+	var err error
+	if params.Memory, err = envUint32(config.Argon2MemoryKiB, params.Memory); err != nil {
+		return nil, err
+	}
+	if params.Iterations, err = envUint32(config.Argon2Iterations, params.Iterations); err != nil {
+		return nil, err
+	}
+	parallelism, err := envUint32(config.Argon2Parallelism, uint32(params.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+	if parallelism == 0 || parallelism > 255 {
+		return nil, fmt.Errorf("%w: %s must be between 1 and 255", apperrors.ErrHashParams, config.Argon2Parallelism)
+	}
+	params.Parallelism = uint8(parallelism)
+
+	cost, err := envUint32(config.BcryptCost, config.DefaultBcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := os.Getenv(config.PasswordHashAlgorithm)
+	if algorithm == "" {
+		algorithm = "argon2id"
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateBcryptCost(int(cost)); err != nil {
+		return nil, err
+	}
+	if algorithm != "argon2id" && algorithm != "bcrypt" {
+		return nil, fmt.Errorf("%w: %q", apperrors.ErrHashAlgorithmUnknown, algorithm)
+	}
+
+	concurrency, err := envUint32(config.HashConcurrency, uint32(DefaultHashConcurrency()))
+	if err != nil {
+		return nil, err
+	}
+	queueDepth, err := envUint32(config.HashQueueDepth, config.DefaultHashQueueDepth)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := NewPool(int(concurrency), int(queueDepth))
+	if err != nil {
+		return nil, err
+	}
+
+	m := newManager(params, int(cost), algorithm)
+	m.Pool = pool
+	return m, nil
+}
+
+// newManager wires up argon2id and bcrypt hashers with the chosen algorithm
+// as default, plus the legacy verifiers
+func newManager(params Argon2idParams, cost int, algorithm string) *Manager {
+	argon := &Argon2idHasher{Params: params}
+	bc := &BcryptHasher{Cost: cost}
+	legacy := LegacyVerifiers()
+	if algorithm == "bcrypt" {
+		return NewManager(bc, append([]Verifier{argon}, legacy...)...)
+	}
+	return NewManager(argon, append([]Verifier{bc}, legacy...)...)
+}
+
+// WriteMetrics writes the Pool's metrics in the Prometheus text format, or
+// nothing if hashing is unbounded
+func (m *Manager) WriteMetrics(w io.Writer) {
+	if m.Pool != nil {
+		m.Pool.WriteMetrics(w)
+	}
+}
+
+// envUint32 parses an unsigned env variable, returning `def` if it is unset
+func envUint32(name string, def uint32) (uint32, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %s", apperrors.ErrHashParams, name, err)
+	}
+	return uint32(n), nil
+}
@@ -0,0 +1,176 @@
+package passwords_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestManager_Verify tests that verification dispatches on the hash prefix
+// and flags hashes that should be upgraded
+func TestManager_Verify(t *testing.T) {
+	is := is.New(t)
+
+	params := passwords.DefaultArgon2idParams()
+	params.Memory = 64
+	params.Iterations = 1
+	argon, err := passwords.NewArgon2idHasher(params)
+	is.NoErr(err)
+	bc, err := passwords.NewBcryptHasher(4)
+	is.NoErr(err)
+	manager := passwords.NewManager(argon, bc)
+
+	t.Run("hashes with default hasher", func(t *testing.T) {
+		hash, err := manager.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+		is.True(strings.HasPrefix(hash, "$argon2id$"))
+
+		match, rehash, err := manager.Verify(testutils.TestingPassword, hash)
+		is.NoErr(err)
+		is.True(match)
+		is.True(!rehash)
+	})
+
+	t.Run("verifies other algorithms and flags rehash", func(t *testing.T) {
+		hash, err := bc.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+
+		match, rehash, err := manager.Verify(testutils.TestingPassword, hash)
+		is.NoErr(err)
+		is.True(match)
+		is.True(rehash)
+	})
+
+	t.Run("does not flag rehash on mismatch", func(t *testing.T) {
+		hash, err := bc.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+
+		match, rehash, err := manager.Verify("notthepassword", hash)
+		is.NoErr(err)
+		is.True(!match)
+		is.True(!rehash)
+	})
+
+	t.Run("errors on unknown format", func(t *testing.T) {
+		_, _, err := manager.Verify(testutils.TestingPassword, "plaintext")
+		is.Equal(err, apperrors.ErrHashFormatUnknown)
+	})
+}
+
+// TestNewManagerFromEnv tests building a Manager from env variables
+func TestNewManagerFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("defaults to argon2id", func(t *testing.T) {
+		manager, err := passwords.NewManagerFromEnv()
+		is.NoErr(err)
+		_, ok := manager.Default.(*passwords.Argon2idHasher)
+		is.True(ok)
+	})
+
+	t.Run("selects bcrypt", func(t *testing.T) {
+		t.Setenv(config.PasswordHashAlgorithm, "bcrypt")
+		t.Setenv(config.BcryptCost, "4")
+		manager, err := passwords.NewManagerFromEnv()
+		is.NoErr(err)
+		hasher, ok := manager.Default.(*passwords.BcryptHasher)
+		is.True(ok)
+		is.Equal(hasher.Cost, 4)
+	})
+
+	t.Run("reads argon2id params", func(t *testing.T) {
+		t.Setenv(config.Argon2MemoryKiB, "128")
+		t.Setenv(config.Argon2Iterations, "3")
+		t.Setenv(config.Argon2Parallelism, "2")
+		manager, err := passwords.NewManagerFromEnv()
+		is.NoErr(err)
+		hasher := manager.Default.(*passwords.Argon2idHasher)
+		is.Equal(hasher.Params.Memory, uint32(128))
+		is.Equal(hasher.Params.Iterations, uint32(3))
+		is.Equal(hasher.Params.Parallelism, uint8(2))
+	})
+
+	t.Run("leaves CPUs free for requests by default", func(t *testing.T) {
+		concurrencyLimit := func(m *passwords.Manager) string {
+			var b strings.Builder
+			m.Pool.WriteMetrics(&b)
+			for _, line := range strings.Split(b.String(), "\n") {
+				if strings.HasPrefix(line, "goauth_hash_concurrency_limit ") {
+					return line
+				}
+			}
+			return ""
+		}
+
+		is.Equal(passwords.DefaultHashConcurrency(), max(1, runtime.NumCPU()/2))
+		manager, err := passwords.NewManagerFromEnv()
+		is.NoErr(err)
+		is.Equal(concurrencyLimit(manager), fmt.Sprintf("goauth_hash_concurrency_limit %d", passwords.DefaultHashConcurrency()))
+
+		t.Setenv(config.HashConcurrency, "64")
+		manager, err = passwords.NewManagerFromEnv()
+		is.NoErr(err)
+		is.Equal(concurrencyLimit(manager), "goauth_hash_concurrency_limit 64")
+	})
+
+	invalid := map[string][2]string{
+		"unknownAlgorithm":  {config.PasswordHashAlgorithm, "md5"},
+		"nonNumericMemory":  {config.Argon2MemoryKiB, "lots"},
+		"zeroIterations":    {config.Argon2Iterations, "0"},
+		"zeroParallelism":   {config.Argon2Parallelism, "0"},
+		"bcryptCostTooHigh": {config.BcryptCost, "32"},
+		"zeroConcurrency":   {config.HashConcurrency, "0"},
+		"negativeQueue":     {config.HashQueueDepth, "-1"},
+	}
+	for name, env := range invalid {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			_, err := passwords.NewManagerFromEnv()
+			is.True(err != nil)
+		})
+	}
+}
+
+// TestManager_VerifyDummy tests a dummy verification takes about as long as a
+// real one, so unknown users cannot be told apart by response time
+func TestManager_VerifyDummy(t *testing.T) {
+	is := is.New(t)
+
+	params := passwords.DefaultArgon2idParams()
+	params.Memory = 8 * 1024
+	argon, err := passwords.NewArgon2idHasher(params)
+	is.NoErr(err)
+	manager := passwords.NewManager(argon)
+
+	hash, err := manager.Hash(testutils.TestingPassword)
+	is.NoErr(err)
+	// The dummy hash is made on first use, keep it out of the measurement
+	manager.VerifyDummy("warmup")
+
+	const rounds = 5
+	verify := timeRounds(rounds, func() { manager.Verify("wrongpassword", hash) })
+	dummy := timeRounds(rounds, func() { manager.VerifyDummy("wrongpassword") })
+
+	ratio := float64(dummy) / float64(verify)
+	if ratio < 0.5 || ratio > 2 {
+		t.Fatalf("dummy verification took %v, real verification %v", dummy, verify)
+	}
+}
+
+// timeRounds returns the mean duration of `f` over `rounds` calls
+func timeRounds(rounds int, f func()) time.Duration {
+	start := time.Now()
+	for range rounds {
+		f()
+	}
+	return time.Since(start) / time.Duration(rounds)
+}
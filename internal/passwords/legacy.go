@@ -0,0 +1,96 @@
+package passwords
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// LegacyVerifiers returns verifiers for hash formats exported by other
+// systems. Users imported with these hashes are rehashed with the default
+// Hasher the first time they log in.
+func LegacyVerifiers() []Verifier {
+	return []Verifier{
+		&PBKDF2Verifier{},
+		&ScryptVerifier{},
+		&Argon2Verifier{},
+		&DjangoBcryptVerifier{},
+		&SaltedSHAVerifier{},
+	}
+}
+
+// djangoArgon2Prefix is prepended by Django to argon2 PHC strings
+const djangoArgon2Prefix = "argon2"
+
+// Argon2Verifier verifies argon2 hashes that Argon2idHasher does not produce:
+// argon2i PHC strings (e.g. PHP's PASSWORD_ARGON2I) and Django's `argon2$`
+// wrapped PHC strings of either variant.
+type Argon2Verifier struct{}
+
+// Verify recomputes the key with the variant, parameters and salt stored in the hash
+func (v *Argon2Verifier) Verify(password, encoded string) (bool, error) {
+	variant, p, salt, key, err := decodeLegacyArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	var other []byte
+	if variant == "argon2i" {
+		other = argon2.Key([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	} else {
+		other = argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// Identifies reports whether the hash is an argon2i or Django argon2 hash
+func (v *Argon2Verifier) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2i$") ||
+		strings.HasPrefix(encoded, djangoArgon2Prefix+"$argon2")
+}
+
+// Validate checks the hash parses as an argon2 PHC string
+func (v *Argon2Verifier) Validate(encoded string) error {
+	_, _, _, _, err := decodeLegacyArgon2(encoded)
+	return err
+}
+
+// decodeLegacyArgon2 strips any Django prefix and decodes the PHC string
+func decodeLegacyArgon2(encoded string) (string, Argon2idParams, []byte, []byte, error) {
+	phc := strings.TrimPrefix(encoded, djangoArgon2Prefix)
+	variant := "argon2id"
+	if strings.HasPrefix(phc, "$argon2i$") {
+		variant = "argon2i"
+	}
+	p, salt, key, err := decodeArgon2(phc, variant)
+	return variant, p, salt, key, err
+}
+
+// DjangoBcryptVerifier verifies Django's bcrypt hashes:
+//
+//	bcrypt$<bcrypt hash>           bcrypt of the password
+//	bcrypt_sha256$<bcrypt hash>    bcrypt of the hex SHA-256 of the password
+type DjangoBcryptVerifier struct{}
+
+// Verify unwraps the bcrypt hash and compares it, pre-hashing for `bcrypt_sha256`
+func (v *DjangoBcryptVerifier) Verify(password, encoded string) (bool, error) {
+	algorithm, hash, _ := strings.Cut(encoded, "$")
+	if algorithm == "bcrypt_sha256" {
+		sum := sha256.Sum256([]byte(password))
+		password = hex.EncodeToString(sum[:])
+	}
+	return (&BcryptHasher{}).Verify(password, hash)
+}
+
+// Identifies reports whether the hash is a Django bcrypt hash
+func (v *DjangoBcryptVerifier) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "bcrypt$") || strings.HasPrefix(encoded, "bcrypt_sha256$")
+}
+
+// Validate checks the wrapped bcrypt hash parses
+func (v *DjangoBcryptVerifier) Validate(encoded string) error {
+	_, hash, _ := strings.Cut(encoded, "$")
+	return (&BcryptHasher{}).Validate(hash)
+}
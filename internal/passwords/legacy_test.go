@@ -0,0 +1,144 @@
+package passwords_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestLegacyVerifiers checks each legacy format against hashes of
+// `testutils.TestingPassword` generated outside of this package
+func TestLegacyVerifiers(t *testing.T) {
+	is := is.New(t)
+
+	manager, err := passwords.NewManagerFromEnv()
+	is.NoErr(err)
+
+	// Vectors generated with Python's hashlib
+	vectors := map[string]string{
+		"pbkdf2_sha256": "pbkdf2_sha256$1000$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk=",
+		"pbkdf2_sha1":   "pbkdf2_sha1$1000$seasalt$Z4vWGplFTQBDZAAnzUNLquuWHow=",
+		"scrypt":        "scrypt$1024$seasalt$8$1$dEfQdbWPxRi1cm7KKRIud7VlXuLUIJ9DDa+Fzmp6lUPO17Y2zpPnXN6AQY60Xi8uB0NHktN3ycP0B7zlVB5XDA==",
+		"ssha":          "{SSHA}YaltpIobxj+B6KPyP5kI4VYhh1YxMjM0NTY3OA==",
+		"ssha256":       "{SSHA256}1tLzQAAUVsBUMa60tG9MY9CLxaspuK/EatGNdrZbIhoxMjM0NTY3OA==",
+		"ssha512":       "{SSHA512}Fh+7dQVjw+3mui7fiVhbUoDX9fte9vXcxrZBrsB43Evm7vlj5rNKQVGn03gWt2YLdJPf6e1CU+KB4iMmj6W+yTEyMzQ1Njc4",
+		"sha1":          "sha1$seasalt$10c77a97e18268db4d8bcb0f1c74110e476e3ea4",
+		"sha256":        "sha256$seasalt$40ecf2571f5c8e0df54c870fcb018889b08f6d396693d7f661b491ee9d2f1bbd",
+	}
+
+	// argon2i and Django-wrapped argon2, built from the reference derivation
+	salt := []byte("saltsaltsaltsalt")
+	b64 := base64.RawStdEncoding.EncodeToString
+	argon2iKey := argon2.Key([]byte(testutils.TestingPassword), salt, 1, 64, 1, 32)
+	argon2idKey := argon2.IDKey([]byte(testutils.TestingPassword), salt, 1, 64, 1, 32)
+	vectors["argon2i"] = fmt.Sprintf("$argon2i$v=19$m=64,t=1,p=1$%s$%s", b64(salt), b64(argon2iKey))
+	vectors["django_argon2"] = fmt.Sprintf("argon2$argon2id$v=19$m=64,t=1,p=1$%s$%s", b64(salt), b64(argon2idKey))
+
+	// bcrypt variants, including PHP's $2y$ and Django's wrappers
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(testutils.TestingPassword), 4)
+	is.NoErr(err)
+	sum := sha256.Sum256([]byte(testutils.TestingPassword))
+	bcryptSHA256Hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(sum[:])), 4)
+	is.NoErr(err)
+	vectors["bcrypt_2y"] = "$2y$" + string(bcryptHash[4:])
+	vectors["django_bcrypt"] = "bcrypt$" + string(bcryptHash)
+	vectors["django_bcrypt_sha256"] = "bcrypt_sha256$" + string(bcryptSHA256Hash)
+
+	for name, encoded := range vectors {
+		t.Run(name, func(t *testing.T) {
+			is.NoErr(manager.Validate(encoded))
+
+			match, rehash, err := manager.Verify(testutils.TestingPassword, encoded)
+			is.NoErr(err)
+			is.True(match)
+			is.True(rehash)
+
+			match, _, err = manager.Verify("notthepassword", encoded)
+			is.NoErr(err)
+			is.True(!match)
+		})
+	}
+}
+
+// TestLegacyVerifiers_Malformed checks malformed legacy hashes fail validation
+func TestLegacyVerifiers_Malformed(t *testing.T) {
+	is := is.New(t)
+
+	manager, err := passwords.NewManagerFromEnv()
+	is.NoErr(err)
+
+	malformed := map[string]string{
+		"pbkdf2MissingSalt":     "pbkdf2_sha256$1000$$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk=",
+		"pbkdf2BadIterations":   "pbkdf2_sha256$lots$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk=",
+		"scryptNotPowerOfTwo":   "scrypt$1000$seasalt$8$1$dEfQdbWPxRi1cm7KKRIud7VlXuLUIJ9DDa+Fzmp6lUPO17Y2zpPnXN6AQY60Xi8uB0NHktN3ycP0B7zlVB5XDA==",
+		"sshaTooShort":          "{SSHA}YWJj",
+		"sha1WrongDigestLength": "sha1$seasalt$abcd",
+		"djangoBcryptGarbage":   "bcrypt$notabcrypthash",
+	}
+	for name, encoded := range malformed {
+		t.Run(name, func(t *testing.T) {
+			is.True(manager.Validate(encoded) != nil)
+		})
+	}
+
+	t.Run("scrypt costs are bounded", func(t *testing.T) {
+		costly := map[string]string{
+			"memory":      "scrypt$1048576$seasalt$8$1$dEfQ",
+			"parallelism": "scrypt$131072$seasalt$8$64$dEfQ",
+			"overflow":    "scrypt$4611686018427387904$seasalt$8$1$dEfQ",
+		}
+		for name, encoded := range costly {
+			t.Run(name, func(t *testing.T) {
+				is.Equal(manager.Validate(encoded), apperrors.ErrHashParams)
+			})
+		}
+
+		// Django's default cost is allowed
+		is.NoErr(manager.Validate("scrypt$16384$seasalt$8$1$dEfQ"))
+	})
+
+	t.Run("pbkdf2 costs are bounded", func(t *testing.T) {
+		costly := map[string]string{
+			"iterations":      "pbkdf2_sha256$10000000$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk=",
+			"sha256KeyBlocks": "pbkdf2_sha256$1000$seasalt$" + base64.StdEncoding.EncodeToString(make([]byte, 33)),
+			"sha1KeyBlocks":   "pbkdf2_sha1$1000$seasalt$" + base64.StdEncoding.EncodeToString(make([]byte, 21)),
+		}
+		for name, encoded := range costly {
+			t.Run(name, func(t *testing.T) {
+				is.Equal(manager.Validate(encoded), apperrors.ErrHashParams)
+			})
+		}
+
+		// Django's default cost is allowed
+		is.NoErr(manager.Validate("pbkdf2_sha256$1000000$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk="))
+	})
+
+	t.Run("argon2 costs are bounded", func(t *testing.T) {
+		costly := map[string]string{
+			"memory":           "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"iterations":       "$argon2id$v=19$m=64,t=1000,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"parallelism":      "$argon2id$v=19$m=4096,t=1,p=255$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"argon2iMemory":    "$argon2i$v=19$m=4194304,t=1,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+			"djangoIterations": "argon2$argon2id$v=19$m=64,t=1000,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ",
+		}
+		for name, encoded := range costly {
+			t.Run(name, func(t *testing.T) {
+				is.Equal(manager.Validate(encoded), apperrors.ErrHashParams)
+			})
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		is.Equal(manager.Validate("md5$abc$def"), apperrors.ErrHashFormatUnknown)
+	})
+}
@@ -0,0 +1,330 @@
+package passwords
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	passwordvalidator "github.com/wagslane/go-password-validator"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Policy rules, reported in `Violation.Rule`
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleEntropy       = "entropy"
+	RuleCommon        = "common"
+	RuleContainsEmail = "contains_email"
+	RuleRecentlyUsed  = "recently_used"
+	RuleBreached      = "breached"
+)
+
+// minEmailLocalPart is the shortest email local part we look for inside a
+// password. Shorter ones match too many unrelated passwords.
+const minEmailLocalPart = 3
+
+//go:embed denylist.txt
+var bundledDenylist string
+
+// Policy is the set of rules a new password must satisfy
+type Policy struct {
+	MinLength      int
+	MaxLength      int
+	MinEntropyBits float64
+	// HistorySize is the number of recent passwords, including the current
+	// one, that cannot be reused. Zero disables the check.
+	HistorySize int
+	// Breached, if set, rejects passwords found in a breached password dataset
+	Breached BreachChecker
+
+	denylist map[string]struct{}
+}
+
+// Violation is a single password policy rule that was not met
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	err error
+}
+
+// PolicyError collects every rule a password failed. It unwraps to the
+// sentinel error of each violation, so `errors.Is` works for single rules.
+type PolicyError struct {
+	Violations []Violation
+}
+
+// Error joins the violation messages
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns ErrPasswordPolicy followed by the sentinel error of each violation
+func (e *PolicyError) Unwrap() []error {
+	errs := []error{apperrors.ErrPasswordPolicy}
+	for _, v := range e.Violations {
+		errs = append(errs, v.err)
+	}
+	return errs
+}
+
+// add records a failed rule for the password field
+func (e *PolicyError) add(rule string, err error, message string) {
+	e.Violations = append(e.Violations, Violation{Field: "password", Rule: rule, Message: message, err: err})
+}
+
+// DefaultPolicy returns the policy used when no password policy env variables are set
+func DefaultPolicy() *Policy {
+	p := &Policy{
+		MinLength:      config.DefaultPasswordMinLength,
+		MaxLength:      config.DefaultPasswordMaxLength,
+		MinEntropyBits: config.MinEntropyBits,
+		HistorySize:    config.DefaultPasswordHistorySize,
+		denylist:       map[string]struct{}{},
+	}
+	// The bundled list is well-formed, so this cannot fail
+	_ = p.LoadDenylist(strings.NewReader(bundledDenylist))
+	return p
+}
+
+// NewPolicyFromEnv builds a Policy from the password policy env variables in
+// `config`, falling back to DefaultPolicy for unset values
+func NewPolicyFromEnv() (*Policy, error) {
+	p := DefaultPolicy()
+	var err error
+	if p.MinLength, err = envPolicyInt(config.PasswordMinLength, p.MinLength); err != nil {
+		return nil, err
+	}
+	if p.MaxLength, err = envPolicyInt(config.PasswordMaxLength, p.MaxLength); err != nil {
+		return nil, err
+	}
+	if p.HistorySize, err = envPolicyInt(config.PasswordHistorySize, p.HistorySize); err != nil {
+		return nil, err
+	}
+	entropy, err := envPolicyInt(config.PasswordMinEntropy, int(p.MinEntropyBits))
+	if err != nil {
+		return nil, err
+	}
+	p.MinEntropyBits = float64(entropy)
+
+	if path := os.Getenv(config.PasswordDenylistFile); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", apperrors.ErrPasswordPolicyConfig, config.PasswordDenylistFile, err)
+		}
+		defer f.Close()
+		if err := p.LoadDenylist(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if path := os.Getenv(config.BreachedPasswordsFile); path != "" {
+		minCount, err := envPolicyInt(config.BreachedPasswordsMinCount, 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.Breached, err = OpenBreachChecker(path, minCount); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", apperrors.ErrPasswordPolicyConfig, config.BreachedPasswordsFile, err)
+		}
+	}
+	return p, nil
+}
+
+// Validate rejects policies that no password could satisfy
+func (p *Policy) Validate() error {
+	if p.MinLength < 1 {
+		return fmt.Errorf("%w: minimum length must be at least 1", apperrors.ErrPasswordPolicyConfig)
+	}
+	if p.MaxLength < p.MinLength {
+		return fmt.Errorf("%w: maximum length is below the minimum length", apperrors.ErrPasswordPolicyConfig)
+	}
+	if p.MinEntropyBits < 0 || p.HistorySize < 0 {
+		return fmt.Errorf("%w: entropy and history size cannot be negative", apperrors.ErrPasswordPolicyConfig)
+	}
+	return nil
+}
+
+// LoadDenylist adds newline separated passwords to the denylist. Blank lines
+// and lines starting with `#` are skipped.
+func (p *Policy) LoadDenylist(r io.Reader) error {
+	if p.denylist == nil {
+		p.denylist = map[string]struct{}{}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.denylist[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: denylist: %s", apperrors.ErrPasswordPolicyConfig, err)
+	}
+	return nil
+}
+
+// Check tests a password against every rule that does not need the user's
+// previous passwords. `email` may be empty when it is not known. On failure
+// the returned error is a *PolicyError.
+func (p *Policy) Check(password, email string) error {
+	perr := &PolicyError{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		perr.add(RuleMinLength, apperrors.ErrPasswordTooShort,
+			fmt.Sprintf("Password must be at least %d characters", p.MinLength))
+	}
+	if length > p.MaxLength {
+		perr.add(RuleMaxLength, apperrors.ErrPasswordExceedsMax,
+			fmt.Sprintf("Password must be at most %d characters", p.MaxLength))
+	}
+	if passwordvalidator.GetEntropy(password) < p.MinEntropyBits {
+		perr.add(RuleEntropy, apperrors.ErrPasswordComplexity, apperrors.ErrPasswordComplexity.Error())
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.denylist[lower]; ok {
+		perr.add(RuleCommon, apperrors.ErrPasswordCommon, apperrors.ErrPasswordCommon.Error())
+	}
+	if local := emailLocalPart(email); local != "" && strings.Contains(lower, local) {
+		perr.add(RuleContainsEmail, apperrors.ErrPasswordContainsEmail, apperrors.ErrPasswordContainsEmail.Error())
+	}
+
+	if p.IsBreached(password) {
+		perr.add(RuleBreached, apperrors.ErrPasswordBreached, apperrors.ErrPasswordBreached.Error())
+	}
+
+	if len(perr.Violations) > 0 {
+		return perr
+	}
+	return nil
+}
+
+// IsBreached reports whether a password is in the breached password dataset.
+// A dataset that cannot be read is logged and the password allowed, so a
+// broken file does not block every registration.
+func (p *Policy) IsBreached(password string) bool {
+	if p.Breached == nil {
+		return false
+	}
+	breached, err := p.Breached.IsBreached(password)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not check breached password dataset")
+		return false
+	}
+	return breached
+}
+
+// CheckHistory rejects a password that matches any of `hashes`, the user's
+// current and previous password hashes, newest first. Only the first
+// `HistorySize` hashes are checked.
+func (p *Policy) CheckHistory(password string, hashes []string) error {
+	if len(hashes) > p.HistorySize {
+		hashes = hashes[:p.HistorySize]
+	}
+	for _, hash := range hashes {
+		// Hashes we can no longer verify cannot be matched, so skip them
+		match, _, err := Default().Verify(password, hash)
+		if errors.Is(err, apperrors.ErrHashingBusy) {
+			return err
+		}
+		if err == nil && match {
+			perr := &PolicyError{}
+			perr.add(RuleRecentlyUsed, apperrors.ErrPasswordRecentlyUsed,
+				fmt.Sprintf("Password must differ from your last %d passwords", p.HistorySize))
+			return perr
+		}
+	}
+	return nil
+}
+
+// emailLocalPart returns the lowercased part of an email before the `@`,
+// without any `+tag`, or "" if it is too short to check for
+func emailLocalPart(email string) string {
+	local, _, found := strings.Cut(strings.ToLower(email), "@")
+	if !found {
+		return ""
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if utf8.RuneCountInString(local) < minEmailLocalPart {
+		return ""
+	}
+	return local
+}
+
+// envPolicyInt parses a non-negative integer env variable, returning `def` if it is unset
+func envPolicyInt(name string, def int) (int, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s must be a non-negative integer", apperrors.ErrPasswordPolicyConfig, name)
+	}
+	return n, nil
+}
+
+// AsPolicyError returns the *PolicyError in err's chain, if any
+func AsPolicyError(err error) (*PolicyError, bool) {
+	var perr *PolicyError
+	ok := errors.As(err, &perr)
+	return perr, ok
+}
+
+var (
+	activePolicy *Policy
+	policyMu     sync.RWMutex
+)
+
+// ActivePolicy returns the process-wide Policy, building it from the
+// environment on first use. Invalid configuration falls back to DefaultPolicy.
+func ActivePolicy() *Policy {
+	policyMu.RLock()
+	p := activePolicy
+	policyMu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	if activePolicy == nil {
+		p, err := NewPolicyFromEnv()
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid password policy config, using defaults")
+			p = DefaultPolicy()
+		}
+		activePolicy = p
+	}
+	return activePolicy
+}
+
+// SetPolicy replaces the process-wide Policy. Passing nil resets it so the
+// next call to ActivePolicy rebuilds it from the environment.
+func SetPolicy(p *Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	activePolicy = p
+}
@@ -0,0 +1,148 @@
+package passwords_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestPolicy_Check tests each password policy rule
+func TestPolicy_Check(t *testing.T) {
+	is := is.New(t)
+
+	policy := passwords.DefaultPolicy()
+
+	t.Run("accepts a strong password", func(t *testing.T) {
+		is.NoErr(policy.Check(testutils.TestingPassword, "someone@test.com"))
+	})
+
+	rejected := map[string]struct {
+		password string
+		rule     string
+		sentinel error
+	}{
+		"tooShort":      {"aB3$", passwords.RuleMinLength, apperrors.ErrPasswordTooShort},
+		"tooLong":       {strings.Repeat(testutils.TestingPassword, 6), passwords.RuleMaxLength, apperrors.ErrPasswordExceedsMax},
+		"lowEntropy":    {"aaaaaaaaaaaaaaaa", passwords.RuleEntropy, apperrors.ErrPasswordComplexity},
+		"common":        {"qwertyuiopasdfghjklzxcvbnm", passwords.RuleCommon, apperrors.ErrPasswordCommon},
+		"commonAnyCase": {"QwertyUiopAsdfGhjklZxcvbnm", passwords.RuleCommon, apperrors.ErrPasswordCommon},
+		"containsEmail": {"someone" + testutils.TestingPassword, passwords.RuleContainsEmail, apperrors.ErrPasswordContainsEmail},
+	}
+	for name, tc := range rejected {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Check(tc.password, "someone+tag@test.com")
+			perr, ok := passwords.AsPolicyError(err)
+			is.True(ok)
+			is.True(errors.Is(err, apperrors.ErrPasswordPolicy))
+			is.True(errors.Is(err, tc.sentinel))
+
+			var rules []string
+			for _, v := range perr.Violations {
+				is.Equal(v.Field, "password")
+				rules = append(rules, v.Rule)
+			}
+			is.True(strings.Contains(strings.Join(rules, ","), tc.rule))
+		})
+	}
+
+	t.Run("reports every failed rule", func(t *testing.T) {
+		perr, ok := passwords.AsPolicyError(policy.Check("abc", ""))
+		is.True(ok)
+		is.True(len(perr.Violations) >= 2)
+	})
+
+	t.Run("ignores short email local parts", func(t *testing.T) {
+		is.NoErr(policy.Check("ab"+testutils.TestingPassword, "ab@test.com"))
+	})
+
+	t.Run("bundled denylist allows the testing password", func(t *testing.T) {
+		err := policy.Check(testutils.TestingPassword, "")
+		is.True(!errors.Is(err, apperrors.ErrPasswordCommon))
+	})
+}
+
+// TestPolicy_CheckHistory tests that recent passwords cannot be reused
+func TestPolicy_CheckHistory(t *testing.T) {
+	is := is.New(t)
+
+	bc, err := passwords.NewBcryptHasher(4)
+	is.NoErr(err)
+	passwords.SetDefault(passwords.NewManager(bc))
+	t.Cleanup(func() { passwords.SetDefault(nil) })
+
+	var hashes []string
+	for _, pw := range []string{"newest" + testutils.TestingPassword, "middle" + testutils.TestingPassword, "oldest" + testutils.TestingPassword} {
+		hash, err := bc.Hash(pw)
+		is.NoErr(err)
+		hashes = append(hashes, hash)
+	}
+
+	policy := passwords.DefaultPolicy()
+	policy.HistorySize = 2
+
+	t.Run("rejects a recent password", func(t *testing.T) {
+		err := policy.CheckHistory("middle"+testutils.TestingPassword, hashes)
+		is.True(errors.Is(err, apperrors.ErrPasswordRecentlyUsed))
+	})
+
+	t.Run("allows passwords older than the history size", func(t *testing.T) {
+		is.NoErr(policy.CheckHistory("oldest"+testutils.TestingPassword, hashes))
+	})
+
+	t.Run("disabled with zero history size", func(t *testing.T) {
+		policy := passwords.DefaultPolicy()
+		policy.HistorySize = 0
+		is.NoErr(policy.CheckHistory("newest"+testutils.TestingPassword, hashes))
+	})
+}
+
+// TestNewPolicyFromEnv tests building a policy from env variables
+func TestNewPolicyFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("reads settings and extra denylist", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "denylist.txt")
+		is.NoErr(os.WriteFile(path, []byte("# company words\nAcmeWidgetsRocks2024!\n"), 0o600))
+
+		t.Setenv(config.PasswordMinLength, "12")
+		t.Setenv(config.PasswordMaxLength, "64")
+		t.Setenv(config.PasswordMinEntropy, "50")
+		t.Setenv(config.PasswordHistorySize, "3")
+		t.Setenv(config.PasswordDenylistFile, path)
+
+		policy, err := passwords.NewPolicyFromEnv()
+		is.NoErr(err)
+		is.Equal(policy.MinLength, 12)
+		is.Equal(policy.MaxLength, 64)
+		is.Equal(policy.MinEntropyBits, 50.0)
+		is.Equal(policy.HistorySize, 3)
+
+		is.True(errors.Is(policy.Check("acmewidgetsrocks2024!", ""), apperrors.ErrPasswordCommon))
+		// Bundled entries are still denied
+		is.True(errors.Is(policy.Check("qwertyuiopasdfghjklzxcvbnm", ""), apperrors.ErrPasswordCommon))
+	})
+
+	invalid := map[string][2]string{
+		"notANumber":    {config.PasswordMinLength, "eight"},
+		"negative":      {config.PasswordHistorySize, "-1"},
+		"zeroMinLength": {config.PasswordMinLength, "0"},
+		"maxBelowMin":   {config.PasswordMaxLength, "4"},
+		"missingFile":   {config.PasswordDenylistFile, "/does/not/exist"},
+	}
+	for name, env := range invalid {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			_, err := passwords.NewPolicyFromEnv()
+			is.True(errors.Is(err, apperrors.ErrPasswordPolicyConfig))
+		})
+	}
+}
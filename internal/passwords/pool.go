@@ -0,0 +1,75 @@
+package passwords
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/al-ce/goauth/internal/metrics"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// Pool bounds how many password hashes run at once, so a burst of logins
+// cannot take every CPU from cheaper requests. Callers beyond the limit wait
+// in a queue of bounded depth; once that is full they are refused with
+// ErrHashingBusy instead of piling up.
+type Pool struct {
+	slots      chan struct{}
+	queueDepth int64
+
+	waiting  atomic.Int64
+	inFlight atomic.Int64
+	rejected atomic.Uint64
+	wait     *metrics.Histogram
+}
+
+// NewPool returns a Pool running at most `concurrency` functions at once with
+// up to `queueDepth` callers waiting
+func NewPool(concurrency, queueDepth int) (*Pool, error) {
+	if concurrency < 1 || queueDepth < 0 {
+		return nil, fmt.Errorf("%w: concurrency must be at least 1 and queue depth not negative", apperrors.ErrHashParams)
+	}
+	return &Pool{
+		slots:      make(chan struct{}, concurrency),
+		queueDepth: int64(queueDepth),
+		wait:       metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+	}, nil
+}
+
+// Do runs f once a slot is free, or returns ErrHashingBusy without running it
+// if the queue is full
+func (p *Pool) Do(f func()) error {
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if p.waiting.Add(1) > p.queueDepth {
+			p.waiting.Add(-1)
+			p.rejected.Add(1)
+			return apperrors.ErrHashingBusy
+		}
+		p.slots <- struct{}{}
+		p.waiting.Add(-1)
+	}
+	p.wait.Observe(time.Since(start).Seconds())
+
+	p.inFlight.Add(1)
+	defer func() {
+		p.inFlight.Add(-1)
+		<-p.slots
+	}()
+	f()
+	return nil
+}
+
+// WriteMetrics writes the pool's queue wait time, utilization and rejections
+// in the Prometheus text format
+func (p *Pool) WriteMetrics(w io.Writer) {
+	p.wait.Write(w, "goauth_hash_queue_wait_seconds", "Time password hashing requests waited for a free slot.")
+	metrics.WriteGauge(w, "goauth_hash_in_flight", "Password hashes currently running.", float64(p.inFlight.Load()))
+	metrics.WriteGauge(w, "goauth_hash_queued", "Password hashing requests waiting for a free slot.", float64(p.waiting.Load()))
+	metrics.WriteGauge(w, "goauth_hash_concurrency_limit", "Maximum password hashes running at once.", float64(cap(p.slots)))
+	metrics.WriteGauge(w, "goauth_hash_queue_depth", "Maximum password hashing requests waiting.", float64(p.queueDepth))
+	metrics.WriteCounter(w, "goauth_hash_rejected_total", "Password hashing requests refused because the queue was full.", float64(p.rejected.Load()))
+}
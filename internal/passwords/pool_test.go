@@ -0,0 +1,146 @@
+package passwords_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestPool tests that the pool bounds concurrency and refuses callers once
+// the queue is full
+func TestPool(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("rejects invalid sizes", func(t *testing.T) {
+		_, err := passwords.NewPool(0, 1)
+		is.True(err != nil)
+		_, err = passwords.NewPool(1, -1)
+		is.True(err != nil)
+	})
+
+	t.Run("queues then rejects when saturated", func(t *testing.T) {
+		pool, err := passwords.NewPool(1, 1)
+		is.NoErr(err)
+
+		release := make(chan struct{})
+		running := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.Do(func() {
+				close(running)
+				<-release
+			})
+		}()
+		<-running
+
+		// Second caller waits in the queue
+		queued := make(chan error)
+		go func() { queued <- pool.Do(func() {}) }()
+		waitForMetric(t, pool, "goauth_hash_queued 1")
+
+		// Third caller is refused without running
+		ran := false
+		err = pool.Do(func() { ran = true })
+		is.Equal(err, apperrors.ErrHashingBusy)
+		is.True(!ran)
+
+		close(release)
+		wg.Wait()
+		is.NoErr(<-queued)
+
+		var b strings.Builder
+		pool.WriteMetrics(&b)
+		out := b.String()
+		is.True(strings.Contains(out, "goauth_hash_rejected_total 1\n"))
+		is.True(strings.Contains(out, "goauth_hash_queue_wait_seconds_count 2\n"))
+		is.True(strings.Contains(out, "goauth_hash_in_flight 0\n"))
+		is.True(strings.Contains(out, "goauth_hash_concurrency_limit 1\n"))
+	})
+
+	t.Run("manager reports busy from hash and verify", func(t *testing.T) {
+		bc, err := passwords.NewBcryptHasher(4)
+		is.NoErr(err)
+		manager := passwords.NewManager(bc)
+		manager.Pool, err = passwords.NewPool(1, 0)
+		is.NoErr(err)
+		hash, err := manager.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+
+		release := make(chan struct{})
+		running := make(chan struct{})
+		go manager.Pool.Do(func() {
+			close(running)
+			<-release
+		})
+		<-running
+		defer close(release)
+
+		_, err = manager.Hash(testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrHashingBusy)
+		match, _, err := manager.Verify(testutils.TestingPassword, hash)
+		is.Equal(err, apperrors.ErrHashingBusy)
+		is.True(!match)
+		is.Equal(manager.VerifyDummy(testutils.TestingPassword), apperrors.ErrHashingBusy)
+	})
+}
+
+// waitForMetric polls the pool's metrics until they contain `line`
+func waitForMetric(t *testing.T, pool *passwords.Pool, line string) {
+	t.Helper()
+	for range 1000 {
+		var b strings.Builder
+		pool.WriteMetrics(&b)
+		if strings.Contains(b.String(), line+"\n") {
+			return
+		}
+		runtime.Gosched()
+	}
+	t.Fatalf("metric %q not reported", line)
+}
+
+// BenchmarkManager_Verify measures verification throughput under parallel
+// load with and without a pool bounding concurrency to the number of CPUs
+func BenchmarkManager_Verify(b *testing.B) {
+	bc, err := passwords.NewBcryptHasher(4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	hash, err := bc.Hash(testutils.TestingPassword)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	levels := []int{0, 1}
+	if runtime.NumCPU() > 1 {
+		levels = append(levels, runtime.NumCPU())
+	}
+	for _, concurrency := range levels {
+		name := "unbounded"
+		manager := passwords.NewManager(bc)
+		if concurrency > 0 {
+			name = fmt.Sprintf("concurrency=%d", concurrency)
+			// A queue deep enough that no benchmark goroutine is refused
+			manager.Pool, _ = passwords.NewPool(concurrency, 1<<16)
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetParallelism(4)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := manager.Verify(testutils.TestingPassword, hash); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package passwords
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// scryptMaxCost bounds the work an imported hash can make us do: the memory
+// of each of its p mixes (128 * N * r bytes), times p
+const scryptMaxCost = 128 << 20
+
+// ScryptVerifier verifies Django's scrypt hashes:
+//
+//	scrypt$<N>$<salt>$<r>$<p>$<base64 key>
+type ScryptVerifier struct{}
+
+// scryptParams are the values stored in a Django scrypt hash
+type scryptParams struct {
+	n, r, p int
+	salt    string
+	key     []byte
+}
+
+// Verify derives the key with the stored cost parameters and salt
+func (v *ScryptVerifier) Verify(password, encoded string) (bool, error) {
+	params, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(password), []byte(params.salt), params.n, params.r, params.p, len(params.key))
+	if err != nil {
+		return false, apperrors.ErrHashMalformed
+	}
+	return subtle.ConstantTimeCompare(params.key, other) == 1, nil
+}
+
+// Identifies reports whether the hash is a Django scrypt hash
+func (v *ScryptVerifier) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "scrypt$")
+}
+
+// Validate checks the hash parses as a Django scrypt hash
+func (v *ScryptVerifier) Validate(encoded string) error {
+	_, err := decodeScrypt(encoded)
+	return err
+}
+
+// decodeScrypt parses a Django scrypt hash
+func decodeScrypt(encoded string) (*scryptParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "scrypt" || parts[2] == "" {
+		return nil, apperrors.ErrHashMalformed
+	}
+	var err error
+	params := &scryptParams{salt: parts[2]}
+	if params.n, err = strconv.Atoi(parts[1]); err != nil {
+		return nil, apperrors.ErrHashMalformed
+	}
+	if params.r, err = strconv.Atoi(parts[3]); err != nil {
+		return nil, apperrors.ErrHashMalformed
+	}
+	if params.p, err = strconv.Atoi(parts[4]); err != nil {
+		return nil, apperrors.ErrHashMalformed
+	}
+	// N must be a power of two greater than one
+	if params.n < 2 || params.n&(params.n-1) != 0 || params.r < 1 || params.p < 1 {
+		return nil, apperrors.ErrHashMalformed
+	}
+	// Divide rather than multiply so large parameters cannot overflow
+	if params.n > scryptMaxCost/128 ||
+		params.r > scryptMaxCost/128/params.n ||
+		params.p > scryptMaxCost/128/params.n/params.r {
+		return nil, apperrors.ErrHashParams
+	}
+	if params.key, err = base64.StdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, apperrors.ErrHashMalformed
+	}
+	return params, nil
+}
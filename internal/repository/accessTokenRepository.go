@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// AccessTokenRepository represents the entry point into the database for
+// managing the `access_tokens` table
+type AccessTokenRepository struct {
+	DB *gorm.DB
+}
+
+// NewAccessTokenRepository returns a value for the AccessTokenRepository struct
+func NewAccessTokenRepository(db *gorm.DB) (*AccessTokenRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &AccessTokenRepository{DB: db}, nil
+}
+
+// CreateAccessToken inserts a new token into the `access_tokens` table
+func (tr *AccessTokenRepository) CreateAccessToken(token *models.AccessToken) error {
+	return tr.DB.Create(token).Error
+}
+
+// GetUnexpiredAccessTokenByTokenHash retrieves a token by its hash with its
+// user, but ignores expired tokens
+func (tr *AccessTokenRepository) GetUnexpiredAccessTokenByTokenHash(tokenHash string) (*models.AccessToken, error) {
+	if tokenHash == "" {
+		return nil, apperrors.ErrSessionIdIsEmpty
+	}
+	var token models.AccessToken
+	result := tr.DB.Preload("User").
+		Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", tokenHash, time.Now().UTC()).
+		First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// GetAccessTokensByUserID retrieves all of a user's tokens, including expired
+// ones, newest first
+func (tr *AccessTokenRepository) GetAccessTokensByUserID(userID string) ([]models.AccessToken, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	var tokens []models.AccessToken
+	result := tr.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens)
+	return tokens, result.Error
+}
+
+// CountAccessTokensByUserID counts a user's tokens, including expired ones
+func (tr *AccessTokenRepository) CountAccessTokensByUserID(userID string) (int64, error) {
+	if userID == "" {
+		return 0, apperrors.ErrUserIdEmpty
+	}
+	var count int64
+	result := tr.DB.Model(&models.AccessToken{}).Where("user_id = ?", userID).Count(&count)
+	return count, result.Error
+}
+
+// UpdateAccessTokenLastUsed records when and from which IP a token was last used
+func (tr *AccessTokenRepository) UpdateAccessTokenLastUsed(tokenID uuid.UUID, usedAt time.Time, ip string) error {
+	if tokenID == uuid.Nil {
+		return apperrors.ErrSessionIdIsEmpty
+	}
+	return tr.DB.Model(&models.AccessToken{}).
+		Where("id = ?", tokenID).
+		Updates(map[string]any{"last_used_at": usedAt.UTC(), "last_used_ip": ip}).Error
+}
+
+// DeleteUserAccessTokenByID deletes one of a user's tokens by its ID. Tokens
+// belonging to other users are not found.
+func (tr *AccessTokenRepository) DeleteUserAccessTokenByID(userID string, tokenID uuid.UUID) error {
+	if userID == "" {
+		return apperrors.ErrUserIdEmpty
+	}
+	if tokenID == uuid.Nil {
+		return apperrors.ErrSessionIdIsEmpty
+	}
+	result := tr.DB.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.AccessToken{})
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
@@ -0,0 +1,98 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestAccessTokenRepository_NewAccessTokenRepository(t *testing.T) {
+	is := is.New(t)
+
+	_, err := repository.NewAccessTokenRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+}
+
+// TestAccessTokenRepository_Lifecycle tests creating, looking up, listing,
+// recording the use of and deleting access tokens
+func TestAccessTokenRepository_Lifecycle(t *testing.T) {
+	is := is.New(t)
+	tr := setupAccessTokenRepository(t)
+
+	user := &models.User{Email: "testAccessTokenRepository@test.com", Password: "password"}
+	is.NoErr(tr.DB.Create(user).Error)
+	userID := user.ID.String()
+
+	past := time.Now().UTC().Add(-time.Hour)
+	expired, err := models.NewAccessToken(user.ID, models.HashAccessToken("expired"), "expired", []string{models.ScopeProfile}, &past)
+	is.NoErr(err)
+	is.NoErr(tr.CreateAccessToken(expired))
+
+	token, err := models.NewAccessToken(user.ID, models.HashAccessToken("token"), "ci", []string{models.ScopeProfile}, nil)
+	is.NoErr(err)
+	is.NoErr(tr.CreateAccessToken(token))
+
+	t.Run("looks up unexpired tokens with their user", func(t *testing.T) {
+		found, err := tr.GetUnexpiredAccessTokenByTokenHash(models.HashAccessToken("token"))
+		is.NoErr(err)
+		is.Equal(found.ID, token.ID)
+		is.Equal(found.User.Email, user.Email)
+
+		_, err = tr.GetUnexpiredAccessTokenByTokenHash(models.HashAccessToken("expired"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+
+		_, err = tr.GetUnexpiredAccessTokenByTokenHash("")
+		is.Equal(err, apperrors.ErrSessionIdIsEmpty)
+	})
+
+	t.Run("lists and counts expired tokens too", func(t *testing.T) {
+		tokens, err := tr.GetAccessTokensByUserID(userID)
+		is.NoErr(err)
+		is.Equal(len(tokens), 2)
+
+		count, err := tr.CountAccessTokensByUserID(userID)
+		is.NoErr(err)
+		is.Equal(count, int64(2))
+	})
+
+	t.Run("records the last use", func(t *testing.T) {
+		usedAt := time.Now().UTC()
+		is.NoErr(tr.UpdateAccessTokenLastUsed(token.ID, usedAt, "203.0.113.7"))
+
+		found, err := tr.GetUnexpiredAccessTokenByTokenHash(models.HashAccessToken("token"))
+		is.NoErr(err)
+		is.True(found.LastUsedAt != nil)
+		is.Equal(found.LastUsedIP, "203.0.113.7")
+	})
+
+	t.Run("deletes only the user's own tokens", func(t *testing.T) {
+		err := tr.DeleteUserAccessTokenByID(uuid.New().String(), token.ID)
+		is.Equal(err, gorm.ErrRecordNotFound)
+
+		is.NoErr(tr.DeleteUserAccessTokenByID(userID, token.ID))
+		_, err = tr.GetUnexpiredAccessTokenByTokenHash(models.HashAccessToken("token"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+}
+
+func setupAccessTokenRepository(t *testing.T) *repository.AccessTokenRepository {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	tr, err := repository.NewAccessTokenRepository(tx)
+	if err != nil {
+		t.Fatalf("failed to create access token repository: %v", err)
+	}
+	return tr
+}
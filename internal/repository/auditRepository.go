@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// AuditRepository represents the entry point into the database for managing
+// the `audit_events` table
+type AuditRepository struct {
+	DB *gorm.DB
+}
+
+// NewAuditRepository returns a value for the AuditRepository struct
+func NewAuditRepository(db *gorm.DB) (*AuditRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &AuditRepository{DB: db}, nil
+}
+
+// CreateAuditEvent inserts a new event into the `audit_events` table
+func (ar *AuditRepository) CreateAuditEvent(event *models.AuditEvent) error {
+	return ar.DB.Create(event).Error
+}
+
+// GetAuditEventsByUserID returns the events about a user, oldest first
+func (ar *AuditRepository) GetAuditEventsByUserID(userID string) ([]models.AuditEvent, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	var events []models.AuditEvent
+	err := ar.DB.Where("user_id = ?", userID).Order("created_at").Find(&events).Error
+	return events, err
+}
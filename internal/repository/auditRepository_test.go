@@ -0,0 +1,42 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestAuditRepository_CreateAuditEvent tests recording and reading back audit
+// events, including for users that no longer exist
+func TestAuditRepository_CreateAuditEvent(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	ar, err := repository.NewAuditRepository(tx)
+	is.NoErr(err)
+
+	_, err = repository.NewAuditRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+
+	userID := uuid.New()
+	err = ar.CreateAuditEvent(models.NewAuditEvent(models.AuditAccountDeleted, userID, "deletion requested"))
+	is.NoErr(err)
+
+	events, err := ar.GetAuditEventsByUserID(userID.String())
+	is.NoErr(err)
+	is.Equal(len(events), 1)
+	is.Equal(events[0].Type, models.AuditAccountDeleted)
+	is.Equal(events[0].Details, "deletion requested")
+
+	_, err = ar.GetAuditEventsByUserID("")
+	is.Equal(err, apperrors.ErrUserIdEmpty)
+}
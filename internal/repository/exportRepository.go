@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// ExportRepository represents the entry point into the database for managing
+// the `data_exports` table
+type ExportRepository struct {
+	DB *gorm.DB
+}
+
+// NewExportRepository returns a value for the ExportRepository struct
+func NewExportRepository(db *gorm.DB) (*ExportRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &ExportRepository{DB: db}, nil
+}
+
+// CreateExport inserts a new export into the `data_exports` table
+func (er *ExportRepository) CreateExport(export *models.DataExport) error {
+	return er.DB.Create(export).Error
+}
+
+// ClaimPendingExports retrieves up to `limit` unexpired exports waiting to be
+// generated, oldest first, without their data column. They are claimed until
+// `lease` after `now`, so other jobs skip them until then.
+func (er *ExportRepository) ClaimPendingExports(now time.Time, limit int, lease time.Duration) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := er.DB.Transaction(func(tx *gorm.DB) error {
+		var pending []models.DataExport
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id").
+			Where("status = ? AND expires_at > ?", models.ExportStatusPending, now.UTC()).
+			Where("claimed_until IS NULL OR claimed_until <= ?", now.UTC()).
+			Order("created_at").
+			Limit(limit).
+			Find(&pending).Error
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(pending))
+		for _, export := range pending {
+			ids = append(ids, export.ID)
+		}
+		err = tx.Model(&models.DataExport{}).
+			Where("id IN ?", ids).
+			Update("claimed_until", now.Add(lease).UTC()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Omit("data").
+			Where("id IN ?", ids).
+			Order("created_at").
+			Find(&exports).Error
+	})
+	return exports, err
+}
+
+// GetUnexpiredExportByTokenHash retrieves an export by the hash of its
+// download token, unless its link has expired
+func (er *ExportRepository) GetUnexpiredExportByTokenHash(tokenHash string) (*models.DataExport, error) {
+	var export models.DataExport
+	result := er.DB.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now().UTC()).First(&export)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, apperrors.ErrExportNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &export, nil
+}
+
+// CompleteExport stores the generated data of a pending export and marks it
+// ready. It reports whether the export was still pending.
+func (er *ExportRepository) CompleteExport(exportID uuid.UUID, data []byte) (bool, error) {
+	return er.finishExport(exportID, map[string]any{
+		"status":       models.ExportStatusReady,
+		"data":         data,
+		"completed_at": time.Now().UTC(),
+	})
+}
+
+// FailExport marks a pending export as failed with the reason
+func (er *ExportRepository) FailExport(exportID uuid.UUID, reason string) error {
+	_, err := er.finishExport(exportID, map[string]any{
+		"status":       models.ExportStatusFailed,
+		"error":        reason,
+		"completed_at": time.Now().UTC(),
+	})
+	return err
+}
+
+// finishExport updates an export only if it is still pending, so an export
+// generated again after its claim expired is stored once
+func (er *ExportRepository) finishExport(exportID uuid.UUID, updates map[string]any) (bool, error) {
+	result := er.DB.Model(&models.DataExport{}).
+		Where("id = ? AND status = ?", exportID, models.ExportStatusPending).
+		Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteExpiredExports deletes exports whose download link expired before `now`
+func (er *ExportRepository) DeleteExpiredExports(now time.Time) (int64, error) {
+	result := er.DB.Where("expires_at <= ?", now.UTC()).Delete(&models.DataExport{})
+	return result.RowsAffected, result.Error
+}
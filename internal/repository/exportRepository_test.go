@@ -0,0 +1,89 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestExportRepository_NewExportRepository(t *testing.T) {
+	is := is.New(t)
+
+	_, err := repository.NewExportRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+}
+
+// TestExportRepository_ClaimPendingExports tests that pending exports are
+// claimed by one job at a time and finished once
+func TestExportRepository_ClaimPendingExports(t *testing.T) {
+	is := is.New(t)
+	er := setupExportRepository(t)
+
+	user := &models.User{Email: "testExportRepository@test.com"}
+	is.NoErr(er.DB.Create(user).Error)
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	first := models.NewDataExport(user.ID, user.ID, "json", models.HashSessionSecret("first"), expiresAt)
+	is.NoErr(er.CreateExport(first))
+	second := models.NewDataExport(user.ID, user.ID, "zip", models.HashSessionSecret("second"), expiresAt)
+	is.NoErr(er.CreateExport(second))
+
+	t.Run("claims pending exports once", func(t *testing.T) {
+		now := time.Now().UTC()
+		claimed, err := er.ClaimPendingExports(now, 10, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 2)
+		is.True(claimed[0].ClaimedUntil != nil)
+
+		claimed, err = er.ClaimPendingExports(now, 10, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 0)
+
+		// Expired claims are pending again
+		claimed, err = er.ClaimPendingExports(now.Add(2*time.Minute), 1, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 1)
+	})
+
+	t.Run("finishes exports once", func(t *testing.T) {
+		completed, err := er.CompleteExport(first.ID, []byte("{}"))
+		is.NoErr(err)
+		is.True(completed)
+
+		completed, err = er.CompleteExport(first.ID, []byte("{}"))
+		is.NoErr(err)
+		is.True(!completed)
+
+		is.NoErr(er.FailExport(second.ID, "could not encode"))
+		claimed, err := er.ClaimPendingExports(time.Now().UTC().Add(2*time.Minute), 10, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 0)
+	})
+
+	t.Run("ignores unknown exports", func(t *testing.T) {
+		completed, err := er.CompleteExport(uuid.New(), []byte("{}"))
+		is.NoErr(err)
+		is.True(!completed)
+	})
+}
+
+func setupExportRepository(t *testing.T) *repository.ExportRepository {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	er, err := repository.NewExportRepository(tx)
+	if err != nil {
+		t.Fatalf("failed to create export repository: %v", err)
+	}
+	return er
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// MagicLinkRepository represents the entry point into the database for
+// managing the `magic_link_tokens` table
+type MagicLinkRepository struct {
+	DB *gorm.DB
+}
+
+// NewMagicLinkRepository returns a value for the MagicLinkRepository struct
+func NewMagicLinkRepository(db *gorm.DB) (*MagicLinkRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &MagicLinkRepository{DB: db}, nil
+}
+
+// CreateMagicLinkToken inserts a new token into the `magic_link_tokens` table
+func (mr *MagicLinkRepository) CreateMagicLinkToken(token *models.MagicLinkToken) error {
+	return mr.DB.Create(token).Error
+}
+
+// CountMagicLinkTokensSince counts the tokens created since `since` for a
+// user and from `clientIP`, used or not. A nil `userID` counts none for the
+// user. Both are counted in one query, so requests for unknown emails cost the
+// same.
+func (mr *MagicLinkRepository) CountMagicLinkTokensSince(userID uuid.UUID, clientIP string, since time.Time) (forUser int64, fromIP int64, err error) {
+	var counts struct {
+		ForUser int64
+		FromIP  int64
+	}
+	result := mr.DB.Model(&models.MagicLinkToken{}).
+		Select("COUNT(*) FILTER (WHERE user_id = ?) AS for_user, COUNT(*) FILTER (WHERE client_ip = ?) AS from_ip", userID, clientIP).
+		Where("created_at > ?", since.UTC()).
+		Scan(&counts)
+	return counts.ForUser, counts.FromIP, result.Error
+}
+
+// GetUnusedMagicLinkTokenByHash retrieves a token by its hash with its user,
+// but ignores used, expired and unsent tokens
+func (mr *MagicLinkRepository) GetUnusedMagicLinkTokenByHash(tokenHash string) (*models.MagicLinkToken, error) {
+	if tokenHash == "" {
+		return nil, apperrors.ErrMagicLinkInvalid
+	}
+	var token models.MagicLinkToken
+	result := mr.DB.Preload("User").
+		Where("token_hash = ? AND user_id IS NOT NULL AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now().UTC()).
+		First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// UseMagicLinkToken marks a token as used at `usedAt`. Only one of concurrent
+// calls for the same token succeeds; the others find it used and get
+// ErrMagicLinkInvalid.
+func (mr *MagicLinkRepository) UseMagicLinkToken(tokenID uuid.UUID, usedAt time.Time) error {
+	if tokenID == uuid.Nil {
+		return apperrors.ErrMagicLinkInvalid
+	}
+	result := mr.DB.Model(&models.MagicLinkToken{}).
+		Where("id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", usedAt.UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrMagicLinkInvalid
+	}
+	return nil
+}
+
+// DeleteStaleMagicLinkTokens deletes tokens created before `before`, used
+// or not, returning the number deleted
+func (mr *MagicLinkRepository) DeleteStaleMagicLinkTokens(before time.Time) (int64, error) {
+	result := mr.DB.Where("created_at < ?", before.UTC()).Delete(&models.MagicLinkToken{})
+	return result.RowsAffected, result.Error
+}
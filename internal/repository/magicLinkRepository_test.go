@@ -0,0 +1,104 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestMagicLinkRepository_NewMagicLinkRepository(t *testing.T) {
+	is := is.New(t)
+
+	_, err := repository.NewMagicLinkRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+}
+
+// TestMagicLinkRepository_Lifecycle tests creating, counting, looking up,
+// using and deleting magic link tokens
+func TestMagicLinkRepository_Lifecycle(t *testing.T) {
+	is := is.New(t)
+	mr := setupMagicLinkRepository(t)
+
+	user := &models.User{Email: "testMagicLinkRepository@test.com"}
+	is.NoErr(mr.DB.Create(user).Error)
+
+	browserHash := models.HashMagicLinkToken("browser")
+	clientIP := "203.0.113.7"
+	expired, err := models.NewMagicLinkToken(user.ID, models.HashMagicLinkToken("expired"), browserHash, clientIP, time.Now().UTC().Add(-time.Minute))
+	is.NoErr(err)
+	expired.CreatedAt = time.Now().UTC().Add(-time.Hour)
+	is.NoErr(mr.CreateMagicLinkToken(expired))
+
+	token, err := models.NewMagicLinkToken(user.ID, models.HashMagicLinkToken("token"), browserHash, clientIP, time.Now().UTC().Add(time.Minute))
+	is.NoErr(err)
+	is.NoErr(mr.CreateMagicLinkToken(token))
+
+	unsent, err := models.NewUnsentMagicLinkToken(models.HashMagicLinkToken("unsent"), browserHash, clientIP, time.Now().UTC().Add(time.Minute))
+	is.NoErr(err)
+	is.NoErr(mr.CreateMagicLinkToken(unsent))
+
+	t.Run("counts recent tokens", func(t *testing.T) {
+		forUser, fromIP, err := mr.CountMagicLinkTokensSince(user.ID, clientIP, time.Now().UTC().Add(-30*time.Minute))
+		is.NoErr(err)
+		is.Equal(forUser, int64(1))
+		is.Equal(fromIP, int64(2))
+
+		forUser, fromIP, err = mr.CountMagicLinkTokensSince(uuid.Nil, "198.51.100.1", time.Now().UTC().Add(-30*time.Minute))
+		is.NoErr(err)
+		is.Equal(forUser, int64(0))
+		is.Equal(fromIP, int64(0))
+	})
+
+	t.Run("looks up unused tokens with their user", func(t *testing.T) {
+		found, err := mr.GetUnusedMagicLinkTokenByHash(models.HashMagicLinkToken("token"))
+		is.NoErr(err)
+		is.Equal(found.ID, token.ID)
+		is.Equal(found.BrowserHash, browserHash)
+		is.Equal(found.User.Email, user.Email)
+
+		_, err = mr.GetUnusedMagicLinkTokenByHash(models.HashMagicLinkToken("expired"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+		_, err = mr.GetUnusedMagicLinkTokenByHash(models.HashMagicLinkToken("unsent"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("uses tokens once", func(t *testing.T) {
+		is.NoErr(mr.UseMagicLinkToken(token.ID, time.Now().UTC()))
+		is.Equal(mr.UseMagicLinkToken(token.ID, time.Now().UTC()), apperrors.ErrMagicLinkInvalid)
+
+		_, err := mr.GetUnusedMagicLinkTokenByHash(models.HashMagicLinkToken("token"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("deletes stale tokens", func(t *testing.T) {
+		deleted, err := mr.DeleteStaleMagicLinkTokens(time.Now().UTC().Add(-30 * time.Minute))
+		is.NoErr(err)
+		is.Equal(deleted, int64(1))
+
+		var count int64
+		is.NoErr(mr.DB.Model(&models.MagicLinkToken{}).Where("client_ip = ?", clientIP).Count(&count).Error)
+		is.Equal(count, int64(2))
+	})
+}
+
+func setupMagicLinkRepository(t *testing.T) *repository.MagicLinkRepository {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	mr, err := repository.NewMagicLinkRepository(tx)
+	if err != nil {
+		t.Fatalf("failed to create magic link repository: %v", err)
+	}
+	return mr
+}
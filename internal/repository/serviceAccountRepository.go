@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// ServiceAccountRepository represents the entry point into the database for
+// managing the `service_accounts`, `service_account_tokens` and
+// `client_assertions` tables
+type ServiceAccountRepository struct {
+	DB *gorm.DB
+}
+
+// NewServiceAccountRepository returns a value for the ServiceAccountRepository struct
+func NewServiceAccountRepository(db *gorm.DB) (*ServiceAccountRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &ServiceAccountRepository{DB: db}, nil
+}
+
+// CreateServiceAccount inserts a new service account into the
+// `service_accounts` table
+func (sr *ServiceAccountRepository) CreateServiceAccount(account *models.ServiceAccount) error {
+	return sr.DB.Create(account).Error
+}
+
+// GetServiceAccounts retrieves every service account, newest first
+func (sr *ServiceAccountRepository) GetServiceAccounts() ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	result := sr.DB.Order("created_at DESC").Find(&accounts)
+	return accounts, result.Error
+}
+
+// GetServiceAccountByID retrieves a service account by its ID
+func (sr *ServiceAccountRepository) GetServiceAccountByID(id uuid.UUID) (*models.ServiceAccount, error) {
+	if id == uuid.Nil {
+		return nil, apperrors.ErrServiceAccountIdEmpty
+	}
+	var account models.ServiceAccount
+	if err := sr.DB.Where("id = ?", id).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// GetServiceAccountByClientID retrieves a service account by its client ID
+func (sr *ServiceAccountRepository) GetServiceAccountByClientID(clientID string) (*models.ServiceAccount, error) {
+	if clientID == "" {
+		return nil, apperrors.ErrClientIDEmpty
+	}
+	var account models.ServiceAccount
+	if err := sr.DB.Where("client_id = ?", clientID).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// UpdateServiceAccountSecret replaces a service account's secret hash and
+// deletes the tokens issued with the old secret
+func (sr *ServiceAccountRepository) UpdateServiceAccountSecret(id uuid.UUID, secretHash string) error {
+	if id == uuid.Nil {
+		return apperrors.ErrServiceAccountIdEmpty
+	}
+	if secretHash == "" {
+		return apperrors.ErrSecretHashIsEmpty
+	}
+	return sr.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ServiceAccount{}).
+			Where("id = ?", id).
+			Update("secret_hash", secretHash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("service_account_id = ?", id).Delete(&models.ServiceAccountToken{}).Error
+	})
+}
+
+// UpdateServiceAccountLastUsed records when a service account last got a token
+func (sr *ServiceAccountRepository) UpdateServiceAccountLastUsed(id uuid.UUID, usedAt time.Time) error {
+	if id == uuid.Nil {
+		return apperrors.ErrServiceAccountIdEmpty
+	}
+	return sr.DB.Model(&models.ServiceAccount{}).
+		Where("id = ?", id).
+		Update("last_used_at", usedAt.UTC()).Error
+}
+
+// DeleteServiceAccountByID deletes a service account, along with its tokens
+// and client assertions
+func (sr *ServiceAccountRepository) DeleteServiceAccountByID(id uuid.UUID) error {
+	if id == uuid.Nil {
+		return apperrors.ErrServiceAccountIdEmpty
+	}
+	result := sr.DB.Where("id = ?", id).Delete(&models.ServiceAccount{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateServiceAccountToken inserts a new token into the
+// `service_account_tokens` table
+func (sr *ServiceAccountRepository) CreateServiceAccountToken(token *models.ServiceAccountToken) error {
+	return sr.DB.Create(token).Error
+}
+
+// GetUnexpiredServiceAccountTokenByTokenHash retrieves a token by its hash
+// with its service account, but ignores expired tokens
+func (sr *ServiceAccountRepository) GetUnexpiredServiceAccountTokenByTokenHash(tokenHash string) (*models.ServiceAccountToken, error) {
+	if tokenHash == "" {
+		return nil, apperrors.ErrSessionIdIsEmpty
+	}
+	var token models.ServiceAccountToken
+	result := sr.DB.Preload("ServiceAccount").
+		Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now().UTC()).
+		First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// RecordClientAssertion stores the ID of a client assertion until it expires.
+// It reports false if the service account already used the ID.
+func (sr *ServiceAccountRepository) RecordClientAssertion(assertion *models.ClientAssertion) (bool, error) {
+	result := sr.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(assertion)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteExpiredServiceAccountTokens deletes tokens and client assertion IDs
+// that expired before `now`, returning the number of tokens deleted
+func (sr *ServiceAccountRepository) DeleteExpiredServiceAccountTokens(now time.Time) (int64, error) {
+	var deleted int64
+	err := sr.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("expires_at <= ?", now.UTC()).Delete(&models.ServiceAccountToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Where("expires_at <= ?", now.UTC()).Delete(&models.ClientAssertion{}).Error
+	})
+	return deleted, err
+}
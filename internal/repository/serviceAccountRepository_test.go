@@ -0,0 +1,132 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestServiceAccountRepository_NewServiceAccountRepository(t *testing.T) {
+	is := is.New(t)
+
+	_, err := repository.NewServiceAccountRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+}
+
+// TestServiceAccountRepository_EmptyArguments tests empty IDs and hashes are
+// refused before the database is queried
+func TestServiceAccountRepository_EmptyArguments(t *testing.T) {
+	is := is.New(t)
+	sr, err := repository.NewServiceAccountRepository(&gorm.DB{})
+	is.NoErr(err)
+
+	_, err = sr.GetServiceAccountByID(uuid.Nil)
+	is.Equal(err, apperrors.ErrServiceAccountIdEmpty)
+	_, err = sr.GetServiceAccountByClientID("")
+	is.Equal(err, apperrors.ErrClientIDEmpty)
+	is.Equal(sr.UpdateServiceAccountSecret(uuid.Nil, "hash"), apperrors.ErrServiceAccountIdEmpty)
+	is.Equal(sr.UpdateServiceAccountSecret(uuid.New(), ""), apperrors.ErrSecretHashIsEmpty)
+	is.Equal(sr.UpdateServiceAccountLastUsed(uuid.Nil, time.Now()), apperrors.ErrServiceAccountIdEmpty)
+	is.Equal(sr.DeleteServiceAccountByID(uuid.Nil), apperrors.ErrServiceAccountIdEmpty)
+}
+
+// TestServiceAccountRepository_Lifecycle tests creating, looking up, rotating
+// the secret of and deleting service accounts and their tokens
+func TestServiceAccountRepository_Lifecycle(t *testing.T) {
+	is := is.New(t)
+	sr := setupServiceAccountRepository(t)
+
+	account, err := models.NewServiceAccount("billing", []string{models.ScopeProfile}, models.HashServiceAccountSecret("secret"), "", uuid.New())
+	is.NoErr(err)
+	is.NoErr(sr.CreateServiceAccount(account))
+
+	expired, err := models.NewServiceAccountToken(account.ID, models.HashServiceAccountSecret("expired"), []string{models.ScopeProfile}, time.Now().Add(-time.Minute))
+	is.NoErr(err)
+	is.NoErr(sr.CreateServiceAccountToken(expired))
+
+	token, err := models.NewServiceAccountToken(account.ID, models.HashServiceAccountSecret("token"), []string{models.ScopeProfile}, time.Now().Add(time.Minute))
+	is.NoErr(err)
+	is.NoErr(sr.CreateServiceAccountToken(token))
+
+	t.Run("looks up accounts by client ID", func(t *testing.T) {
+		found, err := sr.GetServiceAccountByClientID(account.ClientID)
+		is.NoErr(err)
+		is.Equal(found.ID, account.ID)
+
+		_, err = sr.GetServiceAccountByClientID(models.ClientIDPrefix + "unknown")
+		is.Equal(err, gorm.ErrRecordNotFound)
+
+		accounts, err := sr.GetServiceAccounts()
+		is.NoErr(err)
+		is.Equal(len(accounts), 1)
+	})
+
+	t.Run("looks up unexpired tokens with their account", func(t *testing.T) {
+		found, err := sr.GetUnexpiredServiceAccountTokenByTokenHash(models.HashServiceAccountSecret("token"))
+		is.NoErr(err)
+		is.Equal(found.ServiceAccount.ClientID, account.ClientID)
+
+		_, err = sr.GetUnexpiredServiceAccountTokenByTokenHash(models.HashServiceAccountSecret("expired"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("records each client assertion once", func(t *testing.T) {
+		assertion := models.ClientAssertion{ServiceAccountID: account.ID, JTI: "jti", ExpiresAt: time.Now().Add(-time.Minute)}
+		fresh, err := sr.RecordClientAssertion(&assertion)
+		is.NoErr(err)
+		is.True(fresh)
+
+		again := assertion
+		fresh, err = sr.RecordClientAssertion(&again)
+		is.NoErr(err)
+		is.True(!fresh)
+	})
+
+	t.Run("purges expired tokens and assertions", func(t *testing.T) {
+		deleted, err := sr.DeleteExpiredServiceAccountTokens(time.Now())
+		is.NoErr(err)
+		is.Equal(deleted, int64(1))
+
+		var assertions int64
+		is.NoErr(sr.DB.Model(&models.ClientAssertion{}).Count(&assertions).Error)
+		is.Equal(assertions, int64(0))
+	})
+
+	t.Run("rotating the secret revokes tokens", func(t *testing.T) {
+		is.NoErr(sr.UpdateServiceAccountSecret(account.ID, models.HashServiceAccountSecret("rotated")))
+		_, err := sr.GetUnexpiredServiceAccountTokenByTokenHash(models.HashServiceAccountSecret("token"))
+		is.Equal(err, gorm.ErrRecordNotFound)
+
+		is.Equal(sr.UpdateServiceAccountSecret(uuid.New(), models.HashServiceAccountSecret("rotated")), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("deletes accounts", func(t *testing.T) {
+		is.NoErr(sr.DeleteServiceAccountByID(account.ID))
+		is.Equal(sr.DeleteServiceAccountByID(account.ID), gorm.ErrRecordNotFound)
+
+		_, err := sr.GetServiceAccountByID(account.ID)
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+}
+
+func setupServiceAccountRepository(t *testing.T) *repository.ServiceAccountRepository {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	sr, err := repository.NewServiceAccountRepository(tx)
+	if err != nil {
+		t.Fatalf("failed to create service account repository: %v", err)
+	}
+	return sr
+}
@@ -42,7 +42,44 @@ func (sr *SessionRepository) CreateSession(session *models.Session) error {
 	return sr.DB.Create(session).Error
 }
 
-// GetUnexpiredSessionByID retrieves a session from the database by sessionID, but ignores any expired sessions
+// GetUnexpiredSessionByTokenHash retrieves a session from the database by the
+// hash of its token's secret, but ignores any expired sessions
+func (sr *SessionRepository) GetUnexpiredSessionByTokenHash(tokenHash string) (*models.Session, error) {
+	if tokenHash == "" {
+		return nil, apperrors.ErrSessionIdIsEmpty
+	}
+	var session models.Session
+	result := sr.DB.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now().UTC()).First(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// GetUnexpiredSessionsByUserID retrieves all of a user's unexpired sessions, newest first
+func (sr *SessionRepository) GetUnexpiredSessionsByUserID(userID string) ([]models.Session, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	var sessions []models.Session
+	result := sr.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now().UTC()).
+		Order("created_at DESC").
+		Find(&sessions)
+	return sessions, result.Error
+}
+
+// GetSessionsByUserID retrieves all of a user's sessions, including expired
+// ones not yet cleaned up, oldest first
+func (sr *SessionRepository) GetSessionsByUserID(userID string) ([]models.Session, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	var sessions []models.Session
+	result := sr.DB.Where("user_id = ?", userID).Order("created_at").Find(&sessions)
+	return sessions, result.Error
+}
+
+// GetUnexpiredSessionByID retrieves a session from the database by its public ID, but ignores any expired sessions
 func (sr *SessionRepository) GetUnexpiredSessionByID(sessionID uuid.UUID) (*models.Session, error) {
 	if sessionID == uuid.Nil {
 		return nil, apperrors.ErrSessionIdIsEmpty
@@ -55,7 +92,51 @@ func (sr *SessionRepository) GetUnexpiredSessionByID(sessionID uuid.UUID) (*mode
 	return &session, nil
 }
 
-// DeleteSessionByID deletes a single session from the database by sessionID
+// UpdateSessionAuthTime records that the user authenticated again in a session
+func (sr *SessionRepository) UpdateSessionAuthTime(sessionID uuid.UUID, authTime time.Time) error {
+	if sessionID == uuid.Nil {
+		return apperrors.ErrSessionIdIsEmpty
+	}
+	result := sr.DB.Model(&models.Session{}).Where("id = ?", sessionID).Update("auth_time", authTime.UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteSessionByTokenHash deletes a single session from the database by the
+// hash of its token's secret
+func (sr *SessionRepository) DeleteSessionByTokenHash(tokenHash string) error {
+	if tokenHash == "" {
+		return apperrors.ErrSessionIdIsEmpty
+	}
+	result := sr.DB.Where("token_hash = ?", tokenHash).Delete(&models.Session{})
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// DeleteUserSessionByID deletes one of a user's sessions by its public ID.
+// Sessions belonging to other users are not found.
+func (sr *SessionRepository) DeleteUserSessionByID(userID string, sessionID uuid.UUID) error {
+	if userID == "" {
+		return apperrors.ErrUserIdEmpty
+	}
+	if sessionID == uuid.Nil {
+		return apperrors.ErrSessionIdIsEmpty
+	}
+	result := sr.DB.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&models.Session{})
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// DeleteSessionByID deletes a single session from the database by its public ID
 func (sr *SessionRepository) DeleteSessionByID(sessionID uuid.UUID) error {
 	if sessionID == uuid.Nil {
 		return apperrors.ErrSessionIdIsEmpty
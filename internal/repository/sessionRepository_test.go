@@ -58,7 +58,7 @@ func TestSessionRepository_CreateSession(t *testing.T) {
 		err := sr.DB.Create(user).Error
 		is.NoErr(err)
 
-		session, err := models.NewSession(user.ID, uuid.New(), time.Now().UTC().Add(1*time.Hour))
+		session, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 
 		err = sr.CreateSession(session)
@@ -76,10 +76,8 @@ func TestSessionRepository_CreateSession(t *testing.T) {
 		err := sr.DB.Create(user).Error
 		is.NoErr(err)
 
-		sessionID := uuid.New()
-
 		// Insert first session
-		sessionOne, err := models.NewSession(user.ID, sessionID, time.Now().UTC().Add(1*time.Hour))
+		sessionOne, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 
 		err = sr.CreateSession(sessionOne)
@@ -88,10 +86,11 @@ func TestSessionRepository_CreateSession(t *testing.T) {
 		// Insert second session with same ID (expect error)
 		sessionTwo, err := models.NewSession(
 			user.ID,
-			sessionID,
+			models.HashSessionSecret(uuid.NewString()),
 			time.Now().UTC().Add(1*time.Hour),
 		)
 		is.NoErr(err)
+		sessionTwo.ID = sessionOne.ID
 
 		err = sr.CreateSession(sessionTwo)
 		is.Equal(err, apperrors.ErrSessionAlreadyExists)
@@ -130,7 +129,7 @@ func TestSessionRepository_GetUnexpiredSessionByID(t *testing.T) {
 		is.NoErr(err)
 
 		// Insert associated session
-		session, err := models.NewSession(user.ID, uuid.New(), time.Now().UTC().Add(1*time.Hour))
+		session, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 		err = sr.CreateSession(session)
 		is.NoErr(err)
@@ -172,7 +171,7 @@ func TestSessionRepository_DeleteSessionByID(t *testing.T) {
 		is.NoErr(err)
 
 		// Insert session to delete
-		session, err := models.NewSession(user.ID, uuid.New(), time.Now().UTC().Add(1*time.Hour))
+		session, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 		err = sr.CreateSession(session)
 		is.NoErr(err)
@@ -217,13 +216,13 @@ func TestSessionRepository_DeleteSessionsByUserID(t *testing.T) {
 		is.NoErr(err)
 
 		// Insert first session associated with user
-		sessionOne, err := models.NewSession(user.ID, uuid.New(), time.Now().UTC().Add(1*time.Hour))
+		sessionOne, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 		err = sr.CreateSession(sessionOne)
 		is.NoErr(err)
 
 		// Insert second session associated with user
-		sessionTwo, err := models.NewSession(user.ID, uuid.New(), time.Now().UTC().Add(1*time.Hour))
+		sessionTwo, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
 		is.NoErr(err)
 		err = sr.CreateSession(sessionTwo)
 		is.NoErr(err)
@@ -246,6 +245,92 @@ func TestSessionRepository_DeleteSessionsByUserID(t *testing.T) {
 	})
 }
 
+func TestSessionRepository_TokenHash(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("fails on empty token hash", func(t *testing.T) {
+		sr := setupSessionRepository(t)
+
+		session, err := sr.GetUnexpiredSessionByTokenHash("")
+		is.Equal(session, nil)
+		is.Equal(err, apperrors.ErrSessionIdIsEmpty)
+		is.Equal(sr.DeleteSessionByTokenHash(""), apperrors.ErrSessionIdIsEmpty)
+	})
+
+	t.Run("retrieves and deletes session by token hash", func(t *testing.T) {
+		sr := setupSessionRepository(t)
+
+		user := &models.User{
+			Email:    "testSessionByTokenHash@test.com",
+			Password: "password",
+		}
+		err := sr.DB.Create(user).Error
+		is.NoErr(err)
+
+		_, tokenHash, err := models.GenerateSessionToken()
+		is.NoErr(err)
+		session, err := models.NewSession(user.ID, tokenHash, time.Now().UTC().Add(1*time.Hour))
+		is.NoErr(err)
+		err = sr.CreateSession(session)
+		is.NoErr(err)
+
+		retrievedSession, err := sr.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.NoErr(err)
+		is.Equal(retrievedSession.ID, session.ID)
+
+		err = sr.DeleteSessionByTokenHash(tokenHash)
+		is.NoErr(err)
+		_, err = sr.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestSessionRepository_UserSessions(t *testing.T) {
+	is := is.New(t)
+
+	sr := setupSessionRepository(t)
+
+	users := []*models.User{
+		{Email: "testUserSessionsOne@test.com", Password: "password"},
+		{Email: "testUserSessionsTwo@test.com", Password: "password"},
+	}
+	var sessions []*models.Session
+	for _, user := range users {
+		err := sr.DB.Create(user).Error
+		is.NoErr(err)
+		session, err := models.NewSession(user.ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(1*time.Hour))
+		is.NoErr(err)
+		err = sr.CreateSession(session)
+		is.NoErr(err)
+		sessions = append(sessions, session)
+	}
+	expired, err := models.NewSession(users[0].ID, models.HashSessionSecret(uuid.NewString()), time.Now().UTC().Add(-1*time.Hour))
+	is.NoErr(err)
+	err = sr.CreateSession(expired)
+	is.NoErr(err)
+
+	t.Run("lists a user's unexpired sessions", func(t *testing.T) {
+		listed, err := sr.GetUnexpiredSessionsByUserID(users[0].ID.String())
+		is.NoErr(err)
+		is.Equal(len(listed), 1)
+		is.Equal(listed[0].ID, sessions[0].ID)
+	})
+
+	t.Run("does not delete another user's session", func(t *testing.T) {
+		err := sr.DeleteUserSessionByID(users[0].ID.String(), sessions[1].ID)
+		is.Equal(err, gorm.ErrRecordNotFound)
+		_, err = sr.GetUnexpiredSessionByID(sessions[1].ID)
+		is.NoErr(err)
+	})
+
+	t.Run("deletes a user's session by public ID", func(t *testing.T) {
+		err := sr.DeleteUserSessionByID(users[0].ID.String(), sessions[0].ID)
+		is.NoErr(err)
+		_, err = sr.GetUnexpiredSessionByID(sessions[0].ID)
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+}
+
 func setupSessionRepository(t *testing.T) *repository.SessionRepository {
 	t.Helper()
 
@@ -259,3 +344,30 @@ func setupSessionRepository(t *testing.T) *repository.SessionRepository {
 	}
 	return sr
 }
+
+// TestSessionRepository_UpdateSessionAuthTime tests recording a new auth time
+// for a session
+func TestSessionRepository_UpdateSessionAuthTime(t *testing.T) {
+	is := is.New(t)
+	sr := setupSessionRepository(t)
+
+	is.Equal(sr.UpdateSessionAuthTime(uuid.Nil, time.Now()), apperrors.ErrSessionIdIsEmpty)
+	is.Equal(sr.UpdateSessionAuthTime(uuid.New(), time.Now()), gorm.ErrRecordNotFound)
+
+	user := &models.User{
+		Email:    "testUpdateSessionAuthTime@test.com",
+		Password: "password",
+	}
+	is.NoErr(sr.DB.Create(user).Error)
+	_, tokenHash, err := models.GenerateSessionToken()
+	is.NoErr(err)
+	session, err := models.NewSession(user.ID, tokenHash, time.Now().UTC().Add(time.Hour))
+	is.NoErr(err)
+	is.NoErr(sr.CreateSession(session))
+
+	authTime := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	is.NoErr(sr.UpdateSessionAuthTime(session.ID, authTime))
+	updated, err := sr.GetUnexpiredSessionByID(session.ID)
+	is.NoErr(err)
+	is.True(updated.AuthTime.Equal(authTime))
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/al-ce/goauth/internal/models"
 	"github.com/al-ce/goauth/pkg/apperrors"
@@ -41,7 +42,7 @@ func (ur *UserRepository) RegisterUser(u *models.User) error {
 	return err
 }
 
-// GetUserByEmail gets a user in the database by email
+// GetUserByEmail gets a user in the database by the canonical form of an email
 func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 	if email == "" {
 		return nil, apperrors.ErrEmailIsEmpty
@@ -49,7 +50,7 @@ func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 
 	var user models.User
 
-	result := r.DB.First(&user, "email = ?", email)
+	result := r.DB.First(&user, "email_canonical = ?", models.CanonicalEmail(email))
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -86,6 +87,76 @@ func (r *UserRepository) PermanentlyDeleteUser(userID string) (int64, error) {
 	return result.RowsAffected, result.Error
 }
 
+// ListUsers returns up to `limit` users ordered by canonical email, skipping the first `offset`
+func (r *UserRepository) ListUsers(limit, offset int) ([]models.User, error) {
+	var users []models.User
+	result := r.DB.Order("email_canonical").Limit(limit).Offset(offset).Find(&users)
+	return users, result.Error
+}
+
+// ScheduleDeletion marks a user as pending deletion until `purgeAfter`,
+// storing the hash of the token that can restore the account
+func (r *UserRepository) ScheduleDeletion(userID string, restoreTokenHash string, requestedAt, purgeAfter time.Time) error {
+	if userID == "" {
+		return apperrors.ErrUserIdEmpty
+	}
+	result := r.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]any{
+			"deletion_requested_at": requestedAt.UTC(),
+			"purge_after":           purgeAfter.UTC(),
+			"restore_token_hash":    restoreTokenHash,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrUserNotFound
+	}
+	return nil
+}
+
+// RestoreUser cancels the pending deletion of the user whose restore token
+// has the given hash, if the grace period has not ended
+func (r *UserRepository) RestoreUser(restoreTokenHash string) (*models.User, error) {
+	var users []models.User
+	result := r.DB.Model(&users).Clauses(clause.Returning{}).
+		Where("restore_token_hash = ?", restoreTokenHash).
+		Where("purge_after > ?", time.Now().UTC()).
+		Updates(map[string]any{
+			"deletion_requested_at": nil,
+			"purge_after":           nil,
+			"restore_token_hash":    nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(users) == 0 {
+		return nil, apperrors.ErrRestoreTokenInvalid
+	}
+	return &users[0], nil
+}
+
+// GetUsersDueForPurge retrieves the users whose grace period ended before
+// `now`
+func (r *UserRepository) GetUsersDueForPurge(now time.Time) ([]models.User, error) {
+	var users []models.User
+	result := r.DB.Unscoped().Where("purge_after <= ?", now.UTC()).Find(&users)
+	return users, result.Error
+}
+
+// PurgeDeletedUser permanently deletes a user whose grace period ended
+// before `now` and returns the number deleted, so a user restored in the
+// meantime is kept
+func (r *UserRepository) PurgeDeletedUser(userID string, now time.Time) (int64, error) {
+	if userID == "" {
+		return 0, apperrors.ErrUserIdEmpty
+	}
+	result := r.DB.Unscoped().
+		Where("id = ? AND purge_after <= ?", userID, now.UTC()).
+		Delete(&models.User{})
+	return result.RowsAffected, result.Error
+}
+
 // UpdateUser updates a user in the database by usedID, expecting a decoded request to pass updated fields
 func (r *UserRepository) UpdateUser(userID string, request map[string]any) error {
 	if userID == "" {
@@ -97,6 +168,11 @@ func (r *UserRepository) UpdateUser(userID string, request map[string]any) error
 		return apperrors.ErrUserNotFound
 	}
 
+	// Map updates skip model hooks, so keep the canonical email in sync here
+	if email, ok := request["email"].(string); ok && email != "" {
+		request["email_canonical"] = models.CanonicalEmail(email)
+	}
+
 	result := r.DB.Model(&models.User{}).Where("id = ?", userID).Updates(request)
 	if result.Error != nil {
 		return result.Error
@@ -140,6 +216,13 @@ func (r *UserRepository) IncrementFailedLogins(userID string) error {
 
 // LockAccount locks a user account until the time spec'd in `config`
 func (r *UserRepository) LockAccount(userID string) error {
+	until := time.Now().UTC().Add(config.AccountLockoutLength)
+	return r.LockAccountUntil(userID, &until)
+}
+
+// LockAccountUntil locks a user account until `until`, or until it is
+// unlocked if `until` is nil
+func (r *UserRepository) LockAccountUntil(userID string, until *time.Time) error {
 	// Validate user ID
 	if userID == "" {
 		return apperrors.ErrUserIdEmpty
@@ -148,7 +231,7 @@ func (r *UserRepository) LockAccount(userID string) error {
 	result := r.DB.Model(&models.User{}).Where("id = ?", userID).
 		Updates(map[string]any{
 			"account_locked":       true,
-			"account_locked_until": time.Now().UTC().Add(config.AccountLockoutLength),
+			"account_locked_until": until,
 		})
 
 	if result.Error != nil {
@@ -201,3 +284,48 @@ func (r *UserRepository) UnlockAllExpiredLocks() (int64, error) {
 	}
 	return result.RowsAffected, nil
 }
+
+// AddPasswordHistory records a password hash the user has stopped using
+func (r *UserRepository) AddPasswordHistory(userID uuid.UUID, hash string) error {
+	if userID == uuid.Nil {
+		return apperrors.ErrUserIdEmpty
+	}
+	if hash == "" {
+		return apperrors.ErrPasswordIsEmpty
+	}
+	return r.DB.Create(&models.PasswordHistory{
+		UserID:    userID,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	}).Error
+}
+
+// GetPasswordHistory returns up to `limit` of a user's previous password hashes, newest first
+func (r *UserRepository) GetPasswordHistory(userID string, limit int) ([]string, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	var hashes []string
+	result := r.DB.Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Pluck("hash", &hashes)
+	return hashes, result.Error
+}
+
+// PrunePasswordHistory deletes all but the newest `keep` previous password hashes of a user
+func (r *UserRepository) PrunePasswordHistory(userID string, keep int) error {
+	if userID == "" {
+		return apperrors.ErrUserIdEmpty
+	}
+	newest := r.DB.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(keep)
+	return r.DB.
+		Where("user_id = ?", userID).
+		Where("id NOT IN (?)", newest).
+		Delete(&models.PasswordHistory{}).Error
+}
@@ -2,6 +2,7 @@ package repository_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -135,6 +136,43 @@ func TestUserRepository_GetUserByEmail(t *testing.T) {
 		is.Equal(dbUser.Email, "testGetUserByEmail@test.com")
 		is.Equal(dbUser.Password, "password")
 	})
+
+	// Lookup is by canonical email, the display form is kept
+	t.Run("looks up by canonical email", func(t *testing.T) {
+		ur := setupUserRepository(t)
+
+		user := &models.User{
+			Email:    "Bob@Example.com",
+			Password: "password",
+		}
+		err := ur.RegisterUser(user)
+		is.NoErr(err)
+
+		dbUser, err := ur.GetUserByEmail("  bob@EXAMPLE.COM ")
+		is.NoErr(err)
+		is.Equal(dbUser.ID, user.ID)
+		is.Equal(dbUser.Email, "Bob@Example.com")
+		is.Equal(dbUser.EmailCanonical, "bob@example.com")
+
+		// Canonical email is unique
+		err = ur.RegisterUser(&models.User{Email: "BOB@example.com", Password: "password"})
+		is.True(err != nil)
+	})
+
+	// Updating the email updates the canonical form
+	t.Run("update keeps canonical email in sync", func(t *testing.T) {
+		ur := setupUserRepository(t)
+
+		user := &models.User{Email: "before@test.com", Password: "password"}
+		err := ur.RegisterUser(user)
+		is.NoErr(err)
+
+		err = ur.UpdateUser(user.ID.String(), map[string]any{"email": "After@Test.com"})
+		is.NoErr(err)
+		dbUser, err := ur.GetUserByEmail("after@test.com")
+		is.NoErr(err)
+		is.Equal(dbUser.ID, user.ID)
+	})
 }
 
 // TestUserRepository_LookupUser tests lookup of registered users in the database
@@ -415,6 +453,55 @@ func TestUserRepository_UnlockAccount(t *testing.T) {
 	})
 }
 
+// TestUserRepository_LockAccountUntil tests that a lock without an end time
+// is kept by UnlockAllExpiredLocks
+func TestUserRepository_LockAccountUntil(t *testing.T) {
+	is := is.New(t)
+	ur := setupUserRepository(t)
+
+	user := &models.User{
+		Email:    "testLockAccountUntil@test.com",
+		Password: testutils.TestingPassword,
+	}
+	is.NoErr(ur.RegisterUser(user))
+
+	is.NoErr(ur.LockAccountUntil(user.ID.String(), nil))
+	_, err := ur.UnlockAllExpiredLocks()
+	is.NoErr(err)
+
+	user, err = ur.GetUserByID(user.ID.String())
+	is.NoErr(err)
+	is.True(user.AccountLocked)
+	is.Equal(user.AccountLockedUntil, nil)
+
+	is.Equal(ur.LockAccountUntil("", nil), apperrors.ErrUserIdEmpty)
+}
+
+// TestUserRepository_ListUsers tests paging through users ordered by email
+func TestUserRepository_ListUsers(t *testing.T) {
+	is := is.New(t)
+	ur := setupUserRepository(t)
+
+	for _, email := range []string{"testListUsersC@test.com", "testListUsersA@test.com", "testListUsersB@test.com"} {
+		is.NoErr(ur.RegisterUser(&models.User{Email: email, Password: testutils.TestingPassword}))
+	}
+
+	users, err := ur.ListUsers(100, 0)
+	is.NoErr(err)
+	var emails []string
+	for _, u := range users {
+		if strings.HasPrefix(u.Email, "testListUsers") {
+			emails = append(emails, u.Email)
+		}
+	}
+	is.Equal(emails, []string{"testListUsersA@test.com", "testListUsersB@test.com", "testListUsersC@test.com"})
+
+	page, err := ur.ListUsers(1, 1)
+	is.NoErr(err)
+	is.Equal(len(page), 1)
+	is.Equal(page[0].ID, users[1].ID)
+}
+
 // TestUserRepository_UnlockAllExpiredLocks tests that any locked accounts that
 // are past the lock expiration date are locked by UnlockAllExpiredLocks
 func TestUserRepository_UnlockAllExpiredLocks(t *testing.T) {
@@ -471,6 +558,87 @@ func TestUserRepository_UnlockAllExpiredLocks(t *testing.T) {
 	})
 }
 
+// TestUserRepository_ScheduleDeletion tests the pending deletion lifecycle:
+// scheduling, restoring with the token hash, and purging after the grace period
+func TestUserRepository_ScheduleDeletion(t *testing.T) {
+	is := is.New(t)
+
+	schedule := func(t *testing.T, ur *repository.UserRepository, purgeAfter time.Time) (*models.User, string) {
+		user := &models.User{
+			Email:    "testScheduleDeletion@test.com",
+			Password: testutils.TestingPassword,
+		}
+		err := ur.RegisterUser(user)
+		is.NoErr(err)
+		_, tokenHash, err := models.GenerateRestoreToken()
+		is.NoErr(err)
+		err = ur.ScheduleDeletion(user.ID.String(), tokenHash, time.Now().UTC(), purgeAfter)
+		is.NoErr(err)
+		return user, tokenHash
+	}
+
+	t.Run("non-existing user", func(t *testing.T) {
+		ur := setupUserRepository(t)
+		err := ur.ScheduleDeletion(uuid.New().String(), "hash", time.Now(), time.Now())
+		is.Equal(err, apperrors.ErrUserNotFound)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		ur := setupUserRepository(t)
+		user, tokenHash := schedule(t, ur, time.Now().Add(time.Hour))
+
+		dbUser, err := ur.GetUserByID(user.ID.String())
+		is.NoErr(err)
+		is.True(dbUser.PendingDeletion())
+
+		restored, err := ur.RestoreUser(tokenHash)
+		is.NoErr(err)
+		is.Equal(restored.ID, user.ID)
+		is.True(!restored.PendingDeletion())
+		is.Equal(restored.RestoreTokenHash, nil)
+
+		_, err = ur.RestoreUser(tokenHash)
+		is.Equal(err, apperrors.ErrRestoreTokenInvalid)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		ur := setupUserRepository(t)
+		user, tokenHash := schedule(t, ur, time.Now().Add(-time.Minute))
+
+		// Too late to restore
+		_, err := ur.RestoreUser(tokenHash)
+		is.Equal(err, apperrors.ErrRestoreTokenInvalid)
+
+		due, err := ur.GetUsersDueForPurge(time.Now())
+		is.NoErr(err)
+		is.Equal(len(due), 1)
+		is.Equal(due[0].ID, user.ID)
+		is.True(due[0].DeletionRequestedAt != nil)
+
+		purged, err := ur.PurgeDeletedUser(user.ID.String(), time.Now())
+		is.NoErr(err)
+		is.Equal(purged, int64(1))
+
+		_, err = ur.GetUserByID(user.ID.String())
+		is.True(err != nil)
+	})
+
+	t.Run("purge keeps accounts in their grace period", func(t *testing.T) {
+		ur := setupUserRepository(t)
+		user, _ := schedule(t, ur, time.Now().Add(time.Hour))
+
+		due, err := ur.GetUsersDueForPurge(time.Now())
+		is.NoErr(err)
+		is.Equal(len(due), 0)
+
+		purged, err := ur.PurgeDeletedUser(user.ID.String(), time.Now())
+		is.NoErr(err)
+		is.Equal(purged, int64(0))
+		_, err = ur.GetUserByID(user.ID.String())
+		is.NoErr(err)
+	})
+}
+
 func setupUserRepository(t *testing.T) (*repository.UserRepository) {
 	t.Helper()
 
@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// WebhookRepository represents the entry point into the database for
+// managing the `webhook_subscriptions`, `webhook_events` and
+// `webhook_deliveries` tables
+type WebhookRepository struct {
+	DB *gorm.DB
+}
+
+// NewWebhookRepository returns a value for the WebhookRepository struct
+func NewWebhookRepository(db *gorm.DB) (*WebhookRepository, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	return &WebhookRepository{DB: db}, nil
+}
+
+// CreateWebhookSubscription inserts a new subscription into the
+// `webhook_subscriptions` table
+func (wr *WebhookRepository) CreateWebhookSubscription(subscription *models.WebhookSubscription) error {
+	return wr.DB.Create(subscription).Error
+}
+
+// GetWebhookSubscriptions retrieves every subscription, newest first
+func (wr *WebhookRepository) GetWebhookSubscriptions() ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	result := wr.DB.Order("created_at DESC").Find(&subscriptions)
+	return subscriptions, result.Error
+}
+
+// GetWebhookSubscriptionByID retrieves a subscription by its ID
+func (wr *WebhookRepository) GetWebhookSubscriptionByID(id uuid.UUID) (*models.WebhookSubscription, error) {
+	if id == uuid.Nil {
+		return nil, apperrors.ErrWebhookIdEmpty
+	}
+	var subscription models.WebhookSubscription
+	if err := wr.DB.Where("id = ?", id).First(&subscription).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// DeleteWebhookSubscriptionByID deletes a subscription along with its
+// deliveries
+func (wr *WebhookRepository) DeleteWebhookSubscriptionByID(id uuid.UUID) error {
+	if id == uuid.Nil {
+		return apperrors.ErrWebhookIdEmpty
+	}
+	result := wr.DB.Where("id = ?", id).Delete(&models.WebhookSubscription{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent adds an event to the outbox with a pending delivery for
+// each subscription that receives it, and returns the number of deliveries.
+// Events nobody subscribes to are not stored. Call it on the transaction of
+// the change the event describes.
+func (wr *WebhookRepository) EnqueueWebhookEvent(event *models.WebhookEvent) (int, error) {
+	if event == nil {
+		return 0, apperrors.ErrWebhookEvent
+	}
+	subscriptions, err := wr.GetWebhookSubscriptions()
+	if err != nil {
+		return 0, err
+	}
+	var deliveries []*models.WebhookDelivery
+	for _, subscription := range subscriptions {
+		if subscription.Receives(event.Type) {
+			deliveries = append(deliveries, models.NewWebhookDelivery(event.ID, subscription.ID))
+		}
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	if err := wr.DB.Create(event).Error; err != nil {
+		return 0, err
+	}
+	if err := wr.DB.Create(deliveries).Error; err != nil {
+		return 0, err
+	}
+	return len(deliveries), nil
+}
+
+// GetWebhookDeliveries retrieves up to `limit` deliveries to a subscription
+// with their event, newest first. An empty `status` matches every status.
+func (wr *WebhookRepository) GetWebhookDeliveries(subscriptionID uuid.UUID, status string, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	query := wr.DB.Preload("Event").Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	result := query.Order("created_at DESC").Limit(limit).Find(&deliveries)
+	return deliveries, result.Error
+}
+
+// GetWebhookDeliveryByID retrieves a delivery by its ID with its event
+func (wr *WebhookRepository) GetWebhookDeliveryByID(id uuid.UUID) (*models.WebhookDelivery, error) {
+	if id == uuid.Nil {
+		return nil, apperrors.ErrWebhookDeliveryIdEmpty
+	}
+	var delivery models.WebhookDelivery
+	if err := wr.DB.Preload("Event").Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimDueWebhookDeliveries retrieves up to `limit` pending deliveries due
+// before `now`, oldest first, with their event and subscription. Their next
+// attempt is pushed back by `lease`, so other dispatchers skip them until the
+// claim has been recorded or has expired.
+func (wr *WebhookRepository) ClaimDueWebhookDeliveries(now time.Time, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := wr.DB.Transaction(func(tx *gorm.DB) error {
+		var due []models.WebhookDelivery
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id").
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now.UTC()).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(due))
+		for _, delivery := range due {
+			ids = append(ids, delivery.ID)
+		}
+		err = tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease).UTC()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Preload("Event").Preload("Subscription").
+			Where("id IN ?", ids).
+			Order("created_at").
+			Find(&deliveries).Error
+	})
+	return deliveries, err
+}
+
+// UpdateWebhookDeliveryAttempt stores the outcome of an attempt to send a
+// delivery: its status, attempts, next attempt and last response
+func (wr *WebhookRepository) UpdateWebhookDeliveryAttempt(delivery *models.WebhookDelivery) error {
+	if delivery == nil || delivery.ID == uuid.Nil {
+		return apperrors.ErrWebhookDeliveryIdEmpty
+	}
+	return wr.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ?", delivery.ID).
+		Updates(map[string]any{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt.UTC(),
+			"last_attempt_at":  delivery.LastAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"delivered_at":     delivery.DeliveredAt,
+		}).Error
+}
+
+// DeleteFinishedWebhookDeliveries deletes delivered and dead deliveries last
+// attempted before `before`, then the events created before `before` that no
+// delivery refers to anymore, like those of deleted subscriptions. It returns
+// the number of deliveries and events deleted.
+func (wr *WebhookRepository) DeleteFinishedWebhookDeliveries(before time.Time) (int64, int64, error) {
+	var deliveries, events int64
+	err := wr.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("status IN ? AND COALESCE(last_attempt_at, created_at) < ?",
+			[]string{models.WebhookDeliveryDelivered, models.WebhookDeliveryDead}, before.UTC()).
+			Delete(&models.WebhookDelivery{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deliveries = result.RowsAffected
+
+		result = tx.Where("created_at < ? AND NOT EXISTS (?)", before.UTC(),
+			tx.Model(&models.WebhookDelivery{}).Select("1").Where("webhook_deliveries.event_id = webhook_events.id")).
+			Delete(&models.WebhookEvent{})
+		if result.Error != nil {
+			return result.Error
+		}
+		events = result.RowsAffected
+		return nil
+	})
+	return deliveries, events, err
+}
+
+// ResetWebhookDelivery makes a delivered or dead delivery pending again, due
+// at `now` with a fresh set of attempts. It returns gorm.ErrRecordNotFound
+// if no such delivery is delivered or dead.
+func (wr *WebhookRepository) ResetWebhookDelivery(id uuid.UUID, now time.Time) error {
+	if id == uuid.Nil {
+		return apperrors.ErrWebhookDeliveryIdEmpty
+	}
+	result := wr.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status <> ?", id, models.WebhookDeliveryPending).
+		Updates(map[string]any{
+			"status":          models.WebhookDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": now.UTC(),
+			"last_error":      "",
+			"delivered_at":    nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+func TestWebhookRepository_NewWebhookRepository(t *testing.T) {
+	is := is.New(t)
+
+	_, err := repository.NewWebhookRepository(nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+}
+
+// TestWebhookRepository_EmptyArguments tests empty IDs are refused before
+// the database is queried
+func TestWebhookRepository_EmptyArguments(t *testing.T) {
+	is := is.New(t)
+	wr, err := repository.NewWebhookRepository(&gorm.DB{})
+	is.NoErr(err)
+
+	_, err = wr.GetWebhookSubscriptionByID(uuid.Nil)
+	is.Equal(err, apperrors.ErrWebhookIdEmpty)
+	is.Equal(wr.DeleteWebhookSubscriptionByID(uuid.Nil), apperrors.ErrWebhookIdEmpty)
+	_, err = wr.GetWebhookDeliveryByID(uuid.Nil)
+	is.Equal(err, apperrors.ErrWebhookDeliveryIdEmpty)
+	is.Equal(wr.UpdateWebhookDeliveryAttempt(&models.WebhookDelivery{}), apperrors.ErrWebhookDeliveryIdEmpty)
+	is.Equal(wr.ResetWebhookDelivery(uuid.Nil, time.Now()), apperrors.ErrWebhookDeliveryIdEmpty)
+}
+
+// TestWebhookRepository_Outbox tests enqueueing events for the subscriptions
+// that receive them, claiming due deliveries and resetting finished ones
+func TestWebhookRepository_Outbox(t *testing.T) {
+	is := is.New(t)
+	wr := setupWebhookRepository(t)
+
+	subscribe := func(events ...string) *models.WebhookSubscription {
+		subscription, err := models.NewWebhookSubscription("https://crm.example.com/hooks", events, "secret", "", uuid.New())
+		is.NoErr(err)
+		is.NoErr(wr.CreateWebhookSubscription(subscription))
+		return subscription
+	}
+	crm := subscribe(models.WebhookUserRegistered, models.WebhookUserDeleted)
+	billing := subscribe(models.WebhookUserDeleted)
+
+	enqueue := func(eventType string) (*models.WebhookEvent, int) {
+		event, err := models.NewWebhookEvent(eventType, models.WebhookUserData{UserID: uuid.New(), Email: "user@example.com"})
+		is.NoErr(err)
+		enqueued, err := wr.EnqueueWebhookEvent(event)
+		is.NoErr(err)
+		return event, enqueued
+	}
+
+	t.Run("fans events out to their subscribers", func(t *testing.T) {
+		_, enqueued := enqueue(models.WebhookUserDeleted)
+		is.Equal(enqueued, 2)
+		_, enqueued = enqueue(models.WebhookUserRegistered)
+		is.Equal(enqueued, 1)
+
+		event, enqueued := enqueue(models.WebhookUserRestored)
+		is.Equal(enqueued, 0)
+		is.Equal(wr.DB.First(&models.WebhookEvent{}, "id = ?", event.ID).Error, gorm.ErrRecordNotFound)
+
+		deliveries, err := wr.GetWebhookDeliveries(crm.ID, "", 10)
+		is.NoErr(err)
+		is.Equal(len(deliveries), 2)
+		deliveries, err = wr.GetWebhookDeliveries(billing.ID, models.WebhookDeliveryPending, 10)
+		is.NoErr(err)
+		is.Equal(len(deliveries), 1)
+		is.Equal(deliveries[0].Event.Type, models.WebhookUserDeleted)
+	})
+
+	t.Run("claims due deliveries once", func(t *testing.T) {
+		now := time.Now().UTC()
+		claimed, err := wr.ClaimDueWebhookDeliveries(now, 10, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 3)
+		is.True(claimed[0].Event != nil && claimed[0].Subscription != nil)
+
+		claimed, err = wr.ClaimDueWebhookDeliveries(now, 10, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 0)
+
+		// Expired claims are due again
+		claimed, err = wr.ClaimDueWebhookDeliveries(now.Add(2*time.Minute), 2, time.Minute)
+		is.NoErr(err)
+		is.Equal(len(claimed), 2)
+	})
+
+	t.Run("resets finished deliveries", func(t *testing.T) {
+		deliveries, err := wr.GetWebhookDeliveries(billing.ID, "", 10)
+		is.NoErr(err)
+		delivery := deliveries[0]
+
+		is.Equal(wr.ResetWebhookDelivery(delivery.ID, time.Now()), gorm.ErrRecordNotFound)
+
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.Attempts = 10
+		delivery.LastError = "connection refused"
+		is.NoErr(wr.UpdateWebhookDeliveryAttempt(&delivery))
+
+		is.NoErr(wr.ResetWebhookDelivery(delivery.ID, time.Now()))
+		reset, err := wr.GetWebhookDeliveryByID(delivery.ID)
+		is.NoErr(err)
+		is.Equal(reset.Status, models.WebhookDeliveryPending)
+		is.Equal(reset.Attempts, 0)
+		is.Equal(reset.LastError, "")
+	})
+
+	t.Run("deleting a subscription deletes its deliveries", func(t *testing.T) {
+		is.NoErr(wr.DeleteWebhookSubscriptionByID(billing.ID))
+		is.Equal(wr.DeleteWebhookSubscriptionByID(billing.ID), gorm.ErrRecordNotFound)
+
+		deliveries, err := wr.GetWebhookDeliveries(billing.ID, "", 10)
+		is.NoErr(err)
+		is.Equal(len(deliveries), 0)
+
+		subscriptions, err := wr.GetWebhookSubscriptions()
+		is.NoErr(err)
+		is.Equal(len(subscriptions), 1)
+	})
+}
+
+// TestWebhookRepository_DeleteFinishedWebhookDeliveries tests deleting
+// finished deliveries past their retention and the events they leave behind
+func TestWebhookRepository_DeleteFinishedWebhookDeliveries(t *testing.T) {
+	is := is.New(t)
+	wr := setupWebhookRepository(t)
+
+	now := time.Now().UTC()
+	subscribe := func(events ...string) *models.WebhookSubscription {
+		subscription, err := models.NewWebhookSubscription("https://crm.example.com/hooks", events, "secret", "", uuid.New())
+		is.NoErr(err)
+		is.NoErr(wr.CreateWebhookSubscription(subscription))
+		return subscription
+	}
+	// enqueue adds an event created two hours ago
+	enqueue := func(eventType string) *models.WebhookEvent {
+		event, err := models.NewWebhookEvent(eventType, models.WebhookUserData{UserID: uuid.New(), Email: "user@example.com"})
+		is.NoErr(err)
+		event.CreatedAt = now.Add(-2 * time.Hour)
+		_, err = wr.EnqueueWebhookEvent(event)
+		is.NoErr(err)
+		return event
+	}
+	// finish records the last attempt of the delivery of `event` to
+	// `subscription`
+	finish := func(subscription *models.WebhookSubscription, event *models.WebhookEvent, status string, at time.Time) {
+		deliveries, err := wr.GetWebhookDeliveries(subscription.ID, "", 10)
+		is.NoErr(err)
+		for _, delivery := range deliveries {
+			if delivery.EventID == event.ID {
+				delivery.Status = status
+				delivery.LastAttemptAt = &at
+				is.NoErr(wr.UpdateWebhookDeliveryAttempt(&delivery))
+			}
+		}
+	}
+
+	crm := subscribe(models.WebhookUserDeleted)
+	billing := subscribe(models.WebhookUserDeleted)
+	gone := subscribe(models.WebhookUserRegistered)
+
+	finished := enqueue(models.WebhookUserDeleted)
+	finish(crm, finished, models.WebhookDeliveryDelivered, now.Add(-2*time.Hour))
+	finish(billing, finished, models.WebhookDeliveryDead, now.Add(-90*time.Minute))
+
+	recent := enqueue(models.WebhookUserDeleted)
+	finish(crm, recent, models.WebhookDeliveryDelivered, now.Add(-2*time.Hour))
+	finish(billing, recent, models.WebhookDeliveryDead, now.Add(-time.Minute))
+
+	pending := enqueue(models.WebhookUserDeleted)
+
+	orphaned := enqueue(models.WebhookUserRegistered)
+	is.NoErr(wr.DeleteWebhookSubscriptionByID(gone.ID))
+
+	deliveries, events, err := wr.DeleteFinishedWebhookDeliveries(now.Add(-time.Hour))
+	is.NoErr(err)
+	is.Equal(deliveries, int64(3))
+	is.Equal(events, int64(2))
+
+	exists := func(event *models.WebhookEvent) bool {
+		var count int64
+		is.NoErr(wr.DB.Model(&models.WebhookEvent{}).Where("id = ?", event.ID).Count(&count).Error)
+		return count == 1
+	}
+	is.True(!exists(finished))
+	is.True(!exists(orphaned))
+	// Events are kept while any of their deliveries is
+	is.True(exists(recent))
+	is.True(exists(pending))
+
+	left, err := wr.GetWebhookDeliveries(billing.ID, "", 10)
+	is.NoErr(err)
+	is.Equal(len(left), 2)
+	left, err = wr.GetWebhookDeliveries(crm.ID, models.WebhookDeliveryPending, 10)
+	is.NoErr(err)
+	is.Equal(len(left), 1)
+}
+
+func setupWebhookRepository(t *testing.T) *repository.WebhookRepository {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	wr, err := repository.NewWebhookRepository(tx)
+	if err != nil {
+		t.Fatalf("failed to create webhook repository: %v", err)
+	}
+	return wr
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// access is the middleware a route runs behind
+type access int
+
+const (
+	public access = iota
+	// authenticated routes need a valid session
+	authenticated
+	// recentAuth routes also need a recent login or reauthentication, for
+	// sessions
+	recentAuth
+	// admin routes also need the admin role, or a service account with the
+	// admin scope
+	admin
+	// adminRecentAuth routes need both the admin role and a recent login
+	adminRecentAuth
+)
+
+// route is an auth route, registered the same way on gin and net/http.
+// Personal access tokens and service accounts can only use protected routes
+// with a scope they were granted; protected routes without a scope are for
+// sessions only.
+type route struct {
+	method  string
+	path    string
+	access  access
+	scope   string
+	handler func(*handlers.Exchange)
+}
+
+// routes returns the auth routes, except forward auth, which is a middleware
+// rather than a handler
+func (s *APIServer) routes() []route {
+	user := s.HandlerRegistry.User
+	export := s.HandlerRegistry.Export
+	tokens := s.HandlerRegistry.AccessToken
+	serviceAccounts := s.HandlerRegistry.ServiceAccount
+	webhooks := s.HandlerRegistry.Webhook
+	return []route{
+		{http.MethodGet, "/ping", public, "", Ping},
+		{http.MethodGet, "/metrics", public, "", Metrics},
+		{http.MethodGet, "/csrf", public, "", CSRFToken},
+		{http.MethodPost, "/register", public, "", user.RegisterUser},
+		{http.MethodPost, "/login", public, "", user.Login},
+		{http.MethodPost, "/login/magic", public, "", user.RequestMagicLink},
+		{http.MethodPost, "/login/magic/verify", public, "", user.MagicLinkLogin},
+		{http.MethodPost, "/logout", public, "", user.Logout},
+		{http.MethodPost, "/restoreaccount", public, "", user.RestoreAccount},
+		{http.MethodGet, handlers.ExportDownloadPath, public, "", export.DownloadExport},
+		// Authenticated with introspection client credentials instead of a session
+		{http.MethodPost, "/introspect", public, "", s.HandlerRegistry.Introspection.Introspect},
+		// Authenticated with service account client credentials
+		{http.MethodPost, "/oauth/token", public, "", serviceAccounts.Token},
+
+		{http.MethodGet, "/whoami", authenticated, models.ScopeProfile, user.WhoAmI},
+		{http.MethodPost, "/logouteverywhere", authenticated, models.ScopeSessions, user.LogoutEverywhere},
+		{http.MethodGet, "/sessions", authenticated, models.ScopeSessions, user.ListSessions},
+		{http.MethodDelete, "/sessions/:id", authenticated, models.ScopeSessions, user.RevokeSession},
+		{http.MethodPost, "/reauthenticate", authenticated, "", user.Reauthenticate},
+		{http.MethodGet, "/tokens", authenticated, "", tokens.ListAccessTokens},
+		{http.MethodDelete, "/tokens/:id", authenticated, "", tokens.RevokeAccessToken},
+
+		{http.MethodPost, "/updateuser", recentAuth, "", user.UpdateUser},
+		{http.MethodDelete, "/deleteaccount", recentAuth, "", user.DeleteAccount},
+		// A stolen session cookie should not be enough to take all the
+		// user's data; access tokens with the export scope are let through
+		{http.MethodGet, "/export", recentAuth, models.ScopeExport, export.ExportData},
+		{http.MethodPost, "/export", recentAuth, models.ScopeExport, export.RequestExport},
+		{http.MethodPost, "/tokens", recentAuth, "", tokens.CreateAccessToken},
+
+		{http.MethodPost, "/admin/users/import", admin, models.ScopeAdmin, s.HandlerRegistry.Admin.ImportUsers},
+		{http.MethodGet, "/admin/users/:id/export", admin, models.ScopeAdmin, export.AdminExportData},
+		{http.MethodPost, "/admin/users/:id/export", admin, models.ScopeAdmin, export.AdminRequestExport},
+		// Service accounts and webhooks mint and receive credentials, so
+		// they are managed by admins who just logged in, never by tokens
+		{http.MethodPost, "/admin/serviceaccounts", adminRecentAuth, "", serviceAccounts.CreateServiceAccount},
+		{http.MethodGet, "/admin/serviceaccounts", adminRecentAuth, "", serviceAccounts.ListServiceAccounts},
+		{http.MethodPost, "/admin/serviceaccounts/:id/secret", adminRecentAuth, "", serviceAccounts.RotateServiceAccountSecret},
+		{http.MethodDelete, "/admin/serviceaccounts/:id", adminRecentAuth, "", serviceAccounts.DeleteServiceAccount},
+		{http.MethodPost, "/admin/webhooks", adminRecentAuth, "", webhooks.CreateWebhook},
+		{http.MethodGet, "/admin/webhooks", adminRecentAuth, "", webhooks.ListWebhooks},
+		{http.MethodDelete, "/admin/webhooks/:id", adminRecentAuth, "", webhooks.DeleteWebhook},
+		{http.MethodGet, "/admin/webhooks/:id/deliveries", adminRecentAuth, "", webhooks.ListWebhookDeliveries},
+		{http.MethodPost, "/admin/webhooks/:id/deliveries/:deliveryId/redeliver", adminRecentAuth, "", webhooks.RedeliverWebhook},
+	}
+}
+
+// RegisterRoutes adds the auth routes to `r`, behind the CSRF check. The
+// routes can be mounted under a prefix by passing a group.
+func (s *APIServer) RegisterRoutes(r *gin.RouterGroup) {
+	auth := s.MiddlewareProvider.Auth
+	r.Use(s.MiddlewareProvider.CSRF.RequireCSRF())
+
+	for _, rt := range s.routes() {
+		var chain []gin.HandlerFunc
+		switch rt.access {
+		case authenticated:
+			chain = append(chain, auth.RequireAuth(), auth.RequireScope(rt.scope))
+		case recentAuth:
+			chain = append(chain, auth.RequireAuth(), auth.RequireScope(rt.scope), auth.RequireRecentAuth(config.RecentAuthMaxAge))
+		case admin:
+			chain = append(chain, auth.RequireAuth(), auth.RequireScope(rt.scope), auth.RequireAdmin())
+		case adminRecentAuth:
+			chain = append(chain, auth.RequireAuth(), auth.RequireScope(rt.scope), auth.RequireAdmin(), auth.RequireRecentAuth(config.RecentAuthMaxAge))
+		}
+		r.Handle(rt.method, rt.path, append(chain, handlers.Gin(rt.handler))...)
+	}
+	s.HandlerRegistry.Export.DownloadPath = path.Join(r.BasePath(), handlers.ExportDownloadPath)
+
+	// Forward auth subrequests from reverse proxies, which Traefik and Caddy
+	// always send as GET and nginx can with `proxy_method GET`
+	r.Match([]string{http.MethodGet, http.MethodHead}, "/auth/verify", auth.ForwardAuth())
+}
+
+// HTTPHandler returns the auth routes under `prefix` as an http.Handler,
+// behind the CSRF check, for servers that don't use gin. It serves the same
+// handlers and middleware as RegisterRoutes.
+func (s *APIServer) HTTPHandler(prefix string) http.Handler {
+	auth := s.MiddlewareProvider.Auth
+	mux := http.NewServeMux()
+
+	for _, rt := range s.routes() {
+		h := handlers.HTTP(rt.handler)
+		switch rt.access {
+		case authenticated:
+			h = auth.RequireAuthHTTP(auth.RequireScopeHTTP(rt.scope)(h))
+		case recentAuth:
+			h = auth.RequireAuthHTTP(auth.RequireScopeHTTP(rt.scope)(auth.RequireRecentAuthHTTP(config.RecentAuthMaxAge)(h)))
+		case admin:
+			h = auth.RequireAuthHTTP(auth.RequireScopeHTTP(rt.scope)(auth.RequireAdminHTTP(h)))
+		case adminRecentAuth:
+			h = auth.RequireAuthHTTP(auth.RequireScopeHTTP(rt.scope)(auth.RequireAdminHTTP(auth.RequireRecentAuthHTTP(config.RecentAuthMaxAge)(h))))
+		}
+		mux.Handle(rt.method+" "+prefix+muxPattern(rt.path), h)
+	}
+	s.HandlerRegistry.Export.DownloadPath = path.Join("/", prefix, handlers.ExportDownloadPath)
+
+	// A GET pattern also matches HEAD
+	mux.HandleFunc(http.MethodGet+" "+prefix+"/auth/verify", auth.ForwardAuthHTTP)
+
+	return s.MiddlewareProvider.CSRF.RequireCSRFHTTP(mux)
+}
+
+// muxPattern converts gin's `:name` path parameters to http.ServeMux's
+// `{name}`
+func muxPattern(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
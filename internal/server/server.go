@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -14,18 +15,23 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"gorm.io/gorm"
 
+	"github.com/al-ce/goauth/internal/grpcapi"
 	"github.com/al-ce/goauth/internal/handlers"
+	"github.com/al-ce/goauth/internal/metrics"
 	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/repository"
 	"github.com/al-ce/goauth/internal/services"
 	"github.com/al-ce/goauth/pkg/apperrors"
 	"github.com/al-ce/goauth/pkg/config"
 )
 
-// APIServer represents the API server with a gin router.
+// APIServer represents the API server with a gin router, and the gRPC server
+// for the same services.
 type APIServer struct {
 	DB                 *gorm.DB
 	Router             *gin.Engine
+	GRPC               *grpcapi.Server
 	HandlerRegistry    *HandlerRegistry
 	MiddlewareProvider *MiddlewareProvider
 }
@@ -53,6 +59,11 @@ func NewAPIServer(db *gorm.DB) (*APIServer, error) {
 		return nil, err
 	}
 
+	grpcServer, err := grpcapi.NewServer(serviceProvider.User, serviceProvider.Introspection, middlewareProvider.Auth)
+	if err != nil {
+		return nil, err
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
@@ -61,6 +72,7 @@ func NewAPIServer(db *gorm.DB) (*APIServer, error) {
 	server := &APIServer{
 		DB:                 db,
 		Router:             router,
+		GRPC:               grpcServer,
 		HandlerRegistry:    HandlerRegistry,
 		MiddlewareProvider: middlewareProvider,
 	}
@@ -72,33 +84,25 @@ func (s *APIServer) SetupRoutes() {
 	r := s.Router
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     getAllowedOrigins(),
+		AllowOrigins:     AllowedOrigins(),
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", config.CSRFHeaderName},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
-
-	r.GET("/ping", Ping)
-	r.POST("/register", s.HandlerRegistry.User.RegisterUser)
-	r.POST("/login", s.HandlerRegistry.User.Login)
-	r.POST("/logout", s.HandlerRegistry.User.Logout)
-
-	protected := r.Group("")
-	protected.Use(s.MiddlewareProvider.Auth.RequireAuth())
-	{
-		protected.GET("/whoami", s.HandlerRegistry.User.WhoAmI)
-		protected.POST("/logouteverywhere", s.HandlerRegistry.User.LogoutEverywhere)
-		protected.POST("/updateuser", s.HandlerRegistry.User.UpdateUser)
-		protected.DELETE("/deleteaccount", s.HandlerRegistry.User.PermanentlyDeleteUser)
-	}
+	s.RegisterRoutes(&r.RouterGroup)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 }
 
-// Run starts the API server and listens for incoming requests.
+// Run starts the API server and listens for incoming requests. The gRPC
+// server is started on its own port if `GRPC_SERVER_PORT` is set.
 func (s *APIServer) Run() {
+	if port := os.Getenv(config.GRPCServerPort); port != "" {
+		go s.RunGRPC(port)
+	}
+
 	s.SetupRoutes()
 	var port string
 	if val, isSet := os.LookupEnv(config.AuthServerPort); isSet {
@@ -113,6 +117,23 @@ func (s *APIServer) Run() {
 	s.Router.Run(":" + port)
 }
 
+// RunGRPC serves the gRPC server on `port` until Shutdown is called
+func (s *APIServer) RunGRPC(port string) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal().Err(err).Str("port", port).Msg("Could not listen for gRPC")
+	}
+	log.Info().Str("port", port).Msg("Starting gRPC server")
+	if err := s.GRPC.Serve(lis); err != nil {
+		log.Error().Err(err).Msg("gRPC server stopped")
+	}
+}
+
+// Shutdown gracefully stops the gRPC server, waiting for pending calls
+func (s *APIServer) Shutdown() {
+	s.GRPC.GracefulStop()
+}
+
 func NewRepoProvider(db *gorm.DB) (*RepoProvider, error) {
 	if db == nil {
 		return nil, apperrors.ErrDatabaseIsNil
@@ -125,9 +146,34 @@ func NewRepoProvider(db *gorm.DB) (*RepoProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	ar, err := repository.NewAuditRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	er, err := repository.NewExportRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := repository.NewAccessTokenRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	sar, err := repository.NewServiceAccountRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	wr, err := repository.NewWebhookRepository(db)
+	if err != nil {
+		return nil, err
+	}
 	return &RepoProvider{
-		User:    ur,
-		Session: sr,
+		User:           ur,
+		Session:        sr,
+		Audit:          ar,
+		Export:         er,
+		AccessToken:    tr,
+		ServiceAccount: sar,
+		Webhook:        wr,
 	}, nil
 }
 
@@ -139,8 +185,38 @@ func NewServiceProvider(repos *RepoProvider) (*ServiceProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	is, err := services.NewImportService(repos.User)
+	if err != nil {
+		return nil, err
+	}
+	es, err := services.NewExportService(repos.User, repos.Session, repos.Audit, repos.Export)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := services.NewIntrospectionService(repos.User, repos.Session)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := services.NewAccessTokenService(repos.User, repos.AccessToken, repos.Audit)
+	if err != nil {
+		return nil, err
+	}
+	sas, err := services.NewServiceAccountService(repos.ServiceAccount, repos.Audit)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := services.NewWebhookService(repos.Webhook, repos.Audit)
+	if err != nil {
+		return nil, err
+	}
 	return &ServiceProvider{
-		User: us,
+		User:           us,
+		Import:         is,
+		Export:         es,
+		Introspection:  ins,
+		AccessToken:    ts,
+		ServiceAccount: sas,
+		Webhook:        ws,
 	}, nil
 }
 
@@ -149,8 +225,38 @@ func NewHandlerRegistry(services *ServiceProvider) (*HandlerRegistry, error) {
 	if err != nil {
 		return nil, err
 	}
+	ah, err := handlers.NewAdminHandler(services.Import)
+	if err != nil {
+		return nil, err
+	}
+	eh, err := handlers.NewExportHandler(services.Export)
+	if err != nil {
+		return nil, err
+	}
+	ih, err := handlers.NewIntrospectionHandler(services.Introspection)
+	if err != nil {
+		return nil, err
+	}
+	th, err := handlers.NewAccessTokenHandler(services.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	sah, err := handlers.NewServiceAccountHandler(services.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	wh, err := handlers.NewWebhookHandler(services.Webhook)
+	if err != nil {
+		return nil, err
+	}
 	return &HandlerRegistry{
-		User: uh,
+		User:           uh,
+		Admin:          ah,
+		Export:         eh,
+		Introspection:  ih,
+		AccessToken:    th,
+		ServiceAccount: sah,
+		Webhook:        wh,
 	}, nil
 }
 
@@ -159,26 +265,49 @@ func NewMiddlewares(db *gorm.DB) (*MiddlewareProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	csrf, err := middleware.NewCSRFMiddleware(AllowedOrigins())
+	if err != nil {
+		return nil, err
+	}
 	return &MiddlewareProvider{
 		Auth: mw,
+		CSRF: csrf,
 	}, nil
 }
 
 type RepoProvider struct {
-	User    *repository.UserRepository
-	Session *repository.SessionRepository
+	User           *repository.UserRepository
+	Session        *repository.SessionRepository
+	Audit          *repository.AuditRepository
+	Export         *repository.ExportRepository
+	AccessToken    *repository.AccessTokenRepository
+	ServiceAccount *repository.ServiceAccountRepository
+	Webhook        *repository.WebhookRepository
 }
 
 type ServiceProvider struct {
-	User *services.UserService
+	User           *services.UserService
+	Import         *services.ImportService
+	Export         *services.ExportService
+	Introspection  *services.IntrospectionService
+	AccessToken    *services.AccessTokenService
+	ServiceAccount *services.ServiceAccountService
+	Webhook        *services.WebhookService
 }
 
 type HandlerRegistry struct {
-	User *handlers.UserHandler
+	User           *handlers.UserHandler
+	Admin          *handlers.AdminHandler
+	Export         *handlers.ExportHandler
+	Introspection  *handlers.IntrospectionHandler
+	AccessToken    *handlers.AccessTokenHandler
+	ServiceAccount *handlers.ServiceAccountHandler
+	Webhook        *handlers.WebhookHandler
 }
 
 type MiddlewareProvider struct {
 	Auth *middleware.AuthMiddleware
+	CSRF *middleware.CSRFMiddleware
 }
 
 // Ping godoc
@@ -189,13 +318,44 @@ type MiddlewareProvider struct {
 // @Produce json
 // @Success 200 {object} models.MessageResponse "response with message field"
 // @Router /ping [get]
-func Ping(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "pong"})
+func Ping(c *handlers.Exchange) {
+	c.JSON(http.StatusOK, handlers.H{"message": "pong"})
+}
+
+// Metrics godoc
+// @Summary server metrics
+// @Schemes
+// @Description Password hashing queue wait time, utilization and rejections in the Prometheus text format
+// @Produce plain
+// @Success 200 {string} string "Prometheus text exposition format"
+// @Router /metrics [get]
+func Metrics(c *handlers.Exchange) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Writer.WriteHeader(http.StatusOK)
+	passwords.Default().WriteMetrics(c.Writer)
+}
+
+// CSRFToken godoc
+// @Summary get a CSRF token
+// @Schemes
+// @Description Sets the CSRF cookie and returns its token. Cookie-authenticated POST, PUT, PATCH and DELETE requests must send the token in the X-CSRF-Token header.
+// @Produce json
+// @Success 200 {object} models.CSRFTokenResponse "response with csrfToken field"
+// @Failure 500 {object} models.ErrorResponse "response with error field"
+// @Router /csrf [get]
+func CSRFToken(c *handlers.Exchange) {
+	token, err := middleware.IssueCSRFToken(c.Writer, c.Request)
+	if err != nil {
+		log.Error().Str("clientIP", c.ClientIP()).Str("error", err.Error()).Msg("Failed to issue CSRF token")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, handlers.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, handlers.H{"csrfToken": token})
 }
 
-// getAllowedOrigins reads the allowed CORS origins from an environment variable with defaults
-func getAllowedOrigins() []string {
-	corsAllowedOrigins := os.Getenv("CORS_ALLOWED_ORIGINS")
+// AllowedOrigins reads the allowed CORS origins from an environment variable with defaults
+func AllowedOrigins() []string {
+	corsAllowedOrigins := os.Getenv(config.CorsAllowedOrigins)
 	if corsAllowedOrigins == "" {
 		return []string{
 			"http://localhost:5173",
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/matryer/is"
@@ -39,3 +40,23 @@ func TestPingRoute(t *testing.T) {
 	is.Equal(http.StatusOK, rr.Code)
 	is.Equal(response["message"], "pong")
 }
+
+// TestMetricsRoute tests that the `/metrics` route reports the password
+// hashing queue in the Prometheus text format
+func TestMetricsRoute(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	server, err := server.NewAPIServer(testDB)
+	is.NoErr(err)
+	server.SetupRoutes()
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+
+	is.Equal(http.StatusOK, rr.Code)
+	is.True(strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain"))
+	is.True(strings.Contains(rr.Body.String(), "# TYPE goauth_hash_queue_wait_seconds histogram\n"))
+	is.True(strings.Contains(rr.Body.String(), "goauth_hash_rejected_total "))
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// accessTokenNameMaxLength is the longest name a personal access token can have
+const accessTokenNameMaxLength = 100
+
+// CreatedAccessToken is a new personal access token. Token is only available
+// here; the database holds its hash.
+type CreatedAccessToken struct {
+	Token string
+	Info  models.AccessTokenInfo
+}
+
+// AccessTokenService manages the personal access tokens users create for
+// scripts and CI jobs, and authenticates requests made with them
+type AccessTokenService struct {
+	UserRepo        *repository.UserRepository
+	AccessTokenRepo *repository.AccessTokenRepository
+	AuditRepo       *repository.AuditRepository
+}
+
+// NewAccessTokenService returns a value of type AccessTokenService
+func NewAccessTokenService(
+	ur *repository.UserRepository,
+	tr *repository.AccessTokenRepository,
+	ar *repository.AuditRepository,
+) (*AccessTokenService, error) {
+	if ur == nil {
+		return nil, apperrors.ErrUserRepoIsNil
+	}
+	if tr == nil {
+		return nil, apperrors.ErrAccessTokenRepoIsNil
+	}
+	if ar == nil {
+		return nil, apperrors.ErrAuditRepoIsNil
+	}
+	return &AccessTokenService{UserRepo: ur, AccessTokenRepo: tr, AuditRepo: ar}, nil
+}
+
+// CreateAccessToken creates a token for a user with a name, scopes, and an
+// expiry in days from now. Zero days creates a token that never expires.
+// Only admins can grant the admin scope.
+func (ts *AccessTokenService) CreateAccessToken(userID, name string, scopes []string, expiresInDays int) (*CreatedAccessToken, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > accessTokenNameMaxLength {
+		return nil, apperrors.ErrAccessTokenName
+	}
+	if err := models.ValidateAccessTokenScopes(scopes); err != nil {
+		return nil, err
+	}
+	if expiresInDays < 0 || expiresInDays > config.MaxAccessTokenDays {
+		return nil, apperrors.ErrAccessTokenExpiry
+	}
+
+	user, err := ts.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, apperrors.ErrUserNotFound
+	}
+	for _, scope := range scopes {
+		if scope == models.ScopeAdmin && user.Role != models.RoleAdmin {
+			return nil, apperrors.ErrAccessTokenAdmin
+		}
+	}
+
+	count, err := ts.AccessTokenRepo.CountAccessTokensByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= config.MaxAccessTokensPerUser {
+		return nil, apperrors.ErrAccessTokenLimit
+	}
+
+	var expiresAt *time.Time
+	if expiresInDays > 0 {
+		t := time.Now().UTC().Add(time.Duration(expiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	token, tokenHash, err := models.GenerateAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := models.NewAccessToken(user.ID, tokenHash, name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.AccessTokenRepo.CreateAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	ts.recordEvent(models.AuditAccessTokenCreated, user.ID, accessToken)
+	return &CreatedAccessToken{Token: token, Info: accessToken.Info()}, nil
+}
+
+// ListAccessTokens returns a user's tokens, newest first, including expired ones
+func (ts *AccessTokenService) ListAccessTokens(userID string) ([]models.AccessTokenInfo, error) {
+	tokens, err := ts.AccessTokenRepo.GetAccessTokensByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]models.AccessTokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		infos = append(infos, token.Info())
+	}
+	return infos, nil
+}
+
+// RevokeAccessToken deletes one of a user's tokens by its ID
+func (ts *AccessTokenService) RevokeAccessToken(userID, tokenID string) error {
+	parsedID, err := uuid.Parse(tokenID)
+	if err != nil {
+		return apperrors.ErrAccessTokenNotFound
+	}
+	err = ts.AccessTokenRepo.DeleteUserAccessTokenByID(userID, parsedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperrors.ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if user, err := uuid.Parse(userID); err == nil {
+		ts.recordEvent(models.AuditAccessTokenRevoked, user, &models.AccessToken{ID: parsedID})
+	}
+	return nil
+}
+
+// Authenticate returns the unexpired token matching a bearer token, recording
+// its use from `clientIP`. Tokens of locked accounts and accounts scheduled
+// for deletion are refused.
+func (ts *AccessTokenService) Authenticate(token, clientIP string) (*models.AccessToken, error) {
+	if !models.IsAccessToken(token) {
+		return nil, apperrors.ErrInvalidTokenFormat
+	}
+	accessToken, err := ts.AccessTokenRepo.GetUnexpiredAccessTokenByTokenHash(models.HashAccessToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	user := accessToken.User
+	if user == nil {
+		return nil, apperrors.ErrUserNotFound
+	}
+	if user.AccountLocked && (user.AccountLockedUntil == nil || now.Before(*user.AccountLockedUntil)) {
+		return nil, apperrors.ErrAccountIsLocked
+	}
+	if user.PendingDeletion() {
+		return nil, apperrors.ErrAccountPendingDeletion
+	}
+
+	// Record the use at most once per interval unless the IP changed
+	if accessToken.LastUsedAt == nil ||
+		now.Sub(*accessToken.LastUsedAt) >= config.AccessTokenLastUsedInterval ||
+		accessToken.LastUsedIP != clientIP {
+		if err := ts.AccessTokenRepo.UpdateAccessTokenLastUsed(accessToken.ID, now, clientIP); err != nil {
+			log.Warn().
+				Str("tokenID", accessToken.ID.String()).
+				Str("error", err.Error()).
+				Msg("Could not record access token use")
+		}
+		accessToken.LastUsedAt = &now
+		accessToken.LastUsedIP = clientIP
+	}
+	return accessToken, nil
+}
+
+// recordEvent records the creation or revocation of a token in the audit log
+func (ts *AccessTokenService) recordEvent(eventType string, userID uuid.UUID, token *models.AccessToken) {
+	details := fmt.Sprintf("token %s", token.ID)
+	if token.Name != "" {
+		details += fmt.Sprintf(" %q with scopes %s", token.Name, token.Scopes)
+	}
+	if err := ts.AuditRepo.CreateAuditEvent(models.NewAuditEvent(eventType, userID, details)); err != nil {
+		log.Warn().
+			Str("userID", userID.String()).
+			Str("error", err.Error()).
+			Msg("Could not record access token event")
+	}
+}
@@ -0,0 +1,144 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+func TestAccessTokenService_NewAccessTokenService(t *testing.T) {
+	is := is.New(t)
+
+	_, err := services.NewAccessTokenService(nil, nil, nil)
+	is.Equal(err, apperrors.ErrUserRepoIsNil)
+}
+
+func TestAccessTokenService_CreateAccessToken(t *testing.T) {
+	is := is.New(t)
+	ts := setupAccessTokenService(t)
+
+	user, err := models.NewUser("testCreateAccessToken@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(ts.UserRepo.DB.Create(user).Error)
+	userID := user.ID.String()
+
+	t.Run("creates a token shown once", func(t *testing.T) {
+		created, err := ts.CreateAccessToken(userID, " ci ", []string{models.ScopeProfile}, 30)
+		is.NoErr(err)
+		is.True(models.IsAccessToken(created.Token))
+		is.Equal(created.Info.Name, "ci")
+		is.True(created.Info.ExpiresAt.After(time.Now().Add(29 * 24 * time.Hour)))
+
+		// Only the hash is stored
+		var stored models.AccessToken
+		is.NoErr(ts.AccessTokenRepo.DB.First(&stored, "id = ?", created.Info.ID).Error)
+		is.Equal(stored.TokenHash, models.HashAccessToken(created.Token))
+
+		// The creation is audited
+		events, err := ts.AuditRepo.GetAuditEventsByUserID(userID)
+		is.NoErr(err)
+		is.Equal(len(events), 1)
+		is.Equal(events[0].Type, models.AuditAccessTokenCreated)
+	})
+
+	t.Run("without expiry", func(t *testing.T) {
+		created, err := ts.CreateAccessToken(userID, "forever", []string{models.ScopeExport}, 0)
+		is.NoErr(err)
+		is.True(created.Info.ExpiresAt == nil)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		_, err := ts.CreateAccessToken(userID, "", []string{models.ScopeProfile}, 0)
+		is.Equal(err, apperrors.ErrAccessTokenName)
+		_, err = ts.CreateAccessToken(userID, "ci", nil, 0)
+		is.Equal(err, apperrors.ErrAccessTokenNoScopes)
+		_, err = ts.CreateAccessToken(userID, "ci", []string{"everything"}, 0)
+		is.Equal(err, apperrors.ErrAccessTokenScope)
+		_, err = ts.CreateAccessToken(userID, "ci", []string{models.ScopeProfile}, config.MaxAccessTokenDays+1)
+		is.Equal(err, apperrors.ErrAccessTokenExpiry)
+	})
+
+	t.Run("admin scope needs the admin role", func(t *testing.T) {
+		_, err := ts.CreateAccessToken(userID, "ci", []string{models.ScopeAdmin}, 0)
+		is.Equal(err, apperrors.ErrAccessTokenAdmin)
+
+		is.NoErr(ts.UserRepo.DB.Model(user).Update("role", models.RoleAdmin).Error)
+		_, err = ts.CreateAccessToken(userID, "ci", []string{models.ScopeAdmin}, 0)
+		is.NoErr(err)
+	})
+}
+
+func TestAccessTokenService_Authenticate(t *testing.T) {
+	is := is.New(t)
+	ts := setupAccessTokenService(t)
+
+	user, err := models.NewUser("testAuthenticateAccessToken@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(ts.UserRepo.DB.Create(user).Error)
+	userID := user.ID.String()
+
+	created, err := ts.CreateAccessToken(userID, "ci", []string{models.ScopeProfile}, 0)
+	is.NoErr(err)
+
+	t.Run("records the use", func(t *testing.T) {
+		token, err := ts.Authenticate(created.Token, "203.0.113.7")
+		is.NoErr(err)
+		is.Equal(token.UserID, user.ID)
+		is.Equal(token.ScopeList(), []string{models.ScopeProfile})
+
+		tokens, err := ts.ListAccessTokens(userID)
+		is.NoErr(err)
+		is.Equal(len(tokens), 1)
+		is.True(tokens[0].LastUsedAt != nil)
+		is.Equal(tokens[0].LastUsedIP, "203.0.113.7")
+	})
+
+	t.Run("refuses unknown and session tokens", func(t *testing.T) {
+		_, err := ts.Authenticate(models.AccessTokenPrefix+"unknown", "")
+		is.True(err != nil)
+		_, err = ts.Authenticate("default.c2VjcmV0.c2lnbmF0dXJl", "")
+		is.Equal(err, apperrors.ErrInvalidTokenFormat)
+	})
+
+	t.Run("refuses locked accounts", func(t *testing.T) {
+		is.NoErr(ts.UserRepo.DB.Model(user).Update("account_locked", true).Error)
+		_, err := ts.Authenticate(created.Token, "")
+		is.Equal(err, apperrors.ErrAccountIsLocked)
+		is.NoErr(ts.UserRepo.DB.Model(user).Update("account_locked", false).Error)
+	})
+
+	t.Run("refuses revoked tokens", func(t *testing.T) {
+		is.Equal(ts.RevokeAccessToken(userID, uuid.New().String()), apperrors.ErrAccessTokenNotFound)
+		is.Equal(ts.RevokeAccessToken(userID, "not-a-uuid"), apperrors.ErrAccessTokenNotFound)
+
+		is.NoErr(ts.RevokeAccessToken(userID, created.Info.ID.String()))
+		_, err := ts.Authenticate(created.Token, "")
+		is.True(err != nil)
+	})
+}
+
+func setupAccessTokenService(t *testing.T) *services.AccessTokenService {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	ur, _ := repository.NewUserRepository(tx)
+	tr, _ := repository.NewAccessTokenRepository(tx)
+	ar, _ := repository.NewAuditRepository(tx)
+	ts, err := services.NewAccessTokenService(ur, tr, ar)
+	if err != nil {
+		t.Fatalf("failed to create access token service: %v", err)
+	}
+	return ts
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// ClientAssertionType is the `client_assertion_type` of a `private_key_jwt`
+// token request, from RFC 7523
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const (
+	// clientAssertionMaxLifetime bounds how far in the future a client
+	// assertion may expire, which bounds how long its ID must be remembered
+	clientAssertionMaxLifetime = 10 * time.Minute
+	// clientAssertionLeeway allows for clock skew between the service
+	// account and the server
+	clientAssertionLeeway = 30 * time.Second
+	// clientAssertionMaxJTI is the longest assertion ID that is stored
+	clientAssertionMaxJTI = 255
+)
+
+// ClientAssertionClaims are the verified claims of a client assertion
+type ClientAssertionClaims struct {
+	ClientID  string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// clientAssertionHeader is the JOSE header of a client assertion
+type clientAssertionHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+// clientAssertionPayload is the claims set of a client assertion. Times are
+// JSON numbers, which may have a fraction.
+type clientAssertionPayload struct {
+	Issuer    string            `json:"iss"`
+	Subject   string            `json:"sub"`
+	Audience  assertionAudience `json:"aud"`
+	ExpiresAt float64           `json:"exp"`
+	NotBefore float64           `json:"nbf"`
+	JTI       string            `json:"jti"`
+}
+
+// assertionAudience is the `aud` claim, which is a string or an array of them
+type assertionAudience []string
+
+func (a *assertionAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = []string{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// ParseServiceAccountPublicKey parses the PEM encoded public key of a service
+// account and returns the JWS algorithm its assertions must be signed with:
+// RS256 for RSA keys of at least 2048 bits, ES256 for P-256 keys and EdDSA
+// for Ed25519 keys
+func ParseServiceAccountPublicKey(publicKey string) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", apperrors.ErrServiceAccountPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", apperrors.ErrServiceAccountPublicKey
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() >= 2048 {
+			return k, "RS256", nil
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return k, "ES256", nil
+		}
+	case ed25519.PublicKey:
+		return k, "EdDSA", nil
+	}
+	return nil, "", apperrors.ErrServiceAccountPublicKey
+}
+
+// ClientAssertionSubject returns the client ID a client assertion claims to
+// be from, without verifying it, to find the key to verify it with
+func ClientAssertionSubject(assertion string) (string, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: not a compact JWS", apperrors.ErrClientAssertion)
+	}
+	var payload clientAssertionPayload
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return "", err
+	}
+	if payload.Subject == "" {
+		return "", fmt.Errorf("%w: missing sub", apperrors.ErrClientAssertion)
+	}
+	return payload.Subject, nil
+}
+
+// VerifyClientAssertion checks that a `private_key_jwt` client assertion is
+// signed by `publicKey` with the algorithm of the key, was issued by and
+// about `clientID` for `audience`, and is valid at `now`. Assertions must
+// have an ID and expire within 10 minutes.
+func VerifyClientAssertion(assertion, publicKey, clientID, audience string, now time.Time) (*ClientAssertionClaims, error) {
+	key, alg, err := ParseServiceAccountPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a compact JWS", apperrors.ErrClientAssertion)
+	}
+	var header clientAssertionHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// The key decides the algorithm, so an assertion cannot pick a weaker one
+	if header.Algorithm != alg {
+		return nil, fmt.Errorf("%w: alg must be %s", apperrors.ErrClientAssertion, alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", apperrors.ErrClientAssertion)
+	}
+	if !verifySignature(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("%w: bad signature", apperrors.ErrClientAssertion)
+	}
+
+	var payload clientAssertionPayload
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, err
+	}
+	switch {
+	case payload.Issuer != clientID || payload.Subject != clientID:
+		return nil, fmt.Errorf("%w: iss and sub must be the client ID", apperrors.ErrClientAssertion)
+	case !slices.Contains(payload.Audience, audience):
+		return nil, fmt.Errorf("%w: aud must include %s", apperrors.ErrClientAssertion, audience)
+	case payload.JTI == "" || len(payload.JTI) > clientAssertionMaxJTI:
+		return nil, fmt.Errorf("%w: jti must be 1 to %d characters", apperrors.ErrClientAssertion, clientAssertionMaxJTI)
+	}
+
+	expiresAt, ok := numericDate(payload.ExpiresAt)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing or invalid exp", apperrors.ErrClientAssertion)
+	}
+	notBefore, hasNotBefore := numericDate(payload.NotBefore)
+	switch {
+	case payload.NotBefore != 0 && !hasNotBefore:
+		return nil, fmt.Errorf("%w: invalid nbf", apperrors.ErrClientAssertion)
+	case !now.Add(-clientAssertionLeeway).Before(expiresAt):
+		return nil, fmt.Errorf("%w: expired", apperrors.ErrClientAssertion)
+	case expiresAt.After(now.Add(clientAssertionMaxLifetime + clientAssertionLeeway)):
+		return nil, fmt.Errorf("%w: exp is more than %s away", apperrors.ErrClientAssertion, clientAssertionMaxLifetime)
+	case hasNotBefore && notBefore.After(now.Add(clientAssertionLeeway)):
+		return nil, fmt.Errorf("%w: not valid yet", apperrors.ErrClientAssertion)
+	}
+
+	return &ClientAssertionClaims{ClientID: clientID, JTI: payload.JTI, ExpiresAt: expiresAt}, nil
+}
+
+// verifySignature checks a JWS signature over `input` with an RS256, ES256
+// or EdDSA key
+func verifySignature(key crypto.PublicKey, input, signature []byte) bool {
+	digest := sha256.Sum256(input)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the 32 byte R and S concatenated
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, input, signature)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWS into `v`
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", apperrors.ErrClientAssertion)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed JSON", apperrors.ErrClientAssertion)
+	}
+	return nil
+}
+
+// numericDate converts a JWT NumericDate to a time. It reports false for
+// missing dates and those too far out to represent.
+func numericDate(seconds float64) (time.Time, bool) {
+	if seconds <= 0 || seconds > math.MaxInt32*16 {
+		return time.Time{}, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))).UTC(), true
+}
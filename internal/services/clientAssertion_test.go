@@ -0,0 +1,185 @@
+package services_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+const testTokenEndpoint = "https://auth.example.com/oauth/token"
+
+// publicKeyPEM encodes the public half of `key` like the keys admins register
+func publicKeyPEM(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signAssertion signs `claims` with `key` as a compact JWS with `alg`
+func signAssertion(t *testing.T, key crypto.Signer, alg string, claims map[string]any) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to marshal JWS segment: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+
+	var signature []byte
+	var err error
+	digest := sha256.Sum256([]byte(input))
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(input))
+	case *ecdsa.PrivateKey:
+		var r, s []byte
+		rInt, sInt, signErr := ecdsa.Sign(rand.Reader, k, digest[:])
+		err = signErr
+		r, s = rInt.FillBytes(make([]byte, 32)), sInt.FillBytes(make([]byte, 32))
+		signature = append(r, s...)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatalf("failed to sign assertion: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// assertionClaims are valid claims for `clientID`, which tests change
+func assertionClaims(clientID string, now time.Time) map[string]any {
+	return map[string]any{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": testTokenEndpoint,
+		"jti": "assertion-1",
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func TestVerifyClientAssertion(t *testing.T) {
+	is := is.New(t)
+	now := time.Now().UTC()
+	clientID := "goauth_sa_test"
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoErr(err)
+
+	keys := map[string]crypto.Signer{"EdDSA": edKey, "ES256": ecKey, "RS256": rsaKey}
+	for alg, key := range keys {
+		t.Run("accepts "+alg, func(t *testing.T) {
+			assertion := signAssertion(t, key, alg, assertionClaims(clientID, now))
+
+			subject, err := services.ClientAssertionSubject(assertion)
+			is.NoErr(err)
+			is.Equal(subject, clientID)
+
+			claims, err := services.VerifyClientAssertion(assertion, publicKeyPEM(t, key), clientID, testTokenEndpoint, now)
+			is.NoErr(err)
+			is.Equal(claims.JTI, "assertion-1")
+			is.Equal(claims.ExpiresAt.Unix(), now.Add(5*time.Minute).Unix())
+		})
+	}
+
+	t.Run("accepts an audience list", func(t *testing.T) {
+		claims := assertionClaims(clientID, now)
+		claims["aud"] = []string{"https://other.example.com", testTokenEndpoint}
+		_, err := services.VerifyClientAssertion(signAssertion(t, edKey, "EdDSA", claims), publicKeyPEM(t, edKey), clientID, testTokenEndpoint, now)
+		is.NoErr(err)
+	})
+
+	refused := map[string]func(claims map[string]any) (crypto.Signer, string){
+		"another client": func(c map[string]any) (crypto.Signer, string) { c["sub"] = "goauth_sa_other"; return edKey, "EdDSA" },
+		"another issuer": func(c map[string]any) (crypto.Signer, string) { c["iss"] = "goauth_sa_other"; return edKey, "EdDSA" },
+		"another audience": func(c map[string]any) (crypto.Signer, string) {
+			c["aud"] = "https://other.example.com"
+			return edKey, "EdDSA"
+		},
+		"expired": func(c map[string]any) (crypto.Signer, string) {
+			c["exp"] = now.Add(-time.Minute).Unix()
+			return edKey, "EdDSA"
+		},
+		"too long lived": func(c map[string]any) (crypto.Signer, string) {
+			c["exp"] = now.Add(time.Hour).Unix()
+			return edKey, "EdDSA"
+		},
+		"not valid yet": func(c map[string]any) (crypto.Signer, string) {
+			c["nbf"] = now.Add(time.Minute).Unix()
+			return edKey, "EdDSA"
+		},
+		"without an ID":      func(c map[string]any) (crypto.Signer, string) { delete(c, "jti"); return edKey, "EdDSA" },
+		"without an expiry":  func(c map[string]any) (crypto.Signer, string) { delete(c, "exp"); return edKey, "EdDSA" },
+		"another key":        func(c map[string]any) (crypto.Signer, string) { return ecKey, "ES256" },
+		"another algorithm":  func(c map[string]any) (crypto.Signer, string) { return edKey, "ES256" },
+		"the none algorithm": func(c map[string]any) (crypto.Signer, string) { return edKey, "none" },
+	}
+	for name, change := range refused {
+		t.Run("refuses "+name, func(t *testing.T) {
+			claims := assertionClaims(clientID, now)
+			key, alg := change(claims)
+			_, err := services.VerifyClientAssertion(signAssertion(t, key, alg, claims), publicKeyPEM(t, edKey), clientID, testTokenEndpoint, now)
+			is.True(errors.Is(err, apperrors.ErrClientAssertion))
+		})
+	}
+
+	t.Run("refuses a tampered assertion", func(t *testing.T) {
+		assertion := signAssertion(t, edKey, "EdDSA", assertionClaims(clientID, now))
+		other := signAssertion(t, edKey, "EdDSA", assertionClaims("goauth_sa_other", now))
+		tampered := assertion[:len(assertion)-86] + other[len(other)-86:]
+		_, err := services.VerifyClientAssertion(tampered, publicKeyPEM(t, edKey), clientID, testTokenEndpoint, now)
+		is.True(errors.Is(err, apperrors.ErrClientAssertion))
+
+		_, err = services.VerifyClientAssertion("not.a-jwt", publicKeyPEM(t, edKey), clientID, testTokenEndpoint, now)
+		is.True(errors.Is(err, apperrors.ErrClientAssertion))
+	})
+}
+
+func TestParseServiceAccountPublicKey(t *testing.T) {
+	is := is.New(t)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+	_, alg, err := services.ParseServiceAccountPublicKey(publicKeyPEM(t, ecKey))
+	is.NoErr(err)
+	is.Equal(alg, "ES256")
+
+	// Weak or unsupported keys are refused
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	is.NoErr(err)
+	_, _, err = services.ParseServiceAccountPublicKey(publicKeyPEM(t, smallKey))
+	is.Equal(err, apperrors.ErrServiceAccountPublicKey)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	is.NoErr(err)
+	_, _, err = services.ParseServiceAccountPublicKey(publicKeyPEM(t, p384Key))
+	is.Equal(err, apperrors.ErrServiceAccountPublicKey)
+
+	_, _, err = services.ParseServiceAccountPublicKey("not a key")
+	is.Equal(err, apperrors.ErrServiceAccountPublicKey)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"time"
+
+	"github.com/al-ce/goauth/internal/mailer"
+)
+
+// registrationAttemptEmail tells an account owner someone tried to register with their email
+func registrationAttemptEmail(to string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Sign up attempt with your email",
+		Body: "Someone tried to create an account with this email address, which already has one.\n\n" +
+			"If this was you, log in with your existing password instead. " +
+			"If not, you can ignore this email; your account has not been changed.",
+	}
+}
+
+// welcomeEmail confirms a new registration
+func welcomeEmail(to string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Your account has been created",
+		Body:    "Welcome! Your account has been created and you can now log in.",
+	}
+}
+
+// deletionScheduledEmail confirms an account deletion request and explains
+// how to undo it before `purgeAfter`
+func deletionScheduledEmail(to, token, link string, purgeAfter time.Time) mailer.Message {
+	restore := "To restore it, use this code: " + token
+	if link != "" {
+		restore = "To restore it, follow this link: " + link
+	}
+	return mailer.Message{
+		To:      to,
+		Subject: "Your account is scheduled for deletion",
+		Body: "Your account and its data will be permanently deleted on " +
+			purgeAfter.Format("January 2, 2006 at 15:04 MST") + ". " +
+			"You have been logged out everywhere and cannot log in until then.\n\n" +
+			restore + "\n\n" +
+			"If you did not ask to delete your account, restore it and change your password.",
+	}
+}
+
+// accountRestoredEmail confirms an account's pending deletion was cancelled
+func accountRestoredEmail(to string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Your account has been restored",
+		Body:    "Your account is no longer scheduled for deletion and you can log in again.",
+	}
+}
+
+// magicLinkEmail sends a single-use login link that works until `expiresAt`
+func magicLinkEmail(to, token, link string, expiresAt time.Time) mailer.Message {
+	login := "To log in, use this code: " + token
+	if link != "" {
+		login = "To log in, follow this link: " + link
+	}
+	return mailer.Message{
+		To:      to,
+		Subject: "Your login link",
+		Body: login + "\n\n" +
+			"It works once, until " + expiresAt.Format("January 2, 2006 at 15:04 MST") + ", " +
+			"and only in the browser you asked for it from.\n\n" +
+			"If you did not ask to log in, you can ignore this email.",
+	}
+}
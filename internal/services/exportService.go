@@ -0,0 +1,349 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Export formats accepted by ExportData and RequestExport
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZip  = "zip"
+)
+
+// exportTokenBytes is the number of random bytes in an export download token
+const exportTokenBytes = 32
+
+// exportFileName is the name of the JSON file inside a zip export
+const exportFileName = "export.json"
+
+// ExportUser is a user's profile in an export. Credentials and restore tokens
+// are left out.
+type ExportUser struct {
+	ID                  uuid.UUID  `json:"id"`
+	Email               string     `json:"email"`
+	EmailCanonical      string     `json:"emailCanonical"`
+	Role                string     `json:"role"`
+	LastLogin           *time.Time `json:"lastLogin"`
+	FailedLoginAttempts int        `json:"failedLoginAttempts"`
+	AccountLocked       bool       `json:"accountLocked"`
+	AccountLockedUntil  *time.Time `json:"accountLockedUntil"`
+	PasswordBreached    bool       `json:"passwordBreached"`
+	DeletionRequestedAt *time.Time `json:"deletionRequestedAt"`
+	PurgeAfter          *time.Time `json:"purgeAfter"`
+}
+
+// NewExportUser returns the exportable profile of a user
+func NewExportUser(user *models.User) ExportUser {
+	return ExportUser{
+		ID:                  user.ID,
+		Email:               user.Email,
+		EmailCanonical:      user.EmailCanonical,
+		Role:                user.Role,
+		LastLogin:           user.LastLogin,
+		FailedLoginAttempts: user.FailedLoginAttempts,
+		AccountLocked:       user.AccountLocked,
+		AccountLockedUntil:  user.AccountLockedUntil,
+		PasswordBreached:    user.PasswordBreached,
+		DeletionRequestedAt: user.DeletionRequestedAt,
+		PurgeAfter:          user.PurgeAfter,
+	}
+}
+
+// ExportSession is a session in an export, without its token hash
+type ExportSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	AuthTime  time.Time `json:"authTime"`
+}
+
+// ExportAuditEvent is an audit event about the user in an export
+type ExportAuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportIdentity is an external identity linked to the user. Accounts cannot
+// link identities yet, so exports always have an empty list.
+type ExportIdentity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	LinkedAt time.Time `json:"linkedAt"`
+}
+
+// ExportBundle is a copy of the personal data held about a user
+type ExportBundle struct {
+	ExportedAt  time.Time          `json:"exportedAt"`
+	User        ExportUser         `json:"user"`
+	Sessions    []ExportSession    `json:"sessions"`
+	AuditEvents []ExportAuditEvent `json:"auditEvents"`
+	Identities  []ExportIdentity   `json:"identities"`
+}
+
+// ExportRequest is a background export waiting to be generated. Token
+// downloads it once ready, until ExpiresAt.
+type ExportRequest struct {
+	ID        uuid.UUID `json:"id"`
+	Status    string    `json:"status"`
+	Token     string    `json:"-"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ExportService gives users a copy of their personal data, right away or
+// generated in the background for large accounts
+type ExportService struct {
+	UserRepo    *repository.UserRepository
+	SessionRepo *repository.SessionRepository
+	AuditRepo   *repository.AuditRepository
+	ExportRepo  *repository.ExportRepository
+}
+
+// NewExportService returns a value of type ExportService
+func NewExportService(
+	ur *repository.UserRepository,
+	sr *repository.SessionRepository,
+	ar *repository.AuditRepository,
+	er *repository.ExportRepository,
+) (*ExportService, error) {
+	if ur == nil {
+		return nil, apperrors.ErrUserRepoIsNil
+	}
+	if sr == nil {
+		return nil, apperrors.ErrSessionRepoIsNil
+	}
+	if ar == nil {
+		return nil, apperrors.ErrAuditRepoIsNil
+	}
+	if er == nil {
+		return nil, apperrors.ErrExportRepoIsNil
+	}
+	return &ExportService{UserRepo: ur, SessionRepo: sr, AuditRepo: ar, ExportRepo: er}, nil
+}
+
+// BuildBundle collects the personal data held about a user
+func (es *ExportService) BuildBundle(userID string) (*ExportBundle, error) {
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	user, err := es.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, apperrors.ErrUserNotFound
+	}
+	sessions, err := es.SessionRepo.GetSessionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := es.AuditRepo.GetAuditEventsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &ExportBundle{
+		ExportedAt:  time.Now().UTC(),
+		User:        NewExportUser(user),
+		Sessions:    make([]ExportSession, 0, len(sessions)),
+		AuditEvents: make([]ExportAuditEvent, 0, len(events)),
+		Identities:  []ExportIdentity{},
+	}
+	for _, s := range sessions {
+		bundle.Sessions = append(bundle.Sessions, ExportSession{
+			ID:        s.ID,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+			AuthTime:  s.AuthTime,
+		})
+	}
+	for _, e := range events {
+		bundle.AuditEvents = append(bundle.AuditEvents, ExportAuditEvent{
+			ID:        e.ID,
+			Type:      e.Type,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	return bundle, nil
+}
+
+// ExportData returns a user's data in the given format, recording who asked for it
+func (es *ExportService) ExportData(userID, requestedBy, format string) ([]byte, error) {
+	if err := validateExportFormat(format); err != nil {
+		return nil, err
+	}
+	bundle, err := es.BuildBundle(userID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := EncodeExport(bundle, format)
+	if err != nil {
+		return nil, err
+	}
+	es.recordExport(bundle.User.ID, requestedBy, format)
+	return data, nil
+}
+
+// RequestExport queues an export of a user's data to be generated in the
+// background by the ProcessDataExports job
+func (es *ExportService) RequestExport(userID, requestedBy, format string) (*ExportRequest, error) {
+	if err := validateExportFormat(format); err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	user, err := es.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, apperrors.ErrUserNotFound
+	}
+	requester, err := uuid.Parse(requestedBy)
+	if err != nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+
+	random := make([]byte, exportTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+
+	expiresAt := time.Now().UTC().Add(config.DataExportLinkTTL)
+	export := models.NewDataExport(user.ID, requester, format, models.HashSessionSecret(token), expiresAt)
+	if err := es.ExportRepo.CreateExport(export); err != nil {
+		return nil, err
+	}
+	return &ExportRequest{
+		ID:        export.ID,
+		Status:    export.Status,
+		Token:     token,
+		ExpiresAt: export.ExpiresAt,
+	}, nil
+}
+
+// ProcessPendingExports claims and generates up to `limit` pending exports
+// and returns how many were processed. Each instance of the server can run
+// it: an export is claimed for DataExportLease, and only recorded once. An
+// export that cannot be generated is marked failed.
+func (es *ExportService) ProcessPendingExports(limit int) (int, error) {
+	exports, err := es.ExportRepo.ClaimPendingExports(time.Now().UTC(), limit, config.DataExportLease)
+	if err != nil {
+		return 0, err
+	}
+	for _, export := range exports {
+		bundle, err := es.BuildBundle(export.UserID.String())
+		var data []byte
+		if err == nil {
+			data, err = EncodeExport(bundle, export.Format)
+		}
+		if err != nil {
+			log.Error().
+				Str("exportID", export.ID.String()).
+				Str("error", err.Error()).
+				Msg("Could not generate data export")
+			if err := es.ExportRepo.FailExport(export.ID, err.Error()); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		completed, err := es.ExportRepo.CompleteExport(export.ID, data)
+		if err != nil {
+			return 0, err
+		}
+		if !completed {
+			continue
+		}
+		es.recordExport(export.UserID, export.RequestedBy.String(), export.Format)
+	}
+	return len(exports), nil
+}
+
+// DownloadExport returns a background export by its download token
+func (es *ExportService) DownloadExport(token string) (*models.DataExport, error) {
+	if token == "" {
+		return nil, apperrors.ErrExportNotFound
+	}
+	export, err := es.ExportRepo.GetUnexpiredExportByTokenHash(models.HashSessionSecret(token))
+	if err != nil {
+		return nil, err
+	}
+	switch export.Status {
+	case models.ExportStatusPending:
+		return export, apperrors.ErrExportNotReady
+	case models.ExportStatusFailed:
+		return export, apperrors.ErrExportFailed
+	}
+	return export, nil
+}
+
+// recordExport adds an audit event for a generated export
+func (es *ExportService) recordExport(userID uuid.UUID, requestedBy, format string) {
+	details := fmt.Sprintf("%s export requested by %s", format, requestedBy)
+	if err := es.AuditRepo.CreateAuditEvent(models.NewAuditEvent(models.AuditDataExported, userID, details)); err != nil {
+		log.Warn().
+			Str("userID", userID.String()).
+			Str("error", err.Error()).
+			Msg("Could not record data export")
+	}
+}
+
+// EncodeExport encodes a bundle as indented JSON, or as a zip archive holding
+// that JSON as `export.json`
+func EncodeExport(bundle *ExportBundle, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ExportFormatJSON:
+		return data, nil
+	case ExportFormatZip:
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     exportFileName,
+			Method:   zip.Deflate,
+			Modified: bundle.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("%w: %q", apperrors.ErrExportFormat, format)
+}
+
+// ExportContentType returns the media type of an export format
+func ExportContentType(format string) string {
+	if format == ExportFormatZip {
+		return "application/zip"
+	}
+	return "application/json"
+}
+
+// validateExportFormat checks an export format is json or zip
+func validateExportFormat(format string) error {
+	if format != ExportFormatJSON && format != ExportFormatZip {
+		return fmt.Errorf("%w: %q", apperrors.ErrExportFormat, format)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// TestEncodeExport tests the JSON and zip encodings of an export
+func TestEncodeExport(t *testing.T) {
+	is := is.New(t)
+
+	bundle := &services.ExportBundle{
+		ExportedAt:  time.Now().UTC(),
+		User:        services.ExportUser{Email: "testEncodeExport@test.com"},
+		Sessions:    []services.ExportSession{},
+		AuditEvents: []services.ExportAuditEvent{},
+		Identities:  []services.ExportIdentity{},
+	}
+
+	data, err := services.EncodeExport(bundle, services.ExportFormatJSON)
+	is.NoErr(err)
+	var decoded map[string]any
+	is.NoErr(json.Unmarshal(data, &decoded))
+	is.Equal(decoded["identities"], []any{})
+
+	zipped, err := services.EncodeExport(bundle, services.ExportFormatZip)
+	is.NoErr(err)
+	zr, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	is.NoErr(err)
+	is.Equal(zr.File[0].Name, "export.json")
+	f, err := zr.File[0].Open()
+	is.NoErr(err)
+	unzipped, err := io.ReadAll(f)
+	is.NoErr(err)
+	is.Equal(unzipped, data)
+
+	_, err = services.EncodeExport(bundle, "xml")
+	is.True(errors.Is(err, apperrors.ErrExportFormat))
+}
+
+// TestExportService_ExportData tests the contents of a user's export
+func TestExportService_ExportData(t *testing.T) {
+	is := is.New(t)
+	es := setupExportService(t)
+
+	user, err := models.NewUser("testExportData@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(es.UserRepo.RegisterUser(user))
+	session, err := models.NewSession(user.ID, models.HashSessionSecret("secret"), time.Now().Add(time.Hour))
+	is.NoErr(err)
+	is.NoErr(es.SessionRepo.CreateSession(session))
+	userID := user.ID.String()
+
+	data, err := es.ExportData(userID, userID, services.ExportFormatJSON)
+	is.NoErr(err)
+	is.True(!bytes.Contains(data, []byte(user.Password)))
+	is.True(!bytes.Contains(data, []byte(session.TokenHash)))
+
+	var bundle services.ExportBundle
+	is.NoErr(json.Unmarshal(data, &bundle))
+	is.Equal(bundle.User.ID, user.ID)
+	is.Equal(len(bundle.Sessions), 1)
+	is.Equal(bundle.Sessions[0].ID, session.ID)
+
+	// The export itself is audited, and shows up in the next one
+	bundle2, err := es.BuildBundle(userID)
+	is.NoErr(err)
+	is.Equal(len(bundle2.AuditEvents), 1)
+	is.Equal(bundle2.AuditEvents[0].Type, models.AuditDataExported)
+
+	_, err = es.ExportData(userID, userID, "xml")
+	is.True(errors.Is(err, apperrors.ErrExportFormat))
+	_, err = es.ExportData(uuid.New().String(), userID, services.ExportFormatJSON)
+	is.Equal(err, apperrors.ErrUserNotFound)
+}
+
+// TestExportService_RequestExport tests generating an export in the
+// background and downloading it with its token
+func TestExportService_RequestExport(t *testing.T) {
+	is := is.New(t)
+	es := setupExportService(t)
+
+	user, err := models.NewUser("testRequestExport@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(es.UserRepo.RegisterUser(user))
+	userID := user.ID.String()
+
+	request, err := es.RequestExport(userID, userID, services.ExportFormatZip)
+	is.NoErr(err)
+	is.Equal(request.Status, models.ExportStatusPending)
+
+	_, err = es.DownloadExport(request.Token)
+	is.Equal(err, apperrors.ErrExportNotReady)
+
+	processed, err := es.ProcessPendingExports(10)
+	is.NoErr(err)
+	is.Equal(processed, 1)
+
+	export, err := es.DownloadExport(request.Token)
+	is.NoErr(err)
+	is.Equal(export.Format, services.ExportFormatZip)
+	_, err = zip.NewReader(bytes.NewReader(export.Data), int64(len(export.Data)))
+	is.NoErr(err)
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, err := es.DownloadExport("wrong")
+		is.Equal(err, apperrors.ErrExportNotFound)
+		_, err = es.DownloadExport("")
+		is.Equal(err, apperrors.ErrExportNotFound)
+	})
+
+	t.Run("expired link", func(t *testing.T) {
+		err := es.ExportRepo.DB.Model(&models.DataExport{}).Where("id = ?", request.ID).
+			Update("expires_at", time.Now().UTC().Add(-time.Minute)).Error
+		is.NoErr(err)
+		_, err = es.DownloadExport(request.Token)
+		is.Equal(err, apperrors.ErrExportNotFound)
+
+		deleted, err := es.ExportRepo.DeleteExpiredExports(time.Now())
+		is.NoErr(err)
+		is.Equal(deleted, int64(1))
+	})
+}
+
+func setupExportService(t *testing.T) *services.ExportService {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	ur, _ := repository.NewUserRepository(tx)
+	sr, _ := repository.NewSessionRepository(tx)
+	ar, _ := repository.NewAuditRepository(tx)
+	er, _ := repository.NewExportRepository(tx)
+	es, err := services.NewExportService(ur, sr, ar, er)
+	if err != nil {
+		t.Fatalf("failed to create export service: %v", err)
+	}
+	return es
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// Import formats accepted by ImportUsers
+const (
+	ImportFormatJSONL = "jsonl"
+	ImportFormatCSV   = "csv"
+)
+
+// Row statuses in an ImportReport
+const (
+	ImportStatusImported = "imported"
+	ImportStatusValid    = "valid"
+	ImportStatusFailed   = "failed"
+)
+
+// ImportRecord is a single user to import with a password hash from another system
+type ImportRecord struct {
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// ImportRowResult is the outcome of importing one row. Rows are numbered from
+// 1 and exclude the CSV header.
+type ImportRowResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes an import. In a dry run rows are validated but not inserted.
+type ImportReport struct {
+	DryRun   bool              `json:"dryRun"`
+	Total    int               `json:"total"`
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+// ImportService bulk-creates users with pre-hashed passwords
+type ImportService struct {
+	UserRepo *repository.UserRepository
+}
+
+// NewImportService returns a value of type ImportService
+func NewImportService(ur *repository.UserRepository) (*ImportService, error) {
+	if ur == nil {
+		return nil, apperrors.ErrUserRepoIsNil
+	}
+	return &ImportService{UserRepo: ur}, nil
+}
+
+// ImportUsers reads users from JSONL or CSV and inserts every valid row. A bad
+// row is recorded in the report and does not stop the import; only an
+// unreadable input or unknown format returns an error.
+func (ims *ImportService) ImportUsers(r io.Reader, format string, dryRun bool) (*ImportReport, error) {
+	var rows []importRow
+	var err error
+	switch format {
+	case ImportFormatJSONL:
+		rows, err = parseJSONL(r)
+	case ImportFormatCSV:
+		rows, err = parseCSV(r)
+	default:
+		return nil, apperrors.ErrImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Total: len(rows), Rows: []ImportRowResult{}}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		result := ImportRowResult{Row: row.number, Email: row.record.Email}
+		if err := ims.importRow(row, seen, dryRun); err != nil {
+			result.Status = ImportStatusFailed
+			result.Error = err.Error()
+			report.Failed++
+		} else if dryRun {
+			result.Status = ImportStatusValid
+		} else {
+			result.Status = ImportStatusImported
+			report.Imported++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	return report, nil
+}
+
+// importRow validates one row and inserts it unless this is a dry run
+func (ims *ImportService) importRow(row importRow, seen map[string]bool, dryRun bool) error {
+	if row.err != nil {
+		return row.err
+	}
+	record := row.record
+	if record.Email == "" {
+		return apperrors.ErrEmailIsEmpty
+	}
+	if record.PasswordHash == "" {
+		return apperrors.ErrPasswordIsEmpty
+	}
+	if err := models.ValidateEmail(record.Email); err != nil {
+		return err
+	}
+	if err := passwords.Default().Validate(record.PasswordHash); err != nil {
+		return err
+	}
+	canonical := models.CanonicalEmail(record.Email)
+	if seen[canonical] {
+		return apperrors.ErrImportDuplicateRow
+	}
+	seen[canonical] = true
+
+	if user, _ := ims.UserRepo.GetUserByEmail(record.Email); user != nil {
+		return apperrors.ErrDuplicateEmail
+	}
+	if dryRun {
+		return nil
+	}
+	return ims.UserRepo.RegisterUser(&models.User{Email: record.Email, Password: record.PasswordHash})
+}
+
+// importRow is a parsed input row, or the error that prevented parsing it
+type importRow struct {
+	number int
+	record ImportRecord
+	err    error
+}
+
+// parseJSONL reads one JSON object per line, skipping blank lines
+func parseJSONL(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	number := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		number++
+		row := importRow{number: number}
+		row.err = json.Unmarshal([]byte(line), &row.record)
+		row.record.Email = strings.TrimSpace(row.record.Email)
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// parseCSV reads a CSV with a header row naming `email` and `password_hash`
+// columns. Other columns are ignored.
+func parseCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	emailCol, hashCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "email":
+			emailCol = i
+		case "password_hash":
+			hashCol = i
+		}
+	}
+	if emailCol < 0 || hashCol < 0 {
+		return nil, apperrors.ErrImportMissingColumn
+	}
+
+	var rows []importRow
+	for number := 1; ; number++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row := importRow{number: number}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			row.err = err
+		case err != nil:
+			return nil, err
+		case len(fields) <= emailCol || len(fields) <= hashCol:
+			row.err = apperrors.ErrImportMissingColumn
+		default:
+			row.record = ImportRecord{
+				Email:        strings.TrimSpace(fields[emailCol]),
+				PasswordHash: fields[hashCol],
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,158 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// djangoHash is a Django pbkdf2_sha256 hash of `testutils.TestingPassword`
+const djangoHash = "pbkdf2_sha256$1000$seasalt$YQJuLHjIAzeJ94LMg1+8lexz//IHX27DD2Y5+2K90Xk="
+
+// TestImportService_NewImportService tests the creation of a new ImportService
+func TestImportService_NewImportService(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("returns err with nil user repo", func(t *testing.T) {
+		importService, err := services.NewImportService(nil)
+		is.Equal(importService, nil)
+		is.Equal(err, apperrors.ErrUserRepoIsNil)
+	})
+}
+
+// TestImportService_ImportUsers tests bulk import from JSONL and CSV
+func TestImportService_ImportUsers(t *testing.T) {
+	is := is.New(t)
+
+	t.Run("imports jsonl and logs in with legacy hash", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		input := `{"email": "importedJSONL@test.com", "password_hash": "` + djangoHash + `"}
+`
+		report, err := importService.ImportUsers(strings.NewReader(input), services.ImportFormatJSONL, false)
+		is.NoErr(err)
+		is.Equal(report.Total, 1)
+		is.Equal(report.Imported, 1)
+		is.Equal(report.Rows[0].Status, services.ImportStatusImported)
+
+		// Imported user can log in and is rehashed to the current algorithm
+		_, err = us.LoginUser("importedJSONL@test.com", testutils.TestingPassword)
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByEmail("importedJSONL@test.com")
+		is.NoErr(err)
+		is.True(strings.HasPrefix(user.Password, "$argon2id$"))
+	})
+
+	t.Run("imports csv ignoring extra columns", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		input := "id,email,password_hash\n" +
+			"1,importedCSV@test.com,{SSHA}YaltpIobxj+B6KPyP5kI4VYhh1YxMjM0NTY3OA==\n"
+		report, err := importService.ImportUsers(strings.NewReader(input), services.ImportFormatCSV, false)
+		is.NoErr(err)
+		is.Equal(report.Imported, 1)
+
+		_, err = us.LoginUser("importedCSV@test.com", testutils.TestingPassword)
+		is.NoErr(err)
+	})
+
+	t.Run("reports per-row errors without stopping", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		err := us.RegisterUser("alreadyRegistered@test.com", testutils.TestingPassword)
+		is.NoErr(err)
+
+		input := strings.Join([]string{
+			`{"email": "good@test.com", "password_hash": "` + djangoHash + `"}`,
+			`not json`,
+			`{"email": "not an email", "password_hash": "` + djangoHash + `"}`,
+			`{"email": "unknownHash@test.com", "password_hash": "md5$abc$def"}`,
+			`{"email": "good@test.com", "password_hash": "` + djangoHash + `"}`,
+			`{"email": "alreadyRegistered@test.com", "password_hash": "` + djangoHash + `"}`,
+			`{"email": "noHash@test.com"}`,
+		}, "\n")
+		report, err := importService.ImportUsers(strings.NewReader(input), services.ImportFormatJSONL, false)
+		is.NoErr(err)
+		is.Equal(report.Total, 7)
+		is.Equal(report.Imported, 1)
+		is.Equal(report.Failed, 6)
+
+		is.Equal(report.Rows[0].Status, services.ImportStatusImported)
+		is.True(report.Rows[1].Error != "")
+		is.Equal(report.Rows[2].Error, apperrors.ErrEmailFormat.Error())
+		is.Equal(report.Rows[3].Error, apperrors.ErrHashFormatUnknown.Error())
+		is.Equal(report.Rows[4].Error, apperrors.ErrImportDuplicateRow.Error())
+		is.Equal(report.Rows[5].Error, apperrors.ErrDuplicateEmail.Error())
+		is.Equal(report.Rows[6].Error, apperrors.ErrPasswordIsEmpty.Error())
+	})
+
+	t.Run("rejects hashes too costly to verify", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		input := strings.Join([]string{
+			`{"email": "hugeMemory@test.com", "password_hash": "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ"}`,
+			`{"email": "manyIterations@test.com", "password_hash": "$argon2i$v=19$m=64,t=1000,p=1$c2FsdHNhbHRzYWx0$c2FsdHNhbHRzYWx0c2FsdHNhbHQ"}`,
+		}, "\n")
+		report, err := importService.ImportUsers(strings.NewReader(input), services.ImportFormatJSONL, false)
+		is.NoErr(err)
+		is.Equal(report.Failed, 2)
+		for _, row := range report.Rows {
+			is.Equal(row.Error, apperrors.ErrHashParams.Error())
+		}
+	})
+
+	t.Run("dry run inserts nothing", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		input := `{"email": "dryRun@test.com", "password_hash": "` + djangoHash + `"}`
+		report, err := importService.ImportUsers(strings.NewReader(input), services.ImportFormatJSONL, true)
+		is.NoErr(err)
+		is.True(report.DryRun)
+		is.Equal(report.Imported, 0)
+		is.Equal(report.Rows[0].Status, services.ImportStatusValid)
+
+		user, _ := us.UserRepo.GetUserByEmail("dryRun@test.com")
+		is.Equal(user, nil)
+	})
+
+	t.Run("csv without required columns", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		_, err := importService.ImportUsers(strings.NewReader("email,hash\n"), services.ImportFormatCSV, false)
+		is.Equal(err, apperrors.ErrImportMissingColumn)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		us := setupUserService(t)
+		importService := setupImportService(t, us)
+
+		_, err := importService.ImportUsers(strings.NewReader(""), "xml", false)
+		is.Equal(err, apperrors.ErrImportFormat)
+	})
+}
+
+func setupImportService(t *testing.T, us *services.UserService) *services.ImportService {
+	t.Helper()
+
+	ur, err := repository.NewUserRepository(us.UserRepo.DB)
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+	importService, err := services.NewImportService(ur)
+	if err != nil {
+		t.Fatalf("failed to create import service: %v", err)
+	}
+	return importService
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// IntrospectionTokenType is the `token_type` of an active session token
+const IntrospectionTokenType = "session"
+
+// IntrospectionService tells other backend services whether a session token
+// is active and whose it is, so they can trust a token without sharing the
+// session keys or the database
+type IntrospectionService struct {
+	UserRepo    *repository.UserRepository
+	SessionRepo *repository.SessionRepository
+	// Clients maps the IDs of the services allowed to introspect tokens to
+	// their secrets, from `INTROSPECTION_CLIENTS`
+	Clients map[string]string
+	// CacheTTL is how long a result is reused for the same caller and token,
+	// from `INTROSPECTION_CACHE_SECONDS`. Zero disables the cache.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[introspectionCacheKey]introspectionCacheEntry
+}
+
+// introspectionCacheKey is a caller and the digest of a token it introspected
+type introspectionCacheKey struct {
+	clientID string
+	token    [sha256.Size]byte
+}
+
+// introspectionCacheEntry is a cached result and when it must be looked up again
+type introspectionCacheEntry struct {
+	response  models.IntrospectionResponse
+	expiresAt time.Time
+}
+
+// NewIntrospectionService returns a value of type IntrospectionService with
+// the clients and cache TTL read from the environment
+func NewIntrospectionService(ur *repository.UserRepository, sr *repository.SessionRepository) (*IntrospectionService, error) {
+	if ur == nil {
+		return nil, apperrors.ErrUserRepoIsNil
+	}
+	if sr == nil {
+		return nil, apperrors.ErrSessionRepoIsNil
+	}
+	clients, err := IntrospectionClientsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cacheTTL, err := IntrospectionCacheTTLFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &IntrospectionService{
+		UserRepo:    ur,
+		SessionRepo: sr,
+		Clients:     clients,
+		CacheTTL:    cacheTTL,
+	}, nil
+}
+
+// AuthenticateClient reports whether `clientID` and `secret` are the
+// credentials of an introspection client
+func (is *IntrospectionService) AuthenticateClient(clientID, secret string) bool {
+	expected, ok := is.Clients[clientID]
+	if !ok || clientID == "" {
+		// Compare anyway so unknown clients take as long as wrong secrets
+		expected = secret + "-"
+	}
+	// Compare digests so the comparison does not leak the secret's length
+	given, want := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(given[:], want[:]) == 1 && ok
+}
+
+// Introspect returns the state of a session token for `clientID`. Tokens that
+// are malformed, unsigned, expired, revoked or whose user no longer exists are
+// inactive. Unlike RequireAuth, introspection never rotates the session.
+func (is *IntrospectionService) Introspect(clientID, token string) (*models.IntrospectionResponse, error) {
+	key := introspectionCacheKey{clientID: clientID, token: sha256.Sum256([]byte(token))}
+	if response, ok := is.cached(key); ok {
+		return &response, nil
+	}
+
+	response, err := is.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	is.store(key, *response)
+	return response, nil
+}
+
+// Lookup checks a session token against the database, bypassing the cache
+func (is *IntrospectionService) Lookup(token string) (*models.IntrospectionResponse, error) {
+	inactive := &models.IntrospectionResponse{Active: false}
+
+	tokenHash, err := models.ParseSessionToken(token)
+	if err != nil {
+		return inactive, nil
+	}
+	session, err := is.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return inactive, nil
+	} else if err != nil {
+		return nil, err
+	}
+	user, err := is.UserRepo.GetUserByID(session.UserID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return inactive, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &models.IntrospectionResponse{
+		Active:    true,
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		Roles:     []string{user.Role},
+		ExpiresAt: session.ExpiresAt.Unix(),
+		IssuedAt:  session.CreatedAt.Unix(),
+		TokenType: IntrospectionTokenType,
+		Claims:    session.Claims,
+	}, nil
+}
+
+// cached returns the caller's unexpired result for a token, if any
+func (is *IntrospectionService) cached(key introspectionCacheKey) (models.IntrospectionResponse, bool) {
+	if is.CacheTTL <= 0 {
+		return models.IntrospectionResponse{}, false
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	entry, ok := is.cache[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return models.IntrospectionResponse{}, false
+	}
+	return entry.response, true
+}
+
+// store caches a result for CacheTTL, but never past the session's expiry
+func (is *IntrospectionService) store(key introspectionCacheKey, response models.IntrospectionResponse) {
+	if is.CacheTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(is.CacheTTL)
+	if response.Active && time.Unix(response.ExpiresAt, 0).Before(expiresAt) {
+		expiresAt = time.Unix(response.ExpiresAt, 0)
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.cache == nil {
+		is.cache = make(map[introspectionCacheKey]introspectionCacheEntry)
+	}
+	if len(is.cache) >= config.IntrospectionCacheMaxEntries {
+		for k, entry := range is.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(is.cache, k)
+			}
+		}
+		if len(is.cache) >= config.IntrospectionCacheMaxEntries {
+			return
+		}
+	}
+	is.cache[key] = introspectionCacheEntry{response: response, expiresAt: expiresAt}
+}
+
+// IntrospectionClientsFromEnv reads the `id:secret` pairs in
+// `INTROSPECTION_CLIENTS`. It returns no clients when the variable is unset.
+func IntrospectionClientsFromEnv() (map[string]string, error) {
+	return ParseIntrospectionClients(os.Getenv(config.IntrospectionClients))
+}
+
+// ParseIntrospectionClients parses comma separated `id:secret` pairs
+func ParseIntrospectionClients(value string) (map[string]string, error) {
+	clients := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return clients, nil
+	}
+	for i, pair := range strings.Split(value, ",") {
+		// Secrets are left out of errors, which are logged
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("%w: client %d of %s must be id:secret", apperrors.ErrIntrospectionConfig, i+1, config.IntrospectionClients)
+		}
+		if _, exists := clients[id]; exists {
+			return nil, fmt.Errorf("%w: duplicate client %q", apperrors.ErrIntrospectionConfig, id)
+		}
+		clients[id] = secret
+	}
+	return clients, nil
+}
+
+// IntrospectionCacheTTLFromEnv reads `INTROSPECTION_CACHE_SECONDS`
+func IntrospectionCacheTTLFromEnv() (time.Duration, error) {
+	value := os.Getenv(config.IntrospectionCacheSeconds)
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%w: %s=%q", apperrors.ErrIntrospectionConfig, config.IntrospectionCacheSeconds, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
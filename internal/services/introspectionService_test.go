@@ -0,0 +1,106 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// TestParseIntrospectionClients tests parsing `INTROSPECTION_CLIENTS`
+func TestParseIntrospectionClients(t *testing.T) {
+	is := is.New(t)
+
+	clients, err := services.ParseIntrospectionClients("")
+	is.NoErr(err)
+	is.Equal(len(clients), 0)
+
+	clients, err = services.ParseIntrospectionClients("billing:s3cret, search:a:b")
+	is.NoErr(err)
+	is.Equal(clients, map[string]string{"billing": "s3cret", "search": "a:b"})
+
+	for _, value := range []string{"billing", "billing:", ":s3cret", "billing:a,billing:b"} {
+		_, err := services.ParseIntrospectionClients(value)
+		is.True(errors.Is(err, apperrors.ErrIntrospectionConfig))
+	}
+}
+
+// TestIntrospectionCacheTTLFromEnv tests reading `INTROSPECTION_CACHE_SECONDS`
+func TestIntrospectionCacheTTLFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv(config.IntrospectionCacheSeconds, "")
+	ttl, err := services.IntrospectionCacheTTLFromEnv()
+	is.NoErr(err)
+	is.Equal(ttl, time.Duration(0))
+
+	t.Setenv(config.IntrospectionCacheSeconds, "30")
+	ttl, err = services.IntrospectionCacheTTLFromEnv()
+	is.NoErr(err)
+	is.Equal(ttl, 30*time.Second)
+
+	t.Setenv(config.IntrospectionCacheSeconds, "-1")
+	_, err = services.IntrospectionCacheTTLFromEnv()
+	is.True(errors.Is(err, apperrors.ErrIntrospectionConfig))
+}
+
+// TestIntrospectionService_AuthenticateClient tests checking client credentials
+func TestIntrospectionService_AuthenticateClient(t *testing.T) {
+	is := is.New(t)
+	ins := &services.IntrospectionService{Clients: map[string]string{"billing": "s3cret"}}
+
+	is.True(ins.AuthenticateClient("billing", "s3cret"))
+	is.True(!ins.AuthenticateClient("billing", "wrong"))
+	is.True(!ins.AuthenticateClient("search", "s3cret"))
+	is.True(!ins.AuthenticateClient("", ""))
+}
+
+// TestIntrospectionService_Introspect tests introspecting a session token and
+// caching the result per caller
+func TestIntrospectionService_Introspect(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+	ur, err := repository.NewUserRepository(tx)
+	is.NoErr(err)
+	sr, err := repository.NewSessionRepository(tx)
+	is.NoErr(err)
+	ins, err := services.NewIntrospectionService(ur, sr)
+	is.NoErr(err)
+	ins.CacheTTL = time.Minute
+
+	user, err := models.NewUser("testIntrospect@test.com", testutils.TestingPassword)
+	is.NoErr(err)
+	is.NoErr(tx.Create(user).Error)
+	token, tokenHash, err := models.GenerateSessionToken()
+	is.NoErr(err)
+	session, err := models.NewSession(user.ID, tokenHash, time.Now().UTC().Add(time.Hour))
+	is.NoErr(err)
+	is.NoErr(sr.CreateSession(session))
+
+	response, err := ins.Introspect("billing", token)
+	is.NoErr(err)
+	is.True(response.Active)
+	is.Equal(response.Subject, user.ID.String())
+	is.Equal(response.TokenType, services.IntrospectionTokenType)
+
+	// The revoked session stays active for the caller that cached it, but
+	// not for other callers
+	is.NoErr(sr.DeleteSessionByID(session.ID))
+	response, err = ins.Introspect("billing", token)
+	is.NoErr(err)
+	is.True(response.Active)
+	response, err = ins.Introspect("search", token)
+	is.NoErr(err)
+	is.True(!response.Active)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// RequestMagicLink emails a single-use login link to the account with
+// `email`, bound to the browser holding the returned browser token. A valid
+// `browserToken` from the browser's cookie is kept, so the links it asked for
+// before still work; otherwise a new one is made. The answer is the same
+// whether or not a link was sent: nothing is sent to unknown, locked or
+// pending deletion accounts, past MagicLinkMaxRequests links to the account
+// or MagicLinkMaxRequestsPerIP requests from `clientIP` within
+// MagicLinkRequestWindow. Every request is counted and stored the same way,
+// so the time it takes does not tell either; the email is sent in the
+// background.
+func (us *UserService) RequestMagicLink(email, browserToken, clientIP string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", apperrors.ErrEmailIsEmpty
+	}
+	if err := models.ValidateEmail(email); err != nil {
+		return "", err
+	}
+
+	if !models.ValidMagicLinkToken(browserToken) {
+		var err error
+		if browserToken, _, err = models.GenerateMagicLinkToken(); err != nil {
+			return "", err
+		}
+	}
+	browserHash := models.HashMagicLinkToken(browserToken)
+
+	var userID uuid.UUID
+	user, err := us.UserRepo.GetUserByEmail(email)
+	if err != nil {
+		user = nil
+	} else {
+		userID = user.ID
+	}
+
+	now := time.Now().UTC()
+	forUser, fromIP, err := us.MagicLinkRepo.CountMagicLinkTokensSince(userID, clientIP, now.Add(-config.MagicLinkRequestWindow))
+	if err != nil {
+		return "", err
+	}
+	var reason string
+	switch {
+	case fromIP >= config.MagicLinkMaxRequestsPerIP:
+		reason = "too many requests from this address"
+	case user == nil:
+		reason = "unknown email"
+	case forUser >= config.MagicLinkMaxRequests:
+		reason = "too many requests"
+	default:
+		reason = magicLinkRefusal(user)
+	}
+
+	token, tokenHash, err := models.GenerateMagicLinkToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := now.Add(config.MagicLinkExpiration)
+	var link *models.MagicLinkToken
+	if reason == "" {
+		link, err = models.NewMagicLinkToken(userID, tokenHash, browserHash, clientIP, expiresAt)
+	} else {
+		link, err = models.NewUnsentMagicLinkToken(tokenHash, browserHash, clientIP, expiresAt)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := us.MagicLinkRepo.CreateMagicLinkToken(link); err != nil {
+		return "", err
+	}
+
+	if reason != "" {
+		log.Info().
+			Str("email", email).
+			Str("clientIP", clientIP).
+			Msg("Magic link not sent: " + reason)
+		return browserToken, nil
+	}
+	us.sendMail(magicLinkEmail(user.Email, token, us.magicLink(token), expiresAt))
+	return browserToken, nil
+}
+
+// magicLinkRefusal returns why no magic link should be sent to a user, or an
+// empty string if one may be
+func magicLinkRefusal(user *models.User) string {
+	switch {
+	case user.AccountLocked && (user.AccountLockedUntil == nil || time.Now().UTC().Before(*user.AccountLockedUntil)):
+		return "account is locked"
+	case user.PendingDeletion():
+		return "account is pending deletion"
+	default:
+		return ""
+	}
+}
+
+// LoginWithMagicLink logs in the user a magic link token was emailed to and
+// returns the session token. The link must be used before it expires, once,
+// and from the browser that asked for it, whose browser token was set in its
+// cookie. Links used from another browser count as failed logins towards the
+// account lockout, which applies as it does to passwords.
+func (us *UserService) LoginWithMagicLink(token, browserToken string) (string, error) {
+	if token == "" || browserToken == "" {
+		return "", apperrors.ErrMagicLinkInvalid
+	}
+	link, err := us.MagicLinkRepo.GetUnusedMagicLinkTokenByHash(models.HashMagicLinkToken(token))
+	if err != nil || link.User == nil {
+		return "", apperrors.ErrMagicLinkInvalid
+	}
+	user := link.User
+
+	if err := us.checkLockout(user); err != nil {
+		return "", err
+	}
+
+	// The link is not used up by another browser, so its owner can still
+	// use it
+	browserHash := models.HashMagicLinkToken(browserToken)
+	if subtle.ConstantTimeCompare([]byte(browserHash), []byte(link.BrowserHash)) != 1 {
+		log.Warn().
+			Str("userID", user.ID.String()).
+			Msg("Magic link used from another browser")
+		if err := us.UserRepo.IncrementFailedLogins(user.ID.String()); err != nil {
+			return "", err
+		}
+		return "", apperrors.ErrMagicLinkInvalid
+	}
+
+	if user.PendingDeletion() {
+		return "", apperrors.ErrAccountPendingDeletion
+	}
+
+	if err := us.MagicLinkRepo.UseMagicLinkToken(link.ID, time.Now().UTC()); err != nil {
+		return "", err
+	}
+	return us.startSession(user, LoginMethodMagicLink, map[string]any{})
+}
+
+// magicLink returns the link to log in with a magic link token, or an empty
+// string if `MAGIC_LINK_URL` is not set
+func (us *UserService) magicLink(token string) string {
+	return linkWithToken(us.MagicLinkURL, "magic link", token)
+}
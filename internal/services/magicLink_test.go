@@ -0,0 +1,242 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/mailer"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// testClientIP is the IP address magic links are requested from
+const testClientIP = "192.0.2.1"
+
+// magicLinkToken returns the token of a magic link email sent without
+// MAGIC_LINK_URL
+func magicLinkToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(msg.Body, "use this code: ")
+	if !ok {
+		t.Fatalf("no token in email: %q", msg.Body)
+	}
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+// TestUserService_MagicLink tests passwordless login with emailed links that
+// are single use, short lived and bound to the browser that asked for them
+func TestUserService_MagicLink(t *testing.T) {
+	is := is.New(t)
+	us := setupUserService(t)
+	sent := make(chan mailer.Message, 10)
+	us.Mailer = recordingMailer(sent)
+
+	email := "testUserServiceMagicLink@test.com"
+	is.NoErr(us.RegisterPasswordlessUser(email))
+	user, err := us.UserRepo.GetUserByEmail(email)
+	is.NoErr(err)
+	is.True(!user.HasPassword())
+
+	t.Run("logs in passwordless accounts", func(t *testing.T) {
+		browser, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		msg := receiveMail(t, sent)
+		is.Equal(msg.To, email)
+		token := magicLinkToken(t, msg)
+
+		sessionToken, err := us.LoginWithMagicLink(token, browser)
+		is.NoErr(err)
+		tokenHash, err := models.ParseSessionToken(sessionToken)
+		is.NoErr(err)
+		session, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.NoErr(err)
+		is.Equal(session.UserID, user.ID)
+
+		// Links are single use
+		_, err = us.LoginWithMagicLink(token, browser)
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+	})
+
+	t.Run("passwords never match passwordless accounts", func(t *testing.T) {
+		_, err := us.LoginUser(email, "")
+		is.Equal(err, apperrors.ErrPasswordIsEmpty)
+		_, err = us.LoginUser(email, "anything")
+		is.Equal(err, apperrors.ErrInvalidLogin)
+	})
+
+	t.Run("links only work in the browser that asked", func(t *testing.T) {
+		is.NoErr(us.UserRepo.UnlockAccount(user.ID.String()))
+		browser, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		token := magicLinkToken(t, receiveMail(t, sent))
+
+		other, err := us.RequestMagicLink("doesNotExist@test.com", "", testClientIP)
+		is.NoErr(err)
+		is.True(other != browser)
+		_, err = us.LoginWithMagicLink(token, other)
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+		_, err = us.LoginWithMagicLink(token, "")
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+
+		// The owner can still use it
+		_, err = us.LoginWithMagicLink(token, browser)
+		is.NoErr(err)
+	})
+
+	t.Run("asking again from the same browser keeps earlier links", func(t *testing.T) {
+		is.NoErr(us.MagicLinkRepo.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLinkToken{}).Error)
+		browser, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		first := magicLinkToken(t, receiveMail(t, sent))
+
+		again, err := us.RequestMagicLink(email, browser, testClientIP)
+		is.NoErr(err)
+		is.Equal(again, browser)
+		second := magicLinkToken(t, receiveMail(t, sent))
+		is.True(second != first)
+
+		_, err = us.LoginWithMagicLink(first, browser)
+		is.NoErr(err)
+		_, err = us.LoginWithMagicLink(second, browser)
+		is.NoErr(err)
+
+		// Browser tokens not made by the server are replaced
+		other, err := us.RequestMagicLink(email, "chosen by the client", testClientIP)
+		is.NoErr(err)
+		is.True(other != "chosen by the client")
+		receiveMail(t, sent)
+	})
+
+	t.Run("expired links are refused", func(t *testing.T) {
+		is.NoErr(us.MagicLinkRepo.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLinkToken{}).Error)
+		browser, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		token := magicLinkToken(t, receiveMail(t, sent))
+
+		err = us.MagicLinkRepo.DB.Model(&models.MagicLinkToken{}).
+			Where("token_hash = ?", models.HashMagicLinkToken(token)).
+			Update("expires_at", time.Now().UTC().Add(-time.Second)).Error
+		is.NoErr(err)
+		_, err = us.LoginWithMagicLink(token, browser)
+		is.Equal(err, apperrors.ErrMagicLinkInvalid)
+	})
+
+	t.Run("requests are rate limited", func(t *testing.T) {
+		is.NoErr(us.MagicLinkRepo.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLinkToken{}).Error)
+		for range config.MagicLinkMaxRequests {
+			_, err := us.RequestMagicLink(email, "", testClientIP)
+			is.NoErr(err)
+			receiveMail(t, sent)
+		}
+
+		// The answer is the same, but nothing is sent
+		_, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		select {
+		case <-sent:
+			t.Fatal("magic link sent past the limit")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("requests from one address are rate limited", func(t *testing.T) {
+		is.NoErr(us.MagicLinkRepo.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLinkToken{}).Error)
+		clientIP := "198.51.100.1"
+		for range config.MagicLinkMaxRequestsPerIP {
+			_, err := us.RequestMagicLink("doesNotExist@test.com", "", clientIP)
+			is.NoErr(err)
+		}
+
+		// Requests for unknown emails count, so the account gets nothing
+		_, err := us.RequestMagicLink(email, "", clientIP)
+		is.NoErr(err)
+		select {
+		case <-sent:
+			t.Fatal("magic link sent past the limit of the address")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// Other addresses are not limited
+		_, err = us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		receiveMail(t, sent)
+	})
+
+	t.Run("locked accounts cannot log in", func(t *testing.T) {
+		is.NoErr(us.MagicLinkRepo.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLinkToken{}).Error)
+		is.NoErr(us.UserRepo.UnlockAccount(user.ID.String()))
+		browser, err := us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		token := magicLinkToken(t, receiveMail(t, sent))
+
+		is.NoErr(us.UserRepo.LockAccount(user.ID.String()))
+		_, err = us.LoginWithMagicLink(token, browser)
+		is.Equal(err, apperrors.ErrAccountIsLocked)
+
+		// Nor are links sent to them
+		_, err = us.RequestMagicLink(email, "", testClientIP)
+		is.NoErr(err)
+		select {
+		case <-sent:
+			t.Fatal("magic link sent to a locked account")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("invalid emails are refused", func(t *testing.T) {
+		_, err := us.RequestMagicLink("", "", testClientIP)
+		is.Equal(err, apperrors.ErrEmailIsEmpty)
+		_, err = us.RequestMagicLink("not an email", "", testClientIP)
+		is.Equal(err, apperrors.ErrEmailFormat)
+	})
+}
+
+// TestUserService_MagicLinkHooks tests magic link logins run the login hooks
+// with their method
+func TestUserService_MagicLinkHooks(t *testing.T) {
+	is := is.New(t)
+	us := setupUserService(t)
+	sent := make(chan mailer.Message, 1)
+	us.Mailer = recordingMailer(sent)
+	us.MagicLinkURL = "https://app.test/login/magic?next=%2F"
+
+	var methods []string
+	hooks := &loginMethodHooks{methods: &methods}
+	us.Hooks = []services.UserHooks{hooks}
+
+	email := "testUserServiceMagicLinkHooks@test.com"
+	is.NoErr(us.RegisterPasswordlessUser(email))
+
+	browser, err := us.RequestMagicLink(email, "", testClientIP)
+	is.NoErr(err)
+	msg := receiveMail(t, sent)
+	_, link, ok := strings.Cut(msg.Body, "follow this link: ")
+	is.True(ok)
+	link, _, _ = strings.Cut(link, "\n")
+	is.True(strings.HasPrefix(link, "https://app.test/login/magic?"))
+	is.True(strings.Contains(link, "next=%2F"))
+	_, token, ok := strings.Cut(link, "token=")
+	is.True(ok)
+
+	_, err = us.LoginWithMagicLink(token, browser)
+	is.NoErr(err)
+	is.Equal(methods, []string{services.LoginMethodMagicLink})
+}
+
+// loginMethodHooks records the method of each login
+type loginMethodHooks struct {
+	services.NopUserHooks
+	methods *[]string
+}
+
+func (h *loginMethodHooks) PreLogin(_ context.Context, e *services.LoginEvent) error {
+	*h.methods = append(*h.methods, e.Method)
+	return nil
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// serviceAccountNameMaxLength is the longest name a service account can have
+const serviceAccountNameMaxLength = 100
+
+// CreatedServiceAccount is a new service account, or one whose secret was
+// rotated. ClientSecret is only available here, and empty for accounts that
+// authenticate with a public key.
+type CreatedServiceAccount struct {
+	ClientSecret string
+	Info         models.ServiceAccountInfo
+}
+
+// IssuedToken is a short-lived access token for a service account. Token is
+// only available here; the database holds its hash.
+type IssuedToken struct {
+	Token     string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// ServiceAccountService manages the service accounts of machine callers,
+// issues them short-lived access tokens for their client credentials, and
+// authenticates requests made with those tokens
+type ServiceAccountService struct {
+	ServiceAccountRepo *repository.ServiceAccountRepository
+	AuditRepo          *repository.AuditRepository
+	// TokenEndpointURL is the audience `private_key_jwt` client assertions
+	// must name, from `TOKEN_ENDPOINT_URL`. Empty refuses them.
+	TokenEndpointURL string
+}
+
+// NewServiceAccountService returns a value of type ServiceAccountService with
+// the token endpoint URL read from the environment
+func NewServiceAccountService(
+	sr *repository.ServiceAccountRepository,
+	ar *repository.AuditRepository,
+) (*ServiceAccountService, error) {
+	if sr == nil {
+		return nil, apperrors.ErrServiceAccountRepoIsNil
+	}
+	if ar == nil {
+		return nil, apperrors.ErrAuditRepoIsNil
+	}
+	tokenEndpointURL, err := TokenEndpointURLFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAccountService{
+		ServiceAccountRepo: sr,
+		AuditRepo:          ar,
+		TokenEndpointURL:   tokenEndpointURL,
+	}, nil
+}
+
+// CreateServiceAccount creates a service account for an admin. Accounts with
+// a PEM encoded public key authenticate with `private_key_jwt`; others get a
+// client secret, which is returned once.
+func (ss *ServiceAccountService) CreateServiceAccount(createdBy, name string, scopes []string, publicKey string) (*CreatedServiceAccount, error) {
+	creator, err := uuid.Parse(createdBy)
+	if err != nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > serviceAccountNameMaxLength {
+		return nil, apperrors.ErrServiceAccountName
+	}
+	if err := models.ValidateServiceAccountScopes(scopes); err != nil {
+		return nil, err
+	}
+
+	var secret, secretHash string
+	publicKey = strings.TrimSpace(publicKey)
+	if publicKey != "" {
+		if _, _, err := ParseServiceAccountPublicKey(publicKey); err != nil {
+			return nil, err
+		}
+	} else if secret, secretHash, err = models.GenerateClientSecret(); err != nil {
+		return nil, err
+	}
+
+	account, err := models.NewServiceAccount(name, scopes, secretHash, publicKey, creator)
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.ServiceAccountRepo.CreateServiceAccount(account); err != nil {
+		return nil, err
+	}
+
+	ss.recordEvent(models.AuditServiceAccountCreated, creator, account)
+	return &CreatedServiceAccount{ClientSecret: secret, Info: account.Info()}, nil
+}
+
+// ListServiceAccounts returns every service account, newest first
+func (ss *ServiceAccountService) ListServiceAccounts() ([]models.ServiceAccountInfo, error) {
+	accounts, err := ss.ServiceAccountRepo.GetServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]models.ServiceAccountInfo, 0, len(accounts))
+	for _, account := range accounts {
+		infos = append(infos, account.Info())
+	}
+	return infos, nil
+}
+
+// RotateServiceAccountSecret gives a service account a new client secret,
+// returned once, for an admin. The old secret and the tokens issued with it
+// stop working right away.
+func (ss *ServiceAccountService) RotateServiceAccountSecret(actor, id string) (*CreatedServiceAccount, error) {
+	account, err := ss.getServiceAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if account.AuthMethod != models.AuthMethodClientSecret {
+		return nil, apperrors.ErrServiceAccountNoSecret
+	}
+
+	secret, secretHash, err := models.GenerateClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.ServiceAccountRepo.UpdateServiceAccountSecret(account.ID, secretHash); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+	account.SecretHash = secretHash
+
+	if admin, err := uuid.Parse(actor); err == nil {
+		ss.recordEvent(models.AuditServiceAccountSecretRotated, admin, account)
+	}
+	return &CreatedServiceAccount{ClientSecret: secret, Info: account.Info()}, nil
+}
+
+// DeleteServiceAccount deletes a service account and its tokens for an admin
+func (ss *ServiceAccountService) DeleteServiceAccount(actor, id string) error {
+	account, err := ss.getServiceAccount(id)
+	if err != nil {
+		return err
+	}
+	err = ss.ServiceAccountRepo.DeleteServiceAccountByID(account.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperrors.ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if admin, err := uuid.Parse(actor); err == nil {
+		ss.recordEvent(models.AuditServiceAccountDeleted, admin, account)
+	}
+	return nil
+}
+
+// AuthenticateClientSecret returns the service account with a client ID and
+// secret, for `client_secret_basic` and `client_secret_post`
+func (ss *ServiceAccountService) AuthenticateClientSecret(clientID, secret string) (*models.ServiceAccount, error) {
+	if clientID == "" {
+		return nil, apperrors.ErrInvalidClient
+	}
+	account, err := ss.ServiceAccountRepo.GetServiceAccountByClientID(clientID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	expected := models.HashServiceAccountSecret(secret + "-")
+	if account != nil && account.AuthMethod == models.AuthMethodClientSecret {
+		expected = account.SecretHash
+	}
+	// Compare anyway so unknown clients take as long as wrong secrets
+	given := models.HashServiceAccountSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 || account == nil {
+		return nil, apperrors.ErrInvalidClient
+	}
+	return account, nil
+}
+
+// AuthenticateClientAssertion returns the service account that signed a
+// `private_key_jwt` client assertion. Each assertion can only be used once.
+// A non-empty `clientID` must match the assertion's subject.
+func (ss *ServiceAccountService) AuthenticateClientAssertion(clientID, assertion string) (*models.ServiceAccount, error) {
+	if ss.TokenEndpointURL == "" {
+		return nil, apperrors.ErrPrivateKeyJWTDisabled
+	}
+	subject, err := ClientAssertionSubject(assertion)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != "" && clientID != subject {
+		return nil, fmt.Errorf("%w: client_id does not match sub", apperrors.ErrClientAssertion)
+	}
+
+	account, err := ss.ServiceAccountRepo.GetServiceAccountByClientID(subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperrors.ErrInvalidClient
+	} else if err != nil {
+		return nil, err
+	}
+	if account.AuthMethod != models.AuthMethodPrivateKeyJWT {
+		return nil, apperrors.ErrInvalidClient
+	}
+
+	claims, err := VerifyClientAssertion(assertion, account.PublicKey, account.ClientID, ss.TokenEndpointURL, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := ss.ServiceAccountRepo.RecordClientAssertion(&models.ClientAssertion{
+		ServiceAccountID: account.ID,
+		JTI:              claims.JTI,
+		ExpiresAt:        claims.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, apperrors.ErrClientAssertionReplay
+	}
+	return account, nil
+}
+
+// IssueToken creates an access token for an authenticated service account,
+// valid for config.ServiceAccountTokenExpiration. The token has the
+// requested scopes, which must be a subset of the account's, or all of them
+// if none are requested.
+func (ss *ServiceAccountService) IssueToken(account *models.ServiceAccount, scopes []string) (*IssuedToken, error) {
+	if account == nil {
+		return nil, apperrors.ErrInvalidClient
+	}
+	granted := account.ScopeList()
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return nil, apperrors.ErrInvalidScope
+		}
+	}
+	scopes = slices.Compact(slices.Sorted(slices.Values(scopes)))
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(config.ServiceAccountTokenExpiration)
+	token, tokenHash, err := models.GenerateServiceAccountToken()
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := models.NewServiceAccountToken(account.ID, tokenHash, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.ServiceAccountRepo.CreateServiceAccountToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	if err := ss.ServiceAccountRepo.UpdateServiceAccountLastUsed(account.ID, now); err != nil {
+		log.Warn().
+			Str("serviceAccountID", account.ID.String()).
+			Str("error", err.Error()).
+			Msg("Could not record service account use")
+	}
+	return &IssuedToken{Token: token, Scopes: scopes, ExpiresAt: expiresAt}, nil
+}
+
+// Authenticate returns the unexpired token matching a bearer token, with its
+// service account
+func (ss *ServiceAccountService) Authenticate(token string) (*models.ServiceAccountToken, error) {
+	if !models.IsServiceAccountToken(token) {
+		return nil, apperrors.ErrInvalidTokenFormat
+	}
+	accessToken, err := ss.ServiceAccountRepo.GetUnexpiredServiceAccountTokenByTokenHash(models.HashServiceAccountSecret(token))
+	if err != nil {
+		return nil, err
+	}
+	if accessToken.ServiceAccount == nil {
+		return nil, apperrors.ErrServiceAccountNotFound
+	}
+	return accessToken, nil
+}
+
+// getServiceAccount looks up a service account by its ID from a URL
+func (ss *ServiceAccountService) getServiceAccount(id string) (*models.ServiceAccount, error) {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, apperrors.ErrServiceAccountNotFound
+	}
+	account, err := ss.ServiceAccountRepo.GetServiceAccountByID(parsedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperrors.ErrServiceAccountNotFound
+	}
+	return account, err
+}
+
+// recordEvent records an admin's change to a service account in the audit log
+func (ss *ServiceAccountService) recordEvent(eventType string, adminID uuid.UUID, account *models.ServiceAccount) {
+	details := fmt.Sprintf("service account %s %q (%s) with scopes %s", account.ID, account.Name, account.ClientID, account.Scopes)
+	if err := ss.AuditRepo.CreateAuditEvent(models.NewAuditEvent(eventType, adminID, details)); err != nil {
+		log.Warn().
+			Str("userID", adminID.String()).
+			Str("error", err.Error()).
+			Msg("Could not record service account event")
+	}
+}
+
+// TokenEndpointURLFromEnv reads and validates `TOKEN_ENDPOINT_URL`
+func TokenEndpointURLFromEnv() (string, error) {
+	tokenEndpointURL := os.Getenv(config.TokenEndpointURL)
+	if tokenEndpointURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(tokenEndpointURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%w: %q", apperrors.ErrTokenEndpointConfig, tokenEndpointURL)
+	}
+	return tokenEndpointURL, nil
+}
@@ -0,0 +1,184 @@
+package services_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+func TestServiceAccountService_NewServiceAccountService(t *testing.T) {
+	is := is.New(t)
+
+	_, err := services.NewServiceAccountService(nil, nil)
+	is.Equal(err, apperrors.ErrServiceAccountRepoIsNil)
+
+	t.Setenv(config.TokenEndpointURL, "auth.example.com/oauth/token")
+	_, err = services.NewServiceAccountService(&repository.ServiceAccountRepository{}, &repository.AuditRepository{})
+	is.True(errors.Is(err, apperrors.ErrTokenEndpointConfig))
+}
+
+// TestServiceAccountService_ClientSecret tests creating an account with a
+// client secret, issuing tokens for it and rotating the secret
+func TestServiceAccountService_ClientSecret(t *testing.T) {
+	is := is.New(t)
+	ss := setupServiceAccountService(t)
+	admin := uuid.New().String()
+
+	created, err := ss.CreateServiceAccount(admin, " billing ", []string{models.ScopeProfile, models.ScopeAdmin}, "")
+	is.NoErr(err)
+	is.Equal(created.Info.Name, "billing")
+	is.Equal(created.Info.AuthMethod, models.AuthMethodClientSecret)
+	is.True(created.ClientSecret != "")
+
+	t.Run("audits the creation", func(t *testing.T) {
+		events, err := ss.AuditRepo.GetAuditEventsByUserID(admin)
+		is.NoErr(err)
+		is.Equal(len(events), 1)
+		is.Equal(events[0].Type, models.AuditServiceAccountCreated)
+	})
+
+	t.Run("refuses wrong secrets and unknown clients", func(t *testing.T) {
+		_, err := ss.AuthenticateClientSecret(created.Info.ClientID, "wrong")
+		is.Equal(err, apperrors.ErrInvalidClient)
+		_, err = ss.AuthenticateClientSecret(models.ClientIDPrefix+"unknown", created.ClientSecret)
+		is.Equal(err, apperrors.ErrInvalidClient)
+		_, err = ss.AuthenticateClientSecret("", "")
+		is.Equal(err, apperrors.ErrInvalidClient)
+	})
+
+	account, err := ss.AuthenticateClientSecret(created.Info.ClientID, created.ClientSecret)
+	is.NoErr(err)
+
+	t.Run("issues tokens for a subset of the scopes", func(t *testing.T) {
+		issued, err := ss.IssueToken(account, nil)
+		is.NoErr(err)
+		is.Equal(issued.Scopes, []string{models.ScopeAdmin, models.ScopeProfile})
+		is.True(issued.ExpiresAt.Before(time.Now().Add(config.ServiceAccountTokenExpiration + time.Second)))
+
+		issued, err = ss.IssueToken(account, []string{models.ScopeProfile, models.ScopeProfile})
+		is.NoErr(err)
+		is.Equal(issued.Scopes, []string{models.ScopeProfile})
+
+		token, err := ss.Authenticate(issued.Token)
+		is.NoErr(err)
+		is.Equal(token.ServiceAccount.ClientID, created.Info.ClientID)
+		is.Equal(token.ScopeList(), []string{models.ScopeProfile})
+
+		_, err = ss.IssueToken(account, []string{models.ScopeSessions})
+		is.Equal(err, apperrors.ErrInvalidScope)
+	})
+
+	t.Run("rotating the secret revokes the old one and its tokens", func(t *testing.T) {
+		issued, err := ss.IssueToken(account, nil)
+		is.NoErr(err)
+
+		rotated, err := ss.RotateServiceAccountSecret(admin, created.Info.ID.String())
+		is.NoErr(err)
+		is.True(rotated.ClientSecret != created.ClientSecret)
+
+		_, err = ss.AuthenticateClientSecret(created.Info.ClientID, created.ClientSecret)
+		is.Equal(err, apperrors.ErrInvalidClient)
+		_, err = ss.AuthenticateClientSecret(created.Info.ClientID, rotated.ClientSecret)
+		is.NoErr(err)
+		_, err = ss.Authenticate(issued.Token)
+		is.True(err != nil)
+	})
+
+	t.Run("deleting the account revokes its tokens", func(t *testing.T) {
+		issued, err := ss.IssueToken(account, nil)
+		is.NoErr(err)
+
+		is.NoErr(ss.DeleteServiceAccount(admin, created.Info.ID.String()))
+		is.Equal(ss.DeleteServiceAccount(admin, created.Info.ID.String()), apperrors.ErrServiceAccountNotFound)
+		_, err = ss.Authenticate(issued.Token)
+		is.True(err != nil)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		_, err := ss.CreateServiceAccount(admin, "", []string{models.ScopeProfile}, "")
+		is.Equal(err, apperrors.ErrServiceAccountName)
+		_, err = ss.CreateServiceAccount(admin, "billing", []string{models.ScopeExport}, "")
+		is.Equal(err, apperrors.ErrServiceAccountScope)
+		_, err = ss.CreateServiceAccount(admin, "billing", []string{models.ScopeProfile}, "not a key")
+		is.Equal(err, apperrors.ErrServiceAccountPublicKey)
+		_, err = ss.RotateServiceAccountSecret(admin, "not-a-uuid")
+		is.Equal(err, apperrors.ErrServiceAccountNotFound)
+	})
+}
+
+// TestServiceAccountService_ClientAssertion tests authenticating an account
+// with a registered public key through `private_key_jwt`
+func TestServiceAccountService_ClientAssertion(t *testing.T) {
+	is := is.New(t)
+	ss := setupServiceAccountService(t)
+	admin := uuid.New().String()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+	created, err := ss.CreateServiceAccount(admin, "reports", []string{models.ScopeProfile}, publicKeyPEM(t, key))
+	is.NoErr(err)
+	is.Equal(created.ClientSecret, "")
+	is.Equal(created.Info.AuthMethod, models.AuthMethodPrivateKeyJWT)
+
+	clientID := created.Info.ClientID
+	assertion := signAssertion(t, key, "EdDSA", assertionClaims(clientID, time.Now()))
+
+	t.Run("disabled without a token endpoint URL", func(t *testing.T) {
+		ss.TokenEndpointURL = ""
+		_, err := ss.AuthenticateClientAssertion(clientID, assertion)
+		is.Equal(err, apperrors.ErrPrivateKeyJWTDisabled)
+		ss.TokenEndpointURL = testTokenEndpoint
+	})
+
+	t.Run("authenticates an assertion once", func(t *testing.T) {
+		account, err := ss.AuthenticateClientAssertion(clientID, assertion)
+		is.NoErr(err)
+		is.Equal(account.ClientID, clientID)
+
+		_, err = ss.AuthenticateClientAssertion(clientID, assertion)
+		is.Equal(err, apperrors.ErrClientAssertionReplay)
+	})
+
+	t.Run("refuses another client ID", func(t *testing.T) {
+		claims := assertionClaims(clientID, time.Now())
+		claims["jti"] = "assertion-2"
+		_, err := ss.AuthenticateClientAssertion(models.ClientIDPrefix+"other", signAssertion(t, key, "EdDSA", claims))
+		is.True(errors.Is(err, apperrors.ErrClientAssertion))
+	})
+
+	t.Run("accounts with a public key have no secret", func(t *testing.T) {
+		_, err := ss.AuthenticateClientSecret(clientID, "")
+		is.Equal(err, apperrors.ErrInvalidClient)
+		_, err = ss.RotateServiceAccountSecret(admin, created.Info.ID.String())
+		is.Equal(err, apperrors.ErrServiceAccountNoSecret)
+	})
+}
+
+func setupServiceAccountService(t *testing.T) *services.ServiceAccountService {
+	t.Helper()
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	sr, _ := repository.NewServiceAccountRepository(tx)
+	ar, _ := repository.NewAuditRepository(tx)
+	ss, err := services.NewServiceAccountService(sr, ar)
+	if err != nil {
+		t.Fatalf("failed to create service account service: %v", err)
+	}
+	ss.TokenEndpointURL = testTokenEndpoint
+	return ss
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// UserHooks runs custom logic around the lifecycle of users. Hooks are
+// registered on UserService.Hooks and run in that order, each with its own
+// UserService.HookTimeout.
+//
+// Pre hooks run before the change and may refuse it: the first one to return
+// an error stops the others, and the change is not made. Return a *HookVeto
+// to answer the client with its status and message. Any other error, a
+// timeout or a panic fails closed with ErrHookFailed, and is only logged.
+//
+// Post hooks run after the change has been saved, so their errors cannot undo
+// it. Every post hook runs, and errors, timeouts and panics are logged.
+//
+// Embed NopUserHooks to implement only some of the hooks.
+type UserHooks interface {
+	// PreRegister runs once the email and password are valid, before the
+	// email is looked up, so a veto does not tell whether it is registered
+	PreRegister(ctx context.Context, event *RegisterEvent) error
+	// PostRegister runs once the user has been registered
+	PostRegister(ctx context.Context, event *RegisterEvent) error
+	// PreLogin runs once the user has proved who they are, before the
+	// session is created. It may add claims to event.SessionClaims.
+	PreLogin(ctx context.Context, event *LoginEvent) error
+	// PostLogin runs once the session has been created
+	PostLogin(ctx context.Context, event *LoginEvent) error
+	// PreUpdate runs before a user changes their email or password
+	PreUpdate(ctx context.Context, event *UpdateEvent) error
+	// PreScheduleDelete runs before a user schedules their account for
+	// deletion at the end of the grace period
+	PreScheduleDelete(ctx context.Context, event *DeleteEvent) error
+	// PostScheduleDelete runs once the account has been scheduled for
+	// deletion
+	PostScheduleDelete(ctx context.Context, event *DeleteEvent) error
+	// PreDelete runs before an account is permanently deleted, when its grace
+	// period ends or right away. A veto at the end of the grace period
+	// postpones the deletion to the next purge.
+	PreDelete(ctx context.Context, event *DeleteEvent) error
+	// PostDelete runs once an account has been permanently deleted
+	PostDelete(ctx context.Context, event *DeleteEvent) error
+}
+
+// RegisterEvent is a user registration. UserID is only set after the user
+// has been registered.
+type RegisterEvent struct {
+	UserID uuid.UUID
+	Email  string
+}
+
+// LoginEvent is a user logging in with Method. SessionID is only set after
+// the session has been created. SessionClaims are stored with the session and
+// returned by introspection; pre login hooks may add to them.
+type LoginEvent struct {
+	UserID        uuid.UUID
+	Email         string
+	Role          string
+	Method        string
+	SessionID     uuid.UUID
+	SessionClaims map[string]string
+}
+
+// Login methods of LoginEvent
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMagicLink = "magic_link"
+)
+
+// UpdateEvent is a user changing their email or password. NewEmail is empty
+// if the email is not changing.
+type UpdateEvent struct {
+	UserID          uuid.UUID
+	Email           string
+	NewEmail        string
+	PasswordChanged bool
+}
+
+// DeleteEvent is an account deletion. PurgeAfter is the end of the grace
+// period of an account scheduled for deletion, and nil when it is deleted
+// right away.
+type DeleteEvent struct {
+	UserID     uuid.UUID
+	Email      string
+	PurgeAfter *time.Time
+}
+
+// NopUserHooks implements every hook of UserHooks by doing nothing
+type NopUserHooks struct{}
+
+func (NopUserHooks) PreRegister(context.Context, *RegisterEvent) error      { return nil }
+func (NopUserHooks) PostRegister(context.Context, *RegisterEvent) error     { return nil }
+func (NopUserHooks) PreLogin(context.Context, *LoginEvent) error            { return nil }
+func (NopUserHooks) PostLogin(context.Context, *LoginEvent) error           { return nil }
+func (NopUserHooks) PreUpdate(context.Context, *UpdateEvent) error          { return nil }
+func (NopUserHooks) PreScheduleDelete(context.Context, *DeleteEvent) error  { return nil }
+func (NopUserHooks) PostScheduleDelete(context.Context, *DeleteEvent) error { return nil }
+func (NopUserHooks) PreDelete(context.Context, *DeleteEvent) error          { return nil }
+func (NopUserHooks) PostDelete(context.Context, *DeleteEvent) error         { return nil }
+
+// HookVeto is returned by a pre hook to refuse a change. Status is the HTTP
+// status of the response and Message its error. It unwraps to ErrHookVeto.
+type HookVeto struct {
+	Status  int
+	Message string
+}
+
+// Veto returns a HookVeto with `status`, or 403 if it is not a 4xx status,
+// and `message`, or a generic message if it is empty
+func Veto(status int, message string) *HookVeto {
+	if status < 400 || status > 499 {
+		status = http.StatusForbidden
+	}
+	if message == "" {
+		message = apperrors.ErrHookVeto.Error()
+	}
+	return &HookVeto{Status: status, Message: message}
+}
+
+// Error returns the message shown to the client
+func (v *HookVeto) Error() string {
+	return v.Message
+}
+
+// Unwrap returns ErrHookVeto
+func (v *HookVeto) Unwrap() error {
+	return apperrors.ErrHookVeto
+}
+
+// AsHookVeto returns the HookVeto in err's chain, if any
+func AsHookVeto(err error) (*HookVeto, bool) {
+	var veto *HookVeto
+	ok := errors.As(err, &veto)
+	return veto, ok
+}
+
+// runPreHooks runs `call` for each hook in order until one refuses
+func (us *UserService) runPreHooks(name string, call func(ctx context.Context, h UserHooks) error) error {
+	for i, hook := range us.Hooks {
+		if err := us.runHook(hook, call); err != nil {
+			return preHookError(name, i, err)
+		}
+	}
+	return nil
+}
+
+// preHookError logs why the pre hook at `index` refused. A veto is returned
+// as is; other failures become ErrHookFailed so their details stay private.
+func preHookError(name string, index int, err error) error {
+	if veto, ok := AsHookVeto(err); ok {
+		log.Info().
+			Str("hook", name).
+			Int("index", index).
+			Int("status", veto.Status).
+			Str("error", veto.Message).
+			Msg("User hook vetoed the request")
+		return Veto(veto.Status, veto.Message)
+	}
+	log.Error().
+		Str("hook", name).
+		Int("index", index).
+		Str("error", err.Error()).
+		Msg("User hook failed")
+	return apperrors.ErrHookFailed
+}
+
+// runPostHooks runs `call` for each hook in order, logging failures
+func (us *UserService) runPostHooks(name string, call func(ctx context.Context, h UserHooks) error) {
+	for i, hook := range us.Hooks {
+		if err := us.runHook(hook, call); err != nil {
+			log.Error().
+				Str("hook", name).
+				Int("index", i).
+				Str("error", err.Error()).
+				Msg("User hook failed")
+		}
+	}
+}
+
+// runHook runs one hook with HookTimeout. A hook that outlives its timeout
+// is left running in the background and its result is ignored, so hooks
+// must not change the event after their context is done.
+func (us *UserService) runHook(hook UserHooks, call func(ctx context.Context, h UserHooks) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), us.HookTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- call(ctx, hook)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return apperrors.ErrHookTimeout
+	}
+}
+
+// preLoginHooks runs the pre login hooks, each on a copy of `event` so a
+// hook that times out cannot change the session claims afterwards. The claims
+// of each hook that returned in time are kept for the next.
+func (us *UserService) preLoginHooks(event *LoginEvent) error {
+	for i, hook := range us.Hooks {
+		e := *event
+		e.SessionClaims = maps.Clone(event.SessionClaims)
+		if e.SessionClaims == nil {
+			e.SessionClaims = map[string]string{}
+		}
+		err := us.runHook(hook, func(ctx context.Context, h UserHooks) error {
+			return h.PreLogin(ctx, &e)
+		})
+		if err != nil {
+			return preHookError("PreLogin", i, err)
+		}
+		event.SessionClaims = e.SessionClaims
+	}
+	return nil
+}
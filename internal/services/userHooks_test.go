@@ -0,0 +1,328 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// testHooks records the hooks it runs under a name and runs the functions
+// set on it
+type testHooks struct {
+	services.NopUserHooks
+	name  string
+	calls *[]string
+	mu    *sync.Mutex
+
+	preRegister       func(*services.RegisterEvent) error
+	preLogin          func(context.Context, *services.LoginEvent) error
+	postLogin         func(*services.LoginEvent) error
+	preUpdate         func(*services.UpdateEvent) error
+	preScheduleDelete func(*services.DeleteEvent) error
+	preDelete         func(*services.DeleteEvent) error
+}
+
+func (h *testHooks) record(hook string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.calls = append(*h.calls, h.name+"."+hook)
+}
+
+func (h *testHooks) PreRegister(_ context.Context, e *services.RegisterEvent) error {
+	h.record("PreRegister")
+	if h.preRegister != nil {
+		return h.preRegister(e)
+	}
+	return nil
+}
+
+func (h *testHooks) PostRegister(context.Context, *services.RegisterEvent) error {
+	h.record("PostRegister")
+	return nil
+}
+
+func (h *testHooks) PreLogin(ctx context.Context, e *services.LoginEvent) error {
+	h.record("PreLogin")
+	if h.preLogin != nil {
+		return h.preLogin(ctx, e)
+	}
+	return nil
+}
+
+func (h *testHooks) PostLogin(_ context.Context, e *services.LoginEvent) error {
+	h.record("PostLogin")
+	if h.postLogin != nil {
+		return h.postLogin(e)
+	}
+	return nil
+}
+
+func (h *testHooks) PreUpdate(_ context.Context, e *services.UpdateEvent) error {
+	h.record("PreUpdate")
+	if h.preUpdate != nil {
+		return h.preUpdate(e)
+	}
+	return nil
+}
+
+func (h *testHooks) PreScheduleDelete(_ context.Context, e *services.DeleteEvent) error {
+	h.record("PreScheduleDelete")
+	if h.preScheduleDelete != nil {
+		return h.preScheduleDelete(e)
+	}
+	return nil
+}
+
+func (h *testHooks) PostScheduleDelete(context.Context, *services.DeleteEvent) error {
+	h.record("PostScheduleDelete")
+	return nil
+}
+
+func (h *testHooks) PreDelete(_ context.Context, e *services.DeleteEvent) error {
+	h.record("PreDelete")
+	if h.preDelete != nil {
+		return h.preDelete(e)
+	}
+	return nil
+}
+
+func (h *testHooks) PostDelete(context.Context, *services.DeleteEvent) error {
+	h.record("PostDelete")
+	return nil
+}
+
+func TestVeto(t *testing.T) {
+	is := is.New(t)
+
+	veto := services.Veto(http.StatusPaymentRequired, "subscription required")
+	is.Equal(veto.Status, http.StatusPaymentRequired)
+	is.Equal(veto.Error(), "subscription required")
+	is.True(errors.Is(veto, apperrors.ErrHookVeto))
+
+	found, ok := services.AsHookVeto(fmt.Errorf("wrapped: %w", veto))
+	is.True(ok)
+	is.Equal(found, veto)
+
+	// Only 4xx statuses can be answered
+	is.Equal(services.Veto(http.StatusOK, "").Status, http.StatusForbidden)
+	is.Equal(services.Veto(http.StatusBadGateway, "").Status, http.StatusForbidden)
+	is.Equal(services.Veto(0, "").Error(), apperrors.ErrHookVeto.Error())
+
+	_, ok = services.AsHookVeto(apperrors.ErrHookFailed)
+	is.True(!ok)
+}
+
+// TestUserService_Hooks tests the order user hooks run in, that pre hooks
+// can refuse a change and post hooks cannot, and their timeouts
+func TestUserService_Hooks(t *testing.T) {
+	is := is.New(t)
+	us := setupUserService(t)
+	us.HookTimeout = 100 * time.Millisecond
+
+	var calls []string
+	var mu sync.Mutex
+	first := &testHooks{name: "first", calls: &calls, mu: &mu}
+	second := &testHooks{name: "second", calls: &calls, mu: &mu}
+	us.Hooks = []services.UserHooks{first, second}
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = nil
+		*first = testHooks{name: "first", calls: &calls, mu: &mu}
+		*second = testHooks{name: "second", calls: &calls, mu: &mu}
+	}
+	recorded := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+
+	email := "testUserServiceHooks@test.com"
+
+	t.Run("pre register vetoes stop the registration", func(t *testing.T) {
+		reset()
+		first.preRegister = func(e *services.RegisterEvent) error {
+			if strings.HasSuffix(e.Email, "@blocked.com") {
+				return services.Veto(http.StatusForbidden, "registrations from this domain are closed")
+			}
+			return nil
+		}
+
+		err := us.RegisterUser("someone@blocked.com", testutils.TestingPassword)
+		veto, ok := services.AsHookVeto(err)
+		is.True(ok)
+		is.Equal(veto.Message, "registrations from this domain are closed")
+		is.Equal(recorded(), []string{"first.PreRegister"})
+		_, err = us.UserRepo.GetUserByEmail("someone@blocked.com")
+		is.True(err != nil)
+
+		is.NoErr(us.RegisterUser(email, testutils.TestingPassword))
+		is.Equal(recorded(), []string{
+			"first.PreRegister",
+			"first.PreRegister", "second.PreRegister",
+			"first.PostRegister", "second.PostRegister",
+		})
+	})
+
+	t.Run("pre login hooks add session claims", func(t *testing.T) {
+		reset()
+		first.preLogin = func(_ context.Context, e *services.LoginEvent) error {
+			e.SessionClaims["plan"] = "pro"
+			return nil
+		}
+		second.preLogin = func(_ context.Context, e *services.LoginEvent) error {
+			if e.SessionClaims["plan"] != "pro" {
+				return errors.New("first hook did not run")
+			}
+			e.SessionClaims["tenant"] = "acme"
+			return nil
+		}
+		var sessionID string
+		second.postLogin = func(e *services.LoginEvent) error {
+			sessionID = e.SessionID.String()
+			return errors.New("post hooks cannot fail the login")
+		}
+
+		token, err := us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		is.Equal(recorded(), []string{"first.PreLogin", "second.PreLogin", "first.PostLogin", "second.PostLogin"})
+
+		tokenHash, err := models.ParseSessionToken(token)
+		is.NoErr(err)
+		session, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.NoErr(err)
+		is.Equal(session.ID.String(), sessionID)
+		is.Equal(session.Claims, map[string]string{"plan": "pro", "tenant": "acme"})
+
+		// Rotated sessions keep their claims
+		rotated, err := us.RotateSession(session.ID)
+		is.NoErr(err)
+		tokenHash, err = models.ParseSessionToken(rotated)
+		is.NoErr(err)
+		session, err = us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.NoErr(err)
+		is.Equal(session.Claims["tenant"], "acme")
+	})
+
+	t.Run("failed and slow pre hooks fail closed", func(t *testing.T) {
+		reset()
+		first.preLogin = func(context.Context, *services.LoginEvent) error {
+			return errors.New("entitlements service unavailable")
+		}
+		_, err := us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrHookFailed)
+		is.Equal(recorded(), []string{"first.PreLogin"})
+
+		reset()
+		first.preLogin = func(ctx context.Context, e *services.LoginEvent) error {
+			<-ctx.Done()
+			return nil
+		}
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrHookFailed)
+
+		reset()
+		first.preLogin = func(context.Context, *services.LoginEvent) error {
+			panic("boom")
+		}
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrHookFailed)
+	})
+
+	t.Run("pre update hooks see email and password changes", func(t *testing.T) {
+		reset()
+		var event services.UpdateEvent
+		first.preUpdate = func(e *services.UpdateEvent) error {
+			event = *e
+			return services.Veto(http.StatusConflict, "email is managed by the CRM")
+		}
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+
+		err = us.UpdateUser(user.ID.String(), map[string]any{"email": "testUserServiceHooksNew@test.com"})
+		veto, ok := services.AsHookVeto(err)
+		is.True(ok)
+		is.Equal(veto.Status, http.StatusConflict)
+		is.Equal(event.Email, email)
+		is.Equal(event.NewEmail, "testUserServiceHooksNew@test.com")
+		is.True(!event.PasswordChanged)
+		_, err = us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+
+		// Bookkeeping updates do not run the hooks
+		is.NoErr(us.UpdateUser(user.ID.String(), map[string]any{"last_login": time.Now().UTC()}))
+		is.Equal(recorded(), []string{"first.PreUpdate"})
+	})
+
+	t.Run("pre schedule delete hooks refuse scheduling", func(t *testing.T) {
+		reset()
+		first.preScheduleDelete = func(e *services.DeleteEvent) error {
+			is.True(e.PurgeAfter != nil)
+			return services.Veto(http.StatusForbidden, "cancel your subscription first")
+		}
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+
+		_, err = us.RequestAccountDeletion(user.ID.String())
+		_, ok := services.AsHookVeto(err)
+		is.True(ok)
+		user, err = us.UserRepo.GetUserByID(user.ID.String())
+		is.NoErr(err)
+		is.True(!user.PendingDeletion())
+
+		reset()
+		_, err = us.RequestAccountDeletion(user.ID.String())
+		is.NoErr(err)
+		is.Equal(recorded(), []string{"first.PreScheduleDelete", "second.PreScheduleDelete", "first.PostScheduleDelete", "second.PostScheduleDelete"})
+	})
+
+	t.Run("delete hooks run when the account is purged", func(t *testing.T) {
+		reset()
+		first.preDelete = func(e *services.DeleteEvent) error {
+			is.True(e.PurgeAfter != nil)
+			return services.Veto(http.StatusForbidden, "export pending")
+		}
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		is.NoErr(us.UserRepo.DB.Model(&models.User{}).Where("id = ?", user.ID).
+			Update("purge_after", time.Now().UTC().Add(-time.Minute)).Error)
+
+		// A veto postpones the purge
+		purged, err := us.PurgeDeletedAccounts(time.Now().UTC())
+		is.NoErr(err)
+		is.Equal(purged, 0)
+		is.Equal(recorded(), []string{"first.PreDelete"})
+		_, err = us.UserRepo.GetUserByID(user.ID.String())
+		is.NoErr(err)
+
+		reset()
+		purged, err = us.PurgeDeletedAccounts(time.Now().UTC())
+		is.NoErr(err)
+		is.Equal(purged, 1)
+		is.Equal(recorded(), []string{"first.PreDelete", "second.PreDelete", "first.PostDelete", "second.PostDelete"})
+		_, err = us.UserRepo.GetUserByID(user.ID.String())
+		is.True(err != nil)
+	})
+
+	t.Run("delete hooks run when the account is deleted right away", func(t *testing.T) {
+		is.NoErr(us.RegisterUser(email, testutils.TestingPassword))
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+
+		reset()
+		is.NoErr(us.PermanentlyDeleteUser(user.ID.String()))
+		is.Equal(recorded(), []string{"first.PreDelete", "second.PreDelete", "first.PostDelete", "second.PostDelete"})
+	})
+}
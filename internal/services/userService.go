@@ -1,15 +1,22 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/mail"
-	"strings"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
-	passwordvalidator "github.com/wagslane/go-password-validator"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 
+	"github.com/al-ce/goauth/internal/mailer"
 	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/repository"
 	"github.com/al-ce/goauth/pkg/apperrors"
 	"github.com/al-ce/goauth/pkg/config"
@@ -17,8 +24,26 @@ import (
 
 // UserService is a struct that contains the repositories needed for user-related operations
 type UserService struct {
-	UserRepo    *repository.UserRepository
-	SessionRepo *repository.SessionRepository
+	UserRepo      *repository.UserRepository
+	SessionRepo   *repository.SessionRepository
+	MagicLinkRepo *repository.MagicLinkRepository
+	// Mailer notifies account owners out of band
+	Mailer mailer.Mailer
+	// EnumerationResistant hides whether an account exists from login and
+	// registration responses
+	EnumerationResistant bool
+	// DeletionGracePeriod is how long an account scheduled for deletion can
+	// be restored
+	DeletionGracePeriod time.Duration
+	// RestoreURL is the page linked from the deletion email, if any
+	RestoreURL string
+	// MagicLinkURL is the page linked from the magic link email, if any
+	MagicLinkURL string
+	// Hooks run custom logic around registration, login, updates and
+	// deletion, in order. See UserHooks.
+	Hooks []UserHooks
+	// HookTimeout is how long each hook may run
+	HookTimeout time.Duration
 }
 
 // NewUserService returns a value of type UserService
@@ -29,12 +54,41 @@ func NewUserService(ur *repository.UserRepository, sr *repository.SessionReposit
 	if sr == nil {
 		return nil, apperrors.ErrSessionRepoIsNil
 	}
+	gracePeriod, err := DeletionGracePeriodFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	mr, err := repository.NewMagicLinkRepository(ur.DB)
+	if err != nil {
+		return nil, err
+	}
 	return &UserService{
-		UserRepo:    ur,
-		SessionRepo: sr,
+		UserRepo:             ur,
+		SessionRepo:          sr,
+		MagicLinkRepo:        mr,
+		Mailer:               mailer.NewFromEnv(),
+		EnumerationResistant: os.Getenv(config.EnumerationProtection) == "true",
+		DeletionGracePeriod:  gracePeriod,
+		RestoreURL:           os.Getenv(config.AccountRestoreURL),
+		MagicLinkURL:         os.Getenv(config.MagicLinkURL),
+		HookTimeout:          config.UserHookTimeout,
 	}, nil
 }
 
+// DeletionGracePeriodFromEnv reads the account deletion grace period from
+// `ACCOUNT_DELETION_GRACE_DAYS`
+func DeletionGracePeriodFromEnv() (time.Duration, error) {
+	days := config.DefaultAccountDeletionGraceDays
+	if val := os.Getenv(config.AccountDeletionGraceDays); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%w: %q", apperrors.ErrDeletionGraceConfig, val)
+		}
+		days = n
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
 // RegisterUser mediates the new User value creation and the insertion of a user into the database
 func (us *UserService) RegisterUser(email, password string) error {
 	// Check for empty fields
@@ -45,18 +99,68 @@ func (us *UserService) RegisterUser(email, password string) error {
 		return apperrors.ErrPasswordIsEmpty
 	}
 
+	// Validate and hash before looking the email up, so an invalid password
+	// is reported the same way and takes as long whether or not the account exists
+	user, err := models.NewUser(email, password)
+	if err != nil {
+		return err
+	}
+	return us.register(user)
+}
+
+// RegisterPasswordlessUser registers a user without a password, who logs in
+// with magic links
+func (us *UserService) RegisterPasswordlessUser(email string) error {
+	if email == "" {
+		return apperrors.ErrEmailIsEmpty
+	}
+	user, err := models.NewPasswordlessUser(email)
+	if err != nil {
+		return err
+	}
+	return us.register(user)
+}
+
+// register inserts a new user value into the database, running the register
+// hooks around it
+func (us *UserService) register(user *models.User) error {
+	event := &RegisterEvent{Email: user.Email}
+	if err := us.runPreHooks("PreRegister", func(ctx context.Context, h UserHooks) error {
+		return h.PreRegister(ctx, event)
+	}); err != nil {
+		return err
+	}
+
 	// Check if user exists before attempting registration
 	// If user was found, we have duplicate user
-	user, _ := us.UserRepo.GetUserByEmail(email)
-	if user != nil {
-		return apperrors.ErrDuplicateEmail
+	existing, _ := us.UserRepo.GetUserByEmail(user.Email)
+	if existing != nil {
+		if !us.EnumerationResistant {
+			return apperrors.ErrDuplicateEmail
+		}
+		// Tell the owner instead of the requester
+		us.sendMail(registrationAttemptEmail(existing.Email))
+		return nil
 	}
 
-	user, err := models.NewUser(email, password)
+	err := us.transaction(func(ur *repository.UserRepository, _ *repository.SessionRepository, wr *repository.WebhookRepository) error {
+		if err := ur.RegisterUser(user); err != nil {
+			return err
+		}
+		return enqueueUserEvent(wr, models.WebhookUserRegistered, models.WebhookUserData{UserID: user.ID, Email: user.Email})
+	})
 	if err != nil {
 		return err
 	}
-	return us.UserRepo.RegisterUser(user)
+
+	event.UserID = user.ID
+	us.runPostHooks("PostRegister", func(ctx context.Context, h UserHooks) error {
+		return h.PostRegister(ctx, event)
+	})
+	if us.EnumerationResistant {
+		us.sendMail(welcomeEmail(user.Email))
+	}
+	return nil
 }
 
 // LoginUser authenticates a registered user and creates an associated session
@@ -75,64 +179,227 @@ func (us *UserService) LoginUser(email, password string) (string, error) {
 	// Check if user exists
 	user, err := us.UserRepo.GetUserByEmail(email)
 	if err != nil {
+		if us.EnumerationResistant {
+			// Spend as long as a real password check would
+			if err := passwords.Default().VerifyDummy(password); err != nil {
+				return "", err
+			}
+			return "", apperrors.ErrInvalidLogin
+		}
 		return "", apperrors.ErrUserNotFound
 	}
 
-	// Deny login if account is locked
+	// Validate password, subject to the account lockout
+	if err := us.checkPassword(user, password); err != nil {
+		if errors.Is(err, apperrors.ErrAccountIsLocked) {
+			return "", us.accountLockedError(password)
+		}
+		return "", err
+	}
+
+	// Only the owner learns the account is pending deletion, after proving
+	// who they are
+	if user.PendingDeletion() {
+		return "", apperrors.ErrAccountPendingDeletion
+	}
+
+	// Flag passwords that have since appeared in a breach so the client can
+	// prompt for a change
+	updates := map[string]any{}
+	if policy := passwords.ActivePolicy(); policy.Breached != nil {
+		breached := policy.IsBreached(password)
+		if breached {
+			log.Warn().
+				Str("userID", user.ID.String()).
+				Msg("User logged in with a breached password")
+		}
+		updates["password_breached"] = breached
+	}
+	return us.startSession(user, LoginMethodPassword, updates)
+}
+
+// startSession finishes the login of a user who has proved who they are: it
+// runs the login hooks around creating the session, and records the login
+// time with `updates` to the user. It returns the session token.
+func (us *UserService) startSession(user *models.User, method string, updates map[string]any) (string, error) {
+	event := &LoginEvent{UserID: user.ID, Email: user.Email, Role: user.Role, Method: method}
+	if err := us.preLoginHooks(event); err != nil {
+		return "", err
+	}
+
+	session, sessionToken, err := us.createSession(user.ID, event.SessionClaims)
+	if err != nil {
+		return "", err
+	}
+
+	// Update last login time
+	updates["last_login"] = time.Now().UTC()
+	if err := us.UpdateUser(user.ID.String(), updates); err != nil {
+		return "", err
+	}
+
+	event.SessionID = session.ID
+	us.runPostHooks("PostLogin", func(ctx context.Context, h UserHooks) error {
+		return h.PostLogin(ctx, event)
+	})
+	return sessionToken, nil
+}
+
+// checkPassword verifies a user's password with the lockout rules of login:
+// a locked account is refused, and wrong passwords count towards locking it.
+// A correct password with an outdated hash is rehashed. Accounts without a
+// password never match.
+func (us *UserService) checkPassword(user *models.User, password string) error {
+	if err := us.checkLockout(user); err != nil {
+		return err
+	}
+
+	if !user.HasPassword() {
+		// Spend as long as a real password check would
+		if err := passwords.Default().VerifyDummy(password); err != nil {
+			return err
+		}
+		if err := us.UserRepo.IncrementFailedLogins(user.ID.String()); err != nil {
+			return err
+		}
+		return apperrors.ErrInvalidLogin
+	}
+
+	match, rehash, err := passwords.Default().Verify(password, user.Password)
+	if errors.Is(err, apperrors.ErrHashingBusy) {
+		// The password was never checked, so this is not a failed attempt
+		return err
+	}
+	if err != nil || !match {
+		// Increment failed login attempts
+		if err := us.UserRepo.IncrementFailedLogins(user.ID.String()); err != nil {
+			return err
+		}
+		return apperrors.ErrInvalidLogin
+	}
+
+	// Upgrade hashes made with an outdated algorithm or parameters now that
+	// we have the plaintext. A failed upgrade should not fail the check.
+	if rehash {
+		us.rehashPassword(user.ID.String(), password)
+	}
+	return nil
+}
+
+// checkLockout refuses locked accounts, unlocking them once their lockout
+// has ended, and locks accounts with too many failed attempts
+func (us *UserService) checkLockout(user *models.User) error {
+	// Deny if account is locked
 	if user.AccountLocked {
-		// Unlock account if it is after lockout time
-		if user.AccountLockedUntil == nil || time.Now().UTC().After(*user.AccountLockedUntil) {
-			err := us.UserRepo.UnlockAccount(user.ID.String())
-			if err != nil {
-				return "", err
+		// Unlock account if it is after lockout time. Accounts locked by an
+		// admin without an end time stay locked until unlocked.
+		if user.AccountLockedUntil != nil && time.Now().UTC().After(*user.AccountLockedUntil) {
+			if err := us.UserRepo.UnlockAccount(user.ID.String()); err != nil {
+				return err
 			}
+			user.FailedLoginAttempts = 0
 		} else {
-			return "", apperrors.ErrAccountIsLocked
+			return apperrors.ErrAccountIsLocked
 		}
 	}
 
 	// Lock account on too many failed attempts
 	if user.FailedLoginAttempts >= config.MaxLoginAttempts {
-		err = us.UserRepo.LockAccount(user.ID.String())
-		if err != nil {
-			return "", err
+		if err := us.UserRepo.LockAccount(user.ID.String()); err != nil {
+			return err
 		}
-		return "", apperrors.ErrAccountIsLocked
+		return apperrors.ErrAccountIsLocked
+	}
+	return nil
+}
+
+// CheckPassword verifies a logged in user's current password, e.g. before
+// changing it. Wrong passwords count towards the account lockout.
+func (us *UserService) CheckPassword(userID string, password string) error {
+	if userID == "" {
+		return apperrors.ErrUserIdEmpty
+	}
+	if password == "" {
+		return apperrors.ErrPasswordIsEmpty
+	}
+	user, err := us.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return apperrors.ErrUserNotFound
 	}
+	return us.checkPassword(user, password)
+}
 
+// Reauthenticate verifies a logged in user's password and records it as the
+// session's auth time, so the session may briefly perform operations that
+// require a recent authentication
+func (us *UserService) Reauthenticate(userID string, sessionID uuid.UUID, password string) error {
+	if err := us.CheckPassword(userID, password); err != nil {
+		return err
+	}
+	return us.SessionRepo.UpdateSessionAuthTime(sessionID, time.Now().UTC())
+}
 
-	// Validate password
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		// Increment failed login attempts
-		err = us.UserRepo.IncrementFailedLogins(user.ID.String())
-		if err != nil {
-			return "", err
+// accountLockedError returns ErrAccountIsLocked, or in the enumeration-resistant
+// mode the same error and timing as a wrong password
+func (us *UserService) accountLockedError(password string) error {
+	if !us.EnumerationResistant {
+		return apperrors.ErrAccountIsLocked
+	}
+	if err := passwords.Default().VerifyDummy(password); err != nil {
+		return err
+	}
+	return apperrors.ErrInvalidLogin
+}
+
+// sendMail delivers an email in the background, so a slow mail server
+// neither delays the response nor reveals which branch a request took
+func (us *UserService) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.MailSendTimeout)
+		defer cancel()
+		if err := us.Mailer.Send(ctx, msg); err != nil {
+			log.Error().
+				Str("error", err.Error()).
+				Msg("Could not send email")
 		}
-		return "", apperrors.ErrInvalidLogin
+	}()
+}
+
+// rehashPassword replaces a user's stored hash with one from the default hasher
+func (us *UserService) rehashPassword(userID, password string) {
+	hash, err := passwords.Default().Hash(password)
+	if err == nil {
+		err = us.UserRepo.UpdateUser(userID, map[string]any{"password": hash})
 	}
+	if err != nil {
+		log.Warn().
+			Str("userID", userID).
+			Str("error", err.Error()).
+			Msg("Could not upgrade password hash")
+	}
+}
 
-	// Generate session ID
-	sessionID, signature, err := models.GenerateSessionID()
+// createSession stores a new session for a user with the claims added by
+// the login hooks, and returns it with its token
+func (us *UserService) createSession(userID uuid.UUID, claims map[string]string) (*models.Session, string, error) {
+	sessionToken, tokenHash, err := models.GenerateSessionToken()
 	if err != nil {
-		return "", apperrors.ErrSessionIDGeneration
+		return nil, "", apperrors.ErrSessionIDGeneration
 	}
-	sessionToken := sessionID.String() + "." + signature
 
 	// Create session with expiration time (use UTC)
 	expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
-	session, err := models.NewSession(user.ID, sessionID, expiresAt)
-
-	if err := us.SessionRepo.CreateSession(session); err != nil {
-		return "", err
+	session, err := models.NewSession(userID, tokenHash, expiresAt)
+	if err != nil {
+		return nil, "", err
 	}
-
-	// Update last login time
-	requestData := map[string]any{"last_login": time.Now().UTC()}
-	if err := us.UpdateUser(user.ID.String(), requestData); err != nil {
-		return "", err
+	if len(claims) > 0 {
+		session.Claims = claims
 	}
-
-	return sessionToken, nil
+	if err := us.SessionRepo.CreateSession(session); err != nil {
+		return nil, "", err
+	}
+	return session, sessionToken, nil
 }
 
 // Logout invalidates a token by deleting its corresponding session
@@ -140,17 +407,43 @@ func (us *UserService) Logout(sessionToken string) error {
 	if sessionToken == "" {
 		return apperrors.ErrSessionIdIsEmpty
 	}
-	// Split the session token
-	parts := strings.Split(sessionToken, ".")
-	if len(parts) != 2 {
-		return apperrors.ErrInvalidTokenFormat
+	tokenHash, err := models.ParseSessionToken(sessionToken)
+	if err != nil {
+		return err
+	}
+	return us.SessionRepo.DeleteSessionByTokenHash(tokenHash)
+}
+
+// ListSessions returns a user's unexpired sessions, marking the one with the
+// public ID `currentID` as current
+func (us *UserService) ListSessions(userID string, currentID uuid.UUID) ([]models.SessionInfo, error) {
+	sessions, err := us.SessionRepo.GetUnexpiredSessionsByUserID(userID)
+	if err != nil {
+		return nil, err
 	}
-	sessionID := parts[0]
+	infos := make([]models.SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, models.SessionInfo{
+			ID:        session.ID,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   session.ID == currentID,
+		})
+	}
+	return infos, nil
+}
+
+// RevokeSession deletes one of a user's sessions by its public ID
+func (us *UserService) RevokeSession(userID string, sessionID string) error {
 	parsedID, err := uuid.Parse(sessionID)
 	if err != nil {
-		return apperrors.ErrInvalidTokenFormat
+		return apperrors.ErrSessionNotFound
+	}
+	err = us.SessionRepo.DeleteUserSessionByID(userID, parsedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperrors.ErrSessionNotFound
 	}
-	return us.SessionRepo.DeleteSessionByID(parsedID)
+	return err
 }
 
 func (us *UserService) LogoutEverywhere(userID string) error {
@@ -173,8 +466,9 @@ func (us *UserService) GetUserProfile(userID string) (*models.UserProfile, error
 	// The User object contains sensitive information like password hash.
 	// Rather than trust ourselves to never expose that, we create a new struct
 	userProfile := &models.UserProfile{
-		Email:     user.Email,
-		LastLogin: user.LastLogin,
+		Email:            user.Email,
+		LastLogin:        user.LastLogin,
+		PasswordBreached: user.PasswordBreached,
 	}
 	return userProfile, nil
 }
@@ -188,22 +482,41 @@ func (us *UserService) UpdateUser(userID string, request map[string]any) error {
 	// Check if user exists with this email before attempting update
 	if email, ok := request["email"].(string); ok && email != "" {
 		user, _ := us.UserRepo.GetUserByEmail(request["email"].(string))
-		// If user was found, we have duplicate user
-		if user != nil {
+		// If another user was found, we have duplicate user. The same user
+		// may change how their email is displayed, e.g. its case.
+		if user != nil && user.ID.String() != userID {
 			return apperrors.ErrDuplicateEmail
 		}
 	}
 
-	if password, ok := request["password"].(string); ok && password != "" {
-		if err := passwordvalidator.Validate(password, config.MinEntropyBits); err != nil {
+	// Keep the user as it was, for the password history and the email
+	// change webhook
+	var previous *models.User
+	newEmail, _ := request["email"].(string)
+	password, _ := request["password"].(string)
+	if newEmail != "" || password != "" {
+		user, err := us.UserRepo.GetUserByID(userID)
+		if err != nil {
+			return apperrors.ErrUserNotFound
+		}
+		previous = user
+	}
+
+	if password != "" {
+		email := previous.Email
+		if newEmail != "" {
+			email = newEmail
+		}
+		if err := us.checkPasswordPolicy(previous, email, password); err != nil {
 			return err
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		hashedPassword, err := passwords.Default().Hash(password)
 		if err != nil {
 			return err
 		}
-		request["password"] = string(hashedPassword)
+		request["password"] = hashedPassword
+		request["password_breached"] = false
 	}
 
 	if email, ok := request["email"].(string); ok && email != "" {
@@ -215,25 +528,322 @@ func (us *UserService) UpdateUser(userID string, request map[string]any) error {
 		}
 	}
 
-	return us.UserRepo.UpdateUser(userID, request)
+	// The update hooks run for changes of the email or password
+	if previous != nil {
+		event := &UpdateEvent{
+			UserID:          previous.ID,
+			Email:           previous.Email,
+			PasswordChanged: password != "",
+		}
+		if newEmail != previous.Email {
+			event.NewEmail = newEmail
+		}
+		if event.NewEmail != "" || event.PasswordChanged {
+			err := us.runPreHooks("PreUpdate", func(ctx context.Context, h UserHooks) error {
+				return h.PreUpdate(ctx, event)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := us.transaction(func(ur *repository.UserRepository, _ *repository.SessionRepository, wr *repository.WebhookRepository) error {
+		if err := ur.UpdateUser(userID, request); err != nil {
+			return err
+		}
+		if newEmail == "" || newEmail == previous.Email {
+			return nil
+		}
+		return enqueueUserEvent(wr, models.WebhookUserEmailChanged, models.WebhookUserData{
+			UserID:        previous.ID,
+			Email:         newEmail,
+			PreviousEmail: previous.Email,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if password != "" {
+		us.recordPasswordHistory(previous)
+	}
+	return nil
+}
+
+// checkPasswordPolicy checks a user's new password against the password
+// policy and their recent passwords
+func (us *UserService) checkPasswordPolicy(user *models.User, email, password string) error {
+	policy := passwords.ActivePolicy()
+	if err := policy.Check(password, email); err != nil {
+		return err
+	}
+	if policy.HistorySize == 0 {
+		return nil
+	}
+
+	// The current password counts towards the history size
+	var hashes []string
+	if user.HasPassword() {
+		hashes = append(hashes, user.Password)
+	}
+	if policy.HistorySize > 1 {
+		history, err := us.UserRepo.GetPasswordHistory(user.ID.String(), policy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, history...)
+	}
+	return policy.CheckHistory(password, hashes)
+}
+
+// recordPasswordHistory stores a user's replaced password hash and drops
+// hashes older than the password policy remembers. The password change has
+// already been saved, so failures are logged rather than returned.
+func (us *UserService) recordPasswordHistory(user *models.User) {
+	keep := passwords.ActivePolicy().HistorySize - 1
+	userID := user.ID.String()
+	var err error
+	if keep > 0 && user.HasPassword() {
+		err = us.UserRepo.AddPasswordHistory(user.ID, user.Password)
+	}
+	if err == nil {
+		err = us.UserRepo.PrunePasswordHistory(userID, max(keep, 0))
+	}
+	if err != nil {
+		log.Warn().
+			Str("userID", userID).
+			Str("error", err.Error()).
+			Msg("Could not update password history")
+	}
 }
 
 // PermanentlyDeleteUser removes the user from the database. This is a permanent operation rather
-// than a "deletedAt" flag toggle.
+// than a "deletedAt" flag toggle. Users deleting their own account go through
+// RequestAccountDeletion instead, which has a grace period.
 func (us *UserService) PermanentlyDeleteUser(userID string) error {
 	if userID == "" {
 		return apperrors.ErrUserIdEmpty
 	}
-	rowsAffected, err := us.UserRepo.PermanentlyDeleteUser(userID)
+	user, err := us.UserRepo.GetUserByID(userID)
 	if err != nil {
+		return apperrors.ErrUserNotFound
+	}
+	event := &DeleteEvent{UserID: user.ID, Email: user.Email}
+	if err := us.runPreHooks("PreDelete", func(ctx context.Context, h UserHooks) error {
+		return h.PreDelete(ctx, event)
+	}); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return apperrors.ErrUserNotFound
+
+	err = us.transaction(func(ur *repository.UserRepository, _ *repository.SessionRepository, wr *repository.WebhookRepository) error {
+		rowsAffected, err := ur.PermanentlyDeleteUser(userID)
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return apperrors.ErrUserNotFound
+		}
+		return enqueueUserEvent(wr, models.WebhookUserDeleted, models.WebhookUserData{UserID: user.ID, Email: user.Email})
+	})
+	if err != nil {
+		return err
 	}
+
+	us.runPostHooks("PostDelete", func(ctx context.Context, h UserHooks) error {
+		return h.PostDelete(ctx, event)
+	})
 	return nil
 }
 
+// RequestAccountDeletion schedules a user's account for deletion at the end
+// of the grace period and returns when that is. The user's sessions are
+// revoked, login is refused, and a restore token is emailed to the user.
+func (us *UserService) RequestAccountDeletion(userID string) (time.Time, error) {
+	if userID == "" {
+		return time.Time{}, apperrors.ErrUserIdEmpty
+	}
+	user, err := us.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return time.Time{}, apperrors.ErrUserNotFound
+	}
+
+	token, tokenHash, err := models.GenerateRestoreToken()
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now().UTC()
+	purgeAfter := now.Add(us.DeletionGracePeriod)
+	event := &DeleteEvent{UserID: user.ID, Email: user.Email, PurgeAfter: &purgeAfter}
+	if err := us.runPreHooks("PreScheduleDelete", func(ctx context.Context, h UserHooks) error {
+		return h.PreScheduleDelete(ctx, event)
+	}); err != nil {
+		return time.Time{}, err
+	}
+
+	err = us.transaction(func(ur *repository.UserRepository, sr *repository.SessionRepository, wr *repository.WebhookRepository) error {
+		if err := ur.ScheduleDeletion(userID, tokenHash, now, purgeAfter); err != nil {
+			return err
+		}
+		if err := sr.DeleteSessionsByUserID(userID); err != nil {
+			return err
+		}
+		return enqueueUserEvent(wr, models.WebhookUserDeletionScheduled, models.WebhookUserData{
+			UserID:     user.ID,
+			Email:      user.Email,
+			PurgeAfter: &purgeAfter,
+		})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	us.runPostHooks("PostScheduleDelete", func(ctx context.Context, h UserHooks) error {
+		return h.PostScheduleDelete(ctx, event)
+	})
+	us.sendMail(deletionScheduledEmail(user.Email, token, us.restoreLink(token), purgeAfter))
+	return purgeAfter, nil
+}
+
+// PurgeDeletedAccounts permanently deletes the accounts whose grace period
+// ended before `now` and returns how many were deleted. Each account runs the
+// delete hooks and is deleted in its own transaction with its audit event
+// and `user.deleted` webhook event, so an account is never deleted without a
+// record of it. An account a pre delete hook refuses is kept until the next
+// purge.
+func (us *UserService) PurgeDeletedAccounts(now time.Time) (int, error) {
+	users, err := us.UserRepo.GetUsersDueForPurge(now)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, user := range users {
+		event := &DeleteEvent{UserID: user.ID, Email: user.Email, PurgeAfter: user.PurgeAfter}
+		if err := us.runPreHooks("PreDelete", func(ctx context.Context, h UserHooks) error {
+			return h.PreDelete(ctx, event)
+		}); err != nil {
+			log.Warn().
+				Str("userID", user.ID.String()).
+				Str("error", err.Error()).
+				Msg("Account deletion postponed by a hook")
+			continue
+		}
+
+		deleted := false
+		err := us.transaction(func(ur *repository.UserRepository, _ *repository.SessionRepository, wr *repository.WebhookRepository) error {
+			rowsAffected, err := ur.PurgeDeletedUser(user.ID.String(), now)
+			if err != nil {
+				return err
+			}
+			if rowsAffected == 0 {
+				// Restored since it was listed
+				return nil
+			}
+			ar, err := repository.NewAuditRepository(ur.DB)
+			if err != nil {
+				return err
+			}
+			details := "deletion requested"
+			if user.DeletionRequestedAt != nil {
+				details += " at " + user.DeletionRequestedAt.UTC().Format(time.RFC3339)
+			}
+			if err := ar.CreateAuditEvent(models.NewAuditEvent(models.AuditAccountDeleted, user.ID, details)); err != nil {
+				return err
+			}
+			deleted = true
+			return enqueueUserEvent(wr, models.WebhookUserDeleted, models.WebhookUserData{UserID: user.ID, Email: user.Email})
+		})
+		if err != nil {
+			return purged, err
+		}
+		if !deleted {
+			continue
+		}
+		purged++
+
+		us.runPostHooks("PostDelete", func(ctx context.Context, h UserHooks) error {
+			return h.PostDelete(ctx, event)
+		})
+	}
+	return purged, nil
+}
+
+// RestoreAccount cancels the pending deletion of the account a restore token
+// was issued for. The user logs in again afterwards.
+func (us *UserService) RestoreAccount(token string) error {
+	if token == "" {
+		return apperrors.ErrRestoreTokenInvalid
+	}
+	var user *models.User
+	err := us.transaction(func(ur *repository.UserRepository, _ *repository.SessionRepository, wr *repository.WebhookRepository) error {
+		var err error
+		if user, err = ur.RestoreUser(models.HashRestoreToken(token)); err != nil {
+			return err
+		}
+		return enqueueUserEvent(wr, models.WebhookUserRestored, models.WebhookUserData{UserID: user.ID, Email: user.Email})
+	})
+	if err != nil {
+		return err
+	}
+	us.sendMail(accountRestoredEmail(user.Email))
+	return nil
+}
+
+// transaction runs `fn` with user, session and webhook repositories on one
+// database transaction, so the webhook events of a change are added to the
+// outbox only if the change is saved
+func (us *UserService) transaction(fn func(ur *repository.UserRepository, sr *repository.SessionRepository, wr *repository.WebhookRepository) error) error {
+	return us.UserRepo.DB.Transaction(func(tx *gorm.DB) error {
+		ur, err := repository.NewUserRepository(tx)
+		if err != nil {
+			return err
+		}
+		sr, err := repository.NewSessionRepository(tx)
+		if err != nil {
+			return err
+		}
+		wr, err := repository.NewWebhookRepository(tx)
+		if err != nil {
+			return err
+		}
+		return fn(ur, sr, wr)
+	})
+}
+
+// enqueueUserEvent adds a user webhook event to the outbox of `wr`
+func enqueueUserEvent(wr *repository.WebhookRepository, eventType string, data models.WebhookUserData) error {
+	event, err := models.NewWebhookEvent(eventType, data)
+	if err != nil {
+		return err
+	}
+	_, err = wr.EnqueueWebhookEvent(event)
+	return err
+}
+
+// restoreLink returns the link to restore an account with a token, or an
+// empty string if `ACCOUNT_RESTORE_URL` is not set
+func (us *UserService) restoreLink(token string) string {
+	return linkWithToken(us.RestoreURL, "account restore", token)
+}
+
+// linkWithToken returns `base` with a `token` query parameter, or an empty
+// string if `base` is empty or invalid. `name` describes the link in logs.
+func linkWithToken(base, name, token string) string {
+	if base == "" {
+		return ""
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		log.Warn().
+			Str("error", err.Error()).
+			Msg("Invalid " + name + " URL")
+		return ""
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
 // RotateSession generates a new session token for the user and invalidates the old one
 // RotateSession creates a new session and replaces the old one
 func (us *UserService) RotateSession(oldSessionID uuid.UUID) (string, error) {
@@ -244,18 +854,20 @@ func (us *UserService) RotateSession(oldSessionID uuid.UUID) (string, error) {
 	}
 
 	// Generate new session token with same claims
-	newSessionID, signature, err := models.GenerateSessionID()
+	newSessionToken, tokenHash, err := models.GenerateSessionToken()
 	if err != nil {
 		return "", apperrors.ErrSessionIDGeneration
 	}
-	newSessionToken := newSessionID.String() + "." + signature
 
 	// Create new session with the new token and expiration time
 	expiresAt := time.Now().UTC().Add(time.Duration(config.SessionExpiration) * time.Second)
-	newSession, err := models.NewSession(oldSession.UserID, newSessionID, expiresAt)
+	newSession, err := models.NewSession(oldSession.UserID, tokenHash, expiresAt)
 	if err != nil {
 		return "", err
 	}
+	// Rotation is not a new authentication
+	newSession.AuthTime = oldSession.AuthTime
+	newSession.Claims = oldSession.Claims
 
 	// Use the existing database connection/transaction from the repository
 	db := us.SessionRepo.DB
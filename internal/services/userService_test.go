@@ -1,16 +1,21 @@
 package services_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/matryer/is"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/al-ce/goauth/internal/mailer"
 	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/repository"
 	"github.com/al-ce/goauth/internal/services"
 	"github.com/al-ce/goauth/internal/testutils"
@@ -95,6 +100,25 @@ func TestUserService_RegisterUser(t *testing.T) {
 		is.Equal(err, apperrors.ErrDuplicateEmail)
 	})
 
+	// Emails differing only in case identify the same user
+	t.Run("duplicate user with different case", func(t *testing.T) {
+		us := setupUserService(t)
+		err := us.RegisterUser("Bob@Example.com", testutils.TestingPassword)
+		is.NoErr(err)
+		err = us.RegisterUser("bob@example.COM", testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrDuplicateEmail)
+
+		// Login is case-insensitive too
+		_, err = us.LoginUser("BOB@EXAMPLE.COM", testutils.TestingPassword)
+		is.NoErr(err)
+
+		// The owner can change how their email is displayed
+		user, err := us.UserRepo.GetUserByEmail("bob@example.com")
+		is.NoErr(err)
+		err = us.UpdateUser(user.ID.String(), map[string]any{"email": "bob@example.com"})
+		is.NoErr(err)
+	})
+
 }
 
 func TestUserService_GetUserProfile(t *testing.T) {
@@ -179,11 +203,9 @@ func TestUserService_UpdateUser(t *testing.T) {
 			is.Equal(updatedUser.Email, "newUserName@test.com")
 		})
 		t.Run("updates password", func(t *testing.T) {
-			err := bcrypt.CompareHashAndPassword(
-				[]byte(updatedUser.Password),
-				[]byte("new"+testutils.TestingPassword),
-			)
+			match, _, err := passwords.Default().Verify("new"+testutils.TestingPassword, updatedUser.Password)
 			is.NoErr(err)
+			is.True(match)
 		})
 		t.Run("updates last_login", func(t *testing.T) {
 			is.Equal(updatedUser.LastLogin, &referenceTime)
@@ -209,6 +231,56 @@ func TestUserService_UpdateUser(t *testing.T) {
 		err = us.RegisterUser(email, testutils.TestingPassword)
 		is.Equal(err, apperrors.ErrDuplicateEmail)
 	})
+
+	// Password changes follow the password policy
+	t.Run("rejects password policy violations", func(t *testing.T) {
+		email := "testUpdatePolicy@test.com"
+		us := setupUserService(t)
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+
+		err = us.UpdateUser(user.ID.String(), map[string]any{"password": "short"})
+		is.True(errors.Is(err, apperrors.ErrPasswordTooShort))
+
+		err = us.UpdateUser(user.ID.String(), map[string]any{"password": "testUpdatePolicy" + testutils.TestingPassword})
+		is.True(errors.Is(err, apperrors.ErrPasswordContainsEmail))
+	})
+
+	// Recent passwords cannot be reused
+	t.Run("rejects recently used passwords", func(t *testing.T) {
+		email := "testUpdateHistory@test.com"
+		us := setupUserService(t)
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		userID := user.ID.String()
+
+		// Reusing the current password
+		err = us.UpdateUser(userID, map[string]any{"password": testutils.TestingPassword})
+		is.True(errors.Is(err, apperrors.ErrPasswordRecentlyUsed))
+
+		// Reusing a previous password
+		err = us.UpdateUser(userID, map[string]any{"password": "second" + testutils.TestingPassword})
+		is.NoErr(err)
+		err = us.UpdateUser(userID, map[string]any{"password": testutils.TestingPassword})
+		is.True(errors.Is(err, apperrors.ErrPasswordRecentlyUsed))
+
+		// History is pruned to the policy size
+		for _, prefix := range []string{"third", "fourth", "fifth", "sixth", "seventh"} {
+			err = us.UpdateUser(userID, map[string]any{"password": prefix + testutils.TestingPassword})
+			is.NoErr(err)
+		}
+		history, err := us.UserRepo.GetPasswordHistory(userID, 100)
+		is.NoErr(err)
+		is.Equal(len(history), passwords.ActivePolicy().HistorySize-1)
+		err = us.UpdateUser(userID, map[string]any{"password": testutils.TestingPassword})
+		is.NoErr(err)
+	})
 }
 
 // TestUserService_LoginUser tests that a user can be logged in, generating a
@@ -305,6 +377,223 @@ func TestUserService_LoginUser(t *testing.T) {
 		_, err = us.LoginUser(email, testutils.TestingPassword)
 		is.Equal(err, apperrors.ErrAccountIsLocked)
 	})
+
+	t.Run("upgrades outdated hash on login", func(t *testing.T) {
+		us := setupUserService(t)
+
+		// Register a user with a legacy bcrypt hash
+		bcryptHasher, err := passwords.NewBcryptHasher(4)
+		is.NoErr(err)
+		hash, err := bcryptHasher.Hash(testutils.TestingPassword)
+		is.NoErr(err)
+		user := &models.User{Email: email, Password: hash}
+		err = us.UserRepo.RegisterUser(user)
+		is.NoErr(err)
+
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		// Stored hash is now argon2id and still verifies
+		user, err = us.UserRepo.GetUserByID(user.ID.String())
+		is.NoErr(err)
+		is.True(strings.HasPrefix(user.Password, "$argon2id$"))
+		match, rehash, err := passwords.Default().Verify(testutils.TestingPassword, user.Password)
+		is.NoErr(err)
+		is.True(match)
+		is.True(!rehash)
+	})
+
+	t.Run("flags breached password on login", func(t *testing.T) {
+		us := setupUserService(t)
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		// The password shows up in a breach after registration
+		policy := passwords.DefaultPolicy()
+		policy.Breached = breachedSet{testutils.TestingPassword: true}
+		passwords.SetPolicy(policy)
+		t.Cleanup(func() { passwords.SetPolicy(nil) })
+
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		is.True(user.PasswordBreached)
+
+		// Changing the password clears the flag
+		err = us.UpdateUser(user.ID.String(), map[string]any{"password": "new" + testutils.TestingPassword})
+		is.NoErr(err)
+		profile, err := us.GetUserProfile(user.ID.String())
+		is.NoErr(err)
+		is.True(!profile.PasswordBreached)
+	})
+
+	t.Run("busy hashing is not a failed attempt", func(t *testing.T) {
+		us := setupUserService(t)
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		testutils.SaturateHashing(t)
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrHashingBusy)
+
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		is.Equal(user.FailedLoginAttempts, 0)
+	})
+}
+
+// BenchmarkUserService_LoginUser measures login throughput under parallel
+// load with hashing bounded by the pool configured from the environment.
+// Logins refused because the hashing queue was full are reported as
+// rejected/op.
+func BenchmarkUserService_LoginUser(b *testing.B) {
+	testDB := testutils.TestDBSetup()
+	ur, err := repository.NewUserRepository(testDB)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sr, err := repository.NewSessionRepository(testDB)
+	if err != nil {
+		b.Fatal(err)
+	}
+	us, err := services.NewUserService(ur, sr)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	email := "benchmarkUserServiceLoginUser@test.com"
+	if err := us.RegisterUser(email, testutils.TestingPassword); err != nil {
+		b.Fatal(err)
+	}
+	user, err := ur.GetUserByEmail(email)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { us.PermanentlyDeleteUser(user.ID.String()) })
+
+	for _, parallelism := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			var rejected atomic.Int64
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := us.LoginUser(email, testutils.TestingPassword)
+					if errors.Is(err, apperrors.ErrHashingBusy) {
+						rejected.Add(1)
+					} else if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.ReportMetric(float64(rejected.Load())/float64(b.N), "rejected/op")
+		})
+	}
+}
+
+// breachedSet is a BreachChecker over a fixed set of passwords
+type breachedSet map[string]bool
+
+func (b breachedSet) IsBreached(password string) (bool, error) { return b[password], nil }
+func (b breachedSet) Close() error                             { return nil }
+
+// TestUserService_EnumerationResistant tests that login and registration do
+// not reveal whether an account exists
+func TestUserService_EnumerationResistant(t *testing.T) {
+	is := is.New(t)
+
+	email := "testEnumeration@test.com"
+
+	setup := func(t *testing.T) (*services.UserService, chan mailer.Message) {
+		us := setupUserService(t)
+		us.EnumerationResistant = true
+		sent := make(chan mailer.Message, 10)
+		us.Mailer = recordingMailer(sent)
+		return us, sent
+	}
+
+	t.Run("login failures are identical", func(t *testing.T) {
+		us, _ := setup(t)
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		_, err = us.LoginUser("doesNotExist@test.com", testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrInvalidLogin)
+		_, err = us.LoginUser(email, "wrong"+testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrInvalidLogin)
+
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		err = us.UserRepo.LockAccount(user.ID.String())
+		is.NoErr(err)
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrInvalidLogin)
+	})
+
+	t.Run("unknown users take as long as wrong passwords", func(t *testing.T) {
+		us, _ := setup(t)
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		// Stay under the lockout so every round checks the real hash
+		const rounds = 3
+		measure := func(email string) time.Duration {
+			start := time.Now()
+			for range rounds {
+				us.LoginUser(email, "wrong"+testutils.TestingPassword)
+			}
+			return time.Since(start) / rounds
+		}
+		known := measure(email)
+		unknown := measure("doesNotExist@test.com")
+
+		ratio := float64(unknown) / float64(known)
+		if ratio < 0.5 || ratio > 2 {
+			t.Fatalf("unknown user login took %v, wrong password %v", unknown, known)
+		}
+	})
+
+	t.Run("registration notifies the existing owner", func(t *testing.T) {
+		us, sent := setup(t)
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		welcome := receiveMail(t, sent)
+		is.Equal(welcome.To, email)
+
+		// A second registration succeeds from the caller's point of view
+		err = us.RegisterUser(strings.ToUpper(email), "another"+testutils.TestingPassword)
+		is.NoErr(err)
+		notice := receiveMail(t, sent)
+		is.Equal(notice.To, email)
+		is.True(notice.Subject != welcome.Subject)
+
+		// The existing account is unchanged
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+	})
+}
+
+// recordingMailer sends every message to a channel
+type recordingMailer chan mailer.Message
+
+func (m recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// receiveMail waits for the next message sent in the background
+func receiveMail(t *testing.T, sent chan mailer.Message) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+		return mailer.Message{}
+	}
 }
 
 // TestUserService_Logout checks that a token is no longer valid after Logout is called
@@ -329,15 +618,12 @@ func TestUserService_Logout(t *testing.T) {
 		// Logout a user
 		err = us.Logout(token)
 
-		// Get session ID from token
-		parts := strings.Split(token, ".")
-		is.True(len(parts) == 2)
-		sessionID := parts[0]
-		parsedID, err := uuid.Parse(sessionID)
+		// Get session token hash from token
+		tokenHash, err := models.ParseSessionToken(token)
 		is.NoErr(err)
 
 		// Check that corresponding session no longer exists in database
-		session, err := us.SessionRepo.GetUnexpiredSessionByID(parsedID)
+		session, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
 		is.Equal(session, nil)
 		is.Equal(err, gorm.ErrRecordNotFound)
 	})
@@ -375,20 +661,122 @@ func TestUserService_LogoutEverywhere(t *testing.T) {
 
 		// Check that corresponding session no longer exists in database
 		for _, token := range tokens {
-			// Get session ID from token
-			parts := strings.Split(token, ".")
-			is.True(len(parts) == 2)
-			sessionID := parts[0]
-			parsedID, err := uuid.Parse(sessionID)
+			// Get session token hash from token
+			tokenHash, err := models.ParseSessionToken(token)
 			is.NoErr(err)
 			// Confirm session is gone
-			session, err := us.SessionRepo.GetUnexpiredSessionByID(parsedID)
+			session, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
 			is.Equal(session, nil)
 			is.Equal(err, gorm.ErrRecordNotFound)
 		}
 	})
 }
 
+// TestUserService_Sessions tests listing and revoking sessions by public ID
+func TestUserService_Sessions(t *testing.T) {
+	is := is.New(t)
+
+	us := setupUserService(t)
+	email := "testUserServiceSessions@test.com"
+	err := us.RegisterUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	user, err := us.UserRepo.GetUserByEmail(email)
+	is.NoErr(err)
+	token, err := us.LoginUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	_, err = us.LoginUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+
+	tokenHash, err := models.ParseSessionToken(token)
+	is.NoErr(err)
+	current, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+	is.NoErr(err)
+
+	t.Run("lists sessions and marks the current one", func(t *testing.T) {
+		sessions, err := us.ListSessions(user.ID.String(), current.ID)
+		is.NoErr(err)
+		is.Equal(len(sessions), 2)
+		for _, session := range sessions {
+			is.Equal(session.Current, session.ID == current.ID)
+		}
+	})
+
+	t.Run("revokes a session", func(t *testing.T) {
+		err := us.RevokeSession(user.ID.String(), current.ID.String())
+		is.NoErr(err)
+		_, err = us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+		is.Equal(err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("unknown or other user's session", func(t *testing.T) {
+		err := us.RevokeSession(user.ID.String(), "not-a-uuid")
+		is.Equal(err, apperrors.ErrSessionNotFound)
+		err = us.RevokeSession(uuid.NewString(), current.ID.String())
+		is.Equal(err, apperrors.ErrSessionNotFound)
+	})
+}
+
+// TestUserService_Reauthenticate tests that reauthenticating checks the
+// password under the lockout rules and refreshes the session's auth time
+func TestUserService_Reauthenticate(t *testing.T) {
+	is := is.New(t)
+
+	us := setupUserService(t)
+	email := "testUserServiceReauthenticate@test.com"
+	err := us.RegisterUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	user, err := us.UserRepo.GetUserByEmail(email)
+	is.NoErr(err)
+	userID := user.ID.String()
+	token, err := us.LoginUser(email, testutils.TestingPassword)
+	is.NoErr(err)
+	tokenHash, err := models.ParseSessionToken(token)
+	is.NoErr(err)
+	session, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(tokenHash)
+	is.NoErr(err)
+
+	// Age the session's authentication
+	stale := time.Now().UTC().Add(-time.Hour)
+	is.NoErr(us.SessionRepo.UpdateSessionAuthTime(session.ID, stale))
+
+	t.Run("wrong password counts as a failed attempt", func(t *testing.T) {
+		err := us.Reauthenticate(userID, session.ID, "wrong"+testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrInvalidLogin)
+		updated, err := us.UserRepo.GetUserByID(userID)
+		is.NoErr(err)
+		is.Equal(updated.FailedLoginAttempts, user.FailedLoginAttempts+1)
+
+		unchanged, err := us.SessionRepo.GetUnexpiredSessionByID(session.ID)
+		is.NoErr(err)
+		is.True(unchanged.AuthTime.Before(time.Now().UTC().Add(-time.Minute)))
+	})
+
+	t.Run("correct password refreshes the auth time", func(t *testing.T) {
+		err := us.Reauthenticate(userID, session.ID, testutils.TestingPassword)
+		is.NoErr(err)
+		updated, err := us.SessionRepo.GetUnexpiredSessionByID(session.ID)
+		is.NoErr(err)
+		is.True(time.Since(updated.AuthTime) < time.Minute)
+	})
+
+	t.Run("rotation keeps the auth time", func(t *testing.T) {
+		is.NoErr(us.SessionRepo.UpdateSessionAuthTime(session.ID, stale))
+		rotatedToken, err := us.RotateSession(session.ID)
+		is.NoErr(err)
+		rotatedHash, err := models.ParseSessionToken(rotatedToken)
+		is.NoErr(err)
+		rotated, err := us.SessionRepo.GetUnexpiredSessionByTokenHash(rotatedHash)
+		is.NoErr(err)
+		is.True(rotated.AuthTime.Sub(stale).Abs() < time.Millisecond)
+	})
+
+	t.Run("locked account", func(t *testing.T) {
+		is.NoErr(us.UserRepo.LockAccount(userID))
+		err := us.Reauthenticate(userID, session.ID, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrAccountIsLocked)
+	})
+}
+
 func TestUserService_PermanentlyDeleteUser(t *testing.T) {
 	is := is.New(t)
 
@@ -423,6 +811,109 @@ func TestUserService_PermanentlyDeleteUser(t *testing.T) {
 	})
 }
 
+// TestUserService_RequestAccountDeletion tests that a deleted account is
+// locked out during the grace period and can be restored with the emailed token
+func TestUserService_RequestAccountDeletion(t *testing.T) {
+	is := is.New(t)
+
+	email := "testRequestAccountDeletion@test.com"
+
+	setup := func(t *testing.T) (*services.UserService, string, mailer.Message) {
+		us := setupUserService(t)
+		sent := make(chan mailer.Message, 10)
+		us.Mailer = recordingMailer(sent)
+		us.RestoreURL = "https://app.test/restore"
+
+		err := us.RegisterUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByEmail(email)
+		is.NoErr(err)
+		userID := user.ID.String()
+
+		purgeAfter, err := us.RequestAccountDeletion(userID)
+		is.NoErr(err)
+		is.True(purgeAfter.Sub(time.Now().UTC()) > us.DeletionGracePeriod-time.Minute)
+		return us, userID, receiveMail(t, sent)
+	}
+
+	// restoreToken reads the token from the link in the deletion email
+	restoreToken := func(msg mailer.Message) string {
+		_, link, found := strings.Cut(msg.Body, "https://app.test/restore?token=")
+		is.True(found)
+		token, _, _ := strings.Cut(link, "\n")
+		return token
+	}
+
+	t.Run("pending account is locked out", func(t *testing.T) {
+		us, userID, msg := setup(t)
+		is.Equal(msg.To, email)
+
+		sessions, err := us.SessionRepo.GetUnexpiredSessionsByUserID(userID)
+		is.NoErr(err)
+		is.Equal(len(sessions), 0)
+
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrAccountPendingDeletion)
+		// The pending state is not revealed without the password
+		_, err = us.LoginUser(email, "wrong"+testutils.TestingPassword)
+		is.Equal(err, apperrors.ErrInvalidLogin)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		us, userID, msg := setup(t)
+
+		err := us.RestoreAccount(restoreToken(msg))
+		is.NoErr(err)
+		user, err := us.UserRepo.GetUserByID(userID)
+		is.NoErr(err)
+		is.True(!user.PendingDeletion())
+		_, err = us.LoginUser(email, testutils.TestingPassword)
+		is.NoErr(err)
+
+		// The token is single use
+		err = us.RestoreAccount(restoreToken(msg))
+		is.Equal(err, apperrors.ErrRestoreTokenInvalid)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		us, _, _ := setup(t)
+		is.Equal(us.RestoreAccount(""), apperrors.ErrRestoreTokenInvalid)
+		is.Equal(us.RestoreAccount("not-a-token"), apperrors.ErrRestoreTokenInvalid)
+	})
+
+	t.Run("grace period ended", func(t *testing.T) {
+		us, userID, msg := setup(t)
+		err := us.UserRepo.DB.Model(&models.User{}).Where("id = ?", userID).
+			Update("purge_after", time.Now().UTC().Add(-time.Minute)).Error
+		is.NoErr(err)
+
+		err = us.RestoreAccount(restoreToken(msg))
+		is.Equal(err, apperrors.ErrRestoreTokenInvalid)
+	})
+}
+
+// TestDeletionGracePeriodFromEnv tests reading the grace period in days
+func TestDeletionGracePeriodFromEnv(t *testing.T) {
+	is := is.New(t)
+
+	period, err := services.DeletionGracePeriodFromEnv()
+	is.NoErr(err)
+	is.Equal(period, config.DefaultAccountDeletionGraceDays*24*time.Hour)
+
+	t.Setenv(config.AccountDeletionGraceDays, "7")
+	period, err = services.DeletionGracePeriodFromEnv()
+	is.NoErr(err)
+	is.Equal(period, 7*24*time.Hour)
+
+	for _, val := range []string{"-1", "2w", "1.5"} {
+		t.Setenv(config.AccountDeletionGraceDays, val)
+		_, err = services.DeletionGracePeriodFromEnv()
+		is.True(errors.Is(err, apperrors.ErrDeletionGraceConfig))
+	}
+}
+
 func setupUserService(t *testing.T) *services.UserService {
 	t.Helper()
 
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+const (
+	// webhookURLMaxLength is the longest URL a webhook subscription can have
+	webhookURLMaxLength = 2048
+	// webhookDescriptionMaxLength is the longest description a webhook
+	// subscription can have
+	webhookDescriptionMaxLength = 100
+	// webhookDeliveriesLimit is the number of deliveries listed per
+	// subscription
+	webhookDeliveriesLimit = 100
+	// webhookLastErrorMaxLength bounds the error stored for a failed attempt
+	webhookLastErrorMaxLength = 500
+	// webhookResponseMaxBytes is how much of a response body is read, so the
+	// connection can be reused
+	webhookResponseMaxBytes = 64 * 1024
+)
+
+// CreatedWebhook is a new webhook subscription. Secret is only available
+// here.
+type CreatedWebhook struct {
+	Secret string
+	Info   models.WebhookSubscriptionInfo
+}
+
+// WebhookService manages webhook subscriptions for admins and sends the
+// deliveries of the events in the outbox to them
+type WebhookService struct {
+	WebhookRepo *repository.WebhookRepository
+	AuditRepo   *repository.AuditRepository
+	// Client sends webhook requests. It does not follow redirects.
+	Client *http.Client
+	// Retention is how long finished deliveries and their events are kept,
+	// from `WEBHOOK_RETENTION_DAYS`. Zero keeps them forever.
+	Retention time.Duration
+}
+
+// NewWebhookService returns a value of type WebhookService
+func NewWebhookService(wr *repository.WebhookRepository, ar *repository.AuditRepository) (*WebhookService, error) {
+	if wr == nil {
+		return nil, apperrors.ErrWebhookRepoIsNil
+	}
+	if ar == nil {
+		return nil, apperrors.ErrAuditRepoIsNil
+	}
+	retention, err := WebhookRetentionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookService{
+		WebhookRepo: wr,
+		AuditRepo:   ar,
+		Retention:   retention,
+		Client: &http.Client{
+			Timeout: config.WebhookDeliveryTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// CreateWebhook subscribes an endpoint to event types for an admin. The
+// secret that signs its payloads is returned once.
+func (ws *WebhookService) CreateWebhook(createdBy, endpoint string, events []string, description string) (*CreatedWebhook, error) {
+	creator, err := uuid.Parse(createdBy)
+	if err != nil {
+		return nil, apperrors.ErrUserIdEmpty
+	}
+	endpoint = strings.TrimSpace(endpoint)
+	if err := ValidateWebhookURL(endpoint); err != nil {
+		return nil, err
+	}
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > webhookDescriptionMaxLength {
+		return nil, apperrors.ErrWebhookDescription
+	}
+
+	secret, err := models.GenerateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	subscription, err := models.NewWebhookSubscription(endpoint, events, secret, description, creator)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.WebhookRepo.CreateWebhookSubscription(subscription); err != nil {
+		return nil, err
+	}
+
+	ws.recordEvent(models.AuditWebhookCreated, creator, subscription)
+	return &CreatedWebhook{Secret: secret, Info: subscription.Info()}, nil
+}
+
+// ListWebhooks returns every webhook subscription, newest first
+func (ws *WebhookService) ListWebhooks() ([]models.WebhookSubscriptionInfo, error) {
+	subscriptions, err := ws.WebhookRepo.GetWebhookSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]models.WebhookSubscriptionInfo, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		infos = append(infos, subscription.Info())
+	}
+	return infos, nil
+}
+
+// DeleteWebhook deletes a webhook subscription and its deliveries for an
+// admin
+func (ws *WebhookService) DeleteWebhook(actor, id string) error {
+	subscription, err := ws.getWebhook(id)
+	if err != nil {
+		return err
+	}
+	err = ws.WebhookRepo.DeleteWebhookSubscriptionByID(subscription.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperrors.ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if admin, err := uuid.Parse(actor); err == nil {
+		ws.recordEvent(models.AuditWebhookDeleted, admin, subscription)
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries to a webhook subscription,
+// newest first. A non-empty `status` only returns deliveries with it.
+func (ws *WebhookService) ListDeliveries(id, status string) ([]models.WebhookDeliveryInfo, error) {
+	switch status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliveryDelivered, models.WebhookDeliveryDead:
+	default:
+		return nil, apperrors.ErrWebhookDeliveryStatus
+	}
+	subscription, err := ws.getWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := ws.WebhookRepo.GetWebhookDeliveries(subscription.ID, status, webhookDeliveriesLimit)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]models.WebhookDeliveryInfo, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		infos = append(infos, delivery.Info())
+	}
+	return infos, nil
+}
+
+// Redeliver queues a dead or delivered delivery to a webhook subscription to
+// be sent again right away, with a fresh set of attempts. The receiver gets
+// the same delivery ID and payload.
+func (ws *WebhookService) Redeliver(id, deliveryID string) (*models.WebhookDeliveryInfo, error) {
+	subscription, err := ws.getWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	parsedID, err := uuid.Parse(deliveryID)
+	if err != nil {
+		return nil, apperrors.ErrWebhookDeliveryNotFound
+	}
+	delivery, err := ws.WebhookRepo.GetWebhookDeliveryByID(parsedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && delivery.SubscriptionID != subscription.ID) {
+		return nil, apperrors.ErrWebhookDeliveryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if delivery.Status == models.WebhookDeliveryPending {
+		return nil, apperrors.ErrWebhookDeliveryPending
+	}
+
+	now := time.Now().UTC()
+	err = ws.WebhookRepo.ResetWebhookDelivery(delivery.ID, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Redelivered or deleted since it was looked up
+		return nil, apperrors.ErrWebhookDeliveryPending
+	} else if err != nil {
+		return nil, err
+	}
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.LastError = ""
+	delivery.DeliveredAt = nil
+
+	info := delivery.Info()
+	return &info, nil
+}
+
+// DispatchPending sends up to `limit` deliveries that are due and returns
+// how many were attempted. Failed deliveries are retried with exponential
+// backoff until config.WebhookMaxAttempts, after which they are dead.
+func (ws *WebhookService) DispatchPending(ctx context.Context, limit int) (int, error) {
+	// Deliveries are sent one at a time, so the claim covers the whole batch
+	lease := time.Duration(limit)*config.WebhookDeliveryTimeout + time.Minute
+	deliveries, err := ws.WebhookRepo.ClaimDueWebhookDeliveries(time.Now().UTC(), limit, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		statusCode, err := ws.send(ctx, delivery)
+		if ctx.Err() != nil {
+			// Shutting down: the claim expires and the delivery is sent
+			// again without counting this attempt
+			return attempted, ctx.Err()
+		}
+		attempted++
+
+		attemptAt := time.Now().UTC()
+		delivery.Attempts++
+		delivery.LastAttemptAt = &attemptAt
+		delivery.LastStatusCode = statusCode
+		if err == nil {
+			delivery.Status = models.WebhookDeliveryDelivered
+			delivery.DeliveredAt = &attemptAt
+			delivery.LastError = ""
+		} else {
+			delivery.LastError = truncate(err.Error(), webhookLastErrorMaxLength)
+			if delivery.Attempts >= config.WebhookMaxAttempts {
+				delivery.Status = models.WebhookDeliveryDead
+			} else {
+				delivery.NextAttemptAt = attemptAt.Add(WebhookRetryDelay(delivery.Attempts))
+			}
+			log.Warn().
+				Str("deliveryID", delivery.ID.String()).
+				Str("subscriptionID", delivery.SubscriptionID.String()).
+				Int("attempts", delivery.Attempts).
+				Str("status", delivery.Status).
+				Str("error", delivery.LastError).
+				Msg("Webhook delivery failed")
+		}
+		if err := ws.WebhookRepo.UpdateWebhookDeliveryAttempt(delivery); err != nil {
+			return attempted, err
+		}
+	}
+	return attempted, nil
+}
+
+// send posts a delivery's payload to its subscription's URL, signed with the
+// subscription's secret, and returns the response status. Only 2xx responses
+// count as delivered.
+func (ws *WebhookService) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	if delivery.Event == nil || delivery.Subscription == nil {
+		return 0, apperrors.ErrWebhookDeliveryNotFound
+	}
+	payload := []byte(delivery.Event.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Subscription.URL, strings.NewReader(delivery.Event.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goauth-webhooks")
+	req.Header.Set(config.WebhookEventHeader, delivery.Event.Type)
+	req.Header.Set(config.WebhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(config.WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(config.WebhookSignatureHeader, SignWebhookPayload(delivery.Subscription.Secret, timestamp, payload))
+
+	resp, err := ws.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseMaxBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w: %s", apperrors.ErrWebhookStatus, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// getWebhook looks up a webhook subscription by its ID from a URL
+func (ws *WebhookService) getWebhook(id string) (*models.WebhookSubscription, error) {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, apperrors.ErrWebhookNotFound
+	}
+	subscription, err := ws.WebhookRepo.GetWebhookSubscriptionByID(parsedID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperrors.ErrWebhookNotFound
+	}
+	return subscription, err
+}
+
+// recordEvent records an admin's change to a webhook subscription in the
+// audit log
+func (ws *WebhookService) recordEvent(eventType string, adminID uuid.UUID, subscription *models.WebhookSubscription) {
+	details := fmt.Sprintf("webhook %s to %s for %s", subscription.ID, subscription.URL, subscription.Events)
+	if err := ws.AuditRepo.CreateAuditEvent(models.NewAuditEvent(eventType, adminID, details)); err != nil {
+		log.Warn().
+			Str("userID", adminID.String()).
+			Str("error", err.Error()).
+			Msg("Could not record webhook event")
+	}
+}
+
+// PurgeFinished deletes the delivered and dead deliveries last attempted
+// more than Retention before `now`, and the events left without deliveries.
+// It returns the number of deliveries and events deleted.
+func (ws *WebhookService) PurgeFinished(now time.Time) (int64, int64, error) {
+	if ws.Retention == 0 {
+		return 0, 0, nil
+	}
+	return ws.WebhookRepo.DeleteFinishedWebhookDeliveries(now.Add(-ws.Retention))
+}
+
+// WebhookRetentionFromEnv reads the retention of finished webhook deliveries
+// from `WEBHOOK_RETENTION_DAYS`
+func WebhookRetentionFromEnv() (time.Duration, error) {
+	days := config.DefaultWebhookRetentionDays
+	if val := os.Getenv(config.WebhookRetentionDays); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%w: %q", apperrors.ErrWebhookRetentionConfig, val)
+		}
+		days = n
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// SignWebhookPayload returns the signature header of a webhook request:
+// `sha256=` followed by the hex HMAC-SHA256 of `<timestamp>.<payload>` keyed
+// with the subscription's secret. Receivers compute the same value with the
+// timestamp header and raw body, and should refuse old timestamps.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookRetryDelay returns how long to wait before the next attempt of a
+// delivery that has failed `attempts` times
+func WebhookRetryDelay(attempts int) time.Duration {
+	delay := config.WebhookRetryBaseDelay
+	for i := 1; i < attempts && delay < config.WebhookRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, config.WebhookRetryMaxDelay)
+}
+
+// ValidateWebhookURL checks that a webhook URL is an absolute http(s) URL
+func ValidateWebhookURL(endpoint string) error {
+	if endpoint == "" || len(endpoint) > webhookURLMaxLength {
+		return apperrors.ErrWebhookURL
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return apperrors.ErrWebhookURL
+	}
+	return nil
+}
+
+// truncate shortens `s` to at most `n` bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +15,7 @@ import (
 	"gorm.io/gorm/logger"
 
 	"github.com/al-ce/goauth/internal/database"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/pkg/config"
 )
 
@@ -63,3 +65,32 @@ func TestDBSetup() *gorm.DB {
 	}
 	return db
 }
+
+// SaturateHashing replaces the default password Manager with one whose pool
+// is full, so every hash or verification fails with ErrHashingBusy until the
+// test ends
+func SaturateHashing(t testing.TB) {
+	t.Helper()
+	manager, err := passwords.NewManagerFromEnv()
+	if err != nil {
+		t.Fatalf("failed to create password manager: %v", err)
+	}
+	manager.Pool, err = passwords.NewPool(1, 0)
+	if err != nil {
+		t.Fatalf("failed to create hashing pool: %v", err)
+	}
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	go manager.Pool.Do(func() {
+		close(running)
+		<-release
+	})
+	<-running
+
+	passwords.SetDefault(manager)
+	t.Cleanup(func() {
+		close(release)
+		passwords.SetDefault(nil)
+	})
+}
@@ -10,27 +10,40 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
-	passwordvalidator "github.com/wagslane/go-password-validator"
 	"gorm.io/gorm"
 
 	_ "github.com/al-ce/goauth/docs"
+	"github.com/al-ce/goauth/internal/cli"
+	"github.com/al-ce/goauth/internal/cookies"
 	"github.com/al-ce/goauth/internal/database"
 	"github.com/al-ce/goauth/internal/jobs"
+	"github.com/al-ce/goauth/internal/keyring"
+	"github.com/al-ce/goauth/internal/passwords"
 	"github.com/al-ce/goauth/internal/server"
 	"github.com/al-ce/goauth/pkg/config"
 	"github.com/al-ce/goauth/pkg/logger"
 )
 
 // main is the entry point for the auth service. It sets up the logger,
-// connects to the database, starts the API server, and start any background jobs
+// connects to the database, starts the API server, and start any background jobs.
+// Any arguments other than `serve` are run as a subcommand instead, see `internal/cli`.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	checkSessionKey()
 
 	logger.SetupLogger()
 
+	configurePasswordHashing()
+
+	configureCookies()
+
 	db := connectDB()
 
-	startAPIServer(db)
+	apiServer := startAPIServer(db)
 
 	quit := makeQuitListener()
 
@@ -39,6 +52,7 @@ func main() {
 	// Block until quit signal
 	<-quit
 	log.Info().Msg("Server is shutting down...")
+	apiServer.Shutdown()
 
 	// Close context done channel, signaling jobs in waitgroup to finish
 	cancel()
@@ -47,11 +61,53 @@ func main() {
 	log.Info().Msg("Server exited")
 }
 
-// Ensure session key is complex enough for encryption
+// Run a CLI subcommand and exit
+func runCommand(args []string) {
+	logger.SetupLogger()
+	// `config check` reports invalid settings itself instead of exiting on the first one
+	if args[0] != "config" {
+		configurePasswordHashing()
+	}
+	if err := cli.Run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Load the session keyring and ensure every key is complex enough for signing
 func checkSessionKey() {
-	if err := passwordvalidator.Validate(os.Getenv(config.SessionKey), config.MinEntropyBits); err != nil {
+	ring, err := keyring.NewFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not load session keys")
+	}
+	if err := ring.Validate(); err != nil {
 		log.Fatal().Err(err).Msg("Session secret is not complex enough")
 	}
+	keyring.SetDefault(ring)
+}
+
+// Build the password hasher and policy from the environment, refusing to start on invalid parameters
+func configurePasswordHashing() {
+	manager, err := passwords.NewManagerFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid password hashing configuration")
+	}
+	passwords.SetDefault(manager)
+
+	policy, err := passwords.NewPolicyFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid password policy configuration")
+	}
+	passwords.SetPolicy(policy)
+}
+
+// Build the cookie settings from the environment, refusing to start on invalid settings
+func configureCookies() {
+	manager, err := cookies.NewManagerFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid cookie configuration")
+	}
+	cookies.SetDefault(manager)
 }
 
 // Connect and migrate DB
@@ -69,7 +125,7 @@ func connectDB() *gorm.DB {
 }
 
 // Start API Server
-func startAPIServer(db *gorm.DB) {
+func startAPIServer(db *gorm.DB) *server.APIServer {
 	log.Info().
 		Str(config.AuthServerPort, os.Getenv(config.AuthServerPort)).
 		Msg("Starting server")
@@ -78,6 +134,7 @@ func startAPIServer(db *gorm.DB) {
 		log.Fatal().Err(err).Msg("Error initializing server")
 	}
 	go apiServer.Run()
+	return apiServer
 }
 
 
@@ -85,7 +142,7 @@ func startAPIServer(db *gorm.DB) {
 func startJobs(db *gorm.DB) (*sync.WaitGroup, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
-	go jobs.StartJobs(ctx, &wg, db)
+	go jobs.StartJobs(ctx, &wg, db, nil)
 	return &wg, cancel
 }
 
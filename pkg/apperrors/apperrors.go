@@ -8,10 +8,13 @@ var New = errors.New
 
 var (
 	// Authentication errors
-	ErrAccountIsLocked     = New("Account is locked")
-	ErrInvalidLogin        = New("Invalid login credentials")
-	ErrInvalidTokenFormat  = New("Invalid token format")
-	ErrSessionIDGeneration = New("Could not generate token")
+	ErrAccountIsLocked         = New("Account is locked")
+	ErrInvalidLogin            = New("Invalid login credentials")
+	ErrInvalidTokenFormat      = New("Invalid token format")
+	ErrInvalidTokenSignature   = New("Invalid token signature")
+	ErrSessionIDGeneration     = New("Could not generate token")
+	ErrRecentAuthRequired      = New("Please confirm your password to continue")
+	ErrCurrentPasswordRequired = New("Current password is required to change the password")
 
 	// User registration errors
 	ErrDuplicateEmail     = New("User already registered with this email")
@@ -20,27 +23,160 @@ var (
 	ErrEmailFormat        = New("Email format is invalid")
 	ErrPasswordComplexity = New("Please use a more complex password! https://xkcd.com/936")
 
+	// Password policy errors
+	ErrPasswordPolicy        = New("Password does not meet the password policy")
+	ErrPasswordPolicyConfig  = New("Password policy settings are invalid")
+	ErrPasswordTooShort      = New("Password is shorter than the minimum length")
+	ErrPasswordExceedsMax    = New("Password exceeds the maximum length")
+	ErrPasswordCommon        = New("Password is too common")
+	ErrPasswordContainsEmail = New("Password must not contain the email address")
+	ErrPasswordRecentlyUsed  = New("Password was used recently")
+	ErrPasswordBreached      = New("Password has appeared in a data breach")
+
+	// Breached password dataset errors
+	ErrBreachFormat   = New("Breached password dataset is malformed")
+	ErrBreachUnsorted = New("Breached password input must be ordered by hash")
+
+	// Password hashing errors
+	ErrHashAlgorithmUnknown = New("Unknown password hash algorithm")
+	ErrHashFormatUnknown    = New("Password hash format is not recognized")
+	ErrHashMalformed        = New("Password hash is malformed")
+	ErrHashParams           = New("Password hash parameters are invalid")
+	ErrPasswordTooLong      = New("Password exceeds the 72 byte bcrypt limit")
+	ErrHashingBusy          = New("Server is busy, try again shortly")
+
+	// Session keyring errors
+	ErrKeyringConfig = New("Session keyring settings are invalid")
+	ErrKeyNotFound   = New("Session key not found")
+
+	// User import errors
+	ErrImportFormat        = New("Import format must be jsonl or csv")
+	ErrImportMissingColumn = New("CSV header must include email and password_hash columns")
+	ErrImportDuplicateRow  = New("Email appears earlier in the import")
+	ErrImportTooLarge      = New("Import exceeds the maximum upload size")
+
+	// Account deletion errors
+	ErrAccountPendingDeletion = New("Account is scheduled for deletion")
+	ErrRestoreTokenInvalid    = New("Restore token is invalid or has expired")
+	ErrDeletionGraceConfig    = New("Account deletion grace period must be a whole number of days")
+
+	// Magic link errors
+	ErrMagicLinkInvalid = New("Magic link is invalid, has expired or was requested from another browser")
+
+	// Data export errors
+	ErrExportFormat   = New("Export format must be json or zip")
+	ErrExportNotFound = New("Export not found or its link has expired")
+	ErrExportNotReady = New("Export is still being generated")
+	ErrExportFailed   = New("Export could not be generated")
+
+	// Authorization errors
+	ErrAdminRequired    = New("Admin role required")
+	ErrNotAuthenticated = New("Request is not authenticated")
+
+	// CSRF errors
+	ErrCSRFTokenInvalid = New("Missing or invalid CSRF token")
+	ErrCSRFOrigin       = New("Request origin is not allowed")
+	ErrCSRFConfig       = New("Allowed origins must be scheme://host[:port] URLs")
+
+	// Cookie errors
+	ErrCookieConfig = New("Cookie settings are invalid")
+
+	// Forward auth errors
+	ErrForwardAuthConfig = New("Forward auth login URL must be an absolute http(s) URL")
+
+	// Introspection errors
+	ErrIntrospectionConfig = New("Introspection settings are invalid")
+	ErrIntrospectionClient = New("Invalid introspection client credentials")
+	ErrIntrospectionFailed = New("Introspection request failed")
+
+	// Personal access token errors
+	ErrAccessTokenNotFound = New("Access token not found")
+	ErrAccessTokenName     = New("Access token name must be 1 to 100 characters")
+	ErrAccessTokenScope    = New("Unknown access token scope")
+	ErrAccessTokenNoScopes = New("Access token needs at least one scope")
+	ErrAccessTokenAdmin    = New("Only admins can create tokens with the admin scope")
+	ErrAccessTokenExpiry   = New("Access token expiry must be a whole number of days from 1 to 366")
+	ErrAccessTokenLimit    = New("Too many access tokens, revoke one to create another")
+	ErrInsufficientScope   = New("Access token does not have the scope for this route")
+
+	// Service account errors
+	ErrServiceAccountNotFound  = New("Service account not found")
+	ErrServiceAccountName      = New("Service account name must be 1 to 100 characters")
+	ErrServiceAccountScope     = New("Service accounts can only be granted the profile and admin scopes")
+	ErrServiceAccountNoScopes  = New("Service account needs at least one scope")
+	ErrServiceAccountPublicKey = New("Public key must be a PEM encoded RSA key of at least 2048 bits, P-256 key or Ed25519 key")
+	ErrServiceAccountNoSecret  = New("Service account authenticates with a public key and has no secret")
+	ErrUserRequired            = New("This route is for users, not service accounts")
+
+	// Token endpoint errors
+	ErrInvalidClient         = New("Invalid client credentials")
+	ErrInvalidTokenRequest   = New("Token request is malformed")
+	ErrUnsupportedGrantType  = New("Only the client_credentials grant type is supported")
+	ErrInvalidScope          = New("Requested scope was not granted to the service account")
+	ErrClientAssertion       = New("Client assertion is invalid")
+	ErrClientAssertionReplay = New("Client assertion was already used")
+	ErrPrivateKeyJWTDisabled = New("private_key_jwt needs TOKEN_ENDPOINT_URL to be set")
+	ErrTokenEndpointConfig   = New("Token endpoint URL must be an absolute http(s) URL")
+	ErrIssuingToken          = New("Could not issue an access token")
+
+	// Webhook errors
+	ErrWebhookNotFound         = New("Webhook subscription not found")
+	ErrWebhookDeliveryNotFound = New("Webhook delivery not found")
+	ErrWebhookDeliveryPending  = New("Webhook delivery is already pending")
+	ErrWebhookDeliveryStatus   = New("Webhook delivery status must be pending, delivered or dead")
+	ErrWebhookURL              = New("Webhook URL must be an absolute http(s) URL of at most 2048 characters")
+	ErrWebhookEvent            = New("Unknown webhook event type")
+	ErrWebhookNoEvents         = New("Webhook subscription needs at least one event type")
+	ErrWebhookDescription      = New("Webhook description must be at most 100 characters")
+	ErrWebhookStatus           = New("Webhook endpoint did not respond with a 2xx status")
+	ErrWebhookRetentionConfig  = New("Webhook retention must be a whole number of days")
+
+	// User hook errors
+	ErrHookVeto    = New("Request denied")
+	ErrHookFailed  = New("Could not complete the request")
+	ErrHookTimeout = New("User hook timed out")
+
 	// Session errors
 	ErrSessionAlreadyExists = New("Session already exists")
+	ErrSessionNotFound      = New("Session not found")
 
 	// Nil reference argument errors
-	ErrDatabaseIsNil     = New("Database is nil")
-	ErrSessionIsNil      = New("Session is nil")
-	ErrUserIsNil         = New("User is nil")
-	ErrSessionRepoIsNil  = New("Session repo is nil")
-	ErrUserRepoIsNil     = New("UserRepo is nil")
-	ErrUserServiceIsNil  = New("UserService is nil")
-	ErrUserHandlerIsNil  = New("UserHandler is nil")
-	ErrRepoProviderIsNil = New("RepoProvider is nil")
+	ErrDatabaseIsNil              = New("Database is nil")
+	ErrSessionIsNil               = New("Session is nil")
+	ErrUserIsNil                  = New("User is nil")
+	ErrSessionRepoIsNil           = New("Session repo is nil")
+	ErrUserRepoIsNil              = New("UserRepo is nil")
+	ErrUserServiceIsNil           = New("UserService is nil")
+	ErrImportServiceIsNil         = New("ImportService is nil")
+	ErrExportServiceIsNil         = New("ExportService is nil")
+	ErrIntrospectionServiceIsNil  = New("IntrospectionService is nil")
+	ErrAuditRepoIsNil             = New("AuditRepo is nil")
+	ErrAccessTokenRepoIsNil       = New("AccessTokenRepo is nil")
+	ErrAccessTokenServiceIsNil    = New("AccessTokenService is nil")
+	ErrServiceAccountRepoIsNil    = New("ServiceAccountRepo is nil")
+	ErrServiceAccountServiceIsNil = New("ServiceAccountService is nil")
+	ErrWebhookRepoIsNil           = New("WebhookRepo is nil")
+	ErrWebhookServiceIsNil        = New("WebhookService is nil")
+	ErrExportRepoIsNil            = New("ExportRepo is nil")
+	ErrUserHandlerIsNil           = New("UserHandler is nil")
+	ErrAuthMiddlewareIsNil        = New("AuthMiddleware is nil")
+	ErrRepoProviderIsNil          = New("RepoProvider is nil")
 
 	// Empty string argument errors
-	ErrExpiresAtIsEmpty = New("Expiration time is empty")
-	ErrPasswordIsEmpty  = New("Password is empty")
-	ErrSessionIdIsEmpty = New("Token is empty")
-	ErrUserIdEmpty      = New("User ID is empty")
+	ErrExpiresAtIsEmpty       = New("Expiration time is empty")
+	ErrPasswordIsEmpty        = New("Password is empty")
+	ErrSessionIdIsEmpty       = New("Token is empty")
+	ErrSecretHashIsEmpty      = New("Secret hash is empty")
+	ErrWebhookSecretIsEmpty   = New("Webhook secret is empty")
+	ErrUserIdEmpty            = New("User ID is empty")
+	ErrClientIDEmpty          = New("Client ID is empty")
+	ErrServiceAccountIdEmpty  = New("Service account ID is empty")
+	ErrWebhookIdEmpty         = New("Webhook subscription ID is empty")
+	ErrWebhookDeliveryIdEmpty = New("Webhook delivery ID is empty")
 
 	// Database errors
-	ErrUserNotFound = New("User not found")
+	ErrUserNotFound    = New("User not found")
+	ErrEmailCollisions = New("Users share the same canonical email and must be merged or renamed before migrating")
 
 	ErrCouldNotIncrementFailedLogins = New("Could not increment users.failed_login_attempts")
 	ErrCouldNotUpdateUser            = New("Tried to update user but no changes were made")
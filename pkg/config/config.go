@@ -8,12 +8,18 @@ const DatabaseURL = "DATABASE_URL"
 // AuthServerPort is the env variable name for the port to use for the auth server
 const AuthServerPort = "AUTH_SERVER_PORT"
 
+// GRPCServerPort is the env variable name for the port of the gRPC server.
+// Unset disables the gRPC server.
+const GRPCServerPort = "GRPC_SERVER_PORT"
+
 // SessionCookieName is the env variable name used to set the cookie for sessions
 const SessionKey = "SESSION_KEY"
 
 // SessionCookieName is the env variable name used to set the cookie for sessions
 const SessionCookieName = "GOAUTH_SERVICE_SESSION_COOKIE"
 
+// CorsAllowedOrigins is the env variable name for the comma separated origins
+// allowed to make CORS requests and to send state-changing requests
 const CorsAllowedOrigins = "CORS_ALLOWED_ORIGINS"
 
 // SessionExpiration is the time in seconds when a token will expire
@@ -32,3 +38,335 @@ const AccountLockoutLength = 1 * time.Minute
 // AccountUnlockPeriod is how often in minutes the UnlockExpiredLocks job will
 // check for expired locked accounts to unlock
 const AccountUnlockPeriod = 5 * time.Minute
+
+// PasswordHashAlgorithm is the env variable name for the algorithm used to
+// hash new passwords, one of `argon2id` (default) or `bcrypt`
+const PasswordHashAlgorithm = "PASSWORD_HASH_ALGORITHM"
+
+// Argon2MemoryKiB is the env variable name for the argon2id memory cost in KiB
+const Argon2MemoryKiB = "ARGON2_MEMORY_KIB"
+
+// Argon2Iterations is the env variable name for the argon2id time cost
+const Argon2Iterations = "ARGON2_ITERATIONS"
+
+// Argon2Parallelism is the env variable name for the argon2id degree of parallelism
+const Argon2Parallelism = "ARGON2_PARALLELISM"
+
+// BcryptCost is the env variable name for the bcrypt cost factor
+const BcryptCost = "BCRYPT_COST"
+
+// DefaultArgon2MemoryKiB, DefaultArgon2Iterations and DefaultArgon2Parallelism
+// follow the OWASP Password Storage Cheat Sheet minimums for argon2id
+const (
+	DefaultArgon2MemoryKiB   = 19 * 1024
+	DefaultArgon2Iterations  = 2
+	DefaultArgon2Parallelism = 1
+)
+
+// Argon2SaltLength and Argon2KeyLength are the lengths in bytes of the salt
+// and derived key stored in argon2id hashes
+const (
+	Argon2SaltLength = 16
+	Argon2KeyLength  = 32
+)
+
+// DefaultBcryptCost is the bcrypt cost used when `BCRYPT_COST` is not set
+const DefaultBcryptCost = 10
+
+// BcryptMaxPasswordBytes is the number of bytes bcrypt reads from a password.
+// Anything past this limit is silently ignored by the algorithm.
+const BcryptMaxPasswordBytes = 72
+
+// MaxImportBytes is the largest request body accepted by the admin user import endpoint
+const MaxImportBytes = 32 << 20
+
+// PasswordMinLength is the env variable name for the minimum password length in characters
+const PasswordMinLength = "PASSWORD_MIN_LENGTH"
+
+// PasswordMaxLength is the env variable name for the maximum password length in characters
+const PasswordMaxLength = "PASSWORD_MAX_LENGTH"
+
+// PasswordMinEntropy is the env variable name for the minimum password entropy in bits
+const PasswordMinEntropy = "PASSWORD_MIN_ENTROPY_BITS"
+
+// PasswordHistorySize is the env variable name for the number of recent
+// passwords, including the current one, a user may not reuse
+const PasswordHistorySize = "PASSWORD_HISTORY_SIZE"
+
+// PasswordDenylistFile is the env variable name for an optional file of
+// newline separated passwords to reject in addition to the bundled denylist
+const PasswordDenylistFile = "PASSWORD_DENYLIST_FILE"
+
+// DefaultPasswordMinLength, DefaultPasswordMaxLength and
+// DefaultPasswordHistorySize are used when the matching env variable is not set.
+// The entropy threshold defaults to `MinEntropyBits`.
+const (
+	DefaultPasswordMinLength   = 8
+	DefaultPasswordMaxLength   = 128
+	DefaultPasswordHistorySize = 5
+)
+
+// BreachedPasswordsFile is the env variable name for a local Have I Been Pwned
+// dataset: a `HASH:COUNT` file ordered by hash, a directory of range files, or
+// an index built with `goauth breach-index`. Unset disables the breach check.
+const BreachedPasswordsFile = "BREACHED_PASSWORDS_FILE"
+
+// BreachedPasswordsMinCount is the env variable name for the number of times a
+// password must appear in the dataset to be rejected (default 1)
+const BreachedPasswordsMinCount = "BREACHED_PASSWORDS_MIN_COUNT"
+
+// EmailLowercaseLocalPart is the env variable name for whether the part of an
+// email before the `@` is lowercased in its canonical form (default true). The
+// domain is always lowercased. Set to `false` for case-sensitive mailboxes.
+const EmailLowercaseLocalPart = "EMAIL_LOWERCASE_LOCAL_PART"
+
+// EnumerationProtection is the env variable name for enabling the account
+// enumeration-resistant mode. When `true`, login failures for unknown users,
+// wrong passwords and locked accounts are indistinguishable, and registration
+// always answers "check your email".
+const EnumerationProtection = "ENUMERATION_PROTECTION"
+
+// MailFrom is the env variable name for the sender address of outgoing email
+const MailFrom = "MAIL_FROM"
+
+// DefaultMailFrom is used when `MAIL_FROM` is not set
+const DefaultMailFrom = "no-reply@localhost"
+
+// SMTPHost is the env variable name for the SMTP server host. When unset,
+// email is written to the log instead of being sent.
+const SMTPHost = "SMTP_HOST"
+
+// SMTPPort is the env variable name for the SMTP server port
+const SMTPPort = "SMTP_PORT"
+
+// DefaultSMTPPort is the SMTP submission port used when `SMTP_PORT` is not set
+const DefaultSMTPPort = "587"
+
+// SMTPUsername and SMTPPassword are the env variable names for SMTP PLAIN auth
+// credentials. Auth is skipped when the username is unset.
+const (
+	SMTPUsername = "SMTP_USERNAME"
+	SMTPPassword = "SMTP_PASSWORD"
+)
+
+// MailSendTimeout bounds how long sending a single email may take
+const MailSendTimeout = 30 * time.Second
+
+// HashConcurrency is the env variable name for the number of password hashes
+// computed at once (default: half the number of CPUs, at least 1)
+const HashConcurrency = "HASH_CONCURRENCY"
+
+// HashQueueDepth is the env variable name for the number of password hashing
+// requests that may wait for a free slot before new ones are refused
+const HashQueueDepth = "HASH_QUEUE_DEPTH"
+
+// DefaultHashQueueDepth is used when `HASH_QUEUE_DEPTH` is not set
+const DefaultHashQueueDepth = 64
+
+// HashRetryAfter is the `Retry-After` value in seconds sent when the hashing queue is full
+const HashRetryAfter = 1
+
+// SessionKeyringFile is the env variable name for the path of a JSON session
+// keyring, managed with `goauth keys`. It takes precedence over `SESSION_KEYRING`
+// and `SESSION_KEY`.
+const SessionKeyringFile = "SESSION_KEYRING_FILE"
+
+// SessionKeyring is the env variable name for a JSON session keyring given
+// inline, e.g. from a secrets manager. It takes precedence over `SESSION_KEY`.
+const SessionKeyring = "SESSION_KEYRING"
+
+// DefaultSessionKeyID is the key ID of `SESSION_KEY` when no keyring is configured
+const DefaultSessionKeyID = "default"
+
+// CSRFCookieName is the name of the cookie holding the CSRF token
+const CSRFCookieName = "GOAUTH_SERVICE_CSRF_COOKIE"
+
+// CSRFHeaderName is the request header that must repeat the CSRF cookie's
+// token on state-changing requests authenticated with the session cookie
+const CSRFHeaderName = "X-CSRF-Token"
+
+// SessionExpiryCookieName is the name of the optional companion cookie that
+// exposes the session's expiry time, in Unix seconds, to scripts
+const SessionExpiryCookieName = "GOAUTH_SERVICE_SESSION_EXPIRES"
+
+// CookiePrefix is the env variable name for the cookie name prefix: `host`
+// for `__Host-`, `secure` for `__Secure-`, or unset for none
+const CookiePrefix = "COOKIE_PREFIX"
+
+// CookieDomain is the env variable name for the cookie Domain attribute, e.g.
+// `example.com` to share the session with every subdomain. Unset limits
+// cookies to the host that set them.
+const CookieDomain = "COOKIE_DOMAIN"
+
+// CookiePath is the env variable name for the cookie Path attribute
+const CookiePath = "COOKIE_PATH"
+
+// CookieSameSite is the env variable name for the cookie SameSite attribute,
+// one of `lax` (default), `strict` or `none`
+const CookieSameSite = "COOKIE_SAMESITE"
+
+// CookieSecure is the env variable name for the cookie Secure attribute. Set
+// to `false` only for local development over plain HTTP.
+const CookieSecure = "COOKIE_SECURE"
+
+// SessionExpiryCookie is the env variable name for enabling the session expiry
+// companion cookie when set to `true`
+const SessionExpiryCookie = "SESSION_EXPIRY_COOKIE"
+
+// DefaultCookiePath is used when `COOKIE_PATH` is not set
+const DefaultCookiePath = "/"
+
+// RecentAuthMaxAge is how long after logging in or reauthenticating a session
+// may change the account's credentials or delete it
+const RecentAuthMaxAge = 5 * time.Minute
+
+// ForwardAuthLoginURL is the env variable name for the login page that
+// `/auth/verify` points unauthenticated users to, e.g.
+// `https://auth.example.com/login`. The original URL is added as the
+// `ForwardAuthRedirectParam` query parameter.
+const ForwardAuthLoginURL = "FORWARD_AUTH_LOGIN_URL"
+
+// ForwardAuthRedirectParam is the login URL query parameter holding the URL
+// to return to after logging in
+const ForwardAuthRedirectParam = "rd"
+
+// AccountDeletionGraceDays is the env variable name for the number of days an
+// account scheduled for deletion can still be restored before it is
+// permanently deleted
+const AccountDeletionGraceDays = "ACCOUNT_DELETION_GRACE_DAYS"
+
+// DefaultAccountDeletionGraceDays is used when `ACCOUNT_DELETION_GRACE_DAYS` is not set
+const DefaultAccountDeletionGraceDays = 30
+
+// AccountRestoreURL is the env variable name for the page linked from the
+// deletion email, which should POST its `token` query parameter to
+// `/restoreaccount`. When unset, the email contains the token only.
+const AccountRestoreURL = "ACCOUNT_RESTORE_URL"
+
+// AccountPurgePeriod is how often the PurgeDeletedAccounts job permanently
+// deletes accounts whose grace period has ended
+const AccountPurgePeriod = 1 * time.Hour
+
+// DataExportLinkTTL is how long the download link of a background data export
+// stays valid after it is requested
+const DataExportLinkTTL = 24 * time.Hour
+
+// DataExportPeriod is how often the ProcessDataExports job generates pending
+// data exports and deletes expired ones
+const DataExportPeriod = 1 * time.Minute
+
+// DataExportLease is how long a job has to generate the pending data exports
+// it claimed before other jobs can claim them again
+const DataExportLease = 10 * time.Minute
+
+// IntrospectionClients is the env variable name for the comma separated
+// `id:secret` credentials of the services allowed to call `/introspect`, e.g.
+// `billing:s3cret,search:0ther`. Unset disables introspection.
+const IntrospectionClients = "INTROSPECTION_CLIENTS"
+
+// IntrospectionCacheSeconds is the env variable name for how long in seconds
+// `/introspect` reuses a result for the same caller and token. A revoked
+// session may stay active for callers until then. Unset or 0 disables the cache.
+const IntrospectionCacheSeconds = "INTROSPECTION_CACHE_SECONDS"
+
+// IntrospectionCacheMaxEntries bounds the number of results the `/introspect`
+// cache holds across all callers
+const IntrospectionCacheMaxEntries = 10000
+
+// MaxAccessTokensPerUser bounds the personal access tokens a user can hold
+const MaxAccessTokensPerUser = 50
+
+// MaxAccessTokenDays is the longest expiry a personal access token can be
+// created with, in days. Tokens may also be created without an expiry.
+const MaxAccessTokenDays = 366
+
+// AccessTokenLastUsedInterval is how often the last use of a personal access
+// token is written to the database, so busy scripts don't write on every
+// request. A use from a different IP is always written.
+const AccessTokenLastUsedInterval = 1 * time.Minute
+
+// TokenEndpointURL is the env variable name for the public URL of
+// `/oauth/token`, e.g. `https://auth.example.com/oauth/token`. `private_key_jwt`
+// client assertions must name it as their audience, so service accounts with
+// a public key cannot get tokens while it is unset.
+const TokenEndpointURL = "TOKEN_ENDPOINT_URL"
+
+// ServiceAccountTokenExpiration is how long an access token issued to a
+// service account by `/oauth/token` is valid
+const ServiceAccountTokenExpiration = 15 * time.Minute
+
+// ServiceAccountTokenPurgePeriod is how often the PurgeServiceAccountTokens
+// job deletes expired service account tokens and client assertion IDs
+const ServiceAccountTokenPurgePeriod = 1 * time.Hour
+
+// WebhookDispatchPeriod is how often the DispatchWebhooks job sends the
+// webhook deliveries that are due
+const WebhookDispatchPeriod = 10 * time.Second
+
+// WebhookDeliveryTimeout is how long a webhook endpoint has to respond
+const WebhookDeliveryTimeout = 10 * time.Second
+
+// WebhookMaxAttempts is how many times a webhook delivery is attempted
+// before it is marked dead
+const WebhookMaxAttempts = 10
+
+// WebhookRetryBaseDelay and WebhookRetryMaxDelay bound the exponential
+// backoff between attempts of a webhook delivery: the delay doubles after
+// each failed attempt, starting at the base delay, up to the max delay
+const (
+	WebhookRetryBaseDelay = 30 * time.Second
+	WebhookRetryMaxDelay  = 6 * time.Hour
+)
+
+// WebhookRetentionDays is the env variable name for the number of days
+// delivered and dead webhook deliveries are kept after their last attempt,
+// along with their events. `0` keeps them forever.
+const WebhookRetentionDays = "WEBHOOK_RETENTION_DAYS"
+
+// DefaultWebhookRetentionDays is used when `WEBHOOK_RETENTION_DAYS` is not set
+const DefaultWebhookRetentionDays = 30
+
+// WebhookPurgePeriod is how often the PurgeWebhookDeliveries job deletes
+// webhook deliveries and events past their retention
+const WebhookPurgePeriod = 1 * time.Hour
+
+// Webhook request headers. The signature header is `sha256=` followed by the
+// hex HMAC-SHA256 of `<timestamp>.<body>` with the subscription's secret.
+const (
+	WebhookEventHeader     = "X-Goauth-Event"
+	WebhookDeliveryHeader  = "X-Goauth-Delivery"
+	WebhookTimestampHeader = "X-Goauth-Timestamp"
+	WebhookSignatureHeader = "X-Goauth-Signature"
+)
+
+// UserHookTimeout is how long each user lifecycle hook may run before it is
+// treated as failed
+const UserHookTimeout = 5 * time.Second
+
+// MagicLinkURL is the env variable name for the page linked from the magic
+// link email, which should POST its `token` query parameter to
+// `/login/magic/verify` from the browser that asked for the link. When unset,
+// the email contains the token only.
+const MagicLinkURL = "MAGIC_LINK_URL"
+
+// MagicLinkExpiration is how long a magic link can be used
+const MagicLinkExpiration = 15 * time.Minute
+
+// MagicLinkCookieName is the name of the cookie that binds a magic link to
+// the browser that asked for it
+const MagicLinkCookieName = "GOAUTH_SERVICE_MAGIC_LINK_COOKIE"
+
+// MagicLinkMaxRequests is how many magic links are emailed to an account,
+// and MagicLinkMaxRequestsPerIP how many magic links one IP address can ask
+// for, within MagicLinkRequestWindow. Further requests are answered the same
+// way but send nothing.
+const (
+	MagicLinkMaxRequests      = 3
+	MagicLinkMaxRequestsPerIP = 10
+	MagicLinkRequestWindow    = 15 * time.Minute
+)
+
+// MagicLinkPurgePeriod is how often the PurgeMagicLinkTokens job deletes
+// magic link tokens that have expired and no longer count towards the
+// request limit
+const MagicLinkPurgePeriod = 1 * time.Hour
@@ -0,0 +1,304 @@
+// Package goauth embeds the auth service in another Gin or net/http server. It
+// builds the same stack as the standalone server from a Config and a
+// *gorm.DB, mounts the auth routes under a prefix, and exports the middleware
+// and helpers that protect the host's own routes.
+//
+// Session keys, password hashing and cookie settings are process-wide, so a
+// process should create a single Auth.
+package goauth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/cookies"
+	"github.com/al-ce/goauth/internal/database"
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/jobs"
+	"github.com/al-ce/goauth/internal/keyring"
+	"github.com/al-ce/goauth/internal/middleware"
+	"github.com/al-ce/goauth/internal/models"
+	"github.com/al-ce/goauth/internal/passwords"
+	"github.com/al-ce/goauth/internal/repository"
+	"github.com/al-ce/goauth/internal/server"
+	"github.com/al-ce/goauth/internal/services"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+)
+
+// Roles a user can hold
+const (
+	RoleUser  = models.RoleUser
+	RoleAdmin = models.RoleAdmin
+)
+
+// Config configures an embedded Auth. Settings without a field here, like
+// cookies, password hashing and the password policy, are read from the same
+// environment variables as the standalone server (see the README).
+type Config struct {
+	// SessionKey signs session tokens. Empty loads the keys from
+	// `SESSION_KEYRING_FILE`, `SESSION_KEYRING` or `SESSION_KEY`.
+	SessionKey string
+	// AllowedOrigins may send state-changing requests, as normalized
+	// `scheme://host[:port]` origins. Nil reads `CORS_ALLOWED_ORIGINS`.
+	AllowedOrigins []string
+	// SkipMigrate leaves the database schema alone, for hosts that run
+	// `goauth migrate` themselves
+	SkipMigrate bool
+	// Hooks run custom logic around registration, login, updates and
+	// deletion, in order, over HTTP and gRPC alike. See UserHooks.
+	Hooks []UserHooks
+}
+
+// UserHooks runs custom logic around the lifecycle of users, and may refuse
+// a registration, login, email or password change, or deletion by returning
+// a HookVeto. Embed NopUserHooks to implement only some of the hooks.
+type UserHooks = services.UserHooks
+
+// Events passed to UserHooks
+type (
+	RegisterEvent = services.RegisterEvent
+	LoginEvent    = services.LoginEvent
+	UpdateEvent   = services.UpdateEvent
+	DeleteEvent   = services.DeleteEvent
+)
+
+// Login methods of LoginEvent
+const (
+	LoginMethodPassword  = services.LoginMethodPassword
+	LoginMethodMagicLink = services.LoginMethodMagicLink
+)
+
+// NopUserHooks implements every hook of UserHooks by doing nothing
+type NopUserHooks = services.NopUserHooks
+
+// HookVeto is the error a hook returns to refuse a change with an HTTP status
+// and a message for the client
+type HookVeto = services.HookVeto
+
+// Veto returns a HookVeto with a 4xx `status`, 403 otherwise, and `message`
+func Veto(status int, message string) *HookVeto {
+	return services.Veto(status, message)
+}
+
+// User is the account of an authenticated user
+type User struct {
+	ID               uuid.UUID
+	Email            string
+	Role             string
+	LastLogin        *time.Time
+	PasswordBreached bool
+}
+
+// IsAdmin reports whether the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Auth is an embedded auth service
+type Auth struct {
+	db     *gorm.DB
+	users  *repository.UserRepository
+	server *server.APIServer
+}
+
+// New configures the process-wide settings from `cfg` and the environment,
+// migrates the database unless cfg.SkipMigrate is set, and builds the auth
+// services on `db`
+func New(cfg Config, db *gorm.DB) (*Auth, error) {
+	if db == nil {
+		return nil, apperrors.ErrDatabaseIsNil
+	}
+	if err := configure(cfg); err != nil {
+		return nil, err
+	}
+	if !cfg.SkipMigrate {
+		if err := database.Migrate(db); err != nil {
+			return nil, err
+		}
+	}
+
+	srv, err := server.NewAPIServer(db)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AllowedOrigins != nil {
+		csrf, err := middleware.NewCSRFMiddleware(cfg.AllowedOrigins)
+		if err != nil {
+			return nil, err
+		}
+		srv.MiddlewareProvider.CSRF = csrf
+	}
+	// The HTTP handlers and the gRPC server share this UserService
+	srv.HandlerRegistry.User.UserService.Hooks = cfg.Hooks
+	return &Auth{
+		db:     db,
+		users:  srv.MiddlewareProvider.Auth.UserRepo,
+		server: srv,
+	}, nil
+}
+
+// Mount adds the auth routes, e.g. `/login` and `/whoami`, to `rg` under
+// `prefix` and returns the group they were added to. The routes are behind
+// the CSRF check; CORS is left to the host.
+func (a *Auth) Mount(rg *gin.RouterGroup, prefix string) *gin.RouterGroup {
+	group := rg.Group(prefix)
+	a.server.RegisterRoutes(group)
+	return group
+}
+
+// Handler returns the auth routes under `prefix` as an http.Handler for
+// servers that don't use gin, e.g. `mux.Handle("/auth/", auth.Handler("/auth"))`.
+// The routes are behind the CSRF check; CORS is left to the host.
+func (a *Auth) Handler(prefix string) http.Handler {
+	return a.server.HTTPHandler(prefix)
+}
+
+// RequireAuth is a middleware that only lets requests with a valid session
+// cookie through, answering 401 otherwise. Personal access tokens are only
+// accepted by the auth routes, which check their scopes. Read the user with
+// UserID or CurrentUser.
+func (a *Auth) RequireAuth() gin.HandlerFunc {
+	return a.server.MiddlewareProvider.Auth.RequireSession()
+}
+
+// RequireAdmin is a middleware that only lets users with the admin role
+// through, answering 403 otherwise. It must run after RequireAuth.
+func (a *Auth) RequireAdmin() gin.HandlerFunc {
+	return a.server.MiddlewareProvider.Auth.RequireAdmin()
+}
+
+// RequireRecentAuth is a middleware that only lets sessions through whose
+// user logged in or reauthenticated within `maxAge`, answering 403
+// otherwise. It must run after RequireAuth.
+func (a *Auth) RequireRecentAuth(maxAge time.Duration) gin.HandlerFunc {
+	return a.server.MiddlewareProvider.Auth.RequireRecentAuth(maxAge)
+}
+
+// RequireAuthHTTP is RequireAuth for net/http. Read the user with
+// UserIDFromContext or CurrentUserFromContext.
+func (a *Auth) RequireAuthHTTP(next http.Handler) http.Handler {
+	return a.server.MiddlewareProvider.Auth.RequireSessionHTTP(next)
+}
+
+// RequireAdminHTTP is RequireAdmin for net/http. It must run after
+// RequireAuthHTTP.
+func (a *Auth) RequireAdminHTTP(next http.Handler) http.Handler {
+	return a.server.MiddlewareProvider.Auth.RequireAdminHTTP(next)
+}
+
+// RequireRecentAuthHTTP is RequireRecentAuth for net/http. It must run after
+// RequireAuthHTTP.
+func (a *Auth) RequireRecentAuthHTTP(maxAge time.Duration) func(http.Handler) http.Handler {
+	return a.server.MiddlewareProvider.Auth.RequireRecentAuthHTTP(maxAge)
+}
+
+// CurrentUser loads the user authenticated by RequireAuth. It returns
+// ErrNotAuthenticated if the request did not pass RequireAuth.
+func (a *Auth) CurrentUser(c *gin.Context) (*User, error) {
+	return a.CurrentUserFromContext(c.Request.Context())
+}
+
+// CurrentUserFromContext loads the user authenticated by RequireAuth or
+// RequireAuthHTTP from a request context
+func (a *Auth) CurrentUserFromContext(ctx context.Context) (*User, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, apperrors.ErrNotAuthenticated
+	}
+	user, err := a.users.GetUserByID(userID.String())
+	if err != nil {
+		return nil, apperrors.ErrUserNotFound
+	}
+	return &User{
+		ID:               user.ID,
+		Email:            user.Email,
+		Role:             user.Role,
+		LastLogin:        user.LastLogin,
+		PasswordBreached: user.PasswordBreached,
+	}, nil
+}
+
+// StartJobs starts the background jobs that unlock accounts, purge deleted
+// accounts, generate data exports, purge expired service account tokens and
+// send webhooks. The purge runs the delete hooks of Config.Hooks. They stop
+// when `ctx` is done; wait on `wg` for them to finish.
+func (a *Auth) StartJobs(ctx context.Context, wg *sync.WaitGroup) {
+	jobs.StartJobs(ctx, wg, a.db, a.server.HandlerRegistry.User.UserService.Hooks)
+}
+
+// UserID returns the ID of the user authenticated by RequireAuth, without
+// loading the user
+func UserID(c *gin.Context) (uuid.UUID, bool) {
+	return UserIDFromContext(c.Request.Context())
+}
+
+// UserIDFromContext returns the ID of the user authenticated by RequireAuth or
+// RequireAuthHTTP from a request context, without loading the user
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := identity.FromContext(ctx)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return id.UserID, true
+}
+
+// SessionClaims returns the claims the login hooks added to the session
+// authenticated by RequireAuth
+func SessionClaims(c *gin.Context) map[string]string {
+	return SessionClaimsFromContext(c.Request.Context())
+}
+
+// SessionClaimsFromContext returns the claims the login hooks added to the
+// session authenticated by RequireAuth or RequireAuthHTTP from a request
+// context
+func SessionClaimsFromContext(ctx context.Context) map[string]string {
+	id, ok := identity.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return id.SessionClaims
+}
+
+// configure sets the session keys, password hashing, password policy and
+// cookie settings the way the standalone server does on startup
+func configure(cfg Config) error {
+	ring := &keyring.Keyring{
+		Active: config.DefaultSessionKeyID,
+		Keys:   []keyring.Key{{ID: config.DefaultSessionKeyID, Secret: cfg.SessionKey}},
+	}
+	if cfg.SessionKey == "" {
+		var err error
+		if ring, err = keyring.NewFromEnv(); err != nil {
+			return err
+		}
+	}
+	if err := ring.Validate(); err != nil {
+		return err
+	}
+
+	manager, err := passwords.NewManagerFromEnv()
+	if err != nil {
+		return err
+	}
+	policy, err := passwords.NewPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	cookieManager, err := cookies.NewManagerFromEnv()
+	if err != nil {
+		return err
+	}
+
+	keyring.SetDefault(ring)
+	passwords.SetDefault(manager)
+	passwords.SetPolicy(policy)
+	cookies.SetDefault(cookieManager)
+	return nil
+}
@@ -0,0 +1,196 @@
+package goauth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"gorm.io/gorm"
+
+	"github.com/al-ce/goauth/internal/identity"
+	"github.com/al-ce/goauth/internal/testutils"
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/config"
+	"github.com/al-ce/goauth/pkg/goauth"
+)
+
+func TestMain(m *testing.M) {
+	testutils.TestEnvSetup()
+	os.Exit(m.Run())
+}
+
+// TestNew checks the New constructor's validation
+func TestNew(t *testing.T) {
+	is := is.New(t)
+
+	auth, err := goauth.New(goauth.Config{}, nil)
+	is.Equal(auth, nil)
+	is.Equal(err, apperrors.ErrDatabaseIsNil)
+
+	// Settings are checked before the database is used
+	_, err = goauth.New(goauth.Config{SessionKey: "secret"}, &gorm.DB{})
+	is.True(errors.Is(err, apperrors.ErrKeyringConfig))
+
+	_, err = goauth.New(goauth.Config{AllowedOrigins: []string{"not an origin"}, SkipMigrate: true}, &gorm.DB{})
+	is.True(errors.Is(err, apperrors.ErrCSRFConfig))
+}
+
+// TestUserID checks reading the user ID set by RequireAuth
+func TestUserID(t *testing.T) {
+	is := is.New(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+
+	_, ok := goauth.UserID(c)
+	is.True(!ok)
+	_, ok = goauth.UserIDFromContext(c.Request.Context())
+	is.True(!ok)
+
+	userID := uuid.New()
+	c.Request = c.Request.WithContext(identity.NewContext(c.Request.Context(), identity.Identity{UserID: userID}))
+	got, ok := goauth.UserID(c)
+	is.True(ok)
+	is.Equal(got, userID)
+	got, ok = goauth.UserIDFromContext(c.Request.Context())
+	is.True(ok)
+	is.Equal(got, userID)
+}
+
+// TestAuth_Mount checks the auth routes under a prefix protecting a route of
+// the host server
+func TestAuth_Mount(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	auth, err := goauth.New(goauth.Config{SkipMigrate: true}, tx)
+	is.NoErr(err)
+
+	router := gin.New()
+	auth.Mount(&router.RouterGroup, "/auth")
+	api := router.Group("/api", auth.RequireAuth())
+	api.GET("/profile", func(c *gin.Context) {
+		user, err := auth.CurrentUser(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": user.ID, "email": user.Email, "admin": user.IsAdmin()})
+	})
+
+	email := "testGoauthMount@test.com"
+	credentials, _ := json.Marshal(map[string]string{"email": email, "password": testutils.TestingPassword})
+	post := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewReader(credentials)))
+		return rr
+	}
+	is.Equal(post("/auth/register").Code, http.StatusOK)
+	rr := post("/auth/login")
+	is.Equal(rr.Code, http.StatusOK)
+
+	var session *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == config.SessionCookieName {
+			session = cookie
+		}
+	}
+	is.True(session != nil)
+
+	t.Run("host route with session", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/profile", nil)
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusOK)
+
+		var profile map[string]any
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &profile))
+		is.Equal(profile["email"], email)
+		is.Equal(profile["admin"], false)
+	})
+
+	t.Run("mounted route with session", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/auth/whoami", nil)
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusOK)
+	})
+
+	t.Run("host route without session", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/profile", nil))
+		is.Equal(rr.Code, http.StatusUnauthorized)
+	})
+}
+
+// TestAuth_Handler checks the auth routes as an http.Handler protecting a
+// route of a net/http server
+func TestAuth_Handler(t *testing.T) {
+	is := is.New(t)
+
+	testDB := testutils.TestDBSetup()
+	tx := testDB.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	auth, err := goauth.New(goauth.Config{SkipMigrate: true}, tx)
+	is.NoErr(err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", auth.Handler("/auth"))
+	mux.Handle("GET /api/profile", auth.RequireAuthHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.CurrentUserFromContext(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"email": user.Email})
+	})))
+
+	email := "testGoauthHandler@test.com"
+	credentials, _ := json.Marshal(map[string]string{"email": email, "password": testutils.TestingPassword})
+	post := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewReader(credentials)))
+		return rr
+	}
+	is.Equal(post("/auth/register").Code, http.StatusOK)
+	rr := post("/auth/login")
+	is.Equal(rr.Code, http.StatusOK)
+
+	var session *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == config.SessionCookieName {
+			session = cookie
+		}
+	}
+	is.True(session != nil)
+
+	t.Run("host route with session", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/profile", nil)
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusOK)
+
+		var profile map[string]any
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &profile))
+		is.Equal(profile["email"], email)
+	})
+
+	t.Run("host route without session", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/profile", nil))
+		is.Equal(rr.Code, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,253 @@
+// Package introspect is a client for goauth's `/introspect` endpoint, for
+// backend services that receive a session token and need to know whose it is
+// without the session keys or the database.
+//
+// Results are cached in memory: active tokens for CacheTTL, but never past
+// the session's expiry, and inactive tokens for NegativeCacheTTL, so a
+// flood of bad tokens does not reach the auth server. A session revoked in
+// goauth can stay active for a caller until its cached result expires.
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+)
+
+// Defaults of a new Client
+const (
+	DefaultCacheTTL         = 30 * time.Second
+	DefaultNegativeCacheTTL = 5 * time.Second
+	DefaultMaxEntries       = 10000
+)
+
+// Result is the state of a session token
+type Result struct {
+	Active bool
+	// UserID, Email, Roles, ExpiresAt and IssuedAt are only set for active tokens
+	UserID    string
+	Email     string
+	Roles     []string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+	// Claims were added to the session by the login hooks of the auth
+	// service, if any
+	Claims map[string]string
+}
+
+// HasRole reports whether the token's user holds `role`
+func (r *Result) HasRole(role string) bool {
+	for _, held := range r.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of the result that shares no roles or claims with it
+func (r Result) clone() Result {
+	r.Roles = slices.Clone(r.Roles)
+	r.Claims = maps.Clone(r.Claims)
+	return r
+}
+
+// response is the RFC 7662 body answered by `/introspect`
+type response struct {
+	Active    bool              `json:"active"`
+	Subject   string            `json:"sub"`
+	Email     string            `json:"email"`
+	Roles     []string          `json:"roles"`
+	ExpiresAt int64             `json:"exp"`
+	IssuedAt  int64             `json:"iat"`
+	Claims    map[string]string `json:"claims"`
+}
+
+// entry is a cached result and when it must be looked up again
+type entry struct {
+	result    Result
+	expiresAt time.Time
+}
+
+// Client introspects session tokens with the credentials of one service
+type Client struct {
+	// HTTPClient sends the requests. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// CacheTTL is how long an active result is reused. Zero disables caching
+	// active results.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long an inactive result is reused. Zero
+	// disables caching inactive results.
+	NegativeCacheTTL time.Duration
+	// MaxEntries bounds the number of cached results
+	MaxEntries int
+
+	endpoint     string
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]entry
+}
+
+// NewClient returns a Client for the `/introspect` URL `endpoint`, e.g.
+// `https://auth.example.com/introspect`, authenticating with a `clientID` and
+// `clientSecret` pair from the server's `INTROSPECTION_CLIENTS`
+func NewClient(endpoint, clientID, clientSecret string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: endpoint %q must be an absolute http(s) URL", apperrors.ErrIntrospectionConfig, endpoint)
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("%w: client ID and secret are required", apperrors.ErrIntrospectionConfig)
+	}
+	return &Client{
+		CacheTTL:         DefaultCacheTTL,
+		NegativeCacheTTL: DefaultNegativeCacheTTL,
+		MaxEntries:       DefaultMaxEntries,
+		endpoint:         endpoint,
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+	}, nil
+}
+
+// Introspect returns the state of a session token, from the cache if
+// possible. It returns ErrIntrospectionClient if the server rejected the
+// client's credentials and ErrIntrospectionFailed for other failures, which
+// are never cached.
+func (c *Client) Introspect(ctx context.Context, token string) (*Result, error) {
+	if token == "" {
+		return &Result{Active: false}, nil
+	}
+	key := sha256.Sum256([]byte(token))
+	if result, ok := c.cached(key); ok {
+		return &result, nil
+	}
+
+	result, err := c.request(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, *result)
+	return result, nil
+}
+
+// Forget drops a token's cached result, e.g. after the service logs the user
+// out, so the next Introspect asks the server
+func (c *Client) Forget(token string) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, key)
+}
+
+// request asks the server about a token
+func (c *Client) request(ctx context.Context, token string) (*Result, error) {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apperrors.ErrIntrospectionFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apperrors.ErrIntrospectionFailed, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, apperrors.ErrIntrospectionClient
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: status %d", apperrors.ErrIntrospectionFailed, resp.StatusCode)
+	}
+
+	var body response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", apperrors.ErrIntrospectionFailed, err)
+	}
+	if !body.Active {
+		return &Result{Active: false}, nil
+	}
+	return &Result{
+		Active:    true,
+		UserID:    body.Subject,
+		Email:     body.Email,
+		Roles:     body.Roles,
+		ExpiresAt: time.Unix(body.ExpiresAt, 0),
+		IssuedAt:  time.Unix(body.IssuedAt, 0),
+		Claims:    body.Claims,
+	}, nil
+}
+
+// cached returns the unexpired result for a token, if any
+func (c *Client) cached(key [sha256.Size]byte) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.cache[key]
+	if !ok {
+		return Result{}, false
+	}
+	if !time.Now().Before(e.expiresAt) {
+		delete(c.cache, key)
+		return Result{}, false
+	}
+	return e.result.clone(), true
+}
+
+// store caches a copy of a result for CacheTTL or NegativeCacheTTL. Active
+// results are never kept past the session's expiry.
+func (c *Client) store(key [sha256.Size]byte, result Result) {
+	now := time.Now()
+	ttl := c.NegativeCacheTTL
+	if result.Active {
+		ttl = c.CacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := now.Add(ttl)
+	if result.Active && result.ExpiresAt.Before(expiresAt) {
+		expiresAt = result.ExpiresAt
+	}
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[[sha256.Size]byte]entry)
+	}
+	if len(c.cache) >= c.MaxEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= c.MaxEntries {
+			return
+		}
+	}
+	c.cache[key] = entry{result: result.clone(), expiresAt: expiresAt}
+}
@@ -0,0 +1,205 @@
+package introspect_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/al-ce/goauth/pkg/apperrors"
+	"github.com/al-ce/goauth/pkg/introspect"
+)
+
+const (
+	testClientID     = "billing"
+	testClientSecret = "s3cret"
+	activeToken      = "active-token"
+	expiredToken     = "expired-token"
+)
+
+// introspectionServer fakes `/introspect`, counting the requests it answers
+func introspectionServer(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != testClientID || secret != testClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		body := map[string]any{"active": false}
+		switch r.PostFormValue("token") {
+		case activeToken:
+			body = map[string]any{
+				"active": true,
+				"sub":    "5f0c0a3e-8d2b-4c1e-9a57-2b1f6f0e4c2d",
+				"email":  "user@example.com",
+				"roles":  []string{"admin"},
+				"exp":    time.Now().Add(time.Hour).Unix(),
+				"iat":    time.Now().Unix(),
+				"claims": map[string]string{"plan": "pro"},
+			}
+		case expiredToken:
+			// Active, but the session expires before the cache TTL would
+			body = map[string]any{"active": true, "sub": "id", "exp": time.Now().Add(-time.Second).Unix()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// TestNewClient checks the NewClient constructor's validation
+func TestNewClient(t *testing.T) {
+	is := is.New(t)
+
+	for _, endpoint := range []string{"", "/introspect", "ftp://auth.example.com/introspect"} {
+		_, err := introspect.NewClient(endpoint, testClientID, testClientSecret)
+		is.True(errors.Is(err, apperrors.ErrIntrospectionConfig))
+	}
+	_, err := introspect.NewClient("https://auth.example.com/introspect", testClientID, "")
+	is.True(errors.Is(err, apperrors.ErrIntrospectionConfig))
+
+	client, err := introspect.NewClient("https://auth.example.com/introspect", testClientID, testClientSecret)
+	is.NoErr(err)
+	is.Equal(client.CacheTTL, introspect.DefaultCacheTTL)
+	is.Equal(client.NegativeCacheTTL, introspect.DefaultNegativeCacheTTL)
+}
+
+// TestClient_Introspect checks the results and their caching
+func TestClient_Introspect(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("active token is cached", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		for range 2 {
+			result, err := client.Introspect(ctx, activeToken)
+			is.NoErr(err)
+			is.True(result.Active)
+			is.Equal(result.Email, "user@example.com")
+			is.True(result.HasRole("admin"))
+			is.Equal(result.Claims["plan"], "pro")
+			is.True(result.ExpiresAt.After(time.Now()))
+		}
+		is.Equal(calls.Load(), int32(1))
+
+		// Forgetting the token asks the server again
+		client.Forget(activeToken)
+		_, err = client.Introspect(ctx, activeToken)
+		is.NoErr(err)
+		is.Equal(calls.Load(), int32(2))
+	})
+
+	t.Run("cached results are copies", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		// Both the result that was cached and a cache hit
+		for range 2 {
+			result, err := client.Introspect(ctx, activeToken)
+			is.NoErr(err)
+			result.Claims["plan"] = "free"
+			result.Roles[0] = "user"
+		}
+
+		result, err := client.Introspect(ctx, activeToken)
+		is.NoErr(err)
+		is.Equal(result.Claims["plan"], "pro")
+		is.True(result.HasRole("admin"))
+		is.Equal(calls.Load(), int32(1))
+	})
+
+	t.Run("inactive token is negatively cached", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		for range 2 {
+			result, err := client.Introspect(ctx, "revoked-token")
+			is.NoErr(err)
+			is.True(!result.Active)
+			is.Equal(result.UserID, "")
+		}
+		is.Equal(calls.Load(), int32(1))
+	})
+
+	t.Run("negative cache disabled", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+		client.NegativeCacheTTL = 0
+
+		for range 2 {
+			_, err := client.Introspect(ctx, "revoked-token")
+			is.NoErr(err)
+		}
+		is.Equal(calls.Load(), int32(2))
+	})
+
+	t.Run("not cached past the session's expiry", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		for range 2 {
+			_, err := client.Introspect(ctx, expiredToken)
+			is.NoErr(err)
+		}
+		is.Equal(calls.Load(), int32(2))
+	})
+
+	t.Run("empty token is inactive without a request", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		result, err := client.Introspect(ctx, "")
+		is.NoErr(err)
+		is.True(!result.Active)
+		is.Equal(calls.Load(), int32(0))
+	})
+
+	t.Run("rejected credentials", func(t *testing.T) {
+		is := is.New(t)
+		srv, _ := introspectionServer(t, http.StatusOK)
+		client, err := introspect.NewClient(srv.URL, testClientID, "wrong")
+		is.NoErr(err)
+
+		_, err = client.Introspect(ctx, activeToken)
+		is.Equal(err, apperrors.ErrIntrospectionClient)
+	})
+
+	t.Run("server errors are not cached", func(t *testing.T) {
+		is := is.New(t)
+		srv, calls := introspectionServer(t, http.StatusInternalServerError)
+		client, err := introspect.NewClient(srv.URL, testClientID, testClientSecret)
+		is.NoErr(err)
+
+		for range 2 {
+			_, err := client.Introspect(ctx, activeToken)
+			is.True(errors.Is(err, apperrors.ErrIntrospectionFailed))
+		}
+		is.Equal(calls.Load(), int32(2))
+	})
+}